@@ -54,17 +54,25 @@ type TransportType struct {
 
 // TransportConfigurations defines transport-specific configurations
 type TransportConfigurations struct {
-	TransportRequired []string                    `yaml:"transport_required" json:"transport_required"`
-	TransportTypes    map[string]TransportType    `yaml:"transport_types" json:"transport_types"`
+	TransportRequired []string                 `yaml:"transport_required" json:"transport_required"`
+	TransportTypes    map[string]TransportType `yaml:"transport_types" json:"transport_types"`
+}
+
+// AnonymizationConfigurations defines which categories have faces and license plates blurred
+// in the public copies of their photos
+type AnonymizationConfigurations struct {
+	BlurFaces  []string `yaml:"blur_faces" json:"blur_faces"`
+	BlurPlates []string `yaml:"blur_plates" json:"blur_plates"`
 }
 
 // CategoriesConfig holds the complete categories configuration
 type CategoriesConfig struct {
-	Categories              []Category              `yaml:"categories" json:"categories"`
-	Settings                CategorySettings        `yaml:"settings" json:"settings"`
-	LocationRequirements    LocationRequirements    `yaml:"location_requirements" json:"location_requirements"`
-	FormConfigurations      FormConfigurations      `yaml:"form_configurations" json:"form_configurations"`
-	TransportConfigurations TransportConfigurations `yaml:"transport_configurations" json:"transport_configurations"`
+	Categories              []Category                  `yaml:"categories" json:"categories"`
+	Settings                CategorySettings            `yaml:"settings" json:"settings"`
+	LocationRequirements    LocationRequirements        `yaml:"location_requirements" json:"location_requirements"`
+	FormConfigurations      FormConfigurations          `yaml:"form_configurations" json:"form_configurations"`
+	TransportConfigurations TransportConfigurations     `yaml:"transport_configurations" json:"transport_configurations"`
+	Anonymization           AnonymizationConfigurations `yaml:"anonymization" json:"anonymization"`
 }
 
 // Global variable to hold loaded categories
@@ -119,6 +127,26 @@ func (c *CategoriesConfig) IsTransportRequired(categoryID string) bool {
 	return false
 }
 
+// BlursFaces checks if faces are blurred in the public photos of a category
+func (c *CategoriesConfig) BlursFaces(categoryID string) bool {
+	for _, id := range c.Anonymization.BlurFaces {
+		if id == categoryID {
+			return true
+		}
+	}
+	return false
+}
+
+// BlursPlates checks if license plates are blurred in the public photos of a category
+func (c *CategoriesConfig) BlursPlates(categoryID string) bool {
+	for _, id := range c.Anonymization.BlurPlates {
+		if id == categoryID {
+			return true
+		}
+	}
+	return false
+}
+
 // GetTransportTypes returns all available transport types
 func (c *CategoriesConfig) GetTransportTypes() map[string]TransportType {
 	return c.TransportConfigurations.TransportTypes
@@ -176,6 +204,22 @@ func IsTransportRequiredGlobal(categoryID string) bool {
 	return CategoriesData.IsTransportRequired(categoryID)
 }
 
+// BlursFacesGlobal checks if faces are blurred in the public photos of a category
+func BlursFacesGlobal(categoryID string) bool {
+	if CategoriesData == nil {
+		return false
+	}
+	return CategoriesData.BlursFaces(categoryID)
+}
+
+// BlursPlatesGlobal checks if license plates are blurred in the public photos of a category
+func BlursPlatesGlobal(categoryID string) bool {
+	if CategoriesData == nil {
+		return false
+	}
+	return CategoriesData.BlursPlates(categoryID)
+}
+
 // GetTransportTypesGlobal returns all transport types from global config
 func GetTransportTypesGlobal() map[string]TransportType {
 	if CategoriesData == nil {
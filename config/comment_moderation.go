@@ -0,0 +1,129 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Actions of the comment filter
+const (
+	CommentFilterReject = "reject"
+	CommentFilterHold   = "hold"
+)
+
+// CommentFilter is a list of words and regular expressions matched against comment content
+type CommentFilter struct {
+	Words    []string `yaml:"words"`
+	Patterns []string `yaml:"patterns"`
+
+	rules []commentFilterRule
+}
+
+// commentFilterRule is a compiled word or pattern, with the text shown to moderators
+type commentFilterRule struct {
+	source string
+	re     *regexp.Regexp
+}
+
+// CommentModerationConfig holds the comment flag threshold and keyword filters
+type CommentModerationConfig struct {
+	FlagsToHide int           `yaml:"flags_to_hide"`
+	Reject      CommentFilter `yaml:"reject"`
+	Hold        CommentFilter `yaml:"hold"`
+}
+
+var (
+	commentModerationMu sync.Mutex
+	// CommentModerationData holds the loaded comment moderation configuration
+	CommentModerationData *CommentModerationConfig
+)
+
+// LoadCommentModeration loads the comment moderation configuration from COMMENT_MODERATION_FILE
+func LoadCommentModeration() (*CommentModerationConfig, error) {
+	path := getEnvOrDefault("COMMENT_MODERATION_FILE", "config/comment_moderation.yaml")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+
+	config := &CommentModerationConfig{FlagsToHide: 3}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	if config.FlagsToHide < 1 {
+		return nil, fmt.Errorf("flags_to_hide must be at least 1")
+	}
+	if err := config.Reject.compile(); err != nil {
+		return nil, fmt.Errorf("error in reject filter: %w", err)
+	}
+	if err := config.Hold.compile(); err != nil {
+		return nil, fmt.Errorf("error in hold filter: %w", err)
+	}
+
+	commentModerationMu.Lock()
+	CommentModerationData = config
+	commentModerationMu.Unlock()
+
+	return config, nil
+}
+
+// GetCommentModeration returns the loaded configuration, loading it on first use
+func GetCommentModeration() (*CommentModerationConfig, error) {
+	commentModerationMu.Lock()
+	config := CommentModerationData
+	commentModerationMu.Unlock()
+
+	if config != nil {
+		return config, nil
+	}
+	return LoadCommentModeration()
+}
+
+// Check runs the filters on a comment and returns the action to take, or "" to publish it,
+// with the word or pattern that matched
+func (c *CommentModerationConfig) Check(content string) (string, string) {
+	if match := c.Reject.match(content); match != "" {
+		return CommentFilterReject, match
+	}
+	if match := c.Hold.match(content); match != "" {
+		return CommentFilterHold, match
+	}
+	return "", ""
+}
+
+// compile turns words into case-insensitive whole word expressions and compiles the patterns
+func (f *CommentFilter) compile() error {
+	f.rules = nil
+	for _, word := range f.Words {
+		word = strings.TrimSpace(word)
+		if word == "" {
+			continue
+		}
+		// \b only knows ASCII letters, which would split accented words
+		re := regexp.MustCompile(`(?i)(^|[^\p{L}\p{N}])` + regexp.QuoteMeta(word) + `($|[^\p{L}\p{N}])`)
+		f.rules = append(f.rules, commentFilterRule{source: word, re: re})
+	}
+	for _, pattern := range f.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		f.rules = append(f.rules, commentFilterRule{source: pattern, re: re})
+	}
+	return nil
+}
+
+// match returns the first rule matching the content, or ""
+func (f *CommentFilter) match(content string) string {
+	for _, rule := range f.rules {
+		if rule.re.MatchString(content) {
+			return rule.source
+		}
+	}
+	return ""
+}
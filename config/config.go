@@ -17,14 +17,34 @@ type Config struct {
 	DBPassword string
 
 	// Email Configuration
-	SMTPHost     string
-	SMTPPort     int
-	SMTPUsername string
-	SMTPPassword string
+	SMTPHost       string
+	SMTPPort       int
+	SMTPUsername   string
+	SMTPPassword   string
+	SMTPEncryption string // tls (STARTTLS), ssl (implicit TLS) or none
+	MailTransport  string // smtp, file or memory; anything but smtp never reaches real inboxes
+	MailDir        string // Maildir written by the file transport
+	MailFrom       string
+
+	// Storage Configuration
+	StorageBackend   string // local or s3
+	StorageDir       string // Root of the local backend
+	StorageDownloads string // proxy streams files through the app; presigned redirects to the object store
+	S3Endpoint       string // e.g. https://s3.amazonaws.com or http://minio:9000
+	S3Region         string
+	S3Bucket         string
+	S3AccessKey      string
+	S3SecretKey      string
+	S3PathStyle      bool   // bucket in the path instead of the host name, as MinIO expects
+	CascadeDir       string // OpenCV Haar cascades used to find faces and plates to blur
 
 	// Security Configuration
-	SessionKey   string
-	CookieDomain string
+	SessionKey     string
+	CookieDomain   string
+	ModeratorToken string // Optional; moderation tools are disabled when empty
+	BounceToken    string // Optional; the bounce webhook is disabled when empty
+	AgencyToken    string // Optional; lets agencies download the unblurred originals of photos
+	PIIKey         string // Encrypts the originals of redacted texts
 
 	// App Configuration
 	AppVersion string
@@ -103,13 +123,43 @@ func Load() (*Config, error) {
 	config.SMTPPort = getEnvAsIntOrDefault("SMTP_PORT", 587)
 	config.SMTPUsername = getEnvOrDefault("SMTP_USERNAME", "")
 
-	// Read SMTP password from secret file
+	config.MailTransport = strings.ToLower(getEnvOrDefault("MAIL_TRANSPORT", "smtp"))
+	config.MailDir = getEnvOrDefault("MAIL_DIR", "./mail")
+	config.MailFrom = getEnvOrDefault("MAIL_FROM", config.SMTPUsername)
+
+	// Port 465 is implicit TLS; submission ports upgrade with STARTTLS
+	defaultEncryption := "tls"
+	if config.SMTPPort == 465 {
+		defaultEncryption = "ssl"
+	}
+	config.SMTPEncryption = strings.ToLower(getEnvOrDefault("SMTP_ENCRYPTION", defaultEncryption))
+
+	// Read SMTP password from secret file; only the SMTP transport needs it
 	smtpPasswordFile := getEnvOrDefault("SMTP_PASSWORD_FILE", "/run/secrets/smtp_password")
 	config.SMTPPassword, err = readSecretFile(smtpPasswordFile)
-	if err != nil {
+	if err != nil && config.MailTransport == "smtp" {
 		return nil, fmt.Errorf("failed to load SMTP password")
 	}
 
+	// Storage Configuration
+	config.StorageBackend = strings.ToLower(getEnvOrDefault("STORAGE_BACKEND", "local"))
+	config.StorageDir = getEnvOrDefault("STORAGE_DIR", "./uploads")
+	config.StorageDownloads = strings.ToLower(getEnvOrDefault("STORAGE_DOWNLOADS", "proxy"))
+	config.S3Endpoint = strings.TrimSuffix(getEnvOrDefault("S3_ENDPOINT", "https://s3.amazonaws.com"), "/")
+	config.S3Region = getEnvOrDefault("S3_REGION", "us-east-1")
+	config.S3Bucket = getEnvOrDefault("S3_BUCKET", "")
+	config.S3AccessKey = getEnvOrDefault("S3_ACCESS_KEY", "")
+	config.S3PathStyle = getEnvOrDefault("S3_PATH_STYLE", "true") == "true"
+
+	config.CascadeDir = getEnvOrDefault("CASCADE_DIR", "/usr/share/opencv4/haarcascades")
+
+	// Read the S3 secret key from secret file; only the S3 backend needs it
+	s3SecretKeyFile := getEnvOrDefault("S3_SECRET_KEY_FILE", "/run/secrets/s3_secret_key")
+	config.S3SecretKey, err = readSecretFile(s3SecretKeyFile)
+	if err != nil && config.StorageBackend == "s3" {
+		return nil, fmt.Errorf("failed to load S3 secret key")
+	}
+
 	// Security Configuration
 	sessionKeyFile := getEnvOrDefault("SESSION_KEY_FILE", "/run/secrets/session_key")
 	config.SessionKey, err = readSecretFile(sessionKeyFile)
@@ -119,6 +169,32 @@ func Load() (*Config, error) {
 
 	config.CookieDomain = getEnvOrDefault("COOKIE_DOMAIN", ".olhourbano.com")
 
+	// Moderator token is optional so deployments without moderators keep working
+	moderatorTokenFile := getEnvOrDefault("MODERATOR_TOKEN_FILE", "/run/secrets/moderator_token")
+	if config.ModeratorToken, err = readSecretFile(moderatorTokenFile); err != nil {
+		config.ModeratorToken = ""
+	}
+
+	// Bounce webhook token is optional too; bounces can also be imported with email:bounces
+	bounceTokenFile := getEnvOrDefault("BOUNCE_TOKEN_FILE", "/run/secrets/bounce_token")
+	if config.BounceToken, err = readSecretFile(bounceTokenFile); err != nil {
+		config.BounceToken = ""
+	}
+
+	// Agency token is optional; without it only moderators see the unblurred originals
+	agencyTokenFile := getEnvOrDefault("AGENCY_TOKEN_FILE", "/run/secrets/agency_token")
+	if config.AgencyToken, err = readSecretFile(agencyTokenFile); err != nil {
+		config.AgencyToken = ""
+	}
+
+	// PII key encrypts the originals of redacted texts; it is separate from the session key so
+	// rotating one never touches the other
+	piiKeyFile := getEnvOrDefault("PII_KEY_FILE", "/run/secrets/pii_key")
+	config.PIIKey, err = readSecretFile(piiKeyFile)
+	if err != nil || config.PIIKey == "" {
+		return nil, fmt.Errorf("failed to load PII key")
+	}
+
 	// App Configuration
 	config.AppVersion = getEnvOrDefault("APP_VERSION", "2.0.0")
 
@@ -149,6 +225,6 @@ func (c *Config) GetDSN() string {
 
 // String returns a safe representation of the config (no secrets)
 func (c *Config) String() string {
-	return fmt.Sprintf("Config{DBHost:%s, DBPort:%s, DBUser:%s, DBName:%s, SMTPHost:%s, SMTPPort:%d, SMTPUsername:%s, CookieDomain:%s, AppVersion:%s, CPFHubAPIURL:%s, GoogleMapsAPIURL:%s}",
-		c.DBHost, c.DBPort, c.DBUser, c.DBName, c.SMTPHost, c.SMTPPort, c.SMTPUsername, c.CookieDomain, c.AppVersion, c.CPFHubAPIURL, c.GoogleMapsAPIURL)
+	return fmt.Sprintf("Config{DBHost:%s, DBPort:%s, DBUser:%s, DBName:%s, SMTPHost:%s, SMTPPort:%d, SMTPUsername:%s, SMTPEncryption:%s, MailTransport:%s, StorageBackend:%s, CookieDomain:%s, AppVersion:%s, CPFHubAPIURL:%s, GoogleMapsAPIURL:%s}",
+		c.DBHost, c.DBPort, c.DBUser, c.DBName, c.SMTPHost, c.SMTPPort, c.SMTPUsername, c.SMTPEncryption, c.MailTransport, c.StorageBackend, c.CookieDomain, c.AppVersion, c.CPFHubAPIURL, c.GoogleMapsAPIURL)
 }
@@ -0,0 +1,7 @@
+-- Migration 009: Rollback neighborhoods table creation
+DROP INDEX IF EXISTS idx_reports_neighborhood_id;
+ALTER TABLE reports DROP COLUMN IF EXISTS neighborhood_id;
+
+DROP INDEX IF EXISTS idx_neighborhoods_bbox;
+DROP INDEX IF EXISTS idx_neighborhoods_city;
+DROP TABLE IF EXISTS neighborhoods;
@@ -0,0 +1,28 @@
+-- Migration 009: Create neighborhoods table for district-level aggregation
+CREATE TABLE neighborhoods (
+    id SERIAL PRIMARY KEY,
+    city VARCHAR(100) NOT NULL,
+    name VARCHAR(150) NOT NULL,
+    slug VARCHAR(255) NOT NULL UNIQUE,
+    geometry JSONB NOT NULL,
+    min_latitude DOUBLE PRECISION NOT NULL,
+    max_latitude DOUBLE PRECISION NOT NULL,
+    min_longitude DOUBLE PRECISION NOT NULL,
+    max_longitude DOUBLE PRECISION NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE(city, name)
+);
+
+-- Index for listing neighborhoods of a city
+CREATE INDEX IF NOT EXISTS idx_neighborhoods_city ON neighborhoods(city);
+
+-- Bounding box index used to pre-filter point-in-polygon candidates
+CREATE INDEX IF NOT EXISTS idx_neighborhoods_bbox ON neighborhoods(min_latitude, max_latitude, min_longitude, max_longitude);
+
+-- Link reports to the neighborhood their coordinates fall into
+ALTER TABLE reports ADD COLUMN neighborhood_id INTEGER REFERENCES neighborhoods(id) ON DELETE SET NULL;
+
+-- Index for neighborhood filtering and rankings
+CREATE INDEX IF NOT EXISTS idx_reports_neighborhood_id ON reports(neighborhood_id);
+
+COMMENT ON COLUMN neighborhoods.geometry IS 'GeoJSON Polygon or MultiPolygon geometry (lng/lat order)';
@@ -0,0 +1,3 @@
+-- Migration 010: Rollback hotspots table creation
+DROP INDEX IF EXISTS idx_hotspots_category_window;
+DROP TABLE IF EXISTS hotspots;
@@ -0,0 +1,19 @@
+-- Migration 010: Create hotspots table for precomputed report clusters
+CREATE TABLE hotspots (
+    id SERIAL PRIMARY KEY,
+    category VARCHAR(100) NOT NULL DEFAULT '',
+    window_days INTEGER NOT NULL,
+    latitude DOUBLE PRECISION NOT NULL,
+    longitude DOUBLE PRECISION NOT NULL,
+    radius_meters DOUBLE PRECISION NOT NULL,
+    report_count INTEGER NOT NULL,
+    vote_count INTEGER NOT NULL DEFAULT 0,
+    score DOUBLE PRECISION NOT NULL,
+    report_ids INTEGER[] NOT NULL,
+    computed_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Index for fetching the hotspots of a category and time window by relevance
+CREATE INDEX IF NOT EXISTS idx_hotspots_category_window ON hotspots(category, window_days, score DESC);
+
+COMMENT ON COLUMN hotspots.category IS 'Report category, or empty for all categories combined';
@@ -0,0 +1,5 @@
+-- Migration 011: Rollback report merging
+DROP INDEX IF EXISTS idx_reports_problem_type_created_at;
+DROP INDEX IF EXISTS idx_reports_merged_into_id;
+ALTER TABLE reports DROP COLUMN IF EXISTS merged_at;
+ALTER TABLE reports DROP COLUMN IF EXISTS merged_into_id;
@@ -0,0 +1,11 @@
+-- Migration 011: Allow duplicate reports to be merged into a canonical report
+ALTER TABLE reports ADD COLUMN merged_into_id INTEGER REFERENCES reports(id) ON DELETE SET NULL;
+ALTER TABLE reports ADD COLUMN merged_at TIMESTAMP;
+
+-- Index for excluding merged reports from listings and finding a canonical report's duplicates
+CREATE INDEX IF NOT EXISTS idx_reports_merged_into_id ON reports(merged_into_id);
+
+-- Index for the duplicate check (same category, recent reports)
+CREATE INDEX IF NOT EXISTS idx_reports_problem_type_created_at ON reports(problem_type, created_at DESC);
+
+COMMENT ON COLUMN reports.merged_into_id IS 'Canonical report this duplicate was merged into; merged reports are hidden from listings';
@@ -0,0 +1,14 @@
+-- Migration 012: Rollback full-text search
+DROP TRIGGER IF EXISTS comments_search_update ON comments;
+DROP TRIGGER IF EXISTS reports_search_update ON reports;
+DROP FUNCTION IF EXISTS comments_search_trigger();
+DROP FUNCTION IF EXISTS reports_search_trigger();
+DROP FUNCTION IF EXISTS report_search_vector(TEXT, TEXT, INTEGER);
+
+DROP INDEX IF EXISTS idx_reports_search_text_trgm;
+DROP INDEX IF EXISTS idx_reports_search_vector;
+ALTER TABLE reports DROP COLUMN IF EXISTS search_text;
+ALTER TABLE reports DROP COLUMN IF EXISTS search_vector;
+
+DROP TEXT SEARCH CONFIGURATION IF EXISTS portuguese_unaccent;
+DROP FUNCTION IF EXISTS immutable_unaccent(text);
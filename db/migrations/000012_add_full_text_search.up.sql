@@ -0,0 +1,71 @@
+-- Migration 012: Portuguese full-text search over reports and their comments
+CREATE EXTENSION IF NOT EXISTS unaccent;
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- unaccent() is only STABLE; this wrapper pins the dictionary so it can be used in indexes
+CREATE OR REPLACE FUNCTION immutable_unaccent(text) RETURNS text AS $$
+    SELECT public.unaccent('public.unaccent'::regdictionary, $1)
+$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE STRICT;
+
+-- Portuguese stemming that ignores accents ("calçada" matches "calcada")
+DROP TEXT SEARCH CONFIGURATION IF EXISTS portuguese_unaccent;
+CREATE TEXT SEARCH CONFIGURATION portuguese_unaccent (COPY = portuguese);
+ALTER TEXT SEARCH CONFIGURATION portuguese_unaccent
+    ALTER MAPPING FOR hword, hword_part, word WITH unaccent, portuguese_stem;
+
+ALTER TABLE reports ADD COLUMN search_vector TSVECTOR;
+ALTER TABLE reports ADD COLUMN search_text TEXT;
+
+-- Builds the weighted document for a report: description (A), location (B) and comments (C)
+CREATE OR REPLACE FUNCTION report_search_vector(p_description TEXT, p_location TEXT, p_report_id INTEGER) RETURNS TSVECTOR AS $$
+    SELECT setweight(to_tsvector('portuguese_unaccent', COALESCE(p_description, '')), 'A') ||
+           setweight(to_tsvector('portuguese_unaccent', COALESCE(p_location, '')), 'B') ||
+           setweight(to_tsvector('portuguese_unaccent', COALESCE(
+               (SELECT string_agg(content, ' ') FROM comments WHERE report_id = p_report_id), '')), 'C')
+$$ LANGUAGE sql STABLE;
+
+-- Keep the search columns of a report up to date when its text changes
+CREATE OR REPLACE FUNCTION reports_search_trigger() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.search_vector := report_search_vector(NEW.description, NEW.location, NEW.id);
+    NEW.search_text := immutable_unaccent(lower(COALESCE(NEW.description, '') || ' ' || COALESCE(NEW.location, '')));
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER reports_search_update
+    BEFORE INSERT OR UPDATE OF description, location ON reports
+    FOR EACH ROW EXECUTE FUNCTION reports_search_trigger();
+
+-- Refresh the report document when its comments change (including comments moved by a merge)
+CREATE OR REPLACE FUNCTION comments_search_trigger() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE reports SET search_vector = report_search_vector(description, location, id) WHERE id = NEW.report_id;
+    ELSE
+        UPDATE reports SET search_vector = report_search_vector(description, location, id) WHERE id = OLD.report_id;
+        IF TG_OP = 'UPDATE' AND NEW.report_id IS DISTINCT FROM OLD.report_id THEN
+            UPDATE reports SET search_vector = report_search_vector(description, location, id) WHERE id = NEW.report_id;
+        END IF;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER comments_search_update
+    AFTER INSERT OR UPDATE OR DELETE ON comments
+    FOR EACH ROW EXECUTE FUNCTION comments_search_trigger();
+
+-- Backfill existing reports
+UPDATE reports SET
+    search_vector = report_search_vector(description, location, id),
+    search_text = immutable_unaccent(lower(COALESCE(description, '') || ' ' || COALESCE(location, '')));
+
+-- GIN index for full-text queries
+CREATE INDEX IF NOT EXISTS idx_reports_search_vector ON reports USING GIN (search_vector);
+
+-- Trigram index for typo-tolerant matching
+CREATE INDEX IF NOT EXISTS idx_reports_search_text_trgm ON reports USING GIN (search_text gin_trgm_ops);
+
+COMMENT ON COLUMN reports.search_vector IS 'Weighted full-text document of description, location and comments (portuguese_unaccent)';
+COMMENT ON COLUMN reports.search_text IS 'Lowercase unaccented description and location for trigram matching';
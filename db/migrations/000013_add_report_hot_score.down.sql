@@ -0,0 +1,3 @@
+-- Migration 013: Rollback hotness score
+DROP INDEX IF EXISTS idx_reports_hot_score;
+ALTER TABLE reports DROP COLUMN IF EXISTS hot_score;
@@ -0,0 +1,11 @@
+-- Migration 013: Time-decayed hotness score for the trending feed
+ALTER TABLE reports ADD COLUMN hot_score DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+-- Backfill existing reports (same formula as services.hotScoreExpression)
+UPDATE reports SET hot_score =
+    LOG(GREATEST(COALESCE(vote_count, 0) + 2 * COALESCE(comment_count, 0), 1)) + EXTRACT(EPOCH FROM created_at) / 45000;
+
+-- Index backing sort=trending on the feed (merged duplicates are never listed)
+CREATE INDEX IF NOT EXISTS idx_reports_hot_score ON reports(hot_score DESC, created_at DESC) WHERE merged_into_id IS NULL;
+
+COMMENT ON COLUMN reports.hot_score IS 'log10(votes + 2 * comments) + created_at epoch / 45000; newer reports need fewer interactions to rank';
@@ -0,0 +1,10 @@
+-- Migration 014: Rollback subscriptions
+DROP INDEX IF EXISTS idx_reports_subscriptions_unmatched;
+ALTER TABLE reports DROP COLUMN IF EXISTS subscription_match_error;
+ALTER TABLE reports DROP COLUMN IF EXISTS subscription_match_attempts;
+ALTER TABLE reports DROP COLUMN IF EXISTS subscriptions_matched_at;
+DROP INDEX IF EXISTS idx_subscription_matches_unsent;
+DROP TABLE IF EXISTS subscription_matches;
+DROP INDEX IF EXISTS idx_subscriptions_active;
+DROP INDEX IF EXISTS idx_subscriptions_email;
+DROP TABLE IF EXISTS subscriptions;
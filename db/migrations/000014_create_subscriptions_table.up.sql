@@ -0,0 +1,58 @@
+-- Migration 014: Create subscriptions for saved searches and area alerts
+CREATE TABLE subscriptions (
+    id SERIAL PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    category VARCHAR(100) NOT NULL DEFAULT '',
+    city VARCHAR(100) NOT NULL DEFAULT '',
+    neighborhood_id INTEGER REFERENCES neighborhoods(id) ON DELETE CASCADE,
+    polygon JSONB,
+    center_latitude DOUBLE PRECISION,
+    center_longitude DOUBLE PRECISION,
+    radius_meters DOUBLE PRECISION,
+    min_latitude DOUBLE PRECISION,
+    max_latitude DOUBLE PRECISION,
+    min_longitude DOUBLE PRECISION,
+    max_longitude DOUBLE PRECISION,
+    frequency VARCHAR(10) NOT NULL DEFAULT 'instant' CHECK (frequency IN ('instant', 'daily')),
+    confirm_token VARCHAR(64) UNIQUE,
+    unsubscribe_token VARCHAR(64) NOT NULL UNIQUE,
+    confirmed_at TIMESTAMP,
+    unsubscribed_at TIMESTAMP,
+    last_digest_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Index for limiting subscriptions per email address
+CREATE INDEX IF NOT EXISTS idx_subscriptions_email ON subscriptions(LOWER(email));
+
+-- Partial index for the matcher, which only looks at active subscriptions
+CREATE INDEX IF NOT EXISTS idx_subscriptions_active ON subscriptions(category, city)
+    WHERE confirmed_at IS NOT NULL AND unsubscribed_at IS NULL;
+
+-- Reports matched to a subscription, sent instantly or collected for the daily digest
+CREATE TABLE subscription_matches (
+    subscription_id INTEGER NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    report_id INTEGER NOT NULL REFERENCES reports(id) ON DELETE CASCADE,
+    matched_at TIMESTAMP DEFAULT NOW(),
+    sent_at TIMESTAMP,
+    PRIMARY KEY (subscription_id, report_id)
+);
+
+-- Index for finding matches still waiting for a digest
+CREATE INDEX IF NOT EXISTS idx_subscription_matches_unsent ON subscription_matches(subscription_id)
+    WHERE sent_at IS NULL;
+
+-- New reports wait with subscriptions_matched_at unset until the matcher picks them up
+ALTER TABLE reports ADD COLUMN IF NOT EXISTS subscriptions_matched_at TIMESTAMP;
+UPDATE reports SET subscriptions_matched_at = created_at WHERE subscriptions_matched_at IS NULL;
+
+-- Reports whose matching fails are retried a few times, then left with the error for an operator
+ALTER TABLE reports ADD COLUMN IF NOT EXISTS subscription_match_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE reports ADD COLUMN IF NOT EXISTS subscription_match_error TEXT;
+
+-- Partial index for the matcher finding reports not yet matched
+CREATE INDEX IF NOT EXISTS idx_reports_subscriptions_unmatched ON reports(id) WHERE subscriptions_matched_at IS NULL;
+
+COMMENT ON COLUMN subscriptions.category IS 'Report category, or empty for all categories';
+COMMENT ON COLUMN subscriptions.polygon IS 'GeoJSON Polygon or MultiPolygon geometry (lng/lat order) drawn by the subscriber';
+COMMENT ON COLUMN subscriptions.min_latitude IS 'Bounding box of the polygon or radius, used to pre-filter matches';
@@ -0,0 +1,7 @@
+-- Migration 015: Rollback report followers
+ALTER TABLE reports DROP COLUMN IF EXISTS official_response_at;
+ALTER TABLE reports DROP COLUMN IF EXISTS official_response;
+DROP INDEX IF EXISTS idx_report_notifications_unsent;
+DROP TABLE IF EXISTS report_notifications;
+DROP INDEX IF EXISTS idx_report_followers_report_id;
+DROP TABLE IF EXISTS report_followers;
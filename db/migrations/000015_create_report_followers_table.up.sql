@@ -0,0 +1,47 @@
+-- Migration 015: Create report followers and their pending notifications
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+
+CREATE TABLE report_followers (
+    id SERIAL PRIMARY KEY,
+    report_id INTEGER NOT NULL REFERENCES reports(id) ON DELETE CASCADE,
+    hashed_cpf VARCHAR(64) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    preference VARCHAR(10) NOT NULL DEFAULT 'instant' CHECK (preference IN ('instant', 'daily', 'off')),
+    manage_token VARCHAR(64) NOT NULL UNIQUE,
+    pending_email VARCHAR(255),
+    email_change_token VARCHAR(64) UNIQUE,
+    email_change_requested_at TIMESTAMP,
+    last_digest_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE(report_id, hashed_cpf)
+);
+
+-- Index for the fan-out, which skips followers who turned notifications off
+CREATE INDEX IF NOT EXISTS idx_report_followers_report_id ON report_followers(report_id) WHERE preference != 'off';
+
+-- Events collected for followers who prefer a daily digest
+CREATE TABLE report_notifications (
+    id SERIAL PRIMARY KEY,
+    follower_id INTEGER NOT NULL REFERENCES report_followers(id) ON DELETE CASCADE,
+    event_type VARCHAR(20) NOT NULL CHECK (event_type IN ('comment', 'status', 'response')),
+    summary TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    sent_at TIMESTAMP
+);
+
+-- Index for finding notifications still waiting for a digest
+CREATE INDEX IF NOT EXISTS idx_report_notifications_unsent ON report_notifications(follower_id) WHERE sent_at IS NULL;
+
+-- Official response published alongside status changes
+ALTER TABLE reports ADD COLUMN official_response TEXT;
+ALTER TABLE reports ADD COLUMN official_response_at TIMESTAMP;
+
+-- Report owners follow their own reports, preserving the previous owner-only comment emails
+INSERT INTO report_followers (report_id, hashed_cpf, email, preference, manage_token, created_at)
+SELECT id, hashed_cpf, email, 'instant', encode(gen_random_bytes(24), 'hex'), created_at
+FROM reports
+WHERE email IS NOT NULL AND email != '' AND hashed_cpf IS NOT NULL AND hashed_cpf != ''
+ON CONFLICT (report_id, hashed_cpf) DO NOTHING;
+
+COMMENT ON COLUMN report_followers.manage_token IS 'Secret token for the preference and unfollow links sent by email';
+COMMENT ON COLUMN report_followers.pending_email IS 'New address given by an existing follower, applied once confirmed through email_change_token';
@@ -0,0 +1,5 @@
+-- Migration 016: Rollback email outbox
+ALTER TABLE reports DROP COLUMN IF EXISTS status_updated_at;
+DROP INDEX IF EXISTS idx_email_outbox_status;
+DROP INDEX IF EXISTS idx_email_outbox_due;
+DROP TABLE IF EXISTS email_outbox;
@@ -0,0 +1,28 @@
+-- Migration 016: Create durable email outbox
+CREATE TABLE email_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    idempotency_key VARCHAR(255) NOT NULL UNIQUE,
+    recipient VARCHAR(255) NOT NULL,
+    subject TEXT NOT NULL,
+    body TEXT NOT NULL,
+    status VARCHAR(10) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sending', 'sent', 'dead')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL DEFAULT 8,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    locked_at TIMESTAMP,
+    last_error TEXT,
+    created_at TIMESTAMP DEFAULT NOW(),
+    sent_at TIMESTAMP
+);
+
+-- Partial index for workers claiming the next due message
+CREATE INDEX IF NOT EXISTS idx_email_outbox_due ON email_outbox(next_attempt_at) WHERE status = 'pending';
+
+-- Index for listing messages by state in the CLI
+CREATE INDEX IF NOT EXISTS idx_email_outbox_status ON email_outbox(status, created_at DESC);
+
+COMMENT ON COLUMN email_outbox.idempotency_key IS 'Event and recipient; enqueueing the same key twice sends a single email';
+COMMENT ON COLUMN email_outbox.status IS 'pending, sending (claimed by a worker), sent, or dead after max_attempts failures';
+
+-- Status notifications are keyed on when the status changed, so retrying a change never emails twice
+ALTER TABLE reports ADD COLUMN IF NOT EXISTS status_updated_at TIMESTAMP;
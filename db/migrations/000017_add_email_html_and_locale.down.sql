@@ -0,0 +1,9 @@
+-- Migration 017: Rollback email HTML parts and locales
+ALTER TABLE report_notifications ADD COLUMN IF NOT EXISTS summary TEXT NOT NULL DEFAULT '';
+UPDATE report_notifications SET summary = COALESCE(params->>'summary', params->>'excerpt', '');
+ALTER TABLE report_notifications ALTER COLUMN summary DROP DEFAULT;
+ALTER TABLE report_notifications DROP COLUMN IF EXISTS params;
+ALTER TABLE report_followers DROP COLUMN IF EXISTS locale;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS locale;
+ALTER TABLE email_outbox DROP COLUMN IF EXISTS list_unsubscribe;
+ALTER TABLE email_outbox DROP COLUMN IF EXISTS html_body;
@@ -0,0 +1,19 @@
+-- Migration 017: Store HTML parts and unsubscribe headers in the outbox, and the email locale of recipients
+ALTER TABLE email_outbox ADD COLUMN IF NOT EXISTS html_body TEXT NOT NULL DEFAULT '';
+ALTER TABLE email_outbox ADD COLUMN IF NOT EXISTS list_unsubscribe TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS locale VARCHAR(10) NOT NULL DEFAULT 'pt-BR';
+ALTER TABLE report_followers ADD COLUMN IF NOT EXISTS locale VARCHAR(10) NOT NULL DEFAULT 'pt-BR';
+
+-- Follower notifications store the details of the event instead of a Portuguese summary, which the
+-- email templates write in the locale of each follower
+ALTER TABLE report_notifications ADD COLUMN IF NOT EXISTS params JSONB NOT NULL DEFAULT '{}';
+
+-- Notifications already queued keep their text, which the templates show as is
+UPDATE report_notifications SET params = jsonb_build_object('summary', summary) WHERE sent_at IS NULL;
+
+ALTER TABLE report_notifications DROP COLUMN IF EXISTS summary;
+
+COMMENT ON COLUMN email_outbox.list_unsubscribe IS 'One-click unsubscribe URL sent in the List-Unsubscribe header; empty for transactional emails';
+COMMENT ON COLUMN subscriptions.locale IS 'Locale of the emails sent to the subscriber, from templates/emails/<locale>';
+COMMENT ON COLUMN report_followers.locale IS 'Locale of the emails sent to the follower, from templates/emails/<locale>';
@@ -0,0 +1,8 @@
+-- Migration 018: Rollback email verification and suppression list
+UPDATE email_outbox SET status = 'dead' WHERE status = 'suppressed';
+ALTER TABLE email_outbox DROP CONSTRAINT IF EXISTS email_outbox_status_check;
+ALTER TABLE email_outbox ADD CONSTRAINT email_outbox_status_check
+    CHECK (status IN ('pending', 'sending', 'sent', 'dead'));
+
+DROP TABLE IF EXISTS email_suppressions;
+DROP TABLE IF EXISTS email_verifications;
@@ -0,0 +1,32 @@
+-- Migration 018: Email address verification and suppression list
+CREATE TABLE email_verifications (
+    email VARCHAR(255) PRIMARY KEY,
+    token VARCHAR(64) NOT NULL UNIQUE,
+    locale VARCHAR(10) NOT NULL DEFAULT 'pt-BR',
+    verified_at TIMESTAMP,
+    last_sent_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE TABLE email_suppressions (
+    email VARCHAR(255) PRIMARY KEY,
+    reason VARCHAR(20) NOT NULL CHECK (reason IN ('bounce', 'complaint', 'manual')),
+    detail TEXT,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Messages to addresses suppressed after they were queued are dropped instead of sent
+ALTER TABLE email_outbox DROP CONSTRAINT IF EXISTS email_outbox_status_check;
+ALTER TABLE email_outbox ADD CONSTRAINT email_outbox_status_check
+    CHECK (status IN ('pending', 'sending', 'sent', 'dead', 'suppressed'));
+
+-- Confirmed alert subscriptions already proved the address through double opt-in
+INSERT INTO email_verifications (email, token, verified_at, created_at)
+SELECT LOWER(email), encode(gen_random_bytes(24), 'hex'), MIN(confirmed_at), MIN(created_at)
+FROM subscriptions
+WHERE confirmed_at IS NOT NULL
+GROUP BY LOWER(email)
+ON CONFLICT (email) DO NOTHING;
+
+COMMENT ON TABLE email_verifications IS 'Double opt-in state of addresses; non-transactional emails only go to verified ones';
+COMMENT ON TABLE email_suppressions IS 'Addresses that bounced permanently, complained or were blocked by hand; nothing is sent to them';
@@ -0,0 +1,10 @@
+-- Migration 019: Thread comments by letting them reply to another comment of the same report
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS parent_id INTEGER REFERENCES comments(id) ON DELETE CASCADE;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS depth SMALLINT NOT NULL DEFAULT 0 CHECK (depth >= 0);
+
+-- Top-level comments are paged per report, replies per thread
+CREATE INDEX IF NOT EXISTS idx_comments_report_roots ON comments(report_id, created_at DESC) WHERE parent_id IS NULL;
+CREATE INDEX IF NOT EXISTS idx_comments_parent_id ON comments(parent_id, created_at) WHERE parent_id IS NOT NULL;
+
+COMMENT ON COLUMN comments.parent_id IS 'Comment this one replies to; NULL for top-level comments';
+COMMENT ON COLUMN comments.depth IS 'Nesting level, 0 for top-level comments; capped by the application';
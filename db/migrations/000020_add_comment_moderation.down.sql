@@ -0,0 +1,18 @@
+-- Migration 020: Rollback comment moderation; comments that are not visible are blanked so they don't
+-- reappear, without deleting their replies
+CREATE OR REPLACE FUNCTION report_search_vector(p_description TEXT, p_location TEXT, p_report_id INTEGER) RETURNS TSVECTOR AS $$
+    SELECT setweight(to_tsvector('portuguese_unaccent', COALESCE(p_description, '')), 'A') ||
+           setweight(to_tsvector('portuguese_unaccent', COALESCE(p_location, '')), 'B') ||
+           setweight(to_tsvector('portuguese_unaccent', COALESCE(
+               (SELECT string_agg(content, ' ') FROM comments WHERE report_id = p_report_id), '')), 'C')
+$$ LANGUAGE sql STABLE;
+
+DROP INDEX IF EXISTS idx_comments_hidden;
+DROP TABLE IF EXISTS comment_flags;
+UPDATE comments SET content = '[comentário removido]' WHERE status != 'visible';
+ALTER TABLE comments DROP COLUMN IF EXISTS reviewed_at;
+ALTER TABLE comments DROP COLUMN IF EXISTS filter_match;
+ALTER TABLE comments DROP COLUMN IF EXISTS hidden_reason;
+ALTER TABLE comments DROP COLUMN IF EXISTS status;
+UPDATE reports SET comment_count = (SELECT COUNT(*) FROM comments WHERE comments.report_id = reports.id);
+UPDATE reports SET search_vector = report_search_vector(description, location, id);
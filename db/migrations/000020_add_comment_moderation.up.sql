@@ -0,0 +1,35 @@
+-- Migration 020: Comment moderation with citizen flags, hidden comments and a review queue
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'visible'
+    CHECK (status IN ('visible', 'hidden', 'removed'));
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS hidden_reason VARCHAR(20)
+    CHECK (hidden_reason IN ('flags', 'filter', 'moderator'));
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS filter_match TEXT;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS reviewed_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS comment_flags (
+    id SERIAL PRIMARY KEY,
+    comment_id INTEGER NOT NULL REFERENCES comments(id) ON DELETE CASCADE,
+    hashed_cpf VARCHAR(64) NOT NULL,
+    reason VARCHAR(20) NOT NULL CHECK (reason IN ('spam', 'offensive', 'personal_data', 'off_topic', 'other')),
+    detail TEXT CHECK (char_length(detail) <= 300),
+    created_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE (comment_id, hashed_cpf)
+);
+
+CREATE INDEX IF NOT EXISTS idx_comment_flags_comment_id ON comment_flags(comment_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_comments_hidden ON comments(created_at) WHERE status = 'hidden';
+
+-- Only visible comments count towards the search document of a report: hidden ones await review
+-- and removed ones were taken down
+CREATE OR REPLACE FUNCTION report_search_vector(p_description TEXT, p_location TEXT, p_report_id INTEGER) RETURNS TSVECTOR AS $$
+    SELECT setweight(to_tsvector('portuguese_unaccent', COALESCE(p_description, '')), 'A') ||
+           setweight(to_tsvector('portuguese_unaccent', COALESCE(p_location, '')), 'B') ||
+           setweight(to_tsvector('portuguese_unaccent', COALESCE(
+               (SELECT string_agg(content, ' ') FROM comments WHERE report_id = p_report_id AND status = 'visible'), '')), 'C')
+$$ LANGUAGE sql STABLE;
+
+COMMENT ON COLUMN comments.status IS 'visible, hidden (awaiting review, shown as a placeholder) or removed by a moderator';
+COMMENT ON COLUMN comments.hidden_reason IS 'Why the comment was hidden: enough flags, the keyword filter or a moderator';
+COMMENT ON COLUMN comments.filter_match IS 'Filter rule that held the comment for review';
+COMMENT ON COLUMN comments.reviewed_at IS 'When a moderator last kept the comment; only flags after it count towards hiding it again';
+COMMENT ON TABLE comment_flags IS 'Citizen reports of comments, one per hashed CPF';
@@ -0,0 +1,16 @@
+-- Migration 021: Rollback comment edits; deleted comments stay hidden as removed
+CREATE OR REPLACE FUNCTION report_search_vector(p_description TEXT, p_location TEXT, p_report_id INTEGER) RETURNS TSVECTOR AS $$
+    SELECT setweight(to_tsvector('portuguese_unaccent', COALESCE(p_description, '')), 'A') ||
+           setweight(to_tsvector('portuguese_unaccent', COALESCE(p_location, '')), 'B') ||
+           setweight(to_tsvector('portuguese_unaccent', COALESCE(
+               (SELECT string_agg(content, ' ') FROM comments WHERE report_id = p_report_id AND status = 'visible'), '')), 'C')
+$$ LANGUAGE sql STABLE;
+
+DROP INDEX IF EXISTS idx_comment_revisions_comment_id;
+DROP TABLE IF EXISTS comment_revisions;
+UPDATE comments SET status = 'removed' WHERE status = 'deleted';
+ALTER TABLE comments DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE comments DROP COLUMN IF EXISTS edited_at;
+ALTER TABLE comments DROP CONSTRAINT IF EXISTS comments_status_check;
+ALTER TABLE comments ADD CONSTRAINT comments_status_check
+    CHECK (status IN ('visible', 'hidden', 'removed'));
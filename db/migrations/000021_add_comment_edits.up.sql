@@ -0,0 +1,28 @@
+-- Migration 021: Let authors edit their comments, keeping revisions, and delete them softly
+ALTER TABLE comments DROP CONSTRAINT IF EXISTS comments_status_check;
+ALTER TABLE comments ADD CONSTRAINT comments_status_check
+    CHECK (status IN ('visible', 'hidden', 'removed', 'deleted'));
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS comment_revisions (
+    id SERIAL PRIMARY KEY,
+    comment_id INTEGER NOT NULL REFERENCES comments(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    written_at TIMESTAMP NOT NULL,
+    replaced_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_comment_revisions_comment_id ON comment_revisions(comment_id, replaced_at);
+
+-- Comments withdrawn by their author don't count towards the search document of the report either
+CREATE OR REPLACE FUNCTION report_search_vector(p_description TEXT, p_location TEXT, p_report_id INTEGER) RETURNS TSVECTOR AS $$
+    SELECT setweight(to_tsvector('portuguese_unaccent', COALESCE(p_description, '')), 'A') ||
+           setweight(to_tsvector('portuguese_unaccent', COALESCE(p_location, '')), 'B') ||
+           setweight(to_tsvector('portuguese_unaccent', COALESCE(
+               (SELECT string_agg(content, ' ') FROM comments WHERE report_id = p_report_id AND status = 'visible' AND deleted_at IS NULL), '')), 'C')
+$$ LANGUAGE sql STABLE;
+
+COMMENT ON COLUMN comments.edited_at IS 'When the author last edited the comment; shown as an "edited" marker';
+COMMENT ON COLUMN comments.deleted_at IS 'When the author deleted the comment; deleted comments keep their place in the thread as a placeholder';
+COMMENT ON TABLE comment_revisions IS 'Previous contents of edited comments, for moderators';
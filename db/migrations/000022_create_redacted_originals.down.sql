@@ -0,0 +1,4 @@
+-- Migration 022: Rollback redacted originals; the public texts stay masked
+DROP INDEX IF EXISTS idx_redacted_originals_comment_id;
+DROP INDEX IF EXISTS idx_redacted_originals_report_id;
+DROP TABLE IF EXISTS redacted_originals;
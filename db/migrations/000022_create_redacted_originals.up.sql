@@ -0,0 +1,16 @@
+-- Migration 022: Encrypted originals of report descriptions and comments that had personal data masked
+CREATE TABLE redacted_originals (
+    id SERIAL PRIMARY KEY,
+    report_id INTEGER REFERENCES reports(id) ON DELETE CASCADE,   -- Description of this report
+    comment_id INTEGER REFERENCES comments(id) ON DELETE CASCADE, -- Content of this comment, one row per edit
+    kinds TEXT[] NOT NULL,
+    ciphertext BYTEA NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    CHECK ((report_id IS NULL) <> (comment_id IS NULL))
+);
+
+CREATE INDEX idx_redacted_originals_report_id ON redacted_originals(report_id) WHERE report_id IS NOT NULL;
+CREATE INDEX idx_redacted_originals_comment_id ON redacted_originals(comment_id, created_at) WHERE comment_id IS NOT NULL;
+
+COMMENT ON TABLE redacted_originals IS 'Texts as written before CPFs, CNPJs, phones, emails and plates were masked; AES-GCM encrypted, for moderators only';
+COMMENT ON COLUMN redacted_originals.kinds IS 'Kinds of personal data found: cpf, cnpj, phone, email, plate';
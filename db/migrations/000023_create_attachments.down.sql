@@ -0,0 +1,13 @@
+-- Migration 023: Rollback attachments back into comma-separated reports.photo_path
+ALTER TABLE reports ADD COLUMN IF NOT EXISTS photo_path TEXT;
+
+UPDATE reports r SET photo_path = a.paths
+FROM (
+    SELECT report_id, string_agg(path, ',' ORDER BY position) AS paths
+    FROM attachments
+    GROUP BY report_id
+) a
+WHERE a.report_id = r.id;
+
+DROP INDEX IF EXISTS idx_attachments_sha256;
+DROP TABLE IF EXISTS attachments;
@@ -0,0 +1,40 @@
+-- Migration 023: One row per uploaded file instead of comma-separated reports.photo_path
+CREATE TABLE attachments (
+    id SERIAL PRIMARY KEY,
+    report_id INTEGER NOT NULL REFERENCES reports(id) ON DELETE CASCADE,
+    position SMALLINT NOT NULL DEFAULT 0,
+    path VARCHAR(500) NOT NULL,
+    original_name VARCHAR(255),
+    content_type VARCHAR(100),
+    byte_size BIGINT,
+    sha256 CHAR(64),
+    width INTEGER,
+    height INTEGER,
+    duration_seconds REAL,
+    thumbnail_path VARCHAR(500),
+    thumbnail_status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (thumbnail_status IN ('pending', 'ready', 'failed', 'none')),
+    uploader_role VARCHAR(20) NOT NULL DEFAULT 'reporter'
+        CHECK (uploader_role IN ('reporter', 'agency', 'moderator')),
+    created_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE (report_id, position)
+);
+
+CREATE INDEX idx_attachments_sha256 ON attachments(sha256);
+
+-- Split the existing paths keeping their order; size, hash and dimensions are filled in by
+-- the attachments:backfill command, which reads the files
+INSERT INTO attachments (report_id, position, path, created_at)
+SELECT id, ROW_NUMBER() OVER (PARTITION BY id ORDER BY ordinality) - 1, path, created_at
+FROM (
+    SELECT r.id, r.created_at, btrim(p.path) AS path, p.ordinality
+    FROM reports r, unnest(string_to_array(r.photo_path, ',')) WITH ORDINALITY AS p(path, ordinality)
+    WHERE r.photo_path IS NOT NULL
+) paths
+WHERE path <> '';
+
+ALTER TABLE reports DROP COLUMN photo_path;
+
+COMMENT ON TABLE attachments IS 'Files uploaded as evidence of a report, in the order they were sent';
+COMMENT ON COLUMN attachments.path IS 'Path under the uploads directory, as served from /uploads/';
+COMMENT ON COLUMN attachments.uploader_role IS 'Who uploaded the file: the reporter, the responsible agency or a moderator';
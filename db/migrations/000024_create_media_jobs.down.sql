@@ -0,0 +1,4 @@
+-- Migration 024: Rollback media processing job queue
+DROP INDEX IF EXISTS idx_media_jobs_status;
+DROP INDEX IF EXISTS idx_media_jobs_due;
+DROP TABLE IF EXISTS media_jobs;
@@ -0,0 +1,24 @@
+-- Migration 024: Create media processing job queue
+CREATE TABLE media_jobs (
+    id BIGSERIAL PRIMARY KEY,
+    attachment_id INTEGER NOT NULL REFERENCES attachments(id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL,
+    status VARCHAR(10) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'done', 'dead')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL DEFAULT 3,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    locked_at TIMESTAMP,
+    last_error TEXT,
+    created_at TIMESTAMP DEFAULT NOW(),
+    finished_at TIMESTAMP,
+    UNIQUE (attachment_id, kind)
+);
+
+-- Partial index for workers claiming the next due job
+CREATE INDEX IF NOT EXISTS idx_media_jobs_due ON media_jobs(next_attempt_at) WHERE status = 'pending';
+
+-- Index for listing jobs by state in the CLI
+CREATE INDEX IF NOT EXISTS idx_media_jobs_status ON media_jobs(status, created_at DESC);
+
+COMMENT ON COLUMN media_jobs.kind IS 'Processing step, such as thumbnail; each attachment has at most one job of each kind';
+COMMENT ON COLUMN media_jobs.status IS 'pending, running (claimed by a worker), done, or dead after max_attempts failures';
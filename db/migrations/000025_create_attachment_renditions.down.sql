@@ -0,0 +1,2 @@
+-- Migration 025: Rollback attachment renditions
+DROP TABLE IF EXISTS attachment_renditions;
@@ -0,0 +1,15 @@
+-- Migration 025: Create resized renditions of image attachments
+CREATE TABLE attachment_renditions (
+    id SERIAL PRIMARY KEY,
+    attachment_id INTEGER NOT NULL REFERENCES attachments(id) ON DELETE CASCADE,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    format VARCHAR(10) NOT NULL CHECK (format IN ('jpeg', 'webp', 'avif')),
+    path VARCHAR(500) NOT NULL,
+    byte_size BIGINT NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE (attachment_id, width, format)
+);
+
+COMMENT ON TABLE attachment_renditions IS 'Resized copies of image attachments, served through srcset';
+COMMENT ON COLUMN attachment_renditions.format IS 'jpeg is always made; webp and avif only where an encoder is installed';
@@ -0,0 +1,6 @@
+-- Migration 026: Rollback video transcoding
+ALTER TABLE attachments
+    DROP COLUMN IF EXISTS transcode_status,
+    DROP COLUMN IF EXISTS sprite_path,
+    DROP COLUMN IF EXISTS poster_path,
+    DROP COLUMN IF EXISTS playback_path;
@@ -0,0 +1,13 @@
+-- Migration 026: Web-safe playback copy, poster and preview sprite of video attachments
+ALTER TABLE attachments
+    ADD COLUMN playback_path VARCHAR(500),
+    ADD COLUMN poster_path VARCHAR(500),
+    ADD COLUMN sprite_path VARCHAR(500),
+    ADD COLUMN transcode_status VARCHAR(20) NOT NULL DEFAULT 'none'
+        CHECK (transcode_status IN ('pending', 'ready', 'failed', 'none'));
+
+-- Existing videos are queued by thumbnails:regenerate
+UPDATE attachments SET transcode_status = 'pending' WHERE content_type LIKE 'video/%';
+
+COMMENT ON COLUMN attachments.playback_path IS 'H.264/AAC MP4 made from the original video, which is kept as uploaded';
+COMMENT ON COLUMN attachments.sprite_path IS 'Frames spread over the video in one row, for scrubbing previews';
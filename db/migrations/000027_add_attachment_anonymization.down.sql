@@ -0,0 +1,6 @@
+-- Migration 027: Rollback attachment anonymization
+ALTER TABLE attachments
+    DROP COLUMN IF EXISTS blurred_plates,
+    DROP COLUMN IF EXISTS blurred_faces,
+    DROP COLUMN IF EXISTS anonymize_status,
+    DROP COLUMN IF EXISTS original_path;
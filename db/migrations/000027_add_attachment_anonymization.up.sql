@@ -0,0 +1,9 @@
+-- Migration 027: Blurred public copies of photos, with the original kept restricted
+ALTER TABLE attachments
+    ADD COLUMN original_path VARCHAR(500),
+    ADD COLUMN anonymize_status VARCHAR(20) NOT NULL DEFAULT 'none'
+        CHECK (anonymize_status IN ('pending', 'ready', 'failed', 'none')),
+    ADD COLUMN blurred_faces INTEGER,
+    ADD COLUMN blurred_plates INTEGER;
+
+COMMENT ON COLUMN attachments.original_path IS 'Unblurred photo, served only to moderators and agencies; path holds the public copy once anonymize_status is ready';
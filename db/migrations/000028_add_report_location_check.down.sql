@@ -0,0 +1,2 @@
+-- Migration 028: Rollback report location check
+ALTER TABLE reports DROP COLUMN IF EXISTS location_check;
@@ -0,0 +1,6 @@
+-- Migration 028: Whether the photos of a report confirm where and when it was made
+ALTER TABLE reports
+    ADD COLUMN location_check VARCHAR(20) NOT NULL DEFAULT 'unverified'
+        CHECK (location_check IN ('verified', 'far', 'old', 'unverified'));
+
+COMMENT ON COLUMN reports.location_check IS 'Derived from the GPS and capture time of the photos before their metadata is stripped; the metadata itself is never stored';
@@ -0,0 +1,4 @@
+-- Migration 029: Rollback resumable uploads
+DROP TABLE IF EXISTS resumable_uploads;
+DELETE FROM attachments WHERE report_id IS NULL;
+ALTER TABLE attachments ALTER COLUMN report_id SET NOT NULL;
@@ -0,0 +1,27 @@
+-- Migration 029: Resumable uploads, sent in chunks before the report form is submitted
+CREATE TABLE resumable_uploads (
+    id CHAR(32) PRIMARY KEY,
+    category VARCHAR(100) NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    filetype VARCHAR(100),
+    upload_length BIGINT NOT NULL CHECK (upload_length > 0),
+    upload_offset BIGINT NOT NULL DEFAULT 0,
+    attachment_id INTEGER REFERENCES attachments(id) ON DELETE CASCADE,
+    location_check VARCHAR(20),
+    location_check_latitude DOUBLE PRECISION,
+    location_check_longitude DOUBLE PRECISION,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- Index for the cleanup of expired uploads
+CREATE INDEX IF NOT EXISTS idx_resumable_uploads_expires_at ON resumable_uploads(expires_at);
+
+-- Completed uploads are attachments without a report until the form that references them is sent
+ALTER TABLE attachments ALTER COLUMN report_id DROP NOT NULL;
+
+COMMENT ON TABLE resumable_uploads IS 'Uploads in progress, staged under uploads/staging/ until all their bytes arrive';
+COMMENT ON COLUMN resumable_uploads.id IS 'Random token; whoever holds it can resume the upload or attach it to a report';
+COMMENT ON COLUMN resumable_uploads.attachment_id IS 'Attachment made from the file once complete, claimed by the report form';
+COMMENT ON COLUMN resumable_uploads.location_check IS 'Location check of the photo against the pin of the form, NULL for other files; the GPS and capture time of the photo are not stored';
+COMMENT ON COLUMN resumable_uploads.location_check_latitude IS 'Pin the check was made against; NULL when none was placed and only the capture time counted';
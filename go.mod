@@ -5,5 +5,6 @@ go 1.24.4
 require (
 	github.com/gorilla/mux v1.8.1
 	github.com/lib/pq v1.10.9
+	golang.org/x/image v0.25.0
 	gopkg.in/yaml.v3 v3.0.1
 )
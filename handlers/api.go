@@ -5,8 +5,11 @@ import (
 	"log"
 	"net/http"
 	"olhourbano2/db"
+	"olhourbano2/models"
 	"olhourbano2/services"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // CPFVerificationRequest represents the incoming JSON request
@@ -101,9 +104,12 @@ func MapReportsHandler(w http.ResponseWriter, r *http.Request) {
 	category := r.URL.Query().Get("category")
 	status := r.URL.Query().Get("status")
 	city := r.URL.Query().Get("city")
+	neighborhood := r.URL.Query().Get("neighborhood")
+	neighborhoodID := resolveNeighborhoodID(neighborhood)
+	query := services.NormalizeSearchQuery(r.URL.Query().Get("q"))
 
 	// Fetch reports with location data
-	reports, err := services.GetReportsForMap(db.DB, category, status, city)
+	reports, err := services.GetReportsForMap(db.DB, category, status, city, neighborhoodID, query)
 	if err != nil {
 		log.Printf("Error fetching reports for map: %v", err)
 		response := MapReportsResponse{
@@ -113,6 +119,9 @@ func MapReportsHandler(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(response)
 		return
 	}
+	if err := services.LoadReportAttachments(db.DB, reports); err != nil {
+		log.Printf("Error fetching attachments for map: %v", err)
+	}
 
 	// Convert to map format
 	mapReports := make([]MapReportData, 0, len(reports))
@@ -129,18 +138,13 @@ func MapReportsHandler(w http.ResponseWriter, r *http.Request) {
 				hashedCPFDisplay = report.HashedCPF
 			}
 
-			// Process photos
+			// Paths of the evidence files, in upload order; videos point at their playable copy, which
+			// keeps the name the thumbnail is found by, and photos still being blurred are left out
 			var photos []string
-			if report.PhotoPath != "" {
-				photos = strings.Split(report.PhotoPath, ",")
-				// Clean up any empty strings
-				var cleanedPhotos []string
-				for _, photo := range photos {
-					if strings.TrimSpace(photo) != "" {
-						cleanedPhotos = append(cleanedPhotos, strings.TrimSpace(photo))
-					}
+			for _, attachment := range report.Attachments {
+				if attachment.Published() {
+					photos = append(photos, strings.TrimPrefix(attachment.PlaybackURL(), "/"))
 				}
-				photos = cleanedPhotos
 			}
 
 			mapReports = append(mapReports, MapReportData{
@@ -405,9 +409,11 @@ func ShareImageHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 type StatsResponse struct {
-	Success        bool `json:"success"`
-	TotalReports   int  `json:"total_reports"`
-	ActiveCitizens int  `json:"active_citizens"`
+	Success        bool                        `json:"success"`
+	TotalReports   int                         `json:"total_reports"`
+	ActiveCitizens int                         `json:"active_citizens"`
+	Neighborhood   *models.NeighborhoodStats   `json:"neighborhood,omitempty"`
+	Neighborhoods  []*models.NeighborhoodStats `json:"neighborhoods,omitempty"`
 }
 
 func StatsHandler(w http.ResponseWriter, r *http.Request) {
@@ -444,5 +450,119 @@ func StatsHandler(w http.ResponseWriter, r *http.Request) {
 		ActiveCitizens: activeCitizens,
 	}
 
+	// Stats for a single neighborhood
+	if slug := r.URL.Query().Get("neighborhood"); slug != "" {
+		neighborhood, err := services.GetNeighborhoodBySlug(db, slug)
+		if err != nil {
+			http.Error(w, "Neighborhood not found", http.StatusNotFound)
+			return
+		}
+
+		response.Neighborhood, err = services.GetNeighborhoodStats(db, neighborhood)
+		if err != nil {
+			log.Printf("Error getting neighborhood stats: %v", err)
+			http.Error(w, "Error getting statistics", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Neighborhood ranking, optionally restricted to a city
+	if r.URL.Query().Get("ranking") == "neighborhoods" || r.URL.Query().Get("city") != "" {
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		if limit <= 0 || limit > 100 {
+			limit = 20
+		}
+
+		response.Neighborhoods, err = services.GetNeighborhoodRanking(db, r.URL.Query().Get("city"), limit)
+		if err != nil {
+			log.Printf("Error getting neighborhood ranking: %v", err)
+			// Continue without ranking
+			response.Neighborhoods = nil
+		}
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// SimilarReportsResponse represents the response for the duplicate check
+type SimilarReportsResponse struct {
+	Success bool                    `json:"success"`
+	Message string                  `json:"message,omitempty"`
+	Reports []*models.SimilarReport `json:"reports"`
+}
+
+// SimilarReportsHandler returns existing reports that may describe the same problem as a report being written
+func SimilarReportsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	category := r.URL.Query().Get("category")
+	latitude, latErr := strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
+	longitude, lngErr := strconv.ParseFloat(r.URL.Query().Get("lng"), 64)
+	if category == "" || latErr != nil || lngErr != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(SimilarReportsResponse{
+			Success: false,
+			Message: "Categoria e coordenadas são obrigatórias",
+			Reports: []*models.SimilarReport{},
+		})
+		return
+	}
+
+	reports, err := services.FindSimilarReports(db.DB, category, latitude, longitude, r.URL.Query().Get("description"), 5)
+	if err != nil {
+		log.Printf("Error finding similar reports: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(SimilarReportsResponse{
+			Success: false,
+			Message: "Erro ao buscar denúncias semelhantes",
+			Reports: []*models.SimilarReport{},
+		})
+		return
+	}
+	if reports == nil {
+		reports = []*models.SimilarReport{}
+	}
+
+	json.NewEncoder(w).Encode(SimilarReportsResponse{
+		Success: true,
+		Reports: reports,
+	})
+}
+
+// PhotoLocationResponse represents the response for the location of a photo
+type PhotoLocationResponse struct {
+	Success     bool    `json:"success"`
+	Message     string  `json:"message,omitempty"`
+	HasLocation bool    `json:"has_location"`
+	Latitude    float64 `json:"latitude,omitempty"`
+	Longitude   float64 `json:"longitude,omitempty"`
+	TakenAt     string  `json:"taken_at,omitempty"`
+}
+
+// PhotoLocationHandler reads where and when a photo was taken, so the form can place the pin
+// before the photo is sent. The body is the start of the image file, where the EXIF block is;
+// nothing is stored.
+func PhotoLocationHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	capture, err := services.ReadPhotoCapture(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(PhotoLocationResponse{
+			Success: false,
+			Message: "Erro ao ler a foto",
+		})
+		return
+	}
+
+	response := PhotoLocationResponse{Success: true}
+	if capture != nil {
+		response.HasLocation = capture.HasLocation
+		response.Latitude = capture.Latitude
+		response.Longitude = capture.Longitude
+		if !capture.TakenAt.IsZero() {
+			response.TakenAt = capture.TakenAt.Format(time.RFC3339)
+		}
+	}
 	json.NewEncoder(w).Encode(response)
 }
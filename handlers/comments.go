@@ -1,13 +1,19 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"net/http"
 	"olhourbano2/db"
 	"olhourbano2/models"
 	"olhourbano2/services"
 	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
 )
 
 // CommentRequest represents a request to create a comment
@@ -16,15 +22,17 @@ type CommentRequest struct {
 	CPF       string `json:"cpf"`
 	BirthDate string `json:"birth_date"`
 	Content   string `json:"content"`
+	ParentID  int    `json:"parent_id,omitempty"` // Comment being replied to
 }
 
 // CommentResponse represents the response for comment operations
 type CommentResponse struct {
-	Success bool                `json:"success"`
-	Message string              `json:"message,omitempty"`
-	Comment  *models.Comment    `json:"comment,omitempty"`
+	Success  bool                     `json:"success"`
+	Message  string                   `json:"message,omitempty"`
+	Comment  *models.Comment          `json:"comment,omitempty"`
 	Comments []*models.CommentDisplay `json:"comments,omitempty"`
-	Total    int                `json:"total,omitempty"`
+	Total    int                      `json:"total,omitempty"`
+	Threads  int                      `json:"threads,omitempty"` // Top-level comments, which are paged
 }
 
 // CreateCommentHandler handles comment creation
@@ -51,7 +59,7 @@ func CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Comment content exceeds 500 character limit", http.StatusBadRequest)
 		return
 	}
-	
+
 	// Convert birth date format for CPF verification
 	birthDateForVerification, err := services.ConvertBirthDateToDBFormat(req.BirthDate)
 	if err != nil {
@@ -74,9 +82,23 @@ func CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Hash the CPF
 	hashedCPF := services.HashCPF(req.CPF)
+	setCitizenSession(w, r, hashedCPF)
 
 	// Create the comment
-	comment, err := services.CreateComment(db.DB, req.ReportID, hashedCPF, req.Content)
+	comment, err := services.CreateComment(db.DB, req.ReportID, req.ParentID, hashedCPF, req.Content)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Replied comment not found", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, services.ErrCommentRejected) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(CommentResponse{
+			Success: false,
+			Message: "Seu comentário não pode ser publicado por violar as regras de convivência",
+		})
+		return
+	}
 	if err != nil {
 		log.Printf("Error creating comment: %v", err)
 		http.Error(w, "Error creating comment", http.StatusInternalServerError)
@@ -87,7 +109,10 @@ func CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
 	response := CommentResponse{
 		Success: true,
 		Message: "Comment created successfully",
-		Comment:  comment,
+		Comment: comment,
+	}
+	if comment.Status != models.CommentStatusVisible {
+		response.Message = "Seu comentário será publicado após revisão da moderação"
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -125,6 +150,9 @@ func GetCommentsHandler(w http.ResponseWriter, r *http.Request) {
 
 	limit := 20 // Default limit for infinite scroll
 	offset := 0
+	if o, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && o > 0 {
+		offset = o
+	}
 
 	var comments []*models.CommentDisplay
 	comments, err = services.GetCommentsForReport(db.DB, reportID, sort, limit, offset)
@@ -134,6 +162,9 @@ func GetCommentsHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Error retrieving comments", http.StatusInternalServerError)
 		return
 	}
+	if hashedCPF, ok := citizenFromSession(r); ok {
+		services.MarkOwnComments(comments, hashedCPF)
+	}
 
 	// Get total comment count
 	total, err := services.GetCommentCountForReport(db.DB, reportID)
@@ -143,14 +174,269 @@ func GetCommentsHandler(w http.ResponseWriter, r *http.Request) {
 		total = len(comments)
 	}
 
+	threads, err := services.GetCommentThreadCountForReport(db.DB, reportID)
+	if err != nil {
+		log.Printf("Error getting comment thread count: %v", err)
+		threads = offset + len(comments)
+	}
+
 	response := CommentResponse{
 		Success:  true,
 		Comments: comments,
 		Total:    total,
+		Threads:  threads,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetCommentRepliesHandler returns a page of the replies to a comment
+func GetCommentRepliesHandler(w http.ResponseWriter, r *http.Request) {
+	commentID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return
+	}
+
+	limit := 20
+	offset := 0
+	if o, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && o > 0 {
+		offset = o
+	}
+
+	replies, err := services.GetCommentReplies(db.DB, commentID, limit, offset)
+	if err != nil {
+		log.Printf("Error getting replies of comment %d: %v", commentID, err)
+		http.Error(w, "Error retrieving comments", http.StatusInternalServerError)
+		return
+	}
+	if hashedCPF, ok := citizenFromSession(r); ok {
+		services.MarkOwnComments(replies, hashedCPF)
+	}
+
+	response := CommentResponse{
+		Success:  true,
+		Comments: replies,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// FlagCommentRequest represents a citizen request to flag a comment
+type FlagCommentRequest struct {
+	CPF       string `json:"cpf"`
+	BirthDate string `json:"birth_date"`
+	Reason    string `json:"reason"`
+	Detail    string `json:"detail"`
+}
+
+// FlagCommentHandler records a citizen flag on a comment, hiding it for review after enough flags
+func FlagCommentHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	commentID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(CommentResponse{Success: false, Message: "ID de comentário inválido"})
+		return
+	}
 
+	var req FlagCommentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(CommentResponse{Success: false, Message: "Formato de requisição inválido"})
+		return
+	}
+	if _, ok := models.CommentFlagReasons[req.Reason]; !ok || req.CPF == "" || req.BirthDate == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(CommentResponse{Success: false, Message: "Informe o motivo, o CPF e a data de nascimento"})
+		return
+	}
+
+	birthDateForVerification, err := services.ConvertBirthDateToDBFormat(req.BirthDate)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(CommentResponse{Success: false, Message: "Data de nascimento: " + err.Error()})
+		return
+	}
+	verification, err := services.VerifyCPFWithBirthDate(req.CPF, birthDateForVerification)
+	if err != nil {
+		log.Printf("Error verifying CPF for comment flag: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(CommentResponse{Success: false, Message: "Erro ao verificar CPF"})
+		return
+	}
+	if !verification.Success || !verification.Valid {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(CommentResponse{Success: false, Message: "CPF ou data de nascimento inválidos"})
+		return
+	}
+
+	hidden, err := services.FlagComment(db.DB, commentID, services.HashCPF(req.CPF), req.Reason, req.Detail)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(CommentResponse{Success: false, Message: "Comentário não encontrado"})
+		return
+	}
+	if errors.Is(err, services.ErrOwnCommentFlag) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(CommentResponse{Success: false, Message: "Você não pode denunciar seu próprio comentário"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error flagging comment %d: %v", commentID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(CommentResponse{Success: false, Message: "Erro ao denunciar comentário"})
+		return
+	}
+
+	message := "Obrigado! A moderação vai analisar este comentário"
+	if hidden {
+		message = "Obrigado! O comentário foi ocultado até a revisão da moderação"
+	}
+	json.NewEncoder(w).Encode(CommentResponse{Success: true, Message: message})
+}
+
+// CommentAuthorRequest represents an author request to edit or delete a comment. CPF and birth
+// date are only needed when there is no citizen session.
+type CommentAuthorRequest struct {
+	CPF       string `json:"cpf"`
+	BirthDate string `json:"birth_date"`
+	Content   string `json:"content"`
+}
+
+// commentAuthor identifies the citizen behind an edit or delete, from the session or a CPF check.
+// It writes the error response and returns false when the citizen can't be verified.
+func commentAuthor(w http.ResponseWriter, r *http.Request, req CommentAuthorRequest) (string, bool) {
+	if hashedCPF, ok := citizenFromSession(r); ok && req.CPF == "" {
+		return hashedCPF, true
+	}
+	if req.CPF == "" || req.BirthDate == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(CommentResponse{Success: false, Message: "Informe o CPF e a data de nascimento"})
+		return "", false
+	}
+
+	birthDateForVerification, err := services.ConvertBirthDateToDBFormat(req.BirthDate)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(CommentResponse{Success: false, Message: "Data de nascimento: " + err.Error()})
+		return "", false
+	}
+	verification, err := services.VerifyCPFWithBirthDate(req.CPF, birthDateForVerification)
+	if err != nil {
+		log.Printf("Error verifying CPF for comment author: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(CommentResponse{Success: false, Message: "Erro ao verificar CPF"})
+		return "", false
+	}
+	if !verification.Success || !verification.Valid {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(CommentResponse{Success: false, Message: "CPF ou data de nascimento inválidos"})
+		return "", false
+	}
+
+	hashedCPF := services.HashCPF(req.CPF)
+	setCitizenSession(w, r, hashedCPF)
+	return hashedCPF, true
+}
+
+// writeCommentAuthorError answers the errors shared by comment edits and deletions
+func writeCommentAuthorError(w http.ResponseWriter, commentID int, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(CommentResponse{Success: false, Message: "Comentário não encontrado"})
+	case errors.Is(err, services.ErrCommentNotOwner):
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(CommentResponse{Success: false, Message: "Só o autor pode alterar este comentário"})
+	case errors.Is(err, services.ErrCommentEditExpired):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(CommentResponse{Success: false, Message: "O prazo para editar este comentário já passou"})
+	case errors.Is(err, services.ErrCommentNotEditable):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(CommentResponse{Success: false, Message: "Este comentário não pode mais ser editado"})
+	case errors.Is(err, services.ErrCommentRejected):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(CommentResponse{Success: false, Message: "Seu comentário não pode ser publicado por violar as regras de convivência"})
+	default:
+		log.Printf("Error changing comment %d: %v", commentID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(CommentResponse{Success: false, Message: "Erro ao alterar comentário"})
+	}
+}
+
+// EditCommentHandler lets authors fix their comment within the edit window
+func EditCommentHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	commentID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(CommentResponse{Success: false, Message: "ID de comentário inválido"})
+		return
+	}
+
+	var req CommentAuthorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(CommentResponse{Success: false, Message: "Formato de requisição inválido"})
+		return
+	}
+	req.Content = strings.TrimSpace(req.Content)
+	if req.Content == "" || len(req.Content) > 500 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(CommentResponse{Success: false, Message: "O comentário deve ter entre 1 e 500 caracteres"})
+		return
+	}
+
+	hashedCPF, ok := commentAuthor(w, r, req)
+	if !ok {
+		return
+	}
+
+	comment, err := services.EditComment(db.DB, commentID, hashedCPF, req.Content)
+	if err != nil {
+		writeCommentAuthorError(w, commentID, err)
+		return
+	}
+
+	message := "Comentário editado"
+	if comment.Status != models.CommentStatusVisible {
+		message = "Seu comentário será publicado após revisão da moderação"
+	}
+	json.NewEncoder(w).Encode(CommentResponse{Success: true, Message: message, Comment: comment})
+}
+
+// DeleteCommentHandler lets authors delete their comment, leaving a placeholder for its replies
+func DeleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	commentID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(CommentResponse{Success: false, Message: "ID de comentário inválido"})
+		return
+	}
+
+	// An empty body is fine when the citizen has a session
+	var req CommentAuthorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(CommentResponse{Success: false, Message: "Formato de requisição inválido"})
+		return
+	}
+
+	hashedCPF, ok := commentAuthor(w, r, req)
+	if !ok {
+		return
+	}
+
+	if err := services.DeleteComment(db.DB, commentID, hashedCPF); err != nil {
+		writeCommentAuthorError(w, commentID, err)
+		return
+	}
+	json.NewEncoder(w).Encode(CommentResponse{Success: true, Message: "Comentário excluído"})
+}
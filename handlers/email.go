@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"olhourbano2/config"
+	"olhourbano2/db"
+	"olhourbano2/services"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// maxBounceRequestBytes bounds the bounce webhook body, which may carry a whole bounced message
+const maxBounceRequestBytes = 1 << 20
+
+// BounceResponse represents the response of the bounce webhook
+type BounceResponse struct {
+	Success    bool   `json:"success"`
+	Message    string `json:"message,omitempty"`
+	Suppressed int    `json:"suppressed"`
+}
+
+// VerifyEmailHandler confirms an email address from the link in the verification email
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	email, err := services.VerifyEmail(db.DB, mux.Vars(r)["token"])
+
+	data := map[string]interface{}{
+		"PageTitle":   "Email confirmado",
+		"Section":     "verified",
+		"Email":       email,
+		"CurrentPage": "email",
+	}
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error verifying email: %v", err)
+		}
+		w.WriteHeader(http.StatusNotFound)
+		data["PageTitle"] = "Link inválido"
+		data["Section"] = "invalid"
+	}
+
+	if err := renderTemplate(w, "11_email_verification.html", data); err != nil {
+		log.Printf("Error rendering email verification template: %s", err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// BounceWebhookHandler ingests bounces and complaints from the mail provider. It accepts a JSON
+// list of {email, type, detail} events, or a raw delivery status notification as message/rfc822.
+func BounceWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cfg, err := config.Load()
+	if err != nil || cfg.BounceToken == "" {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(BounceResponse{Success: false, Message: "Webhook desativado"})
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.BounceToken)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(BounceResponse{Success: false, Message: "Não autorizado"})
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxBounceRequestBytes)
+	var events []services.BounceEvent
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "message/rfc822") {
+		events, err = services.ParseBounceMessage(body)
+	} else {
+		var data []byte
+		if data, err = io.ReadAll(body); err == nil {
+			err = json.Unmarshal(data, &events)
+		}
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(BounceResponse{Success: false, Message: "Formato de requisição inválido"})
+		return
+	}
+
+	suppressed := 0
+	for _, event := range events {
+		ok, err := services.ProcessBounce(db.DB, event)
+		if err != nil {
+			log.Printf("Error processing bounce for %s: %v", event.Email, err)
+			continue
+		}
+		if ok {
+			suppressed++
+		}
+	}
+
+	json.NewEncoder(w).Encode(BounceResponse{Success: true, Suppressed: suppressed})
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"html/template"
 	"log"
 	"net/http"
 	"olhourbano2/config"
@@ -26,9 +27,16 @@ func FeedHandler(w http.ResponseWriter, r *http.Request) {
 	category := r.URL.Query().Get("category")
 	status := r.URL.Query().Get("status")
 	city := r.URL.Query().Get("city")
+	neighborhood := r.URL.Query().Get("neighborhood")
+	neighborhoodID := resolveNeighborhoodID(neighborhood)
+	query := services.NormalizeSearchQuery(r.URL.Query().Get("q"))
+	latitude, longitude, hasLocation := parseLocationParams(r)
 	sort := r.URL.Query().Get("sort")
 	if sort == "" {
 		sort = "recent" // default sort
+		if query != "" {
+			sort = "relevance" // best matches first when searching
+		}
 	}
 
 	// Get categories for filter dropdown
@@ -41,8 +49,18 @@ func FeedHandler(w http.ResponseWriter, r *http.Request) {
 		cities = []string{} // Continue with empty cities list
 	}
 
+	// Get neighborhoods for the selected city (only shown once a city is chosen)
+	var neighborhoods []*models.Neighborhood
+	if city != "" {
+		neighborhoods, err = services.GetNeighborhoods(db.DB, city)
+		if err != nil {
+			log.Printf("Error fetching neighborhoods: %v", err)
+			neighborhoods = nil
+		}
+	}
+
 	// Fetch reports from database
-	reports, err := services.GetReports(db.DB, page, category, status, city, sort, ReportsPerPage)
+	reports, err := services.GetReports(db.DB, page, category, status, city, neighborhoodID, query, sort, latitude, longitude, ReportsPerPage)
 	if err != nil {
 		log.Printf("Error fetching reports: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -50,7 +68,7 @@ func FeedHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get total count for pagination
-	totalReports, err := services.GetTotalReports(db.DB, category, status, city)
+	totalReports, err := services.GetTotalReports(db.DB, category, status, city, neighborhoodID, query)
 	if err != nil {
 		log.Printf("Error getting total reports: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -91,9 +109,15 @@ func FeedHandler(w http.ResponseWriter, r *http.Request) {
 		"Category":       category,
 		"Status":         status,
 		"City":           city,
+		"Neighborhood":   neighborhood,
+		"Query":          query,
 		"Sort":           sort,
+		"HasLocation":    hasLocation,
+		"Latitude":       latitude,
+		"Longitude":      longitude,
 		"Categories":     categories,
 		"Cities":         cities,
+		"Neighborhoods":  neighborhoods,
 		"Reports":        processedReports,
 		"TotalReports":   totalReports,
 		"TotalPages":     totalPages,
@@ -116,10 +140,27 @@ func FeedHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// parseLocationParams reads the lat/lng query parameters used by the "nearby" sort
+func parseLocationParams(r *http.Request) (float64, float64, bool) {
+	latitude, err := strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
+	if err != nil || latitude < -90 || latitude > 90 {
+		return 0, 0, false
+	}
+	longitude, err := strconv.ParseFloat(r.URL.Query().Get("lng"), 64)
+	if err != nil || longitude < -180 || longitude > 180 {
+		return 0, 0, false
+	}
+	return latitude, longitude, true
+}
+
 // processReportsForTemplate converts database reports to template-friendly format
 func processReportsForTemplate(reports []*models.Report) []map[string]interface{} {
 	var processed []map[string]interface{}
 
+	if err := services.LoadReportAttachments(db.DB, reports); err != nil {
+		log.Printf("Error fetching report attachments: %v", err)
+	}
+
 	for _, report := range reports {
 		// Get category info
 		category := config.GetCategory(report.ProblemType)
@@ -133,19 +174,6 @@ func processReportsForTemplate(reports []*models.Report) []map[string]interface{
 		// Get status text
 		statusText := getStatusText(report.Status)
 
-		// Process photos
-		var photos []string
-		if report.PhotoPath != "" {
-			// Split by comma and trim whitespace
-			rawPhotos := strings.Split(report.PhotoPath, ",")
-			for _, photo := range rawPhotos {
-				trimmed := strings.TrimSpace(photo)
-				if trimmed != "" {
-					photos = append(photos, trimmed)
-				}
-			}
-		}
-
 		// Process transport info
 		transportTypeName := ""
 		transportDetails := ""
@@ -171,8 +199,8 @@ func processReportsForTemplate(reports []*models.Report) []map[string]interface{
 			"StatusText":        statusText,
 			"Location":          report.Location,
 			"Description":       report.Description,
-			"PhotoPath":         report.PhotoPath,
-			"Photos":            photos,
+			"Snippet":           template.HTML(report.Snippet), // Escaped by services.highlightSnippet
+			"Attachments":       report.Attachments,
 			"TransportType":     report.TransportType,
 			"TransportTypeName": transportTypeName,
 			"TransportDetails":  transportDetails,
@@ -188,14 +216,7 @@ func processReportsForTemplate(reports []*models.Report) []map[string]interface{
 
 // getStatusText returns human-readable status text
 func getStatusText(status string) string {
-	switch status {
-	case "pending":
-		return "Pendente"
-	case "approved":
-		return "Resolvida"
-	default:
-		return "Pendente"
-	}
+	return services.ReportStatusLabel(status)
 }
 
 // getTransportTypeName returns human-readable transport type name
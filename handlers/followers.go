@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"olhourbano2/db"
+	"olhourbano2/models"
+	"olhourbano2/services"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// FollowRequest represents a request to follow a report
+type FollowRequest struct {
+	ReportID   int    `json:"report_id"`
+	CPF        string `json:"cpf"`
+	BirthDate  string `json:"birth_date"`
+	Email      string `json:"email"`
+	Preference string `json:"preference"`
+}
+
+// FollowResponse represents the response for follow operations
+type FollowResponse struct {
+	Success    bool   `json:"success"`
+	Message    string `json:"message,omitempty"`
+	Preference string `json:"preference,omitempty"`
+}
+
+// FollowReportHandler lets citizens verified with CPF and birth date follow a report
+func FollowReportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req FollowRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(FollowResponse{Success: false, Message: "Formato de requisição inválido"})
+		return
+	}
+
+	if req.ReportID <= 0 || req.CPF == "" || req.BirthDate == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(FollowResponse{Success: false, Message: "CPF e data de nascimento são obrigatórios"})
+		return
+	}
+	if !services.ValidateEmail(req.Email) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(FollowResponse{Success: false, Message: "Email inválido"})
+		return
+	}
+	if req.Preference == "" {
+		req.Preference = models.FrequencyInstant
+	}
+	if !services.ValidFollowerPreference(req.Preference) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(FollowResponse{Success: false, Message: "Preferência inválida"})
+		return
+	}
+
+	report, err := services.GetReportByID(db.DB, req.ReportID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(FollowResponse{Success: false, Message: "Denúncia não encontrada"})
+		return
+	}
+	if report.MergedIntoID > 0 {
+		req.ReportID = report.MergedIntoID
+	}
+
+	hashedCPF := services.HashCPF(req.CPF)
+
+	// Everyone is checked with CPFHub, voters and the owner included: a CPF alone is easy to learn
+	birthDateForVerification, err := services.ConvertBirthDateToDBFormat(req.BirthDate)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(FollowResponse{Success: false, Message: "Data de nascimento: " + err.Error()})
+		return
+	}
+
+	verification, err := services.VerifyCPFWithBirthDate(req.CPF, birthDateForVerification)
+	if err != nil {
+		log.Printf("Error verifying CPF for follower: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(FollowResponse{Success: false, Message: "Erro ao verificar CPF"})
+		return
+	}
+	if !verification.Success || !verification.Valid {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(FollowResponse{Success: false, Message: "CPF ou data de nascimento inválidos"})
+		return
+	}
+
+	locale := services.EmailLocaleFromAcceptLanguage(r.Header.Get("Accept-Language"))
+	follower, err := services.FollowReport(db.DB, req.ReportID, hashedCPF, req.Email, req.Preference, locale)
+	if err != nil {
+		log.Printf("Error following report %d: %v", req.ReportID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(FollowResponse{Success: false, Message: "Erro ao seguir denúncia"})
+		return
+	}
+
+	message := "Você está acompanhando esta denúncia"
+	if follower.Email != strings.ToLower(strings.TrimSpace(req.Email)) {
+		message = "Preferências salvas. Confirme o novo email pelo link que enviamos; até lá, as notificações continuam indo para o email anterior"
+	} else if follower.Preference == models.FollowerPreferenceOff {
+		message = "Notificações desta denúncia desativadas"
+	} else {
+		verified, err := services.RequestEmailVerification(db.DB, follower.Email, locale)
+		if err != nil {
+			log.Printf("Error requesting email verification for follower %d: %v", follower.ID, err)
+		}
+		if !verified {
+			message = "Você está acompanhando esta denúncia. Confirme seu email pelo link que enviamos para começar a receber as notificações"
+		}
+	}
+	json.NewEncoder(w).Encode(FollowResponse{Success: true, Message: message, Preference: follower.Preference})
+}
+
+// ConfirmFollowerEmailHandler applies a new follower address from the link sent to it
+func ConfirmFollowerEmailHandler(w http.ResponseWriter, r *http.Request) {
+	email, err := services.ConfirmFollowerEmail(db.DB, mux.Vars(r)["token"])
+
+	data := map[string]interface{}{
+		"PageTitle":   "Email confirmado",
+		"Section":     "email_confirmed",
+		"Email":       email,
+		"CurrentPage": "following",
+	}
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error confirming follower email: %v", err)
+		}
+		w.WriteHeader(http.StatusNotFound)
+		data["PageTitle"] = "Link inválido"
+		data["Section"] = "invalid"
+	}
+
+	if err := renderTemplate(w, "10_following.html", data); err != nil {
+		log.Printf("Error rendering following template: %s", err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// FollowerPreferencesHandler shows and updates the notification preference of a follower from its email link
+func FollowerPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	var follower *models.ReportFollower
+	var err error
+	saved := false
+	if r.Method == http.MethodPost {
+		follower, err = services.SetFollowerPreference(db.DB, token, r.FormValue("preference"))
+		saved = err == nil
+	} else {
+		follower, err = services.GetFollowerByToken(db.DB, token)
+	}
+
+	renderFollowerPage(w, follower, err, saved)
+}
+
+// StopFollowingHandler turns off notifications for a follower in one click
+func StopFollowingHandler(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	follower, err := services.SetFollowerPreference(db.DB, token, models.FollowerPreferenceOff)
+
+	if r.Method == http.MethodPost {
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		json.NewEncoder(w).Encode(FollowResponse{Success: err == nil})
+		return
+	}
+
+	renderFollowerPage(w, follower, err, err == nil)
+}
+
+// renderFollowerPage renders the follower preferences page, or the invalid link message
+func renderFollowerPage(w http.ResponseWriter, follower *models.ReportFollower, err error, saved bool) {
+	data := map[string]interface{}{
+		"PageTitle":   "Acompanhar denúncia",
+		"Section":     "preferences",
+		"Saved":       saved,
+		"CurrentPage": "following",
+	}
+
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error loading follower: %v", err)
+		}
+		w.WriteHeader(http.StatusNotFound)
+		data["PageTitle"] = "Link inválido"
+		data["Section"] = "invalid"
+	} else {
+		data["Follower"] = follower
+	}
+
+	if err := renderTemplate(w, "10_following.html", data); err != nil {
+		log.Printf("Error rendering following template: %s", err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
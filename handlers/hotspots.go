@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"olhourbano2/config"
+	"olhourbano2/db"
+	"olhourbano2/services"
+	"strconv"
+)
+
+// GeoJSONFeatureCollection represents a GeoJSON FeatureCollection response
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+// GeoJSONFeature represents a single GeoJSON feature
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   GeoJSONPoint           `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// GeoJSONPoint represents a GeoJSON Point geometry
+type GeoJSONPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+// HotspotsHandler returns precomputed hotspots as GeoJSON for a category and time window
+func HotspotsHandler(w http.ResponseWriter, r *http.Request) {
+	category := r.URL.Query().Get("category")
+
+	windowDays := 30
+	if windowParam := r.URL.Query().Get("window"); windowParam != "" {
+		parsed, err := strconv.Atoi(windowParam)
+		if err != nil || !isHotspotWindow(parsed) {
+			http.Error(w, "Invalid window parameter", http.StatusBadRequest)
+			return
+		}
+		windowDays = parsed
+	}
+
+	if category != "" && config.GetCategory(category) == nil {
+		http.Error(w, "Invalid category parameter", http.StatusBadRequest)
+		return
+	}
+
+	hotspots, err := services.GetHotspots(db.DB, category, windowDays)
+	if err != nil {
+		log.Printf("Error fetching hotspots: %v", err)
+		http.Error(w, "Error fetching hotspots", http.StatusInternalServerError)
+		return
+	}
+
+	collection := GeoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: make([]GeoJSONFeature, 0, len(hotspots)),
+	}
+
+	for _, hotspot := range hotspots {
+		collection.Features = append(collection.Features, GeoJSONFeature{
+			Type: "Feature",
+			Geometry: GeoJSONPoint{
+				Type:        "Point",
+				Coordinates: [2]float64{hotspot.Longitude, hotspot.Latitude},
+			},
+			Properties: map[string]interface{}{
+				"id":            hotspot.ID,
+				"category":      hotspot.Category,
+				"window_days":   hotspot.WindowDays,
+				"radius_meters": hotspot.RadiusMeters,
+				"report_count":  hotspot.ReportCount,
+				"vote_count":    hotspot.VoteCount,
+				"score":         hotspot.Score,
+				"report_ids":    hotspot.ReportIDs,
+				"computed_at":   hotspot.ComputedAt,
+			},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	json.NewEncoder(w).Encode(collection)
+}
+
+// isHotspotWindow checks if a time window is one hotspots are computed for
+func isHotspotWindow(windowDays int) bool {
+	for _, window := range services.HotspotWindows {
+		if window == windowDays {
+			return true
+		}
+	}
+	return false
+}
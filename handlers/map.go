@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"olhourbano2/config"
 	"olhourbano2/db"
+	"olhourbano2/models"
 	"olhourbano2/services"
 )
 
@@ -14,6 +15,8 @@ func MapHandler(w http.ResponseWriter, r *http.Request) {
 	category := r.URL.Query().Get("category")
 	status := r.URL.Query().Get("status")
 	city := r.URL.Query().Get("city")
+	neighborhood := r.URL.Query().Get("neighborhood")
+	query := services.NormalizeSearchQuery(r.URL.Query().Get("q"))
 
 	// Get categories for filter dropdown
 	categories := config.GetAllCategories()
@@ -25,6 +28,16 @@ func MapHandler(w http.ResponseWriter, r *http.Request) {
 		cities = []string{}
 	}
 
+	// Get neighborhoods for the selected city
+	var neighborhoods []*models.Neighborhood
+	if city != "" {
+		neighborhoods, err = services.GetNeighborhoods(db.DB, city)
+		if err != nil {
+			log.Printf("Error fetching neighborhoods: %v", err)
+			neighborhoods = nil
+		}
+	}
+
 	// Get statistics
 	stats, err := services.GetReportStats(db.DB)
 	if err != nil {
@@ -49,8 +62,11 @@ func MapHandler(w http.ResponseWriter, r *http.Request) {
 		"Category":         category,
 		"Status":           status,
 		"City":             city,
+		"Neighborhood":     neighborhood,
+		"Query":            query,
 		"Categories":       categories,
 		"Cities":           cities,
+		"Neighborhoods":    neighborhoods,
 		"PageTitle":        "Mapa de Denúncias",
 		"GoogleMapsAPIKey": getGoogleMapsAPIKey(),
 		"TotalReports":     totalReports,
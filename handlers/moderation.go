@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"olhourbano2/config"
+	"olhourbano2/db"
+	"olhourbano2/models"
+	"olhourbano2/services"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const moderatorCookieName = "moderator_session"
+
+// MergeRequest represents a moderator request to merge a duplicate report into a canonical one
+type MergeRequest struct {
+	DuplicateID int `json:"duplicate_id"`
+	CanonicalID int `json:"canonical_id"`
+}
+
+// StatusUpdateRequest represents a moderator request to change a report status, with an optional official response
+type StatusUpdateRequest struct {
+	ReportID         int    `json:"report_id"`
+	Status           string `json:"status"`
+	OfficialResponse string `json:"official_response"`
+}
+
+// CommentReviewRequest represents a moderator decision on a comment in the review queue
+type CommentReviewRequest struct {
+	CommentID int    `json:"comment_id"`
+	Decision  string `json:"decision"`
+}
+
+// ModerationResponse represents the response for moderation actions
+type ModerationResponse struct {
+	Success bool                    `json:"success"`
+	Message string                  `json:"message,omitempty"`
+	Report  *models.ModeratorReport `json:"report,omitempty"`
+}
+
+// moderatorSessionValue derives the session cookie value from the moderator token
+func moderatorSessionValue(cfg *config.Config) string {
+	mac := hmac.New(sha256.New, []byte(cfg.SessionKey))
+	mac.Write([]byte(cfg.ModeratorToken))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// isModerator reports whether the request carries the moderator token or a valid moderator session
+func isModerator(r *http.Request) bool {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Printf("Error loading config for moderator check: %v", err)
+		return false
+	}
+	if cfg.ModeratorToken == "" {
+		return false
+	}
+
+	if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); token != "" {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.ModeratorToken)) == 1 {
+			return true
+		}
+	}
+
+	if cookie, err := r.Cookie(moderatorCookieName); err == nil {
+		return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(moderatorSessionValue(cfg))) == 1
+	}
+
+	return false
+}
+
+// RequireModerator restricts a handler to moderators. API requests get 401, pages redirect to the login.
+func RequireModerator(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isModerator(r) {
+			next(w, r)
+			return
+		}
+
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(ModerationResponse{
+				Success: false,
+				Message: "Acesso restrito a moderadores",
+			})
+			return
+		}
+
+		http.Redirect(w, r, "/moderacao/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+	}
+}
+
+// ModeratorLoginHandler shows the moderator login form and starts a moderator session
+func ModeratorLoginHandler(w http.ResponseWriter, r *http.Request) {
+	next := r.FormValue("next")
+	if !strings.HasPrefix(next, "/moderacao") {
+		next = "/moderacao/duplicados"
+	}
+
+	data := map[string]interface{}{
+		"PageTitle":   "Moderação",
+		"Section":     "login",
+		"Next":        next,
+		"CurrentPage": "moderation",
+	}
+
+	if r.Method == http.MethodPost {
+		cfg, err := config.Load()
+		if err != nil {
+			log.Printf("Error loading config for moderator login: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		token := r.FormValue("token")
+		if cfg.ModeratorToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.ModeratorToken)) == 1 {
+			http.SetCookie(w, &http.Cookie{
+				Name:     moderatorCookieName,
+				Value:    moderatorSessionValue(cfg),
+				Path:     "/",
+				Expires:  time.Now().Add(12 * time.Hour),
+				HttpOnly: true,
+				Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+				SameSite: http.SameSiteStrictMode,
+			})
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+
+		data["Error"] = "Token de moderação inválido"
+	}
+
+	if err := renderTemplate(w, "07_moderation.html", data); err != nil {
+		log.Printf("Error rendering moderation login template: %s", err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// ModeratorLogoutHandler ends the moderator session
+func ModeratorLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     moderatorCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, "/moderacao/login", http.StatusSeeOther)
+}
+
+// DuplicatesModerationHandler lists likely duplicate report pairs for moderators to merge
+func DuplicatesModerationHandler(w http.ResponseWriter, r *http.Request) {
+	candidates, err := services.FindDuplicateCandidates(db.DB, 50)
+	if err != nil {
+		log.Printf("Error finding duplicate candidates: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"PageTitle":   "Denúncias duplicadas",
+		"Section":     "duplicates",
+		"Candidates":  candidates,
+		"CurrentPage": "moderation",
+	}
+
+	if err := renderTemplate(w, "07_moderation.html", data); err != nil {
+		log.Printf("Error rendering duplicates moderation template: %s", err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// MergeReportsHandler merges a duplicate report into its canonical report
+func MergeReportsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req MergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ModerationResponse{Success: false, Message: "Formato de requisição inválido"})
+		return
+	}
+
+	if req.DuplicateID <= 0 || req.CanonicalID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ModerationResponse{Success: false, Message: "IDs de denúncia inválidos"})
+		return
+	}
+
+	if err := services.MergeReports(db.DB, req.DuplicateID, req.CanonicalID); err != nil {
+		log.Printf("Error merging report %d into %d: %v", req.DuplicateID, req.CanonicalID, err)
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ModerationResponse{Success: false, Message: err.Error()})
+		return
+	}
+
+	log.Printf("Report %d merged into report %d", req.DuplicateID, req.CanonicalID)
+	json.NewEncoder(w).Encode(ModerationResponse{Success: true, Message: "Denúncias unificadas com sucesso"})
+}
+
+// UpdateReportStatusHandler changes a report status and publishes an official response, notifying followers
+func UpdateReportStatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req StatusUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ModerationResponse{Success: false, Message: "Formato de requisição inválido"})
+		return
+	}
+
+	if req.ReportID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ModerationResponse{Success: false, Message: "ID de denúncia inválido"})
+		return
+	}
+	if len(req.OfficialResponse) > 5000 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ModerationResponse{Success: false, Message: "Resposta oficial muito longa"})
+		return
+	}
+
+	if err := services.UpdateReportStatus(db.DB, req.ReportID, req.Status, req.OfficialResponse); err != nil {
+		log.Printf("Error updating status of report %d: %v", req.ReportID, err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ModerationResponse{Success: false, Message: "Não foi possível atualizar a denúncia"})
+		return
+	}
+
+	log.Printf("Report %d status set to %s", req.ReportID, req.Status)
+	response := ModerationResponse{Success: true, Message: "Denúncia atualizada"}
+	if report, err := services.GetReportByID(db.DB, req.ReportID); err == nil {
+		response.Report = &models.ModeratorReport{Report: report, LocationCheck: report.LocationCheck}
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// CommentsModerationHandler lists hidden and flagged comments for moderators to review
+func CommentsModerationHandler(w http.ResponseWriter, r *http.Request) {
+	comments, err := services.ListCommentReviewQueue(db.DB, 100)
+	if err != nil {
+		log.Printf("Error listing comment review queue: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"PageTitle":   "Comentários denunciados",
+		"Section":     "comments",
+		"Comments":    comments,
+		"CurrentPage": "moderation",
+	}
+
+	if err := renderTemplate(w, "07_moderation.html", data); err != nil {
+		log.Printf("Error rendering comments moderation template: %s", err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// ReviewCommentHandler keeps or removes a comment from the review queue
+func ReviewCommentHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req CommentReviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ModerationResponse{Success: false, Message: "Formato de requisição inválido"})
+		return
+	}
+
+	if req.CommentID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ModerationResponse{Success: false, Message: "ID de comentário inválido"})
+		return
+	}
+
+	if err := services.ReviewComment(db.DB, req.CommentID, req.Decision); err != nil {
+		log.Printf("Error reviewing comment %d: %v", req.CommentID, err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ModerationResponse{Success: false, Message: "Não foi possível revisar o comentário"})
+		return
+	}
+
+	log.Printf("Comment %d reviewed: %s", req.CommentID, req.Decision)
+	json.NewEncoder(w).Encode(ModerationResponse{Success: true, Message: "Comentário revisado"})
+}
+
+// CommentRevisionsResponse lists the previous versions of a comment for moderators
+type CommentRevisionsResponse struct {
+	Success   bool                      `json:"success"`
+	Message   string                    `json:"message,omitempty"`
+	Revisions []*models.CommentRevision `json:"revisions"`
+}
+
+// CommentRevisionsHandler returns the previous versions of an edited comment
+func CommentRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	commentID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(CommentRevisionsResponse{Success: false, Message: "ID de comentário inválido"})
+		return
+	}
+
+	revisions, err := services.GetCommentRevisions(db.DB, commentID)
+	if err != nil {
+		log.Printf("Error getting revisions of comment %d: %v", commentID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(CommentRevisionsResponse{Success: false, Message: "Erro ao carregar versões"})
+		return
+	}
+	if revisions == nil {
+		revisions = []*models.CommentRevision{}
+	}
+	json.NewEncoder(w).Encode(CommentRevisionsResponse{Success: true, Revisions: revisions})
+}
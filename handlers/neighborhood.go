@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"olhourbano2/db"
+	"olhourbano2/services"
+
+	"github.com/gorilla/mux"
+)
+
+// NeighborhoodHandler shows report counts by category and resolution rate for a neighborhood
+func NeighborhoodHandler(w http.ResponseWriter, r *http.Request) {
+	slug := mux.Vars(r)["slug"]
+
+	neighborhood, err := services.GetNeighborhoodBySlug(db.DB, slug)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error fetching neighborhood %s: %v", slug, err)
+		}
+		http.NotFound(w, r)
+		return
+	}
+
+	stats, err := services.GetNeighborhoodStats(db.DB, neighborhood)
+	if err != nil {
+		log.Printf("Error getting stats for neighborhood %s: %v", slug, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Latest reports in the neighborhood
+	reports, err := services.GetReports(db.DB, 1, "", "", "", neighborhood.ID, "", "recent", 0, 0, ReportsPerPage)
+	if err != nil {
+		log.Printf("Error fetching reports for neighborhood %s: %v", slug, err)
+		reports = nil
+	}
+
+	// Ranking position among the neighborhoods of the same city
+	ranking, err := services.GetNeighborhoodRanking(db.DB, neighborhood.City, 0)
+	if err != nil {
+		log.Printf("Error getting neighborhood ranking for %s: %v", neighborhood.City, err)
+	}
+	rankPosition := 0
+	for i, entry := range ranking {
+		if entry.ID == neighborhood.ID {
+			rankPosition = i + 1
+			break
+		}
+	}
+
+	data := map[string]interface{}{
+		"PageTitle":    neighborhood.Name + " - " + neighborhood.City,
+		"Neighborhood": neighborhood,
+		"Stats":        stats,
+		"Reports":      processReportsForTemplate(reports),
+		"RankPosition": rankPosition,
+		"RankTotal":    len(ranking),
+		"CurrentPage":  "neighborhood",
+	}
+
+	if err := renderTemplate(w, "06_neighborhood.html", data); err != nil {
+		log.Printf("Error rendering neighborhood template: %s", err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// resolveNeighborhoodID converts a neighborhood slug from a query string into its ID.
+// Returns 0 when no slug is given and -1 when the slug is unknown.
+func resolveNeighborhoodID(slug string) int {
+	if slug == "" {
+		return 0
+	}
+
+	neighborhood, err := services.GetNeighborhoodBySlug(db.DB, slug)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error resolving neighborhood %s: %v", slug, err)
+		}
+		return -1 // Unknown slug matches no reports
+	}
+	return neighborhood.ID
+}
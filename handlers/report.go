@@ -195,7 +195,13 @@ func handleReportSubmission(w http.ResponseWriter, r *http.Request, category *co
 	if files == nil {
 		files = make([]*multipart.FileHeader, 0)
 	}
-	fileValidationErrors := services.ValidateFiles(len(files))
+
+	// Large files are sent ahead through resumable uploads, which the form references by ID
+	resumedFiles, err := services.CompletedUploads(db.DB, category.ID, r.MultipartForm.Value["upload_ids"])
+	if err != nil {
+		log.Printf("Error loading resumable uploads: %v", err)
+	}
+	fileValidationErrors := services.ValidateFiles(len(files) + len(resumedFiles))
 	validationErrors = append(validationErrors, fileValidationErrors...)
 
 	if len(validationErrors) > 0 {
@@ -232,7 +238,7 @@ func handleReportSubmission(w http.ResponseWriter, r *http.Request, category *co
 	}
 
 	// Process file uploads
-	var uploadedFiles []string
+	var uploadedFiles []*services.FileUploadResult
 
 	for _, fileHeader := range files {
 		file, err := fileHeader.Open()
@@ -247,8 +253,9 @@ func handleReportSubmission(w http.ResponseWriter, r *http.Request, category *co
 			continue
 		}
 
-		uploadedFiles = append(uploadedFiles, result.SavedPath)
+		uploadedFiles = append(uploadedFiles, result)
 	}
+	uploadedFiles = append(uploadedFiles, resumedFiles...)
 
 	// Create report record
 	report := &models.Report{
@@ -260,8 +267,8 @@ func handleReportSubmission(w http.ResponseWriter, r *http.Request, category *co
 		Latitude:      latitude,
 		Longitude:     longitude,
 		Description:   description,
-		PhotoPath:     strings.Join(uploadedFiles, ","), // Store multiple paths comma-separated
 		TransportType: transportType,
+		LocationCheck: services.CheckPhotoLocation(uploadedFiles, latitude, longitude, time.Now()),
 	}
 
 	// Set transport data if available
@@ -274,15 +281,23 @@ func handleReportSubmission(w http.ResponseWriter, r *http.Request, category *co
 	}
 
 	// Save to database
-	reportID, err := services.CreateReport(db.DB, report)
+	locale := services.EmailLocaleFromAcceptLanguage(r.Header.Get("Accept-Language"))
+	reportID, err := services.CreateReport(db.DB, report, locale)
 	if err != nil {
 		log.Printf("Error creating report: %v", err)
 		http.Error(w, "Erro ao salvar denúncia", http.StatusInternalServerError)
 		return
 	}
 
-	// Send confirmation email (async)
-	go services.SendConfirmationEmail(email, reportID, category.Name)
+	// Record the evidence files; thumbnails are generated by the media workers
+	if _, err := services.CreateAttachments(db.DB, reportID, uploadedFiles, models.AttachmentRoleReporter); err != nil {
+		log.Printf("Error saving attachments of report %d: %v", reportID, err)
+	}
+
+	// Queue the confirmation email in the outbox
+	services.SendConfirmationEmail(db.DB, locale, email, reportID, category.Name)
+
+	// Subscribers whose saved searches match the new report are alerted by the subscription matcher
 
 	// Redirect to success page
 	http.Redirect(w, r, fmt.Sprintf("/report/success/%d", reportID), http.StatusSeeOther)
@@ -332,19 +347,25 @@ func ReportDetailHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Duplicates merged by moderators point to their canonical report
+	if report.MergedIntoID > 0 {
+		http.Redirect(w, r, fmt.Sprintf("/report/%d", report.MergedIntoID), http.StatusMovedPermanently)
+		return
+	}
+
 	// Get category info
 	category := config.GetCategory(report.ProblemType)
 
-	// Process photos
-	var photos []string
-	if report.PhotoPath != "" {
-		// Split by comma and trim whitespace
-		rawPhotos := strings.Split(report.PhotoPath, ",")
-		for _, photo := range rawPhotos {
-			trimmed := strings.TrimSpace(photo)
-			if trimmed != "" {
-				photos = append(photos, trimmed)
-			}
+	// Evidence files, in upload order; the first picture is used when sharing
+	attachments, err := services.GetReportAttachments(db.DB, reportID)
+	if err != nil {
+		log.Printf("Error fetching attachments for report %d: %v", reportID, err)
+	}
+	shareImage := ""
+	for _, attachment := range attachments {
+		if attachment.IsImage() && attachment.Published() {
+			shareImage = attachment.URL()
+			break
 		}
 	}
 
@@ -376,17 +397,17 @@ func ReportDetailHandler(w http.ResponseWriter, r *http.Request) {
 		// Continue without comments
 		comments = []*models.CommentDisplay{}
 	}
+	if hashedCPF, ok := citizenFromSession(r); ok {
+		services.MarkOwnComments(comments, hashedCPF)
+	}
+	commentThreads, err := services.GetCommentThreadCountForReport(db.DB, reportID)
+	if err != nil {
+		log.Printf("Error counting comment threads for report %d: %v", reportID, err)
+		commentThreads = len(comments)
+	}
 
 	// Process status text for display
-	statusText := ""
-	switch report.Status {
-	case "pending":
-		statusText = "Pendente"
-	case "approved":
-		statusText = "Resolvida"
-	default:
-		statusText = "Pendente"
-	}
+	statusText := services.ReportStatusLabel(report.Status)
 
 	// Process transport details for display
 	transportDetails := ""
@@ -460,18 +481,28 @@ func ReportDetailHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	moderator := isModerator(r)
 	data := map[string]interface{}{
 		"ReportID":          reportID,
 		"Report":            report,
 		"Category":          category,
-		"Photos":            photos,
+		"Attachments":       attachments,
+		"ShareImage":        shareImage,
 		"HashedCPFDisplay":  hashedCPFDisplay,
 		"PageTitle":         "Denúncia #" + reportIDStr,
 		"GoogleMapsAPIKey":  cfg.GoogleMapsAPIKey,
 		"Comments":          comments,
+		"CommentThreads":    commentThreads,
 		"TransportDetails":  transportDetails,
 		"TransportTypeName": transportTypeName,
 		"StatusText":        statusText,
+		"IsModerator":       moderator,
+	}
+	if moderator {
+		data["OriginalDescription"], err = services.GetReportOriginal(db.DB, reportID)
+		if err != nil {
+			log.Printf("Error loading original description of report %d: %v", reportID, err)
+		}
 	}
 
 	if err := renderTemplate(w, "04_report_detail.html", data); err != nil {
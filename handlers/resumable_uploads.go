@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"olhourbano2/db"
+	"olhourbano2/services"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// tusVersion is the version of the tus resumable upload protocol spoken by /api/uploads
+const tusVersion = "1.0.0"
+
+// ResumableUploadOptionsHandler describes the tus protocol supported by the upload endpoints
+func ResumableUploadOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,termination")
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(services.MaxFileSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateResumableUploadHandler starts an upload (tus creation). Upload-Metadata carries the
+// filename, filetype and report category, base64-encoded as the protocol defines.
+func CreateResumableUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil {
+		http.Error(w, "Upload-Length inválido", http.StatusBadRequest)
+		return
+	}
+	metadata := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+
+	upload, err := services.CreateResumableUpload(db.DB, metadata["category"], metadata["filename"], metadata["filetype"], length)
+	if err != nil {
+		writeResumableUploadError(w, "", err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/uploads/"+upload.ID)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// ResumableUploadOffsetHandler tells how many bytes of an upload arrived, so the client
+// resumes from there
+func ResumableUploadOffsetHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+
+	upload, err := services.GetResumableUpload(db.DB, mux.Vars(r)["id"])
+	if err != nil {
+		writeResumableUploadError(w, mux.Vars(r)["id"], err)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// AppendResumableUploadHandler writes a chunk of an upload; the last one validates the file. The
+// form sends where its pin is in Report-Location, so photos are checked against it.
+func AppendResumableUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type deve ser application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Upload-Offset inválido", http.StatusBadRequest)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	upload, err := services.AppendResumableUpload(db.DB, id, offset, r.Body, parseReportLocation(r.Header.Get("Report-Location")))
+	if err != nil {
+		writeResumableUploadError(w, id, err)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteResumableUploadHandler cancels an upload (tus termination), as when the user removes
+// the file from the form
+func DeleteResumableUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if err := services.DeleteResumableUpload(db.DB, id); err != nil {
+		writeResumableUploadError(w, id, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkTusVersion sets the protocol header of the response and rejects clients speaking
+// another version
+func checkTusVersion(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "Versão do protocolo tus não suportada", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// writeResumableUploadError answers with the status the tus protocol gives to an error
+func writeResumableUploadError(w http.ResponseWriter, id string, err error) {
+	var rejected *services.UploadRejectedError
+	switch {
+	case errors.As(err, &rejected):
+		http.Error(w, rejected.Reason, http.StatusBadRequest)
+	case errors.Is(err, services.ErrUploadNotFound):
+		http.Error(w, "Upload não encontrado", http.StatusNotFound)
+	case errors.Is(err, services.ErrUploadOffsetMismatch):
+		http.Error(w, "Upload-Offset não corresponde ao recebido", http.StatusConflict)
+	case errors.Is(err, services.ErrUploadBusy):
+		http.Error(w, "Upload em andamento em outra requisição", http.StatusLocked)
+	case errors.Is(err, services.ErrUploadTooLarge):
+		http.Error(w, "Arquivo muito grande", http.StatusRequestEntityTooLarge)
+	default:
+		log.Printf("Error handling resumable upload %s: %v", id, err)
+		http.Error(w, "Erro ao receber arquivo", http.StatusInternalServerError)
+	}
+}
+
+// parseReportLocation reads the "latitude,longitude" of the form's pin; nil when it isn't placed
+func parseReportLocation(header string) *services.PinLocation {
+	latitudeText, longitudeText, ok := strings.Cut(header, ",")
+	if !ok {
+		return nil
+	}
+	latitude, err := strconv.ParseFloat(strings.TrimSpace(latitudeText), 64)
+	if err != nil || latitude < -90 || latitude > 90 {
+		return nil
+	}
+	longitude, err := strconv.ParseFloat(strings.TrimSpace(longitudeText), 64)
+	if err != nil || longitude < -180 || longitude > 180 || (latitude == 0 && longitude == 0) {
+		return nil
+	}
+	return &services.PinLocation{Latitude: latitude, Longitude: longitude}
+}
+
+// parseUploadMetadata decodes the Upload-Metadata header: comma-separated keys, each followed
+// by a space and its base64 value
+func parseUploadMetadata(header string) map[string]string {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			continue
+		}
+		metadata[key] = string(value)
+	}
+	return metadata
+}
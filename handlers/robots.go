@@ -24,6 +24,10 @@ Allow: /articles
 # Disallow admin and API endpoints
 Disallow: /api/
 Disallow: /admin/
+Disallow: /moderacao/
+Disallow: /alertas/
+Disallow: /seguindo/
+Disallow: /email/
 Disallow: /uploads/
 Disallow: /templates/
 
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"olhourbano2/config"
+	"olhourbano2/db"
+	"olhourbano2/services"
+	"strconv"
+)
+
+// SearchReportsResponse represents the response for the search API
+type SearchReportsResponse struct {
+	Success    bool               `json:"success"`
+	Message    string             `json:"message,omitempty"`
+	Query      string             `json:"query"`
+	Page       int                `json:"page"`
+	Total      int                `json:"total"`
+	TotalPages int                `json:"total_pages"`
+	Reports    []SearchReportData `json:"reports"`
+}
+
+// SearchReportData represents a ranked search result
+type SearchReportData struct {
+	ID          int    `json:"id"`
+	Category    string `json:"category"`
+	Description string `json:"description"`
+	Snippet     string `json:"snippet"` // HTML with <mark> around matched words; all other text is escaped
+	Address     string `json:"address"`
+	City        string `json:"city"`
+	Status      string `json:"status"`
+	VoteCount   int    `json:"vote_count"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// SearchHandler renders the /busca page with ranked full-text results
+func SearchHandler(w http.ResponseWriter, r *http.Request) {
+	page := 1
+	if p := r.URL.Query().Get("page"); p != "" {
+		if parsed, err := strconv.Atoi(p); err == nil && parsed > 0 {
+			page = parsed
+		}
+	}
+
+	query := services.NormalizeSearchQuery(r.URL.Query().Get("q"))
+	category := r.URL.Query().Get("category")
+	city := r.URL.Query().Get("city")
+
+	data := map[string]interface{}{
+		"Page":        page,
+		"Query":       query,
+		"Category":    category,
+		"City":        city,
+		"Sort":        "relevance",
+		"Categories":  config.GetAllCategories(),
+		"PageTitle":   "Buscar Denúncias",
+		"CurrentPage": "search",
+	}
+
+	if query != "" {
+		reports, err := services.GetReports(db.DB, page, category, "", city, 0, query, "relevance", 0, 0, ReportsPerPage)
+		if err != nil {
+			log.Printf("Error searching reports for %q: %v", query, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		totalReports, err := services.GetTotalReports(db.DB, category, "", city, 0, query)
+		if err != nil {
+			log.Printf("Error counting search results for %q: %v", query, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		totalPages := (totalReports + ReportsPerPage - 1) / ReportsPerPage
+		data["PageTitle"] = "Busca: " + query
+		data["Reports"] = processReportsForTemplate(reports)
+		data["TotalReports"] = totalReports
+		data["TotalPages"] = totalPages
+		data["HasNext"] = page < totalPages
+		data["HasPrev"] = page > 1
+		data["PrevPage"] = page - 1
+		data["NextPage"] = page + 1
+	}
+
+	if err := renderTemplate(w, "08_search.html", data); err != nil {
+		log.Printf("Error rendering search template: %s", err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// SearchReportsHandler returns ranked full-text search results with highlighted snippets as JSON
+func SearchReportsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := services.NormalizeSearchQuery(r.URL.Query().Get("q"))
+	if query == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(SearchReportsResponse{
+			Success: false,
+			Message: "Parâmetro q é obrigatório",
+			Reports: []SearchReportData{},
+		})
+		return
+	}
+
+	page := 1
+	if p := r.URL.Query().Get("page"); p != "" {
+		if parsed, err := strconv.Atoi(p); err == nil && parsed > 0 {
+			page = parsed
+		}
+	}
+
+	category := r.URL.Query().Get("category")
+	status := r.URL.Query().Get("status")
+	city := r.URL.Query().Get("city")
+	neighborhoodID := resolveNeighborhoodID(r.URL.Query().Get("neighborhood"))
+	latitude, longitude, _ := parseLocationParams(r)
+	sort := r.URL.Query().Get("sort")
+	if sort == "" {
+		sort = "relevance"
+	}
+
+	reports, err := services.GetReports(db.DB, page, category, status, city, neighborhoodID, query, sort, latitude, longitude, ReportsPerPage)
+	if err != nil {
+		log.Printf("Error searching reports for %q: %v", query, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(SearchReportsResponse{
+			Success: false,
+			Message: "Erro ao buscar denúncias",
+			Reports: []SearchReportData{},
+		})
+		return
+	}
+
+	total, err := services.GetTotalReports(db.DB, category, status, city, neighborhoodID, query)
+	if err != nil {
+		log.Printf("Error counting search results for %q: %v", query, err)
+		total = len(reports)
+	}
+
+	results := make([]SearchReportData, 0, len(reports))
+	for _, report := range reports {
+		results = append(results, SearchReportData{
+			ID:          report.ID,
+			Category:    report.ProblemType,
+			Description: report.Description,
+			Snippet:     report.Snippet,
+			Address:     report.Location,
+			City:        report.City,
+			Status:      report.Status,
+			VoteCount:   report.VoteCount,
+			CreatedAt:   report.CreatedAt.Format("02/01/2006"),
+		})
+	}
+
+	json.NewEncoder(w).Encode(SearchReportsResponse{
+		Success:    true,
+		Query:      query,
+		Page:       page,
+		Total:      total,
+		TotalPages: (total + ReportsPerPage - 1) / ReportsPerPage,
+		Reports:    results,
+	})
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"olhourbano2/config"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const citizenCookieName = "citizen_session"
+
+// citizenSessionDuration is how long a CPF check lets citizens manage their own comments
+const citizenSessionDuration = 12 * time.Hour
+
+// citizenSessionMAC signs the hashed CPF and expiry of a citizen session with the session key
+func citizenSessionMAC(cfg *config.Config, hashedCPF, expires string) string {
+	mac := hmac.New(sha256.New, []byte(cfg.SessionKey))
+	mac.Write([]byte("citizen|" + hashedCPF + "|" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// setCitizenSession remembers a citizen whose CPF was just verified
+func setCitizenSession(w http.ResponseWriter, r *http.Request, hashedCPF string) {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Printf("Error loading config for citizen session: %v", err)
+		return
+	}
+
+	expiresAt := time.Now().Add(citizenSessionDuration)
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	http.SetCookie(w, &http.Cookie{
+		Name:     citizenCookieName,
+		Value:    hashedCPF + "." + expires + "." + citizenSessionMAC(cfg, hashedCPF, expires),
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// citizenFromSession returns the hashed CPF of the citizen session, if it is valid and not expired
+func citizenFromSession(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(citizenCookieName)
+	if err != nil {
+		return "", false
+	}
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+	hashedCPF, expires, signature := parts[0], parts[1], parts[2]
+
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() >= expiresAt {
+		return "", false
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Printf("Error loading config for citizen session: %v", err)
+		return "", false
+	}
+	if subtle.ConstantTimeCompare([]byte(signature), []byte(citizenSessionMAC(cfg, hashedCPF, expires))) != 1 {
+		return "", false
+	}
+	return hashedCPF, true
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"olhourbano2/config"
+	"olhourbano2/db"
+	"olhourbano2/models"
+	"olhourbano2/services"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// SubscriptionHandler shows the alert subscription form and creates unconfirmed subscriptions
+func SubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	cities, err := services.GetCitiesFromReports(db.DB)
+	if err != nil {
+		log.Printf("Error fetching cities: %v", err)
+		cities = []string{}
+	}
+
+	neighborhoods, err := services.GetNeighborhoods(db.DB, "")
+	if err != nil {
+		log.Printf("Error fetching neighborhoods: %v", err)
+		neighborhoods = nil
+	}
+
+	// Pre-fill the form from the filters of the page the user came from
+	area := r.FormValue("area")
+	if area == "" {
+		switch {
+		case r.FormValue("neighborhood") != "":
+			area = "neighborhood"
+		case r.FormValue("lat") != "" && r.FormValue("lng") != "":
+			area = "radius"
+		default:
+			area = "city"
+		}
+	}
+
+	frequency := r.FormValue("frequency")
+	if frequency == "" {
+		frequency = models.FrequencyInstant
+	}
+
+	radius := r.FormValue("radius")
+	if radius == "" {
+		radius = strconv.Itoa(services.DefaultSubscriptionRadius)
+	}
+
+	data := map[string]interface{}{
+		"PageTitle":     "Alertas de Denúncias",
+		"Section":       "form",
+		"Categories":    config.GetAllCategories(),
+		"Cities":        cities,
+		"Neighborhoods": neighborhoods,
+		"MinRadius":     services.MinSubscriptionRadiusMeters,
+		"MaxRadius":     services.MaxSubscriptionRadiusMeters,
+		"FormData": map[string]interface{}{
+			"Email":        r.FormValue("email"),
+			"Category":     r.FormValue("category"),
+			"City":         r.FormValue("city"),
+			"Neighborhood": r.FormValue("neighborhood"),
+			"Area":         area,
+			"Latitude":     r.FormValue("lat"),
+			"Longitude":    r.FormValue("lng"),
+			"Radius":       radius,
+			"Polygon":      r.FormValue("polygon"),
+			"Frequency":    frequency,
+		},
+		"CurrentPage": "subscriptions",
+	}
+
+	if r.Method == http.MethodPost {
+		sub, validationErrors := parseSubscriptionForm(r, area)
+		if len(validationErrors) == 0 {
+			created, err := services.CreateSubscription(db.DB, sub)
+			if err != nil {
+				log.Printf("Error creating subscription: %v", err)
+				validationErrors = append(validationErrors, "Não foi possível criar o alerta: "+err.Error())
+			} else {
+				services.SendSubscriptionConfirmationEmail(db.DB, created)
+				data["Section"] = "pending"
+				data["Email"] = created.Email
+			}
+		}
+		data["Errors"] = validationErrors
+	}
+
+	if err := renderTemplate(w, "09_subscriptions.html", data); err != nil {
+		log.Printf("Error rendering subscriptions template: %s", err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// ConfirmSubscriptionHandler activates a subscription from the double opt-in link
+func ConfirmSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	data := map[string]interface{}{
+		"PageTitle":   "Alerta confirmado",
+		"Section":     "confirmed",
+		"CurrentPage": "subscriptions",
+	}
+
+	sub, err := services.ConfirmSubscription(db.DB, token)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error confirming subscription: %v", err)
+		}
+		w.WriteHeader(http.StatusNotFound)
+		data["PageTitle"] = "Link inválido"
+		data["Section"] = "invalid"
+	} else {
+		data["Summary"] = services.SubscriptionSummary(sub)
+		data["UnsubscribeToken"] = sub.UnsubscribeToken
+	}
+
+	if err := renderTemplate(w, "09_subscriptions.html", data); err != nil {
+		log.Printf("Error rendering subscription confirmation template: %s", err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// UnsubscribeHandler cancels a subscription in one click. POST supports mail clients using List-Unsubscribe-Post.
+func UnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	sub, err := services.Unsubscribe(db.DB, token)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error cancelling subscription: %v", err)
+	}
+
+	if r.Method == http.MethodPost {
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"success": err == nil})
+		return
+	}
+
+	data := map[string]interface{}{
+		"PageTitle":   "Alerta cancelado",
+		"Section":     "unsubscribed",
+		"CurrentPage": "subscriptions",
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		data["PageTitle"] = "Link inválido"
+		data["Section"] = "invalid"
+	} else {
+		data["Summary"] = services.SubscriptionSummary(sub)
+	}
+
+	if err := renderTemplate(w, "09_subscriptions.html", data); err != nil {
+		log.Printf("Error rendering unsubscribe template: %s", err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// parseSubscriptionForm builds a subscription from the submitted form, keeping only the chosen area type
+func parseSubscriptionForm(r *http.Request, area string) (*models.Subscription, []string) {
+	sub := &models.Subscription{
+		Email:     strings.TrimSpace(r.FormValue("email")),
+		Category:  r.FormValue("category"),
+		City:      strings.TrimSpace(r.FormValue("city")),
+		Frequency: r.FormValue("frequency"),
+		Locale:    services.EmailLocaleFromAcceptLanguage(r.Header.Get("Accept-Language")),
+	}
+
+	var errors []string
+	switch area {
+	case "city":
+	case "neighborhood":
+		neighborhood, err := services.GetNeighborhoodBySlug(db.DB, r.FormValue("neighborhood"))
+		if err != nil {
+			errors = append(errors, "Bairro inválido")
+			break
+		}
+		sub.City = neighborhood.City
+		sub.NeighborhoodID = neighborhood.ID
+		sub.NeighborhoodName = neighborhood.Name
+	case "radius":
+		latitude, latErr := strconv.ParseFloat(r.FormValue("lat"), 64)
+		longitude, lngErr := strconv.ParseFloat(r.FormValue("lng"), 64)
+		radius, radiusErr := strconv.ParseFloat(r.FormValue("radius"), 64)
+		if latErr != nil || lngErr != nil || radiusErr != nil {
+			errors = append(errors, "Informe a localização e o raio do alerta")
+			break
+		}
+		sub.City = ""
+		sub.CenterLatitude = latitude
+		sub.CenterLongitude = longitude
+		sub.RadiusMeters = radius
+	case "polygon":
+		polygon := strings.TrimSpace(r.FormValue("polygon"))
+		if polygon == "" {
+			errors = append(errors, "Informe o polígono da área")
+			break
+		}
+		sub.City = ""
+		sub.Polygon = json.RawMessage(polygon)
+	default:
+		errors = append(errors, "Tipo de área inválido")
+	}
+
+	if len(errors) > 0 {
+		return sub, errors
+	}
+	return sub, services.ValidateSubscription(sub)
+}
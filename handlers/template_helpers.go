@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"olhourbano2/models"
 	"olhourbano2/services"
 	"path/filepath"
 	"strings"
@@ -62,16 +63,6 @@ func TemplateFuncs() template.FuncMap {
 			}
 			return "document"
 		},
-		"getThumbnailPath": func(filePath string) string {
-			// Check if thumbnail exists and return web-accessible path
-			thumbnailPath := services.GetThumbnailPath(filePath)
-			if thumbnailPath != "" {
-				// Return web-accessible path instead of file system path
-				filename := filepath.Base(thumbnailPath)
-				return "/thumbnails/" + filename
-			}
-			return ""
-		},
 		"getThumbnailFilename": func(filePath string) string {
 			if filePath == "" {
 				return ""
@@ -108,5 +99,6 @@ func TemplateFuncs() template.FuncMap {
 
 			return services.GetFileTypeIcon(contentType)
 		},
+		"flagReasonLabel": models.FlagReasonLabel,
 	}
 }
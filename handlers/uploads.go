@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"olhourbano2/config"
+	"olhourbano2/services"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// presignedDownloadExpiry is how long a redirect to the object store stays valid
+const presignedDownloadExpiry = 15 * time.Minute
+
+// UploadHandler serves an uploaded file from the storage backend. Unblurred originals of photos
+// are only served to moderators and agencies; anyone else gets a 404, as if they didn't exist.
+// Chunks of resumable uploads haven't been validated and are never served.
+func UploadHandler(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	if services.IsStagingKey(key) || (services.IsRestrictedKey(key) && !canViewOriginals(r)) {
+		http.NotFound(w, r)
+		return
+	}
+	serveBlob(w, r, key)
+}
+
+// canViewOriginals reports whether a request comes from a moderator or carries the agency token
+func canViewOriginals(r *http.Request) bool {
+	if isModerator(r) {
+		return true
+	}
+
+	cfg, err := config.Load()
+	if err != nil || cfg.AgencyToken == "" {
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AgencyToken)) == 1
+}
+
+// ThumbnailHandler serves the thumbnail of an uploaded file
+func ThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	serveBlob(w, r, "thumbnails/"+mux.Vars(r)["name"])
+}
+
+// servesInline reports whether a content type is shown in the page rather than downloaded.
+// PDFs open in the file viewer; they were checked for scripts when uploaded.
+func servesInline(contentType string) bool {
+	switch strings.SplitN(contentType, ";", 2)[0] {
+	case "image/jpeg", "image/png", "image/webp", "application/pdf":
+		return true
+	}
+	return strings.HasPrefix(contentType, "video/")
+}
+
+// serveBlob redirects to a presigned URL when STORAGE_DOWNLOADS=presigned and the backend
+// supports it, and otherwise streams the file through the app
+func serveBlob(w http.ResponseWriter, r *http.Request, key string) {
+	store, err := services.CurrentBlobStore()
+	if err != nil {
+		log.Printf("Error opening storage backend: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Only pictures and videos are redirected, since the object store doesn't add the headers below
+	contentType := mime.TypeByExtension(strings.ToLower(path.Ext(key)))
+	media := strings.HasPrefix(contentType, "image/") || strings.HasPrefix(contentType, "video/")
+	if services.PresignedDownloads() && media && servesInline(contentType) {
+		url, err := store.PresignURL(key, presignedDownloadExpiry)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if url != "" {
+			w.Header().Set("Cache-Control", "private, max-age=600")
+			if services.IsRestrictedKey(key) {
+				w.Header().Set("Cache-Control", "private, no-store")
+			}
+			http.Redirect(w, r, url, http.StatusFound)
+			return
+		}
+	}
+
+	reader, info, err := store.Get(key)
+	if errors.Is(err, services.ErrBlobNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading %s from storage: %v", key, err)
+		http.NotFound(w, r)
+		return
+	}
+	defer reader.Close()
+
+	// File names are hashes, so a stored file never changes; originals stay out of shared caches
+	if services.IsRestrictedKey(key) {
+		w.Header().Set("Cache-Control", "private, no-store")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=604800")
+	}
+	// Always set a type, or ServeContent would sniff one
+	switch {
+	case info.ContentType != "":
+		w.Header().Set("Content-Type", info.ContentType)
+	case contentType != "":
+		w.Header().Set("Content-Type", contentType)
+	default:
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+
+	// Never let a browser run an upload as a page: no sniffing, no scripts, and anything that
+	// isn't a picture, a video or a PDF is downloaded instead of opened. Browsers won't render
+	// PDFs in a sandbox, so they go without one.
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if !strings.HasPrefix(contentType, "application/pdf") {
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; img-src 'self'; media-src 'self'; sandbox")
+	}
+	if servesInline(contentType) {
+		w.Header().Set("Content-Disposition", "inline")
+	} else {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(key)}))
+	}
+
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, key, info.ModTime, seeker)
+		return
+	}
+
+	if !info.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+	if info.Size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		log.Printf("Error streaming %s from storage: %v", key, err)
+	}
+}
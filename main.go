@@ -10,6 +10,8 @@ import (
 	"olhourbano2/services"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 func main() {
@@ -31,6 +33,38 @@ func main() {
 	}
 	fmt.Println("Categories configuration loaded successfully")
 
+	// Load comment moderation filters
+	if _, err = config.LoadCommentModeration(); err != nil {
+		fmt.Printf("Error loading comment moderation configuration: %v\n", err)
+		return
+	}
+
+	// Set up the cipher for the originals of redacted texts
+	if err := services.SetPIIKey(cfg.PIIKey); err != nil {
+		fmt.Printf("Error setting up PII encryption: %v\n", err)
+		return
+	}
+
+	// Set up the mail transport; staging uses the file or memory transport so no real citizen is emailed
+	mailer, err := services.NewMailer(cfg)
+	if err != nil {
+		fmt.Printf("Error setting up mail transport: %v\n", err)
+		return
+	}
+	defer mailer.Close()
+	services.SetMailer(mailer, cfg.MailFrom)
+	fmt.Printf("Mail transport: %s\n", cfg.MailTransport)
+
+	// Set up the storage backend of uploads; s3 lets several containers share the files
+	blobStore, err := services.NewBlobStore(cfg, cfg.StorageBackend)
+	if err != nil {
+		fmt.Printf("Error setting up storage backend: %v\n", err)
+		return
+	}
+	services.SetBlobStore(blobStore)
+	services.SetPresignedDownloads(cfg.StorageDownloads == "presigned")
+	fmt.Printf("Storage backend: %s (downloads: %s)\n", cfg.StorageBackend, cfg.StorageDownloads)
+
 	// Connect to the database
 	db.DB, err = db.ConnectDB()
 	if err != nil {
@@ -98,6 +132,280 @@ func main() {
 			fmt.Println("Successfully updated reports with city data")
 			return
 
+		case "neighborhoods:import":
+			if len(os.Args) < 4 {
+				log.Fatalf("Usage: %s neighborhoods:import <city> <file.geojson> [name_property]\n", os.Args[0])
+			}
+
+			nameProperty := "name"
+			if len(os.Args) > 4 {
+				nameProperty = os.Args[4]
+			}
+
+			imported, err := services.ImportNeighborhoodsFromGeoJSON(db.DB, os.Args[2], os.Args[3], nameProperty)
+			if err != nil {
+				log.Fatalf("Error importing neighborhoods: %v\n", err)
+			}
+			fmt.Printf("Imported %d neighborhoods for %s\n", imported, os.Args[2])
+
+			assigned, err := services.AssignNeighborhoodsToReports(db.DB)
+			if err != nil {
+				log.Fatalf("Error assigning neighborhoods to reports: %v\n", err)
+			}
+			fmt.Printf("Assigned neighborhoods to %d existing reports\n", assigned)
+			return
+
+		case "neighborhoods:assign":
+			fmt.Println("Assigning neighborhoods to existing reports...")
+			assigned, err := services.AssignNeighborhoodsToReports(db.DB)
+			if err != nil {
+				log.Fatalf("Error assigning neighborhoods to reports: %v\n", err)
+			}
+			fmt.Printf("Assigned neighborhoods to %d reports\n", assigned)
+			return
+
+		case "hotspots:compute":
+			fmt.Println("Computing hotspots...")
+			count, err := services.ComputeHotspots(db.DB)
+			if err != nil {
+				log.Fatalf("Error computing hotspots: %v\n", err)
+			}
+			fmt.Printf("Computed %d hotspots\n", count)
+			return
+
+		case "reports:duplicates":
+			candidates, err := services.FindDuplicateCandidates(db.DB, 0)
+			if err != nil {
+				log.Fatalf("Error finding duplicate reports: %v\n", err)
+			}
+			for _, candidate := range candidates {
+				fmt.Printf("#%d -> #%d  score=%.2f  distance=%.0fm  text=%.2f  [%s]\n",
+					candidate.Duplicate.ID, candidate.Canonical.ID, candidate.Score,
+					candidate.Duplicate.DistanceMeters, candidate.Duplicate.TextSimilarity, candidate.Duplicate.ProblemType)
+			}
+			fmt.Printf("Found %d possible duplicates\n", len(candidates))
+			return
+
+		case "reports:merge":
+			if len(os.Args) < 4 {
+				log.Fatalf("Usage: %s reports:merge <duplicate_id> <canonical_id>\n", os.Args[0])
+			}
+			duplicateID, err := strconv.Atoi(os.Args[2])
+			if err != nil {
+				log.Fatalf("Invalid duplicate report ID: %v\n", err)
+			}
+			canonicalID, err := strconv.Atoi(os.Args[3])
+			if err != nil {
+				log.Fatalf("Invalid canonical report ID: %v\n", err)
+			}
+			if err := services.MergeReports(db.DB, duplicateID, canonicalID); err != nil {
+				log.Fatalf("Error merging reports: %v\n", err)
+			}
+			fmt.Printf("Merged report %d into report %d\n", duplicateID, canonicalID)
+			return
+
+		case "subscriptions:digest":
+			fmt.Println("Queueing daily subscription digests...")
+			count, err := services.SendDailyDigests(db.DB)
+			if err != nil {
+				log.Fatalf("Error sending subscription digests: %v\n", err)
+			}
+			fmt.Printf("Queued %d subscription digests\n", count)
+			return
+
+		case "followers:digest":
+			fmt.Println("Queueing daily follower digests...")
+			count, err := services.SendFollowerDigests(db.DB)
+			if err != nil {
+				log.Fatalf("Error sending follower digests: %v\n", err)
+			}
+			fmt.Printf("Queued %d follower digests\n", count)
+			return
+
+		case "email:failed":
+			messages, err := services.ListOutboxMessages(db.DB, "", 100)
+			if err != nil {
+				log.Fatalf("Error listing failed emails: %v\n", err)
+			}
+			for _, message := range messages {
+				fmt.Printf("#%d  %s  %s  attempts=%d/%d  %q\n    key=%s\n    error=%s\n",
+					message.ID, message.Status, message.Recipient, message.Attempts, message.MaxAttempts,
+					message.Subject, message.IdempotencyKey, message.LastError)
+			}
+			fmt.Printf("Found %d failed emails\n", len(messages))
+			return
+
+		case "email:resend":
+			if len(os.Args) < 3 {
+				log.Fatalf("Usage: %s email:resend <id|all>\n", os.Args[0])
+			}
+			if os.Args[2] == "all" {
+				count, err := services.ResendDeadOutboxMessages(db.DB)
+				if err != nil {
+					log.Fatalf("Error requeueing failed emails: %v\n", err)
+				}
+				fmt.Printf("Requeued %d failed emails\n", count)
+				return
+			}
+			id, err := strconv.ParseInt(os.Args[2], 10, 64)
+			if err != nil {
+				log.Fatalf("Invalid email ID: %v\n", err)
+			}
+			if err := services.ResendOutboxMessage(db.DB, id); err != nil {
+				log.Fatalf("Error requeueing email: %v\n", err)
+			}
+			fmt.Printf("Requeued email %d\n", id)
+			return
+
+		case "email:bounces":
+			if len(os.Args) < 3 {
+				log.Fatalf("Usage: %s email:bounces <file|maildir>...\n", os.Args[0])
+			}
+			total := 0
+			for _, path := range os.Args[2:] {
+				count, err := services.ImportBounceMessages(db.DB, path)
+				if err != nil {
+					log.Fatalf("Error importing bounces from %s: %v\n", path, err)
+				}
+				total += count
+			}
+			fmt.Printf("Suppressed %d addresses\n", total)
+			return
+
+		case "email:suppress":
+			if len(os.Args) < 3 {
+				log.Fatalf("Usage: %s email:suppress <email> [detail]\n", os.Args[0])
+			}
+			detail := strings.Join(os.Args[3:], " ")
+			if err := services.SuppressEmail(db.DB, os.Args[2], "manual", detail); err != nil {
+				log.Fatalf("Error suppressing email: %v\n", err)
+			}
+			fmt.Printf("Suppressed %s\n", os.Args[2])
+			return
+
+		case "email:unsuppress":
+			if len(os.Args) < 3 {
+				log.Fatalf("Usage: %s email:unsuppress <email>\n", os.Args[0])
+			}
+			removed, err := services.UnsuppressEmail(db.DB, os.Args[2])
+			if err != nil {
+				log.Fatalf("Error removing suppression: %v\n", err)
+			}
+			if !removed {
+				log.Fatalf("%s is not suppressed\n", os.Args[2])
+			}
+			fmt.Printf("Removed %s from the suppression list\n", os.Args[2])
+			return
+
+		case "email:suppressed":
+			suppressions, err := services.ListSuppressedEmails(db.DB, 500)
+			if err != nil {
+				log.Fatalf("Error listing suppressed emails: %v\n", err)
+			}
+			for _, suppression := range suppressions {
+				fmt.Printf("%s  %-9s  %s  %s\n", suppression.CreatedAt.Format("2006-01-02 15:04"),
+					suppression.Reason, suppression.Email, suppression.Detail)
+			}
+			fmt.Printf("Found %d suppressed emails\n", len(suppressions))
+			return
+
+		case "attachments:backfill":
+			fmt.Println("Reading attachments saved without size and hash...")
+			updated, err := services.BackfillAttachments(db.DB)
+			if err != nil {
+				log.Fatalf("Error backfilling attachments: %v\n", err)
+			}
+			fmt.Printf("Updated %d attachments\n", updated)
+			return
+
+		case "thumbnails:regenerate":
+			all := len(os.Args) > 2 && os.Args[2] == "--all"
+			queued, err := services.RegenerateThumbnails(db.DB, all)
+			if err != nil {
+				log.Fatalf("Error queueing thumbnails: %v\n", err)
+			}
+			fmt.Printf("Queued %d media jobs, running...\n", queued)
+			ran, err := services.RunMediaJobs(db.DB, services.MediaJobWorkers)
+			if err != nil {
+				log.Fatalf("Error running media jobs: %v\n", err)
+			}
+			fmt.Printf("Ran %d media jobs (see media:failed for failures)\n", ran)
+			return
+
+		case "media:failed":
+			jobs, err := services.ListMediaJobs(db.DB, "", 100)
+			if err != nil {
+				log.Fatalf("Error listing failed media jobs: %v\n", err)
+			}
+			for _, job := range jobs {
+				fmt.Printf("#%d  %s  %s  attachment=%d  attempts=%d/%d\n    error=%s\n",
+					job.ID, job.Status, job.Kind, job.AttachmentID, job.Attempts, job.MaxAttempts, job.LastError)
+			}
+			fmt.Printf("Found %d failed media jobs\n", len(jobs))
+			return
+
+		case "storage:migrate":
+			if len(os.Args) < 4 {
+				log.Fatalf("Usage: %s storage:migrate <from> <to> [--dry-run]\n", os.Args[0])
+			}
+			from, err := services.NewBlobStore(cfg, os.Args[2])
+			if err != nil {
+				log.Fatalf("Error opening storage backend %s: %v\n", os.Args[2], err)
+			}
+			to, err := services.NewBlobStore(cfg, os.Args[3])
+			if err != nil {
+				log.Fatalf("Error opening storage backend %s: %v\n", os.Args[3], err)
+			}
+			dryRun := len(os.Args) > 4 && os.Args[4] == "--dry-run"
+			fmt.Printf("Copying files from %s to %s...\n", os.Args[2], os.Args[3])
+			copied, bytes, err := services.MigrateBlobs(from, to, dryRun)
+			if err != nil {
+				log.Fatalf("Error migrating files: %v\n", err)
+			}
+			if dryRun {
+				fmt.Printf("Would copy %d files (%d bytes)\n", copied, bytes)
+			} else {
+				fmt.Printf("Copied %d files (%d bytes)\n", copied, bytes)
+			}
+			return
+
+		case "storage:gc":
+			dryRun := false
+			grace := services.StorageGCGracePeriod
+			for i := 2; i < len(os.Args); i++ {
+				switch os.Args[i] {
+				case "--dry-run":
+					dryRun = true
+				case "--grace":
+					if i+1 >= len(os.Args) {
+						log.Fatalf("Usage: %s storage:gc [--dry-run] [--grace <duration>]\n", os.Args[0])
+					}
+					i++
+					if grace, err = time.ParseDuration(os.Args[i]); err != nil || grace < 0 {
+						log.Fatalf("Invalid grace period %s: use a duration such as 48h\n", os.Args[i])
+					}
+				default:
+					log.Fatalf("Usage: %s storage:gc [--dry-run] [--grace <duration>]\n", os.Args[0])
+				}
+			}
+			fmt.Printf("Looking for files not referenced by the database and older than %s...\n", grace)
+			result, err := services.CollectStorageGarbage(db.DB, grace, dryRun)
+			if err != nil {
+				log.Fatalf("Error collecting orphaned files: %v\n", err)
+			}
+			for _, key := range result.Missing {
+				fmt.Printf("Missing: %s\n", key)
+			}
+			if dryRun {
+				fmt.Printf("Scanned %d files; would delete %d orphaned files (%d bytes)\n", result.Scanned, result.Deleted, result.ReclaimedBytes)
+			} else {
+				fmt.Printf("Scanned %d files; deleted %d orphaned files (%d bytes reclaimed)\n", result.Scanned, result.Deleted, result.ReclaimedBytes)
+			}
+			if len(result.Missing) > 0 {
+				fmt.Printf("%d files referenced by attachments are missing from storage\n", len(result.Missing))
+			}
+			return
+
 		default:
 			fmt.Printf("Unknown command: %s\n", os.Args[1])
 			fmt.Println("Available commands:")
@@ -106,10 +414,38 @@ func main() {
 			fmt.Println("  migrate:rollback <version> - Rollback to specific version")
 			fmt.Println("  migrate:validate  - Validate migration files")
 			fmt.Println("  update:cities     - Update existing reports with city data")
+			fmt.Println("  neighborhoods:import <city> <file.geojson> [name_property] - Import neighborhood polygons")
+			fmt.Println("  neighborhoods:assign - Assign neighborhoods to reports without one")
+			fmt.Println("  hotspots:compute  - Recompute report hotspots")
+			fmt.Println("  reports:duplicates - List likely duplicate reports")
+			fmt.Println("  reports:merge <duplicate_id> <canonical_id> - Merge a duplicate into its canonical report")
+			fmt.Println("  subscriptions:digest - Send pending daily alert digests")
+			fmt.Println("  followers:digest  - Send pending daily digests to report followers")
+			fmt.Println("  email:failed      - List emails that failed or were given up on")
+			fmt.Println("  email:resend <id|all> - Requeue a failed email, or every dead one")
+			fmt.Println("  email:bounces <file|maildir>... - Suppress addresses from bounce and complaint messages")
+			fmt.Println("  email:suppress <email> [detail] - Stop every email to an address")
+			fmt.Println("  email:unsuppress <email> - Remove an address from the suppression list")
+			fmt.Println("  email:suppressed  - List suppressed addresses")
+			fmt.Println("  attachments:backfill - Record type, size, hash and dimensions of attachments migrated from photo_path")
+			fmt.Println("  thumbnails:regenerate [--all] - Generate missing or failed thumbnails, image renditions, video transcodes and blurred photos, or every one with --all")
+			fmt.Println("  media:failed      - List media jobs that failed or were given up on")
+			fmt.Println("  storage:migrate <from> <to> [--dry-run] - Copy uploads between storage backends (local, s3)")
+			fmt.Println("  storage:gc [--dry-run] [--grace <duration>] - Delete stored files no attachment points at, older than the grace period (24h)")
 			return
 		}
 	}
 
+	// Start background jobs
+	services.StartHotspotScheduler(db.DB, time.Hour)
+	services.StartSubscriptionDigestScheduler(db.DB, time.Hour)
+	services.StartSubscriptionMatcher(db.DB, services.SubscriptionMatchInterval)
+	services.StartFollowerDigestScheduler(db.DB, time.Hour)
+	services.StartEmailOutboxWorkers(db.DB, services.EmailOutboxWorkers)
+	services.StartMediaJobWorkers(db.DB, services.MediaJobWorkers)
+	services.StartResumableUploadCleanup(db.DB, time.Hour)
+	services.StartStorageGCScheduler(db.DB, services.StorageGCInterval)
+
 	// Create routes
 	r := routes.CreateRoutes()
 
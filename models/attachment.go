@@ -0,0 +1,157 @@
+package models
+
+import (
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Who uploaded an attachment
+const (
+	AttachmentRoleReporter  = "reporter"
+	AttachmentRoleAgency    = "agency"
+	AttachmentRoleModerator = "moderator"
+)
+
+// Thumbnail states of an attachment
+const (
+	ThumbnailStatusPending = "pending"
+	ThumbnailStatusReady   = "ready"
+	ThumbnailStatusFailed  = "failed"
+	ThumbnailStatusNone    = "none" // File type without thumbnails
+)
+
+// Transcoding states of a video attachment
+const (
+	TranscodeStatusPending = "pending"
+	TranscodeStatusReady   = "ready"
+	TranscodeStatusFailed  = "failed"
+	TranscodeStatusNone    = "none" // Not a video
+)
+
+// Anonymization states of a photo whose faces or plates are blurred in public
+const (
+	AnonymizeStatusPending = "pending"
+	AnonymizeStatusReady   = "ready"
+	AnonymizeStatusFailed  = "failed"
+	AnonymizeStatusNone    = "none" // Published as uploaded
+)
+
+// Formats of image renditions
+const (
+	RenditionFormatJPEG = "jpeg"
+	RenditionFormatWebP = "webp"
+	RenditionFormatAVIF = "avif"
+)
+
+// Attachment represents a file uploaded as evidence of a report
+type Attachment struct {
+	ID              int       `json:"id" db:"id"`
+	ReportID        int       `json:"report_id" db:"report_id"`
+	Position        int       `json:"position" db:"position"`
+	Path            string    `json:"path" db:"path"`
+	OriginalName    string    `json:"-" db:"original_name"` // May carry personal data
+	ContentType     string    `json:"content_type" db:"content_type"`
+	ByteSize        int64     `json:"byte_size" db:"byte_size"`
+	SHA256          string    `json:"sha256" db:"sha256"`
+	Width           int       `json:"width,omitempty" db:"width"`
+	Height          int       `json:"height,omitempty" db:"height"`
+	DurationSeconds float64   `json:"duration_seconds,omitempty" db:"duration_seconds"`
+	ThumbnailPath   string    `json:"thumbnail_path,omitempty" db:"thumbnail_path"`
+	ThumbnailStatus string    `json:"thumbnail_status" db:"thumbnail_status"`
+	PlaybackPath    string    `json:"playback_path,omitempty" db:"playback_path"`
+	PosterPath      string    `json:"poster_path,omitempty" db:"poster_path"`
+	SpritePath      string    `json:"sprite_path,omitempty" db:"sprite_path"`
+	TranscodeStatus string    `json:"transcode_status" db:"transcode_status"`
+	OriginalPath    string    `json:"-" db:"original_path"` // Unblurred photo, restricted to moderators and agencies
+	AnonymizeStatus string    `json:"anonymize_status" db:"anonymize_status"`
+	BlurredFaces    int       `json:"blurred_faces,omitempty" db:"blurred_faces"`
+	BlurredPlates   int       `json:"blurred_plates,omitempty" db:"blurred_plates"`
+	UploaderRole    string    `json:"uploader_role" db:"uploader_role"`
+	CreatedAt       time.Time `json:"created_at" db:"created_at"`
+
+	Renditions []*AttachmentRendition `json:"renditions,omitempty" db:"-"`
+}
+
+// AttachmentRendition is a resized copy of an image attachment in one format
+type AttachmentRendition struct {
+	AttachmentID int    `json:"-" db:"attachment_id"`
+	Width        int    `json:"width" db:"width"`
+	Height       int    `json:"height" db:"height"`
+	Format       string `json:"format" db:"format"`
+	Path         string `json:"path" db:"path"`
+	ByteSize     int64  `json:"byte_size" db:"byte_size"`
+}
+
+// URL returns the web path of the file
+func (a *Attachment) URL() string {
+	return "/" + strings.TrimPrefix(a.Path, "/")
+}
+
+// ThumbnailURL returns the web path of the thumbnail, or an empty string while it isn't ready
+func (a *Attachment) ThumbnailURL() string {
+	if a.ThumbnailStatus != ThumbnailStatusReady || a.ThumbnailPath == "" {
+		return ""
+	}
+	return "/thumbnails/" + path.Base(a.ThumbnailPath)
+}
+
+// Published reports whether the file at Path can be shown: photos that get faces or plates
+// blurred only have a public copy once the blurring is done
+func (a *Attachment) Published() bool {
+	return a.AnonymizeStatus == AnonymizeStatusNone || a.AnonymizeStatus == AnonymizeStatusReady || a.AnonymizeStatus == ""
+}
+
+// OriginalURL returns the web path of the unblurred photo, or an empty string when the public
+// file is the original
+func (a *Attachment) OriginalURL() string {
+	if a.OriginalPath == "" {
+		return ""
+	}
+	return "/" + strings.TrimPrefix(a.OriginalPath, "/")
+}
+
+// PlaybackURL returns the web path browsers should play: the transcoded copy of a video once
+// it is ready, and the file itself otherwise
+func (a *Attachment) PlaybackURL() string {
+	if a.TranscodeStatus != TranscodeStatusReady || a.PlaybackPath == "" {
+		return a.URL()
+	}
+	return "/" + strings.TrimPrefix(a.PlaybackPath, "/")
+}
+
+// PosterURL returns the web path of the poster frame of a video, or an empty string while it
+// isn't ready
+func (a *Attachment) PosterURL() string {
+	if a.TranscodeStatus != TranscodeStatusReady || a.PosterPath == "" {
+		return ""
+	}
+	return "/" + strings.TrimPrefix(a.PosterPath, "/")
+}
+
+// SpriteURL returns the web path of the preview sprite of a video, or an empty string while it
+// isn't ready
+func (a *Attachment) SpriteURL() string {
+	if a.TranscodeStatus != TranscodeStatusReady || a.SpritePath == "" {
+		return ""
+	}
+	return "/" + strings.TrimPrefix(a.SpritePath, "/")
+}
+
+// IsImage reports whether the attachment is a picture
+func (a *Attachment) IsImage() bool {
+	return strings.HasPrefix(a.ContentType, "image/")
+}
+
+// SrcSet returns the srcset of the renditions in a format, narrowest first, or an empty string
+// when there are none
+func (a *Attachment) SrcSet(format string) string {
+	var candidates []string
+	for _, rendition := range a.Renditions {
+		if rendition.Format == format {
+			candidates = append(candidates, "/"+strings.TrimPrefix(rendition.Path, "/")+" "+strconv.Itoa(rendition.Width)+"w")
+		}
+	}
+	return strings.Join(candidates, ", ")
+}
@@ -4,22 +4,110 @@ import (
 	"time"
 )
 
+// Comment statuses
+const (
+	CommentStatusVisible = "visible"
+	CommentStatusHidden  = "hidden" // Awaiting moderator review; shown as a placeholder
+	CommentStatusRemoved = "removed"
+	CommentStatusDeleted = "deleted" // Deleted by its author
+)
+
+// CommentFlagReasons lists the reasons citizens can flag a comment for, with their labels
+var CommentFlagReasons = map[string]string{
+	"spam":          "Spam ou propaganda",
+	"offensive":     "Ofensivo ou discurso de ódio",
+	"personal_data": "Expõe dados pessoais",
+	"off_topic":     "Não tem relação com a denúncia",
+	"other":         "Outro motivo",
+}
+
+// CommentEditWindow is how long after posting authors can edit a comment; they can delete it any time
+const CommentEditWindow = 30 * time.Minute
+
+// MaxCommentDepth is the deepest nesting level of replies; replies to a comment at this level join its thread instead
+const MaxCommentDepth = 2
+
 // Comment represents a comment on a report
 type Comment struct {
-	ID        int       `json:"id" db:"id"`
-	ReportID  int       `json:"report_id" db:"report_id"`
-	HashedCPF string    `json:"-" db:"hashed_cpf"` // Don't expose in JSON
-	Content   string    `json:"content" db:"content"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	ID        int        `json:"id" db:"id"`
+	ReportID  int        `json:"report_id" db:"report_id"`
+	ParentID  *int       `json:"parent_id,omitempty" db:"parent_id"`
+	Depth     int        `json:"depth" db:"depth"`
+	HashedCPF string     `json:"-" db:"hashed_cpf"` // Don't expose in JSON
+	Content   string     `json:"content" db:"content"`
+	Status    string     `json:"status" db:"status"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	EditedAt  *time.Time `json:"edited_at,omitempty" db:"edited_at"`
 }
 
 // CommentDisplay represents a comment with display information
 type CommentDisplay struct {
-	ID              int       `json:"id"`
-	ReportID        int       `json:"report_id"`
-	Content         string    `json:"content"`
-	CreatedAt       time.Time `json:"created_at"`
-	HashedCPFDisplay string   `json:"hashed_cpf_display"`
+	ID               int               `json:"id"`
+	ReportID         int               `json:"report_id"`
+	Content          string            `json:"content"`
+	CreatedAt        time.Time         `json:"created_at"`
+	HashedCPFDisplay string            `json:"hashed_cpf_display"`
+	ParentID         *int              `json:"parent_id,omitempty"`
+	Depth            int               `json:"depth"`
+	ReplyCount       int               `json:"reply_count"`
+	Status           string            `json:"status"` // Content and author are blanked unless visible; shown as a placeholder
+	EditedAt         *time.Time        `json:"edited_at,omitempty"`
+	AuthorCPF        string            `json:"-"`                 // Hashed CPF, to tell the viewer which comments are theirs
+	Own              bool              `json:"own"`               // Written by the viewer, who can edit or delete it
+	Replies          []*CommentDisplay `json:"replies,omitempty"` // First replies of the thread; the rest are paged
+}
+
+// IsHidden reports whether the comment is shown as a placeholder
+func (c *CommentDisplay) IsHidden() bool {
+	return c.Status != CommentStatusVisible
+}
+
+// Editable reports whether the author can still edit the comment
+func (c *CommentDisplay) Editable() bool {
+	return c.Own && c.Status == CommentStatusVisible && time.Since(c.CreatedAt) < CommentEditWindow
+}
+
+// MoreReplies returns how many replies were not loaded with the comment
+func (c *CommentDisplay) MoreReplies() int {
+	return c.ReplyCount - len(c.Replies)
+}
+
+// CommentFlag represents a citizen report of a comment
+type CommentFlag struct {
+	ID        int       `json:"id" db:"id"`
+	CommentID int       `json:"comment_id" db:"comment_id"`
+	HashedCPF string    `json:"-" db:"hashed_cpf"`
+	Reason    string    `json:"reason" db:"reason"`
+	Detail    string    `json:"detail,omitempty" db:"detail"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// CommentRevision is a previous content of an edited comment
+type CommentRevision struct {
+	ID         int       `json:"id" db:"id"`
+	CommentID  int       `json:"comment_id" db:"comment_id"`
+	Content    string    `json:"content" db:"content"`
+	WrittenAt  time.Time `json:"written_at" db:"written_at"`
+	ReplacedAt time.Time `json:"replaced_at" db:"replaced_at"`
+}
+
+// FlaggedComment is a comment in the moderator review queue
+type FlaggedComment struct {
+	Comment
+	HiddenReason string         `json:"hidden_reason,omitempty"`
+	FilterMatch  string         `json:"filter_match,omitempty"`
+	Original     string         `json:"original,omitempty"` // Content before personal data was masked
+	FlagCount    int            `json:"flag_count"`
+	FlagReasons  map[string]int `json:"flag_reasons"`
+	FlagDetails  []string       `json:"flag_details,omitempty"`
+}
+
+// FlagReasonLabel returns the label of a flag reason
+func FlagReasonLabel(reason string) string {
+	if label, ok := CommentFlagReasons[reason]; ok {
+		return label
+	}
+	return reason
 }
 
 // CommentFormData represents the form data for creating a comment
@@ -28,10 +116,9 @@ type CommentFormData struct {
 	CPF       string `form:"cpf" validate:"required,cpf"`
 	BirthDate string `form:"birth_date" validate:"required"`
 	Content   string `form:"content" validate:"required,min=1,max=500"`
+	ParentID  int    `form:"parent_id"`
 }
 
-
-
 // GetHashedCPFDisplay returns the display format for hashed CPF
 func (c *Comment) GetHashedCPFDisplay() string {
 	if len(c.HashedCPF) >= 8 {
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+)
+
+// SimilarReport represents an existing report that may describe the same problem as another one
+type SimilarReport struct {
+	ID             int       `json:"id"`
+	ProblemType    string    `json:"problem_type"`
+	Location       string    `json:"location"`
+	Description    string    `json:"description"`
+	Status         string    `json:"status"`
+	VoteCount      int       `json:"vote_count"`
+	CommentCount   int       `json:"comment_count"`
+	CreatedAt      time.Time `json:"created_at"`
+	DistanceMeters float64   `json:"distance_meters"`
+	TextSimilarity float64   `json:"text_similarity"`
+	Score          float64   `json:"score"`
+}
+
+// DuplicateCandidate represents a pair of reports a moderator may merge.
+// Canonical is the older report the duplicate would be merged into.
+type DuplicateCandidate struct {
+	Duplicate *SimilarReport `json:"duplicate"`
+	Canonical *SimilarReport `json:"canonical"`
+	Score     float64        `json:"score"`
+}
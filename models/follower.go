@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+)
+
+// FollowerPreferenceOff stops notifications without removing the follower; instant and daily reuse the subscription frequencies
+const FollowerPreferenceOff = "off"
+
+// Follower event types
+const (
+	FollowerEventComment  = "comment"
+	FollowerEventStatus   = "status"
+	FollowerEventResponse = "response"
+)
+
+// ReportFollower represents someone notified about changes to a report
+type ReportFollower struct {
+	ID           int        `json:"id" db:"id"`
+	ReportID     int        `json:"report_id" db:"report_id"`
+	HashedCPF    string     `json:"-" db:"hashed_cpf"`
+	Email        string     `json:"-" db:"email"`
+	Preference   string     `json:"preference" db:"preference"`
+	Locale       string     `json:"locale" db:"locale"`
+	ManageToken  string     `json:"-" db:"manage_token"`
+	PendingEmail string     `json:"-" db:"pending_email"` // New address waiting for confirmation; Email is used until then
+	LastDigestAt *time.Time `json:"last_digest_at,omitempty" db:"last_digest_at"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+}
+
+// FollowerEvent describes a change to a report that followers are told about
+type FollowerEvent struct {
+	Type      string
+	Key       string // Identifies this occurrence of the event, so each follower is emailed about it once
+	ReportID  int
+	Params    map[string]string // Details the email templates describe the event with, in the follower's locale
+	ActorCPF  string            // Hashed CPF of whoever caused the event; they are not notified
+	SkipCPF   string            // Hashed CPF already told about the event some other way, like the author of a replied comment
+	CreatedAt time.Time
+}
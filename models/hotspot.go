@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+)
+
+// Hotspot represents a cluster of nearby reports within a time window
+type Hotspot struct {
+	ID           int       `json:"id" db:"id"`
+	Category     string    `json:"category" db:"category"`
+	WindowDays   int       `json:"window_days" db:"window_days"`
+	Latitude     float64   `json:"latitude" db:"latitude"`
+	Longitude    float64   `json:"longitude" db:"longitude"`
+	RadiusMeters float64   `json:"radius_meters" db:"radius_meters"`
+	ReportCount  int       `json:"report_count" db:"report_count"`
+	VoteCount    int       `json:"vote_count" db:"vote_count"`
+	Score        float64   `json:"score" db:"score"`
+	ReportIDs    []int64   `json:"report_ids" db:"report_ids"`
+	ComputedAt   time.Time `json:"computed_at" db:"computed_at"`
+}
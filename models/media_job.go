@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+)
+
+// Media job status constants
+const (
+	MediaJobPending = "pending"
+	MediaJobRunning = "running"
+	MediaJobDone    = "done"
+	MediaJobDead    = "dead"
+)
+
+// Kinds of media processing
+const (
+	MediaJobThumbnail  = "thumbnail"
+	MediaJobRenditions = "renditions" // Resized copies of images for srcset
+	MediaJobTranscode  = "transcode"  // Web-safe copy, poster and sprite of videos
+	MediaJobAnonymize  = "anonymize"  // Public copy of photos with faces and plates blurred
+)
+
+// MediaJob is a processing step of an attachment, run by the media workers
+type MediaJob struct {
+	ID            int64      `json:"id" db:"id"`
+	AttachmentID  int        `json:"attachment_id" db:"attachment_id"`
+	Kind          string     `json:"kind" db:"kind"`
+	Status        string     `json:"status" db:"status"`
+	Attempts      int        `json:"attempts" db:"attempts"`
+	MaxAttempts   int        `json:"max_attempts" db:"max_attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" db:"next_attempt_at"`
+	LastError     string     `json:"last_error,omitempty" db:"last_error"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty" db:"finished_at"`
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Neighborhood represents an imported neighborhood or administrative region polygon
+type Neighborhood struct {
+	ID           int             `json:"id" db:"id"`
+	City         string          `json:"city" db:"city"`
+	Name         string          `json:"name" db:"name"`
+	Slug         string          `json:"slug" db:"slug"`
+	Geometry     json.RawMessage `json:"geometry,omitempty" db:"geometry"`
+	MinLatitude  float64         `json:"-" db:"min_latitude"`
+	MaxLatitude  float64         `json:"-" db:"max_latitude"`
+	MinLongitude float64         `json:"-" db:"min_longitude"`
+	MaxLongitude float64         `json:"-" db:"max_longitude"`
+	CreatedAt    time.Time       `json:"created_at" db:"created_at"`
+}
+
+// CategoryCount represents the number of reports in a category
+type CategoryCount struct {
+	Category string `json:"category"`
+	Name     string `json:"name"`
+	Icon     string `json:"icon"`
+	Count    int    `json:"count"`
+}
+
+// NeighborhoodStats represents aggregated report statistics for a neighborhood
+type NeighborhoodStats struct {
+	ID             int             `json:"id"`
+	City           string          `json:"city"`
+	Name           string          `json:"name"`
+	Slug           string          `json:"slug"`
+	TotalReports   int             `json:"total_reports"`
+	Resolved       int             `json:"resolved"`
+	Pending        int             `json:"pending"`
+	ResolutionRate int             `json:"resolution_rate"`
+	ByCategory     []CategoryCount `json:"by_category,omitempty"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+)
+
+// Outbox message status constants
+const (
+	OutboxPending    = "pending"
+	OutboxSending    = "sending"
+	OutboxSent       = "sent"
+	OutboxDead       = "dead"
+	OutboxSuppressed = "suppressed"
+)
+
+// OutboxMessage represents an email waiting in, or delivered from, the durable outbox
+type OutboxMessage struct {
+	ID              int64      `json:"id" db:"id"`
+	IdempotencyKey  string     `json:"idempotency_key" db:"idempotency_key"`
+	Recipient       string     `json:"recipient" db:"recipient"`
+	Subject         string     `json:"subject" db:"subject"`
+	Body            string     `json:"-" db:"body"`
+	HTMLBody        string     `json:"-" db:"html_body"`
+	ListUnsubscribe string     `json:"list_unsubscribe,omitempty" db:"list_unsubscribe"`
+	Status          string     `json:"status" db:"status"`
+	Attempts        int        `json:"attempts" db:"attempts"`
+	MaxAttempts     int        `json:"max_attempts" db:"max_attempts"`
+	NextAttemptAt   time.Time  `json:"next_attempt_at" db:"next_attempt_at"`
+	LastError       string     `json:"last_error,omitempty" db:"last_error"`
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
+	SentAt          *time.Time `json:"sent_at,omitempty" db:"sent_at"`
+}
+
+// EmailSuppression is an address nothing is sent to, after a bounce, a complaint or by hand
+type EmailSuppression struct {
+	Email     string    `json:"email" db:"email"`
+	Reason    string    `json:"reason" db:"reason"`
+	Detail    string    `json:"detail,omitempty" db:"detail"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
@@ -7,23 +7,35 @@ import (
 
 // Report represents a report in the database
 type Report struct {
-	ID            int             `json:"id" db:"id"`
-	ProblemType   string          `json:"problem_type" db:"problem_type"`
-	HashedCPF     string          `json:"-" db:"hashed_cpf"` // Don't expose in JSON
-	BirthDate     string          `json:"-" db:"birth_date"` // Don't expose in JSON
-	Email         string          `json:"email" db:"email"`
-	Location      string          `json:"location" db:"location"`
-	City          string          `json:"city" db:"city"`
-	Latitude      float64         `json:"latitude" db:"latitude"`
-	Longitude     float64         `json:"longitude" db:"longitude"`
-	Description   string          `json:"description" db:"description"`
-	PhotoPath     string          `json:"photo_path" db:"photo_path"`
-	TransportType string          `json:"transport_type,omitempty" db:"transport_type"`
-	TransportData json.RawMessage `json:"transport_data,omitempty" db:"transport_data"`
-	CreatedAt     time.Time       `json:"created_at" db:"created_at"`
-	VoteCount     int             `json:"vote_count" db:"vote_count"`
-	CommentCount  int             `json:"comment_count" db:"comment_count"`
-	Status        string          `json:"status" db:"status"`
+	ID                 int             `json:"id" db:"id"`
+	ProblemType        string          `json:"problem_type" db:"problem_type"`
+	HashedCPF          string          `json:"-" db:"hashed_cpf"` // Don't expose in JSON
+	BirthDate          string          `json:"-" db:"birth_date"` // Don't expose in JSON
+	Email              string          `json:"email" db:"email"`
+	Location           string          `json:"location" db:"location"`
+	City               string          `json:"city" db:"city"`
+	NeighborhoodID     int             `json:"neighborhood_id,omitempty" db:"neighborhood_id"`
+	Latitude           float64         `json:"latitude" db:"latitude"`
+	Longitude          float64         `json:"longitude" db:"longitude"`
+	Description        string          `json:"description" db:"description"`
+	TransportType      string          `json:"transport_type,omitempty" db:"transport_type"`
+	TransportData      json.RawMessage `json:"transport_data,omitempty" db:"transport_data"`
+	CreatedAt          time.Time       `json:"created_at" db:"created_at"`
+	VoteCount          int             `json:"vote_count" db:"vote_count"`
+	CommentCount       int             `json:"comment_count" db:"comment_count"`
+	Status             string          `json:"status" db:"status"`
+	MergedIntoID       int             `json:"merged_into_id,omitempty" db:"merged_into_id"`
+	OfficialResponse   string          `json:"official_response,omitempty" db:"official_response"`
+	OfficialResponseAt *time.Time      `json:"official_response_at,omitempty" db:"official_response_at"`
+	LocationCheck      string          `json:"-" db:"location_check"`    // Only moderators see why a report wasn't verified
+	Snippet            string          `json:"snippet,omitempty" db:"-"` // Escaped HTML excerpt with <mark> around search matches
+	Attachments        []*Attachment   `json:"attachments,omitempty" db:"-"`
+}
+
+// ModeratorReport is the JSON form of a report for moderators, with the fields the public one leaves out
+type ModeratorReport struct {
+	*Report
+	LocationCheck string `json:"location_check"`
 }
 
 // TransportData represents the transport-specific information
@@ -80,6 +92,14 @@ const (
 	StatusInReview = "in_review"
 )
 
+// Location check outcomes, from the GPS and capture time of the photos sent with a report
+const (
+	LocationCheckVerified   = "verified"   // A recent photo taken near the pin
+	LocationCheckFar        = "far"        // A photo taken far from the pin
+	LocationCheckOld        = "old"        // Photos taken long before the report
+	LocationCheckUnverified = "unverified" // No photo had GPS and capture time
+)
+
 // AllowedFileTypes defines allowed file types per category
 var AllowedFileTypes = map[string][]string{
 	"default": {
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// ResumableUpload is a file sent in chunks, which becomes an attachment without a report once
+// all its bytes arrive
+type ResumableUpload struct {
+	ID           string    `json:"id" db:"id"`
+	Category     string    `json:"category" db:"category"`
+	Filename     string    `json:"filename" db:"filename"`
+	FileType     string    `json:"filetype,omitempty" db:"filetype"`
+	Length       int64     `json:"length" db:"upload_length"`
+	Offset       int64     `json:"offset" db:"upload_offset"`
+	AttachmentID int       `json:"attachment_id,omitempty" db:"attachment_id"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	ExpiresAt    time.Time `json:"expires_at" db:"expires_at"`
+}
+
+// Complete reports whether every byte of the upload has arrived
+func (u *ResumableUpload) Complete() bool {
+	return u.Offset == u.Length
+}
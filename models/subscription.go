@@ -0,0 +1,39 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Subscription frequency constants
+const (
+	FrequencyInstant = "instant"
+	FrequencyDaily   = "daily"
+)
+
+// Subscription represents a saved search or area alert delivered by email
+type Subscription struct {
+	ID               int             `json:"id" db:"id"`
+	Email            string          `json:"-" db:"email"`
+	Category         string          `json:"category" db:"category"`
+	City             string          `json:"city" db:"city"`
+	NeighborhoodID   int             `json:"neighborhood_id,omitempty" db:"neighborhood_id"`
+	NeighborhoodName string          `json:"neighborhood_name,omitempty" db:"-"`
+	Polygon          json.RawMessage `json:"polygon,omitempty" db:"polygon"`
+	CenterLatitude   float64         `json:"center_latitude,omitempty" db:"center_latitude"`
+	CenterLongitude  float64         `json:"center_longitude,omitempty" db:"center_longitude"`
+	RadiusMeters     float64         `json:"radius_meters,omitempty" db:"radius_meters"`
+	Frequency        string          `json:"frequency" db:"frequency"`
+	Locale           string          `json:"locale" db:"locale"`
+	ConfirmToken     string          `json:"-" db:"confirm_token"`
+	UnsubscribeToken string          `json:"-" db:"unsubscribe_token"`
+	ConfirmedAt      *time.Time      `json:"confirmed_at,omitempty" db:"confirmed_at"`
+	UnsubscribedAt   *time.Time      `json:"unsubscribed_at,omitempty" db:"unsubscribed_at"`
+	LastDigestAt     *time.Time      `json:"last_digest_at,omitempty" db:"last_digest_at"`
+	CreatedAt        time.Time       `json:"created_at" db:"created_at"`
+}
+
+// IsActive returns true if the subscription was confirmed and not cancelled
+func (s *Subscription) IsActive() bool {
+	return s.ConfirmedAt != nil && s.UnsubscribedAt == nil
+}
@@ -26,9 +26,9 @@ func CreateRoutes() *mux.Router {
 	fileServer := http.FileServer(http.Dir("./static/"))
 	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", fileServer))
 
-	// Serve uploaded files
-	uploadServer := http.FileServer(http.Dir("./uploads/"))
-	r.PathPrefix("/uploads/").Handler(http.StripPrefix("/uploads/", uploadServer))
+	// Serve uploaded files and thumbnails from the storage backend
+	r.HandleFunc("/uploads/{key:.+}", handlers.UploadHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/thumbnails/{name}", handlers.ThumbnailHandler).Methods("GET", "HEAD")
 
 	// Serve templates
 	fileServer = http.FileServer(http.Dir("./templates/pages/"))
@@ -47,20 +47,62 @@ func CreateRoutes() *mux.Router {
 	r.HandleFunc("/report/{id:[0-9]+}", handlers.ReportDetailHandler).Methods("GET")                // View existing report
 
 	// API routes
-	r.HandleFunc("/api/googlemaps", handlers.GoogleMapsAPIHandler).Methods("GET") // Google Maps config
-	r.HandleFunc("/api/verify-cpf", handlers.VerifyCPFHandler).Methods("POST")    // CPF verification
-	r.HandleFunc("/api/reports/map", handlers.MapReportsHandler).Methods("GET")   // Map reports data
-	r.HandleFunc("/api/reports/cities", handlers.CitiesHandler).Methods("GET")    // Cities data
-	r.HandleFunc("/api/vote", handlers.VoteHandler).Methods("POST")               // Vote on reports
-	r.HandleFunc("/api/share-image", handlers.ShareImageHandler).Methods("POST")  // Share image generation
-	r.HandleFunc("/api/stats", handlers.StatsHandler).Methods("GET")              // Statistics data
+	r.HandleFunc("/api/googlemaps", handlers.GoogleMapsAPIHandler).Methods("GET")       // Google Maps config
+	r.HandleFunc("/api/verify-cpf", handlers.VerifyCPFHandler).Methods("POST")          // CPF verification
+	r.HandleFunc("/api/reports/map", handlers.MapReportsHandler).Methods("GET")         // Map reports data
+	r.HandleFunc("/api/reports/cities", handlers.CitiesHandler).Methods("GET")          // Cities data
+	r.HandleFunc("/api/vote", handlers.VoteHandler).Methods("POST")                     // Vote on reports
+	r.HandleFunc("/api/share-image", handlers.ShareImageHandler).Methods("POST")        // Share image generation
+	r.HandleFunc("/api/stats", handlers.StatsHandler).Methods("GET")                    // Statistics data
+	r.HandleFunc("/api/v1/hotspots", handlers.HotspotsHandler).Methods("GET")           // Hotspots as GeoJSON
+	r.HandleFunc("/api/reports/similar", handlers.SimilarReportsHandler).Methods("GET") // Duplicate check before submitting
+	r.HandleFunc("/api/reports/search", handlers.SearchReportsHandler).Methods("GET")   // Full-text search
+	r.HandleFunc("/api/follow", handlers.FollowReportHandler).Methods("POST")           // Follow a report
+	r.HandleFunc("/api/photos/location", handlers.PhotoLocationHandler).Methods("POST") // GPS of a photo, to place the pin
+
+	// Resumable uploads (tus protocol), sent before the report form that references them
+	r.HandleFunc("/api/uploads", handlers.ResumableUploadOptionsHandler).Methods("OPTIONS")                 // Supported protocol
+	r.HandleFunc("/api/uploads", handlers.CreateResumableUploadHandler).Methods("POST")                     // Start an upload
+	r.HandleFunc("/api/uploads/{id:[0-9a-f]{32}}", handlers.ResumableUploadOffsetHandler).Methods("HEAD")   // Bytes received so far
+	r.HandleFunc("/api/uploads/{id:[0-9a-f]{32}}", handlers.AppendResumableUploadHandler).Methods("PATCH")  // Send a chunk
+	r.HandleFunc("/api/uploads/{id:[0-9a-f]{32}}", handlers.DeleteResumableUploadHandler).Methods("DELETE") // Cancel an upload
 
 	// Comment routes
-	r.HandleFunc("/api/comments", handlers.CreateCommentHandler).Methods("POST") // Create comment
-	r.HandleFunc("/api/comments", handlers.GetCommentsHandler).Methods("GET")    // Get comments
+	r.HandleFunc("/api/comments", handlers.CreateCommentHandler).Methods("POST")                        // Create comment
+	r.HandleFunc("/api/comments", handlers.GetCommentsHandler).Methods("GET")                           // Get comments
+	r.HandleFunc("/api/comments/{id:[0-9]+}/replies", handlers.GetCommentRepliesHandler).Methods("GET") // Get replies of a comment
+	r.HandleFunc("/api/comments/{id:[0-9]+}/flag", handlers.FlagCommentHandler).Methods("POST")         // Flag a comment for moderation
+	r.HandleFunc("/api/comments/{id:[0-9]+}", handlers.EditCommentHandler).Methods("PUT")               // Author edits a comment
+	r.HandleFunc("/api/comments/{id:[0-9]+}", handlers.DeleteCommentHandler).Methods("DELETE")          // Author deletes a comment
 
 	r.HandleFunc("/feed", handlers.FeedHandler).Methods("GET")
 	r.HandleFunc("/map", handlers.MapHandler).Methods("GET")
+	r.HandleFunc("/bairro/{slug}", handlers.NeighborhoodHandler).Methods("GET")
+	r.HandleFunc("/busca", handlers.SearchHandler).Methods("GET")
+
+	// Alert subscription routes
+	r.HandleFunc("/alertas", handlers.SubscriptionHandler).Methods("GET", "POST")
+	r.HandleFunc("/alertas/confirmar/{token:[0-9a-f]+}", handlers.ConfirmSubscriptionHandler).Methods("GET")
+	r.HandleFunc("/alertas/cancelar/{token:[0-9a-f]+}", handlers.UnsubscribeHandler).Methods("GET", "POST")
+
+	// Report follower routes (links sent by email)
+	r.HandleFunc("/seguindo/{token:[0-9a-f]+}", handlers.FollowerPreferencesHandler).Methods("GET", "POST")
+	r.HandleFunc("/seguindo/{token:[0-9a-f]+}/parar", handlers.StopFollowingHandler).Methods("GET", "POST")
+	r.HandleFunc("/seguindo/email/{token:[0-9a-f]+}", handlers.ConfirmFollowerEmailHandler).Methods("GET")
+
+	// Email address verification and bounce ingestion
+	r.HandleFunc("/email/verificar/{token:[0-9a-f]+}", handlers.VerifyEmailHandler).Methods("GET")
+	r.HandleFunc("/api/email/bounces", handlers.BounceWebhookHandler).Methods("POST")
+
+	// Moderation routes
+	r.HandleFunc("/moderacao/login", handlers.ModeratorLoginHandler).Methods("GET", "POST")
+	r.HandleFunc("/moderacao/logout", handlers.ModeratorLogoutHandler).Methods("POST")
+	r.HandleFunc("/moderacao/duplicados", handlers.RequireModerator(handlers.DuplicatesModerationHandler)).Methods("GET")
+	r.HandleFunc("/moderacao/comentarios", handlers.RequireModerator(handlers.CommentsModerationHandler)).Methods("GET")
+	r.HandleFunc("/api/moderation/merge", handlers.RequireModerator(handlers.MergeReportsHandler)).Methods("POST")                             // Merge duplicate reports
+	r.HandleFunc("/api/moderation/status", handlers.RequireModerator(handlers.UpdateReportStatusHandler)).Methods("POST")                      // Status and official response
+	r.HandleFunc("/api/moderation/comments", handlers.RequireModerator(handlers.ReviewCommentHandler)).Methods("POST")                         // Keep or remove a comment
+	r.HandleFunc("/api/moderation/comments/{id:[0-9]+}/revisions", handlers.RequireModerator(handlers.CommentRevisionsHandler)).Methods("GET") // Previous versions of a comment
 
 	// Article routes
 	r.HandleFunc("/articles", handlers.ArticlesHandler).Methods("GET")
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"image"
+	"olhourbano2/config"
+	"olhourbano2/models"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	RestrictedDir     = "./uploads/restricted"
+	anonymizedQuality = 90
+	blurPadding       = 0.15 // Boxes grow by this much on each side, since detectors hug the object
+	blurPasses        = 3    // Box blurs in a row approach a gaussian blur
+)
+
+// Haar cascades shipped with OpenCV. The plate cascade was trained on Russian plates, which have
+// the proportions of Mercosul ones.
+const (
+	faceCascadeFile  = "haarcascade_frontalface_default.xml"
+	plateCascadeFile = "haarcascade_russian_plate_number.xml"
+)
+
+// needsAnonymization reports whether an upload of a category gets a blurred public copy
+func needsAnonymization(category, contentType string) bool {
+	return hasRenditions(contentType) && (config.BlursFacesGlobal(category) || config.BlursPlatesGlobal(category))
+}
+
+// anonymizedPathFor returns the public path of the blurred copy of a photo. Copies are always
+// JPEG, whatever the original was.
+func anonymizedPathFor(filename string) string {
+	return filepath.Join(UploadDir, strings.TrimSuffix(filename, filepath.Ext(filename))+".jpg")
+}
+
+// restrictedPathFor returns where the unblurred original of an upload is kept
+func restrictedPathFor(filename string) string {
+	return filepath.Join(RestrictedDir, filename)
+}
+
+// IsRestrictedKey reports whether a storage key is an unblurred original, which only moderators
+// and agencies may download
+func IsRestrictedKey(key string) bool {
+	return strings.HasPrefix(key, UploadKey(RestrictedDir)+"/")
+}
+
+// anonymizeAttachment blurs the faces and plates found in the restricted original of a photo
+// and writes the result to the public path of the attachment, then queues its thumbnail and
+// renditions, which are made from the blurred copy. What is blurred follows the current
+// settings of the report category.
+func anonymizeAttachment(ctx context.Context, db *sql.DB, attachment *models.Attachment) error {
+	var category string
+	if err := db.QueryRow(`SELECT problem_type FROM reports WHERE id = $1`, attachment.ReportID).Scan(&category); err != nil {
+		return fmt.Errorf("error loading report category: %w", err)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	var cascades []string
+	if config.BlursFacesGlobal(category) {
+		cascades = append(cascades, faceCascadeFile)
+	}
+	if config.BlursPlatesGlobal(category) {
+		cascades = append(cascades, plateCascadeFile)
+	}
+
+	// Regions blurred by each cascade
+	counts := make(map[string]int)
+	err = withUploadedFile(attachment.OriginalPath, func(localPath string) error {
+		img, err := decodeImage(localPath)
+		if err != nil {
+			return err
+		}
+		img = flattenImage(img)
+
+		// Detect on a smaller copy; boxes are scaled back to the full image
+		gray := toGray(img)
+		scale := 1.0
+		if side := max(gray.width, gray.height); side > haarMaxDetectSide {
+			scale = float64(side) / haarMaxDetectSide
+			gray = gray.resize(max(1, int(float64(gray.width)/scale)), max(1, int(float64(gray.height)/scale)))
+		}
+
+		for _, name := range cascades {
+			cascade, err := loadHaarCascade(filepath.Join(cfg.CascadeDir, name))
+			if err != nil {
+				return fmt.Errorf("error loading cascade: %w", err)
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			for _, box := range cascade.detect(gray) {
+				blurRegion(img, scaleRect(box, scale))
+				counts[name]++
+			}
+		}
+
+		if err := os.MkdirAll(filepath.Dir(attachment.Path), 0755); err != nil {
+			return err
+		}
+		return writeJPEG(img, attachment.Path, anonymizedQuality)
+	})
+	if err != nil {
+		return err
+	}
+
+	defer releaseUploadedFile(attachment.Path)
+	if err := storeUploadedFile(attachment.Path, "image/jpeg"); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE attachments SET anonymize_status = 'ready', blurred_faces = $2, blurred_plates = $3
+		WHERE id = $1
+	`, attachment.ID, counts[faceCascadeFile], counts[plateCascadeFile])
+	if err != nil {
+		return err
+	}
+	if attachment.ThumbnailPath != "" {
+		if err := EnqueueMediaJob(tx, attachment.ID, models.MediaJobThumbnail); err != nil {
+			return err
+		}
+	}
+	if err := EnqueueMediaJob(tx, attachment.ID, models.MediaJobRenditions); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// scaleRect scales a box and pads it by blurPadding
+func scaleRect(r image.Rectangle, scale float64) image.Rectangle {
+	padX, padY := float64(r.Dx())*blurPadding, float64(r.Dy())*blurPadding
+	return image.Rect(
+		int((float64(r.Min.X)-padX)*scale), int((float64(r.Min.Y)-padY)*scale),
+		int((float64(r.Max.X)+padX)*scale+0.5), int((float64(r.Max.Y)+padY)*scale+0.5))
+}
+
+// blurRegion blurs a box of an image so strongly that faces and characters can't be made out,
+// with a radius proportional to the box
+func blurRegion(img *image.RGBA, r image.Rectangle) {
+	r = r.Intersect(img.Bounds())
+	if r.Empty() {
+		return
+	}
+	radius := max(2, min(r.Dx(), r.Dy())/6)
+
+	w, h := r.Dx(), r.Dy()
+	channels := make([][]float64, 3)
+	for c := range channels {
+		channels[c] = make([]float64, w*h)
+	}
+	for y := 0; y < h; y++ {
+		row := img.Pix[img.PixOffset(r.Min.X, r.Min.Y+y):]
+		for x := 0; x < w; x++ {
+			for c := range channels {
+				channels[c][y*w+x] = float64(row[x*4+c])
+			}
+		}
+	}
+
+	line := make([]float64, max(w, h))
+	for _, channel := range channels {
+		for pass := 0; pass < blurPasses; pass++ {
+			for y := 0; y < h; y++ {
+				boxBlurLine(channel[y*w:], 1, w, radius, line)
+			}
+			for x := 0; x < w; x++ {
+				boxBlurLine(channel[x:], w, h, radius, line)
+			}
+		}
+	}
+
+	for y := 0; y < h; y++ {
+		row := img.Pix[img.PixOffset(r.Min.X, r.Min.Y+y):]
+		for x := 0; x < w; x++ {
+			for c, channel := range channels {
+				row[x*4+c] = uint8(min(max(channel[y*w+x]+0.5, 0), 255))
+			}
+		}
+	}
+}
+
+// boxBlurLine replaces n values, stride apart, with the mean of the values within radius,
+// repeating the edge values past the ends
+func boxBlurLine(values []float64, stride, n, radius int, scratch []float64) {
+	at := func(i int) float64 {
+		return values[min(max(i, 0), n-1)*stride]
+	}
+	sum := 0.0
+	for i := -radius; i <= radius; i++ {
+		sum += at(i)
+	}
+	for i := 0; i < n; i++ {
+		scratch[i] = sum / float64(2*radius+1)
+		sum += at(i+radius+1) - at(i-radius)
+	}
+	for i := 0; i < n; i++ {
+		values[i*stride] = scratch[i]
+	}
+}
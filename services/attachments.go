@@ -0,0 +1,414 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/gif"  // Registers GIF for image.DecodeConfig
+	_ "image/jpeg" // Registers JPEG for image.DecodeConfig
+	_ "image/png"  // Registers PNG for image.DecodeConfig
+	"io"
+	"log"
+	"mime"
+	"olhourbano2/models"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// attachmentColumns are the columns scanned by scanAttachment
+const attachmentColumns = `id, COALESCE(report_id, 0), position, path, COALESCE(original_name, ''), COALESCE(content_type, ''),
+	COALESCE(byte_size, 0), COALESCE(sha256, ''), COALESCE(width, 0), COALESCE(height, 0), COALESCE(duration_seconds, 0),
+	COALESCE(thumbnail_path, ''), thumbnail_status, COALESCE(playback_path, ''), COALESCE(poster_path, ''),
+	COALESCE(sprite_path, ''), transcode_status, COALESCE(original_path, ''), anonymize_status, COALESCE(blurred_faces, 0),
+	COALESCE(blurred_plates, 0), uploader_role, created_at`
+
+// scanAttachment reads a row selected with attachmentColumns
+func scanAttachment(row rowScanner) (*models.Attachment, error) {
+	attachment := &models.Attachment{}
+	err := row.Scan(&attachment.ID, &attachment.ReportID, &attachment.Position, &attachment.Path, &attachment.OriginalName,
+		&attachment.ContentType, &attachment.ByteSize, &attachment.SHA256, &attachment.Width, &attachment.Height,
+		&attachment.DurationSeconds, &attachment.ThumbnailPath, &attachment.ThumbnailStatus, &attachment.PlaybackPath,
+		&attachment.PosterPath, &attachment.SpritePath, &attachment.TranscodeStatus, &attachment.OriginalPath,
+		&attachment.AnonymizeStatus, &attachment.BlurredFaces, &attachment.BlurredPlates, &attachment.UploaderRole,
+		&attachment.CreatedAt)
+	return attachment, err
+}
+
+// rowQuerier is implemented by *sql.DB and *sql.Tx
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// CreateAttachments records the uploaded files of a report, in the order they were sent, and
+// queues their thumbnails, image renditions, video transcoding and photo blurring for the
+// media workers. Files sent ahead through a resumable upload are already attachments and are
+// claimed for the report instead.
+func CreateAttachments(db *sql.DB, reportID int, uploads []*FileUploadResult, role string) ([]*models.Attachment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	attachments := make([]*models.Attachment, 0, len(uploads))
+	for position, upload := range uploads {
+		var attachment *models.Attachment
+		if upload.AttachmentID != 0 {
+			attachment, err = claimAttachment(tx, upload.AttachmentID, reportID, position, role)
+		} else {
+			attachment, err = insertAttachment(tx, sql.NullInt64{Int64: int64(reportID), Valid: true}, position, upload, role)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := enqueueAttachmentJobs(tx, attachment); err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing attachments: %w", err)
+	}
+	return attachments, nil
+}
+
+// insertAttachment records a processed upload. The report is NULL for resumable uploads, which
+// wait for the form that references them.
+func insertAttachment(db rowQuerier, reportID sql.NullInt64, position int, upload *FileUploadResult, role string) (*models.Attachment, error) {
+	thumbnailStatus := models.ThumbnailStatusNone
+	if upload.ThumbnailPath != "" {
+		thumbnailStatus = models.ThumbnailStatusPending
+	}
+	transcodeStatus := models.TranscodeStatusNone
+	if isTranscodable(upload.ContentType) {
+		transcodeStatus = models.TranscodeStatusPending
+	}
+	anonymizeStatus := models.AnonymizeStatusNone
+	if upload.OriginalPath != "" {
+		anonymizeStatus = models.AnonymizeStatusPending
+	}
+
+	attachment, err := scanAttachment(db.QueryRow(`
+		INSERT INTO attachments (report_id, position, path, original_name, content_type, byte_size, sha256, width, height,
+			duration_seconds, thumbnail_path, thumbnail_status, transcode_status, original_path, anonymize_status,
+			uploader_role, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, 0), NULLIF($9, 0), NULLIF($10, 0), NULLIF($11, ''), $12, $13,
+			NULLIF($14, ''), $15, $16, NOW())
+		RETURNING `+attachmentColumns,
+		reportID, position, filepath.ToSlash(upload.SavedPath), upload.OriginalName, upload.ContentType, upload.FileSize,
+		upload.SHA256, upload.Width, upload.Height, upload.DurationSeconds, filepath.ToSlash(upload.ThumbnailPath),
+		thumbnailStatus, transcodeStatus, filepath.ToSlash(upload.OriginalPath), anonymizeStatus, role))
+	if err != nil {
+		return nil, fmt.Errorf("error saving attachment %s: %w", upload.SavedPath, err)
+	}
+	return attachment, nil
+}
+
+// claimAttachment moves a completed resumable upload to a report and ends the upload
+func claimAttachment(tx *sql.Tx, attachmentID, reportID, position int, role string) (*models.Attachment, error) {
+	attachment, err := scanAttachment(tx.QueryRow(`
+		UPDATE attachments SET report_id = $2, position = $3, uploader_role = $4
+		WHERE id = $1 AND report_id IS NULL
+		RETURNING `+attachmentColumns,
+		attachmentID, reportID, position, role))
+	if err != nil {
+		return nil, fmt.Errorf("error claiming attachment %d: %w", attachmentID, err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM resumable_uploads WHERE attachment_id = $1`, attachmentID); err != nil {
+		return nil, fmt.Errorf("error ending upload of attachment %d: %w", attachmentID, err)
+	}
+	return attachment, nil
+}
+
+// enqueueAttachmentJobs queues the media jobs a new attachment needs
+func enqueueAttachmentJobs(tx *sql.Tx, attachment *models.Attachment) error {
+	// Thumbnails and renditions of blurred photos wait for the public copy
+	if attachment.AnonymizeStatus == models.AnonymizeStatusPending {
+		return EnqueueMediaJob(tx, attachment.ID, models.MediaJobAnonymize)
+	}
+	if attachment.ThumbnailStatus == models.ThumbnailStatusPending {
+		if err := EnqueueMediaJob(tx, attachment.ID, models.MediaJobThumbnail); err != nil {
+			return err
+		}
+	}
+	if hasRenditions(attachment.ContentType) {
+		if err := EnqueueMediaJob(tx, attachment.ID, models.MediaJobRenditions); err != nil {
+			return err
+		}
+	}
+	if attachment.TranscodeStatus == models.TranscodeStatusPending {
+		if err := EnqueueMediaJob(tx, attachment.ID, models.MediaJobTranscode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generateAttachmentThumbnail makes the thumbnail from a local copy of the file and keeps it in
+// the storage backend
+func generateAttachmentThumbnail(ctx context.Context, attachment *models.Attachment) error {
+	if err := os.MkdirAll(filepath.Dir(attachment.ThumbnailPath), 0755); err != nil {
+		return err
+	}
+	err := withUploadedFile(attachment.Path, func(localPath string) error {
+		return generateThumbnail(ctx, localPath, attachment.ContentType, attachment.ThumbnailPath)
+	})
+	if err != nil {
+		return err
+	}
+	defer releaseUploadedFile(attachment.ThumbnailPath)
+	return storeUploadedFile(attachment.ThumbnailPath, "image/jpeg")
+}
+
+// GetReportAttachments returns the attachments of a report in upload order
+func GetReportAttachments(db *sql.DB, reportID int) ([]*models.Attachment, error) {
+	rows, err := db.Query(`SELECT `+attachmentColumns+` FROM attachments WHERE report_id = $1 ORDER BY position`, reportID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying attachments: %w", err)
+	}
+	defer rows.Close()
+
+	var attachments []*models.Attachment
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning attachment: %w", err)
+		}
+		attachments = append(attachments, attachment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attachments, loadAttachmentRenditions(db, attachments)
+}
+
+// LoadReportAttachments fills the attachments of a page of reports with a single query
+func LoadReportAttachments(db *sql.DB, reports []*models.Report) error {
+	if len(reports) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*models.Report, len(reports))
+	ids := make([]int64, 0, len(reports))
+	for _, report := range reports {
+		byID[report.ID] = report
+		ids = append(ids, int64(report.ID))
+	}
+
+	rows, err := db.Query(`SELECT `+attachmentColumns+` FROM attachments WHERE report_id = ANY($1) ORDER BY report_id, position`, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("error querying attachments: %w", err)
+	}
+	defer rows.Close()
+
+	var attachments []*models.Attachment
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return fmt.Errorf("error scanning attachment: %w", err)
+		}
+		report := byID[attachment.ReportID]
+		report.Attachments = append(report.Attachments, attachment)
+		attachments = append(attachments, attachment)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return loadAttachmentRenditions(db, attachments)
+}
+
+// loadAttachmentRenditions fills the renditions of attachments with a single query
+func loadAttachmentRenditions(db *sql.DB, attachments []*models.Attachment) error {
+	if len(attachments) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*models.Attachment, len(attachments))
+	ids := make([]int64, 0, len(attachments))
+	for _, attachment := range attachments {
+		byID[attachment.ID] = attachment
+		ids = append(ids, int64(attachment.ID))
+	}
+
+	rows, err := db.Query(`
+		SELECT attachment_id, width, height, format, path, byte_size FROM attachment_renditions
+		WHERE attachment_id = ANY($1)
+		ORDER BY attachment_id, width, format
+	`, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("error querying renditions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rendition := &models.AttachmentRendition{}
+		if err := rows.Scan(&rendition.AttachmentID, &rendition.Width, &rendition.Height, &rendition.Format,
+			&rendition.Path, &rendition.ByteSize); err != nil {
+			return fmt.Errorf("error scanning rendition: %w", err)
+		}
+		attachment := byID[rendition.AttachmentID]
+		attachment.Renditions = append(attachment.Renditions, rendition)
+	}
+
+	return rows.Err()
+}
+
+// BackfillAttachments reads the files of attachments saved without a hash, such as the ones split
+// from the old photo_path column, and records their type, size, hash, dimensions and thumbnail.
+// Returns how many attachments were updated.
+func BackfillAttachments(db *sql.DB) (int, error) {
+	rows, err := db.Query(`SELECT ` + attachmentColumns + ` FROM attachments WHERE sha256 IS NULL ORDER BY id`)
+	if err != nil {
+		return 0, fmt.Errorf("error querying attachments: %w", err)
+	}
+	var attachments []*models.Attachment
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning attachment: %w", err)
+		}
+		attachments = append(attachments, attachment)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, attachment := range attachments {
+		contentType := attachment.ContentType
+		if contentType == "" {
+			contentType = strings.SplitN(mime.TypeByExtension(strings.ToLower(filepath.Ext(attachment.Path))), ";", 2)[0]
+		}
+
+		info := &FileUploadResult{ContentType: contentType}
+		err := withUploadedFile(attachment.Path, func(localPath string) error {
+			info.SavedPath = localPath
+			return inspectUploadedFile(info)
+		})
+		if err != nil {
+			log.Printf("Skipping attachment %d: %v", attachment.ID, err)
+			continue
+		}
+
+		// Thumbnails of old uploads were named after the file, so they may already be stored
+		thumbnailPath, thumbnailStatus := "", models.ThumbnailStatusNone
+		if shouldGenerateThumbnail(contentType) {
+			thumbnailPath, thumbnailStatus = thumbnailPathFor(attachment.Path), models.ThumbnailStatusPending
+			if store, err := CurrentBlobStore(); err == nil {
+				if _, err := store.Stat(UploadKey(thumbnailPath)); err == nil {
+					thumbnailStatus = models.ThumbnailStatusReady
+				}
+			}
+		}
+
+		transcodeStatus := models.TranscodeStatusNone
+		if isTranscodable(contentType) {
+			transcodeStatus = models.TranscodeStatusPending
+		}
+
+		_, err = db.Exec(`
+			UPDATE attachments SET content_type = NULLIF($2, ''), byte_size = $3, sha256 = $4, width = NULLIF($5, 0),
+				height = NULLIF($6, 0), duration_seconds = NULLIF($7, 0), thumbnail_path = NULLIF($8, ''), thumbnail_status = $9,
+				transcode_status = $10
+			WHERE id = $1
+		`, attachment.ID, contentType, info.FileSize, info.SHA256, info.Width, info.Height, info.DurationSeconds,
+			filepath.ToSlash(thumbnailPath), thumbnailStatus, transcodeStatus)
+		if err != nil {
+			return updated, fmt.Errorf("error updating attachment %d: %w", attachment.ID, err)
+		}
+		if thumbnailStatus == models.ThumbnailStatusPending {
+			if err := EnqueueMediaJob(db, attachment.ID, models.MediaJobThumbnail); err != nil {
+				return updated, err
+			}
+		}
+		if hasRenditions(contentType) {
+			if err := EnqueueMediaJob(db, attachment.ID, models.MediaJobRenditions); err != nil {
+				return updated, err
+			}
+		}
+		if transcodeStatus == models.TranscodeStatusPending {
+			if err := EnqueueMediaJob(db, attachment.ID, models.MediaJobTranscode); err != nil {
+				return updated, err
+			}
+		}
+		updated++
+	}
+
+	return updated, nil
+}
+
+// inspectUploadedFile records the size and SHA-256 of a saved file, plus the dimensions of
+// images and the duration of videos when they can be read
+func inspectUploadedFile(result *FileUploadResult) error {
+	file, err := os.Open(result.SavedPath)
+	if err != nil {
+		return fmt.Errorf("erro ao abrir arquivo: %v", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return fmt.Errorf("erro ao ler arquivo: %v", err)
+	}
+	result.FileSize = size
+	result.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	switch {
+	case strings.HasPrefix(result.ContentType, "image/"):
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil
+		}
+		if config, _, err := image.DecodeConfig(file); err == nil {
+			result.Width, result.Height = config.Width, config.Height
+		}
+	case strings.HasPrefix(result.ContentType, "video/"):
+		result.Width, result.Height, result.DurationSeconds = probeVideo(result.SavedPath)
+	}
+
+	return nil
+}
+
+// probeVideo reads the dimensions and duration of a video with ffprobe, returning zeros when it
+// isn't installed or can't read the file
+func probeVideo(videoPath string) (int, int, float64) {
+	output, err := exec.Command("ffprobe",
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=width,height:format=duration",
+		"-of", "default=noprint_wrappers=1",
+		videoPath,
+	).Output()
+	if err != nil {
+		return 0, 0, 0
+	}
+
+	var width, height int
+	var duration float64
+	for _, line := range strings.Split(string(output), "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch key {
+		case "width":
+			width, _ = strconv.Atoi(value)
+		case "height":
+			height, _ = strconv.Atoi(value)
+		case "duration":
+			duration, _ = strconv.ParseFloat(value, 64)
+		}
+	}
+	return width, height, duration
+}
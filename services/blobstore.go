@@ -0,0 +1,358 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"olhourbano2/config"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrBlobNotFound is returned when a key is not in the store
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobInfo describes a stored file
+type BlobInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// BlobStore keeps uploaded files and their thumbnails. Keys are slash-separated paths relative
+// to the uploads directory, such as "abc.jpg" or "thumbnails/abc_thumb.jpg".
+type BlobStore interface {
+	Put(key string, r io.Reader, size int64, contentType string) error
+	// Get opens a file; the reader also implements io.ReadSeeker when the store allows ranges
+	Get(key string) (io.ReadCloser, *BlobInfo, error)
+	Stat(key string) (*BlobInfo, error)
+	Delete(key string) error
+	// List calls fn for every file whose key starts with prefix
+	List(prefix string, fn func(BlobInfo) error) error
+	// PresignURL returns a temporary download URL, or "" when downloads must go through the app
+	PresignURL(key string, expires time.Duration) (string, error)
+}
+
+// NewBlobStore creates the storage backend named by backend ("local" or "s3")
+func NewBlobStore(cfg *config.Config, backend string) (BlobStore, error) {
+	switch backend {
+	case "local":
+		return NewLocalBlobStore(cfg.StorageDir), nil
+	case "s3":
+		return NewS3BlobStore(cfg.S3Endpoint, cfg.S3Region, cfg.S3Bucket, cfg.S3AccessKey, cfg.S3SecretKey, cfg.S3PathStyle)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
+
+// The store used for uploads and how downloads are served; main sets them up from config,
+// otherwise the store is created on first use and downloads stream through the app
+var (
+	blobStoreMu        sync.Mutex
+	blobStore          BlobStore
+	presignedDownloads bool
+)
+
+// SetBlobStore replaces the store used for uploads
+func SetBlobStore(store BlobStore) {
+	blobStoreMu.Lock()
+	defer blobStoreMu.Unlock()
+	blobStore = store
+}
+
+// SetPresignedDownloads chooses whether downloads redirect to presigned URLs of the store
+// (STORAGE_DOWNLOADS=presigned) instead of streaming through the app
+func SetPresignedDownloads(enabled bool) {
+	blobStoreMu.Lock()
+	defer blobStoreMu.Unlock()
+	presignedDownloads = enabled
+}
+
+// PresignedDownloads reports whether downloads redirect to presigned URLs of the store
+func PresignedDownloads() bool {
+	blobStoreMu.Lock()
+	defer blobStoreMu.Unlock()
+	return presignedDownloads
+}
+
+// CurrentBlobStore returns the store selected by STORAGE_BACKEND
+func CurrentBlobStore() (BlobStore, error) {
+	blobStoreMu.Lock()
+	defer blobStoreMu.Unlock()
+
+	if blobStore == nil {
+		cfg, err := config.Load()
+		if err != nil {
+			return nil, fmt.Errorf("erro ao carregar configuração: %v", err)
+		}
+		if blobStore, err = NewBlobStore(cfg, cfg.StorageBackend); err != nil {
+			return nil, err
+		}
+	}
+	return blobStore, nil
+}
+
+// UploadKey converts a path saved in the database, like "uploads/abc.jpg", into a store key
+func UploadKey(filePath string) string {
+	key := strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(filePath)), "/")
+	return strings.TrimPrefix(key, strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(UploadDir)), "/")+"/")
+}
+
+// validBlobKey rejects keys that are empty or try to leave the store
+func validBlobKey(key string) bool {
+	return key != "" && !strings.HasPrefix(key, "/") && path.Clean(key) == key && key != ".." && !strings.HasPrefix(key, "../")
+}
+
+// storesInUploadDir reports whether the store keeps files where uploads are processed, so the
+// working copies already are the stored files
+func storesInUploadDir(store BlobStore) bool {
+	local, ok := store.(*LocalBlobStore)
+	if !ok {
+		return false
+	}
+	root, err1 := filepath.Abs(local.root)
+	uploads, err2 := filepath.Abs(UploadDir)
+	return err1 == nil && err2 == nil && root == uploads
+}
+
+// storeUploadedFile copies a processed file from the uploads directory to the store
+func storeUploadedFile(filePath, contentType string) error {
+	store, err := CurrentBlobStore()
+	if err != nil {
+		return err
+	}
+	if storesInUploadDir(store) {
+		return nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return store.Put(UploadKey(filePath), file, info.Size(), contentType)
+}
+
+// releaseUploadedFile removes the working copy of a file once it is in the store
+func releaseUploadedFile(filePath string) {
+	store, err := CurrentBlobStore()
+	if err != nil || storesInUploadDir(store) {
+		return
+	}
+	os.Remove(filePath)
+}
+
+// withUploadedFile runs fn with a local copy of a stored file, downloading it when the working
+// copy is gone
+func withUploadedFile(filePath string, fn func(localPath string) error) error {
+	if _, err := os.Stat(filePath); err == nil {
+		return fn(filePath)
+	}
+
+	store, err := CurrentBlobStore()
+	if err != nil {
+		return err
+	}
+	reader, _, err := store.Get(UploadKey(filePath))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	temp, err := os.CreateTemp("", "blob-*"+filepath.Ext(filePath))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	if _, err := io.Copy(temp, reader); err != nil {
+		return err
+	}
+	return fn(temp.Name())
+}
+
+// MigrateBlobs copies every file missing from the destination, or stored there with a different
+// size, from one store to the other. Returns how many files and bytes were copied.
+func MigrateBlobs(from, to BlobStore, dryRun bool) (int, int64, error) {
+	copied := 0
+	var copiedBytes int64
+	err := from.List("", func(info BlobInfo) error {
+		// Partial uploads stay on the instance receiving them
+		if IsStagingKey(info.Key) {
+			return nil
+		}
+		if existing, err := to.Stat(info.Key); err == nil && existing.Size == info.Size {
+			return nil
+		} else if err != nil && !errors.Is(err, ErrBlobNotFound) {
+			return fmt.Errorf("error checking %s: %w", info.Key, err)
+		}
+
+		if !dryRun {
+			reader, _, err := from.Get(info.Key)
+			if err != nil {
+				return fmt.Errorf("error reading %s: %w", info.Key, err)
+			}
+			err = to.Put(info.Key, reader, info.Size, info.ContentType)
+			reader.Close()
+			if err != nil {
+				return fmt.Errorf("error writing %s: %w", info.Key, err)
+			}
+		}
+		copied++
+		copiedBytes += info.Size
+		return nil
+	})
+	return copied, copiedBytes, err
+}
+
+// LocalBlobStore keeps files in a directory on local disk
+type LocalBlobStore struct {
+	root string
+}
+
+// NewLocalBlobStore creates a store rooted at dir
+func NewLocalBlobStore(dir string) *LocalBlobStore {
+	return &LocalBlobStore{root: dir}
+}
+
+// filePath returns where a key is stored on disk
+func (s *LocalBlobStore) filePath(key string) (string, error) {
+	if !validBlobKey(key) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+// Put writes a file through a temporary name, so readers never see it half written
+func (s *LocalBlobStore) Put(key string, r io.Reader, size int64, contentType string) error {
+	filePath, err := s.filePath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(filePath), ".put-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(temp, r); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	os.Chmod(temp.Name(), 0644)
+	return os.Rename(temp.Name(), filePath)
+}
+
+// Get opens a file; *os.File lets downloads serve ranges
+func (s *LocalBlobStore) Get(key string) (io.ReadCloser, *BlobInfo, error) {
+	info, err := s.Stat(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	filePath, _ := s.filePath(key)
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, info, nil
+}
+
+// Stat describes a file, guessing its content type from the extension
+func (s *LocalBlobStore) Stat(key string) (*BlobInfo, error) {
+	filePath, err := s.filePath(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(filePath)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &BlobInfo{
+		Key:         key,
+		Size:        info.Size(),
+		ContentType: mime.TypeByExtension(strings.ToLower(filepath.Ext(key))),
+		ModTime:     info.ModTime(),
+	}, nil
+}
+
+// Delete removes a file; deleting a missing file is not an error
+func (s *LocalBlobStore) Delete(key string) error {
+	filePath, err := s.filePath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List walks the directory, skipping temporary files of interrupted writes and files removed
+// while it runs. A missing directory has no files.
+func (s *LocalBlobStore) List(prefix string, fn func(BlobInfo) error) error {
+	err := filepath.WalkDir(s.root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if filePath != s.root && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".put-") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, filePath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return fn(BlobInfo{
+			Key:         key,
+			Size:        info.Size(),
+			ContentType: mime.TypeByExtension(strings.ToLower(filepath.Ext(key))),
+			ModTime:     info.ModTime(),
+		})
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		if _, statErr := os.Stat(s.root); errors.Is(statErr, fs.ErrNotExist) {
+			return nil
+		}
+	}
+	return err
+}
+
+// PresignURL returns "": local files are always served by the app
+func (s *LocalBlobStore) PresignURL(key string, expires time.Duration) (string, error) {
+	return "", nil
+}
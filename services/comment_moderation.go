@@ -0,0 +1,234 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"olhourbano2/config"
+	"olhourbano2/models"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Moderator decisions on a comment in the review queue
+const (
+	CommentReviewKeep   = "keep"
+	CommentReviewRemove = "remove"
+)
+
+// ErrOwnCommentFlag is returned by FlagComment when authors flag their own comment
+var ErrOwnCommentFlag = errors.New("comment authors can't flag their own comments")
+
+// flagsSinceReview counts the flags of comment c that arrived after its last review
+const flagsSinceReview = `(SELECT COUNT(*) FROM comment_flags f WHERE f.comment_id = c.id AND f.created_at > COALESCE(c.reviewed_at, '-infinity'))`
+
+// FlagComment records a citizen flag on a comment, one per hashed CPF, and hides the comment once
+// it gets enough flags since its last review. Returns whether the comment is now hidden.
+func FlagComment(db *sql.DB, commentID int, hashedCPF, reason, detail string) (bool, error) {
+	if _, ok := models.CommentFlagReasons[reason]; !ok {
+		return false, fmt.Errorf("invalid flag reason %q", reason)
+	}
+	moderation, err := config.GetCommentModeration()
+	if err != nil {
+		return false, fmt.Errorf("error loading comment moderation: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the comment so concurrent flags don't both miss the threshold
+	var reportID int
+	var status, authorCPF string
+	err = tx.QueryRow(`SELECT report_id, status, hashed_cpf FROM comments WHERE id = $1 FOR UPDATE`, commentID).Scan(&reportID, &status, &authorCPF)
+	if err != nil {
+		return false, err
+	}
+	if status == models.CommentStatusRemoved || status == models.CommentStatusDeleted {
+		return false, sql.ErrNoRows
+	}
+	if authorCPF == hashedCPF {
+		return false, ErrOwnCommentFlag
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO comment_flags (comment_id, hashed_cpf, reason, detail, created_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NOW())
+		ON CONFLICT (comment_id, hashed_cpf) DO NOTHING
+	`, commentID, hashedCPF, reason, truncateEmailText(strings.TrimSpace(detail), 300))
+	if err != nil {
+		return false, fmt.Errorf("error flagging comment: %w", err)
+	}
+
+	if status != models.CommentStatusVisible {
+		return true, tx.Commit()
+	}
+
+	result, err := tx.Exec(`
+		UPDATE comments c SET status = 'hidden', hidden_reason = 'flags'
+		WHERE c.id = $1 AND `+flagsSinceReview+` >= $2
+	`, commentID, moderation.FlagsToHide)
+	if err != nil {
+		return false, fmt.Errorf("error hiding flagged comment: %w", err)
+	}
+	hidden, _ := result.RowsAffected()
+	if hidden > 0 {
+		if err := updateCommentCount(tx, reportID); err != nil {
+			return false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing flag: %w", err)
+	}
+	return hidden > 0, nil
+}
+
+// ListCommentReviewQueue returns the hidden comments awaiting review, then visible comments with
+// flags since their last review, most flagged first
+func ListCommentReviewQueue(db *sql.DB, limit int) ([]*models.FlaggedComment, error) {
+	rows, err := db.Query(`
+		SELECT c.id, c.report_id, c.parent_id, c.depth, c.content, c.status, c.created_at, c.edited_at,
+		       COALESCE(c.hidden_reason, ''), COALESCE(c.filter_match, ''), `+flagsSinceReview+` AS flag_count
+		FROM comments c
+		WHERE c.status = 'hidden' OR (c.status = 'visible' AND `+flagsSinceReview+` > 0)
+		ORDER BY c.status = 'hidden' DESC, flag_count DESC, c.created_at ASC
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying comment review queue: %w", err)
+	}
+
+	var comments []*models.FlaggedComment
+	byID := make(map[int]*models.FlaggedComment)
+	var ids []int64
+	for rows.Next() {
+		comment := &models.FlaggedComment{FlagReasons: make(map[string]int)}
+		var parentID sql.NullInt64
+		var editedAt sql.NullTime
+		err := rows.Scan(&comment.ID, &comment.ReportID, &parentID, &comment.Depth, &comment.Content, &comment.Status,
+			&comment.CreatedAt, &editedAt, &comment.HiddenReason, &comment.FilterMatch, &comment.FlagCount)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning flagged comment: %w", err)
+		}
+		if parentID.Valid {
+			id := int(parentID.Int64)
+			comment.ParentID = &id
+		}
+		if editedAt.Valid {
+			comment.EditedAt = &editedAt.Time
+		}
+		comments = append(comments, comment)
+		byID[comment.ID] = comment
+		ids = append(ids, int64(comment.ID))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return comments, nil
+	}
+
+	// Summarize the pending flags of each comment
+	rows, err = db.Query(`
+		SELECT f.comment_id, f.reason, COALESCE(f.detail, '')
+		FROM comment_flags f
+		JOIN comments c ON c.id = f.comment_id
+		WHERE f.comment_id = ANY($1) AND f.created_at > COALESCE(c.reviewed_at, '-infinity')
+		ORDER BY f.created_at ASC
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("error querying comment flags: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var commentID int
+		var reason, detail string
+		if err := rows.Scan(&commentID, &reason, &detail); err != nil {
+			return nil, fmt.Errorf("error scanning comment flag: %w", err)
+		}
+		comment := byID[commentID]
+		comment.FlagReasons[reason]++
+		if detail != "" {
+			comment.FlagDetails = append(comment.FlagDetails, detail)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Moderators see what was written before personal data was masked
+	rows, err = db.Query(`
+		SELECT DISTINCT ON (o.comment_id) o.comment_id, o.ciphertext
+		FROM redacted_originals o
+		JOIN comments c ON c.id = o.comment_id
+		WHERE o.comment_id = ANY($1) AND o.created_at >= COALESCE(c.edited_at, c.created_at)
+		ORDER BY o.comment_id, o.created_at DESC, o.id DESC
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("error querying comment originals: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var commentID int
+		var ciphertext []byte
+		if err := rows.Scan(&commentID, &ciphertext); err != nil {
+			return nil, fmt.Errorf("error scanning comment original: %w", err)
+		}
+		if byID[commentID].Original, err = decryptPII(ciphertext); err != nil {
+			log.Printf("Error decrypting original of comment %d: %v", commentID, err)
+		}
+	}
+
+	return comments, rows.Err()
+}
+
+// ReviewComment applies a moderator decision: keep makes the comment visible and discounts its
+// current flags, remove takes it down for good. The report comment count follows either way.
+func ReviewComment(db *sql.DB, commentID int, decision string) error {
+	var status string
+	switch decision {
+	case CommentReviewKeep:
+		status = models.CommentStatusVisible
+	case CommentReviewRemove:
+		status = models.CommentStatusRemoved
+	default:
+		return fmt.Errorf("invalid review decision %q", decision)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var reportID int
+	err = tx.QueryRow(`
+		UPDATE comments SET
+			status = $2,
+			hidden_reason = CASE WHEN $2 = 'visible' THEN NULL ELSE COALESCE(hidden_reason, 'moderator') END,
+			reviewed_at = NOW()
+		WHERE id = $1 AND status != 'deleted'
+		RETURNING report_id
+	`, commentID, status).Scan(&reportID)
+	if err != nil {
+		return err
+	}
+
+	if err := updateCommentCount(tx, reportID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing review: %w", err)
+	}
+	return nil
+}
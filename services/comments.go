@@ -2,118 +2,328 @@ package services
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
+	"olhourbano2/config"
 	"olhourbano2/models"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// CommentRepliesPreview is how many replies of each thread are loaded with their comment; the rest are paged
+const CommentRepliesPreview = 3
+
+// commentDisplayColumns lists the columns scanned by queryCommentDisplays, for comments aliased as c
+const commentDisplayColumns = `c.id, c.report_id, c.parent_id, c.depth, c.content, c.created_at, c.hashed_cpf, c.status, c.edited_at,
+	(SELECT COUNT(*) FROM comments r WHERE r.parent_id = c.id)`
+
+// commentCountExpression counts the visible comments of the report being updated; hidden and
+// removed comments are placeholders and don't count
+const commentCountExpression = `(SELECT COUNT(*) FROM comments WHERE comments.report_id = reports.id AND comments.status = 'visible')`
+
+// Errors returned when creating, editing or deleting comments
+var (
+	ErrCommentRejected    = errors.New("comment rejected by the content filter")
+	ErrCommentNotOwner    = errors.New("comment belongs to someone else")
+	ErrCommentEditExpired = errors.New("comment edit window has passed")
+	ErrCommentNotEditable = errors.New("comment is not visible")
 )
 
-// CreateComment creates a new comment on a report
-func CreateComment(db *sql.DB, reportID int, hashedCPF, content string) (*models.Comment, error) {
+// CreateComment creates a new comment on a report, or a reply when parentID is set. Replies to a
+// comment at the maximum depth join that comment's thread, and its author is notified either way.
+// The content filter runs first: rejected comments return ErrCommentRejected, and held ones are
+// saved hidden for review without notifying anyone.
+func CreateComment(db *sql.DB, reportID, parentID int, hashedCPF, content string) (*models.Comment, error) {
 	// Validate content length
 	if len(content) > 500 {
 		return nil, fmt.Errorf("comment content exceeds 500 character limit")
 	}
 
-	// Insert the comment
+	moderation, err := config.GetCommentModeration()
+	if err != nil {
+		return nil, fmt.Errorf("error loading comment filters: %w", err)
+	}
+	status, hiddenReason, filterMatch := models.CommentStatusVisible, sql.NullString{}, sql.NullString{}
+	switch action, match := moderation.Check(content); action {
+	case config.CommentFilterReject:
+		log.Printf("Comentário na denúncia %d recusado pelo filtro %q", reportID, match)
+		return nil, ErrCommentRejected
+	case config.CommentFilterHold:
+		status = models.CommentStatusHidden
+		hiddenReason = sql.NullString{String: "filter", Valid: true}
+		filterMatch = sql.NullString{String: match, Valid: true}
+	}
+
+	// Personal data is masked in the public content; moderators can still read the original
+	original := content
+	content, piiKinds := RedactPII(content)
+
+	var parent *models.Comment
+	var threadParentID *int
+	depth := 0
+	if parentID > 0 {
+		parent, err = GetComment(db, parentID)
+		if err == nil && (parent.ReportID != reportID || parent.Status != models.CommentStatusVisible) {
+			err = sql.ErrNoRows
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error loading parent comment %d: %w", parentID, err)
+		}
+
+		threadParentID, depth = &parent.ID, parent.Depth+1
+		if parent.Depth >= models.MaxCommentDepth {
+			threadParentID, depth = parent.ParentID, parent.Depth
+		}
+	}
+
+	// Insert the comment and the original of its masked content together
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	var comment models.Comment
-	err := db.QueryRow(`
-		INSERT INTO comments (report_id, hashed_cpf, content, created_at)
-		VALUES ($1, $2, $3, NOW())
-		RETURNING id, report_id, hashed_cpf, content, created_at
-	`, reportID, hashedCPF, content).Scan(
-		&comment.ID, &comment.ReportID, &comment.HashedCPF, &comment.Content, &comment.CreatedAt,
+	var insertedParentID sql.NullInt64
+	err = tx.QueryRow(`
+		INSERT INTO comments (report_id, parent_id, depth, hashed_cpf, content, status, hidden_reason, filter_match, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW())
+		RETURNING id, report_id, parent_id, depth, hashed_cpf, content, status, created_at
+	`, reportID, threadParentID, depth, hashedCPF, content, status, hiddenReason, filterMatch).Scan(
+		&comment.ID, &comment.ReportID, &insertedParentID, &comment.Depth, &comment.HashedCPF, &comment.Content, &comment.Status, &comment.CreatedAt,
 	)
 
 	if err != nil {
 		return nil, fmt.Errorf("error creating comment: %w", err)
 	}
+	if insertedParentID.Valid {
+		id := int(insertedParentID.Int64)
+		comment.ParentID = &id
+	}
+	if len(piiKinds) > 0 {
+		if err := saveRedactedOriginal(tx, "comment_id", comment.ID, original, piiKinds); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing comment: %w", err)
+	}
 
-	// Update comment count in reports table
-	_, err = db.Exec(`
-		UPDATE reports 
-		SET comment_count = (
-			SELECT COUNT(*) 
-			FROM comments 
-			WHERE report_id = $1
-		)
-		WHERE id = $1
-	`, reportID)
+	if comment.Status != models.CommentStatusVisible {
+		return &comment, nil
+	}
 
-	if err != nil {
-		return nil, fmt.Errorf("error updating comment count: %w", err)
+	if err := updateCommentCount(db, reportID); err != nil {
+		return nil, err
 	}
 
-	// Send email notification to report owner (async)
-	go sendCommentNotificationEmail(db, reportID, hashedCPF, content)
+	// The author of the replied comment gets a reply email instead of the generic follower one
+	var repliedCPF string
+	if parent != nil && NotifyCommentReply(db, parent, &comment) {
+		repliedCPF = parent.HashedCPF
+	}
+
+	// Notify the report followers, including the owner, through the email outbox
+	NotifyReportFollowers(db, models.FollowerEvent{
+		Type:     models.FollowerEventComment,
+		Key:      fmt.Sprintf("comment:%d", comment.ID),
+		ReportID: reportID,
+		Params: map[string]string{
+			"author":  "OlhoUrbano" + comment.GetHashedCPFDisplay(),
+			"excerpt": truncateEmailText(content, 100),
+		},
+		ActorCPF: hashedCPF,
+		SkipCPF:  repliedCPF,
+	})
 
 	return &comment, nil
 }
 
-// sendCommentNotificationEmail sends email notification to report owner
-func sendCommentNotificationEmail(db *sql.DB, reportID int, commenterHashedCPF, commentContent string) {
-	// Get report owner's email
-	var reportOwnerEmail string
+// NotifyCommentReply emails the author of a comment about a reply, at the last address they gave
+// for their CPF, unless they muted the report. Returns whether the email was queued.
+func NotifyCommentReply(db *sql.DB, parent, reply *models.Comment) bool {
+	if parent.HashedCPF == "" || parent.HashedCPF == reply.HashedCPF {
+		return false
+	}
+
+	// Prefer the follower entry for this report, whose stop link works as unsubscribe
+	var email, locale, manageToken, preference string
 	err := db.QueryRow(`
-		SELECT email 
-		FROM reports 
-		WHERE id = $1
-	`, reportID).Scan(&reportOwnerEmail)
+		SELECT email, locale, manage_token, preference
+		FROM (
+			SELECT report_id, email, locale, manage_token, preference, created_at
+			FROM report_followers
+			WHERE hashed_cpf = $1
+			UNION ALL
+			SELECT id, email, $3::VARCHAR, ''::VARCHAR, $4::VARCHAR, created_at
+			FROM reports
+			WHERE hashed_cpf = $1 AND email IS NOT NULL AND email != ''
+		) known
+		ORDER BY (report_id = $2 AND manage_token != '') DESC, created_at DESC
+		LIMIT 1
+	`, parent.HashedCPF, reply.ReportID, DefaultEmailLocale, models.FrequencyInstant).Scan(&email, &locale, &manageToken, &preference)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Erro ao buscar email do autor do comentário %d: %v", parent.ID, err)
+		}
+		return false
+	}
+	if preference == models.FollowerPreferenceOff {
+		return false
+	}
 
+	verified, err := RequestEmailVerification(db, email, locale)
 	if err != nil {
-		// Log error but don't fail the comment creation
-		fmt.Printf("Error getting report owner email for notification: %v\n", err)
-		return
+		log.Printf("Erro ao solicitar verificação do email do autor do comentário %d: %v", parent.ID, err)
+	}
+	if !verified {
+		return false
 	}
 
-	// Don't send notification if no email or if commenter is the report owner
-	if reportOwnerEmail == "" {
-		return
+	unsubscribeURL := ""
+	if manageToken != "" {
+		unsubscribeURL = fmt.Sprintf("%s/seguindo/%s/parar", siteURL, manageToken)
 	}
+	template, err := GetCommentReplyEmailTemplate(locale, reply, unsubscribeURL)
+	if err == nil {
+		err = EnqueueEmail(db, fmt.Sprintf("comment-reply:%d", reply.ID), email, template)
+	}
+	if err != nil {
+		log.Printf("Erro ao enfileirar resposta ao comentário %d: %v", parent.ID, err)
+		return false
+	}
+	return true
+}
 
-	// Check if commenter is the report owner (to avoid self-notifications)
-	var reportOwnerHashedCPF string
-	err = db.QueryRow(`
-		SELECT hashed_cpf 
-		FROM reports 
+// GetComment retrieves a single comment
+func GetComment(db *sql.DB, commentID int) (*models.Comment, error) {
+	var comment models.Comment
+	var parentID sql.NullInt64
+	var editedAt sql.NullTime
+	err := db.QueryRow(`
+		SELECT id, report_id, parent_id, depth, hashed_cpf, content, status, created_at, edited_at
+		FROM comments
 		WHERE id = $1
-	`, reportID).Scan(&reportOwnerHashedCPF)
-
+	`, commentID).Scan(
+		&comment.ID, &comment.ReportID, &parentID, &comment.Depth, &comment.HashedCPF, &comment.Content, &comment.Status, &comment.CreatedAt, &editedAt,
+	)
 	if err != nil {
-		fmt.Printf("Error getting report owner CPF for notification: %v\n", err)
-		return
+		return nil, err
 	}
 
-	// Don't send notification if commenter is the report owner
-	if commenterHashedCPF == reportOwnerHashedCPF {
-		return
+	if editedAt.Valid {
+		comment.EditedAt = &editedAt.Time
 	}
-
-	// Get commenter display name (first 8 characters of hashed CPF)
-	commenterName := commenterHashedCPF
-	if len(commenterHashedCPF) >= 8 {
-		commenterName = commenterHashedCPF[:8]
+	if parentID.Valid {
+		id := int(parentID.Int64)
+		comment.ParentID = &id
 	}
+	return &comment, nil
+}
 
-	// Truncate comment content for email (max 100 characters)
-	emailCommentContent := commentContent
-	if len(commentContent) > 100 {
-		emailCommentContent = commentContent[:97] + "..."
+// GetCommentsForReport retrieves the top-level comments of a report, newest first, each with the
+// first replies of its thread
+func GetCommentsForReport(db *sql.DB, reportID int, sort string, limit int, offset int) ([]*models.CommentDisplay, error) {
+	query := `
+		SELECT ` + commentDisplayColumns + `
+		FROM comments c
+		WHERE c.report_id = $1 AND c.parent_id IS NULL
+		ORDER BY c.created_at DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	comments, err := queryCommentDisplays(db, query, reportID, limit, offset)
+	if err != nil {
+		return nil, err
 	}
 
-	// Send the notification email
-	SendCommentNotificationEmail(reportOwnerEmail, reportID, commenterName, emailCommentContent)
+	if err := loadCommentReplies(db, comments); err != nil {
+		return nil, err
+	}
+	return comments, nil
 }
 
-// GetCommentsForReport retrieves comments for a specific report
-func GetCommentsForReport(db *sql.DB, reportID int, sort string, limit int, offset int) ([]*models.CommentDisplay, error) {
+// GetCommentReplies retrieves a page of the replies to a comment, oldest first, each with the
+// first replies of its own thread
+func GetCommentReplies(db *sql.DB, parentID int, limit int, offset int) ([]*models.CommentDisplay, error) {
 	query := `
-		SELECT c.id, c.report_id, c.content, c.created_at, c.hashed_cpf
+		SELECT ` + commentDisplayColumns + `
 		FROM comments c
-		WHERE c.report_id = $1
-		ORDER BY c.created_at DESC
+		WHERE c.parent_id = $1
+		ORDER BY c.created_at ASC, c.id ASC
 		LIMIT $2 OFFSET $3
 	`
 
-	rows, err := db.Query(query, reportID, limit, offset)
+	replies, err := queryCommentDisplays(db, query, parentID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := loadCommentReplies(db, replies); err != nil {
+		return nil, err
+	}
+	return replies, nil
+}
+
+// GetCommentThreadCountForReport returns the number of top-level comments of a report, which are the pages of comments
+func GetCommentThreadCountForReport(db *sql.DB, reportID int) (int, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*)
+		FROM comments
+		WHERE report_id = $1 AND parent_id IS NULL
+	`, reportID).Scan(&count)
+
+	if err != nil {
+		return 0, fmt.Errorf("error getting comment thread count: %w", err)
+	}
+
+	return count, nil
+}
+
+// loadCommentReplies fills in the first replies of each comment, one query per nesting level
+func loadCommentReplies(db *sql.DB, comments []*models.CommentDisplay) error {
+	for len(comments) > 0 {
+		byID := make(map[int]*models.CommentDisplay)
+		var ids []int64
+		for _, comment := range comments {
+			if comment.ReplyCount > 0 {
+				byID[comment.ID] = comment
+				ids = append(ids, int64(comment.ID))
+			}
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		replies, err := queryCommentDisplays(db, `
+			SELECT `+commentDisplayColumns+`
+			FROM (
+				SELECT *, ROW_NUMBER() OVER (PARTITION BY parent_id ORDER BY created_at ASC, id ASC) AS position
+				FROM comments
+				WHERE parent_id = ANY($1)
+			) c
+			WHERE c.position <= $2
+			ORDER BY c.created_at ASC, c.id ASC
+		`, pq.Array(ids), CommentRepliesPreview)
+		if err != nil {
+			return err
+		}
+
+		for _, reply := range replies {
+			parent := byID[*reply.ParentID]
+			parent.Replies = append(parent.Replies, reply)
+		}
+		comments = replies
+	}
+	return nil
+}
+
+// queryCommentDisplays runs a query selecting commentDisplayColumns
+func queryCommentDisplays(db *sql.DB, query string, args ...interface{}) ([]*models.CommentDisplay, error) {
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error querying comments: %w", err)
 	}
@@ -122,8 +332,11 @@ func GetCommentsForReport(db *sql.DB, reportID int, sort string, limit int, offs
 	var comments []*models.CommentDisplay
 	for rows.Next() {
 		var comment models.Comment
+		var parentID sql.NullInt64
+		var editedAt sql.NullTime
+		var replyCount int
 		err := rows.Scan(
-			&comment.ID, &comment.ReportID, &comment.Content, &comment.CreatedAt, &comment.HashedCPF,
+			&comment.ID, &comment.ReportID, &parentID, &comment.Depth, &comment.Content, &comment.CreatedAt, &comment.HashedCPF, &comment.Status, &editedAt, &replyCount,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning comment: %w", err)
@@ -135,12 +348,194 @@ func GetCommentsForReport(db *sql.DB, reportID int, sort string, limit int, offs
 			Content:          comment.Content,
 			CreatedAt:        comment.CreatedAt,
 			HashedCPFDisplay: comment.GetHashedCPFDisplay(),
+			Depth:            comment.Depth,
+			ReplyCount:       replyCount,
+			Status:           comment.Status,
+			AuthorCPF:        comment.HashedCPF,
+		}
+		if editedAt.Valid {
+			commentDisplay.EditedAt = &editedAt.Time
+		}
+		if commentDisplay.IsHidden() {
+			commentDisplay.Content = ""
+			commentDisplay.HashedCPFDisplay = ""
+		}
+		if parentID.Valid {
+			id := int(parentID.Int64)
+			commentDisplay.ParentID = &id
 		}
 
 		comments = append(comments, commentDisplay)
 	}
 
-	return comments, nil
+	return comments, rows.Err()
+}
+
+// EditComment replaces the content of a visible comment, keeping the previous one as a revision.
+// Only the author can edit, within CommentEditWindow, and the new content goes through the filter.
+func EditComment(db *sql.DB, commentID int, hashedCPF, content string) (*models.Comment, error) {
+	if len(content) > 500 {
+		return nil, fmt.Errorf("comment content exceeds 500 character limit")
+	}
+
+	moderation, err := config.GetCommentModeration()
+	if err != nil {
+		return nil, fmt.Errorf("error loading comment filters: %w", err)
+	}
+	action, match := moderation.Check(content)
+	if action == config.CommentFilterReject {
+		log.Printf("Edição do comentário %d recusada pelo filtro %q", commentID, match)
+		return nil, ErrCommentRejected
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var comment models.Comment
+	var previousWrittenAt time.Time
+	err = tx.QueryRow(`
+		SELECT id, report_id, hashed_cpf, content, status, created_at, COALESCE(edited_at, created_at)
+		FROM comments
+		WHERE id = $1
+		FOR UPDATE
+	`, commentID).Scan(&comment.ID, &comment.ReportID, &comment.HashedCPF, &comment.Content, &comment.Status, &comment.CreatedAt, &previousWrittenAt)
+	if err != nil {
+		return nil, err
+	}
+	if comment.HashedCPF != hashedCPF {
+		return nil, ErrCommentNotOwner
+	}
+	if comment.Status != models.CommentStatusVisible {
+		return nil, ErrCommentNotEditable
+	}
+	if time.Since(comment.CreatedAt) >= models.CommentEditWindow {
+		return nil, ErrCommentEditExpired
+	}
+
+	original := content
+	content, piiKinds := RedactPII(content)
+	if content == comment.Content {
+		return &comment, nil
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO comment_revisions (comment_id, content, written_at, replaced_at)
+		VALUES ($1, $2, $3, NOW())
+	`, comment.ID, comment.Content, previousWrittenAt)
+	if err != nil {
+		return nil, fmt.Errorf("error saving comment revision: %w", err)
+	}
+
+	// Edits the filter holds are hidden for review like new comments
+	status, hiddenReason, filterMatch := models.CommentStatusVisible, sql.NullString{}, sql.NullString{}
+	if action == config.CommentFilterHold {
+		status = models.CommentStatusHidden
+		hiddenReason = sql.NullString{String: "filter", Valid: true}
+		filterMatch = sql.NullString{String: match, Valid: true}
+	}
+
+	var editedAt time.Time
+	err = tx.QueryRow(`
+		UPDATE comments SET content = $2, status = $3, hidden_reason = $4, filter_match = $5, edited_at = NOW()
+		WHERE id = $1
+		RETURNING edited_at
+	`, comment.ID, content, status, hiddenReason, filterMatch).Scan(&editedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error editing comment: %w", err)
+	}
+	comment.Content, comment.Status, comment.EditedAt = content, status, &editedAt
+
+	if len(piiKinds) > 0 {
+		if err := saveRedactedOriginal(tx, "comment_id", comment.ID, original, piiKinds); err != nil {
+			return nil, err
+		}
+	}
+
+	if status != models.CommentStatusVisible {
+		if err := updateCommentCount(tx, comment.ReportID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing comment edit: %w", err)
+	}
+	return &comment, nil
+}
+
+// DeleteComment lets the author delete a comment. It stays as a placeholder so its replies keep
+// their thread, and the report comment count is recalculated.
+func DeleteComment(db *sql.DB, commentID int, hashedCPF string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var reportID int
+	var authorCPF, status string
+	err = tx.QueryRow(`SELECT report_id, hashed_cpf, status FROM comments WHERE id = $1 FOR UPDATE`, commentID).Scan(&reportID, &authorCPF, &status)
+	if err != nil {
+		return err
+	}
+	if authorCPF != hashedCPF {
+		return ErrCommentNotOwner
+	}
+	if status == models.CommentStatusDeleted || status == models.CommentStatusRemoved {
+		return nil
+	}
+
+	_, err = tx.Exec(`UPDATE comments SET status = 'deleted', deleted_at = NOW() WHERE id = $1`, commentID)
+	if err != nil {
+		return fmt.Errorf("error deleting comment: %w", err)
+	}
+	if err := updateCommentCount(tx, reportID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing comment deletion: %w", err)
+	}
+	return nil
+}
+
+// GetCommentRevisions returns the previous contents of a comment, oldest first
+func GetCommentRevisions(db *sql.DB, commentID int) ([]*models.CommentRevision, error) {
+	rows, err := db.Query(`
+		SELECT id, comment_id, content, written_at, replaced_at
+		FROM comment_revisions
+		WHERE comment_id = $1
+		ORDER BY replaced_at ASC, id ASC
+	`, commentID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying comment revisions: %w", err)
+	}
+	defer rows.Close()
+
+	var revisions []*models.CommentRevision
+	for rows.Next() {
+		revision := &models.CommentRevision{}
+		if err := rows.Scan(&revision.ID, &revision.CommentID, &revision.Content, &revision.WrittenAt, &revision.ReplacedAt); err != nil {
+			return nil, fmt.Errorf("error scanning comment revision: %w", err)
+		}
+		revisions = append(revisions, revision)
+	}
+
+	return revisions, rows.Err()
+}
+
+// MarkOwnComments flags the comments of a tree written by the viewer
+func MarkOwnComments(comments []*models.CommentDisplay, hashedCPF string) {
+	if hashedCPF == "" {
+		return
+	}
+	for _, comment := range comments {
+		comment.Own = comment.AuthorCPF == hashedCPF
+		MarkOwnComments(comment.Replies, hashedCPF)
+	}
 }
 
 // GetCommentCountForReport returns the total number of comments for a report
@@ -149,7 +544,7 @@ func GetCommentCountForReport(db *sql.DB, reportID int) (int, error) {
 	err := db.QueryRow(`
 		SELECT COUNT(*) 
 		FROM comments 
-		WHERE report_id = $1
+		WHERE report_id = $1 AND status = 'visible'
 	`, reportID).Scan(&count)
 
 	if err != nil {
@@ -161,13 +556,21 @@ func GetCommentCountForReport(db *sql.DB, reportID int) (int, error) {
 
 // UpdateAllReportCommentCounts updates comment counts for all reports
 func UpdateAllReportCommentCounts(db *sql.DB) error {
-	_, err := db.Exec(`
-		UPDATE reports 
-		SET comment_count = (
-			SELECT COUNT(*) 
-			FROM comments 
-			WHERE comments.report_id = reports.id
-		)
-	`)
+	_, err := db.Exec(`UPDATE reports SET comment_count = ` + commentCountExpression)
+	if err != nil {
+		return err
+	}
+
+	// Keep hotness consistent with the recalculated counts
+	_, err = db.Exec(`UPDATE reports SET hot_score = ` + hotScoreExpression)
 	return err
 }
+
+// updateCommentCount recalculates the comment count and hotness of a report after its comments change
+func updateCommentCount(db sqlExecer, reportID int) error {
+	_, err := db.Exec(`UPDATE reports SET comment_count = `+commentCountExpression+` WHERE id = $1`, reportID)
+	if err != nil {
+		return fmt.Errorf("error updating comment count: %w", err)
+	}
+	return updateHotScore(db, reportID)
+}
@@ -15,20 +15,38 @@ type ReportStats struct {
 	Resolved     int
 }
 
-// CreateReport inserts a new report into the database
-func CreateReport(db *sql.DB, report *models.Report) (int, error) {
+// CreateReport inserts a new report into the database. The locale is used for the emails sent to the owner.
+// Personal data is masked in the public description; the original is kept encrypted for moderators,
+// in the same transaction, so a report is never published without it.
+func CreateReport(db *sql.DB, report *models.Report, locale string) (int, error) {
 	// Extract city name from location for better filtering
 	city := ExtractCityFromLocation(report.Location)
 
+	// Assign the neighborhood whose polygon contains the report coordinates
+	var neighborhoodID sql.NullInt64
+	if report.NeighborhoodID > 0 {
+		neighborhoodID = sql.NullInt64{Int64: int64(report.NeighborhoodID), Valid: true}
+	} else if id, err := FindNeighborhoodForPoint(db, report.Latitude, report.Longitude); err != nil {
+		// Don't fail the report if neighborhood lookup fails
+		fmt.Printf("Warning: Failed to find neighborhood for report: %v\n", err)
+	} else if id > 0 {
+		neighborhoodID = sql.NullInt64{Int64: int64(id), Valid: true}
+	}
+
 	query := `
-		INSERT INTO reports (problem_type, hashed_cpf, birth_date, email, location, city, latitude, longitude, description, photo_path, transport_type, transport_data, created_at, vote_count, status)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		INSERT INTO reports (problem_type, hashed_cpf, birth_date, email, location, city, neighborhood_id, latitude, longitude, description, transport_type, transport_data, created_at, vote_count, status, location_check)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
 		RETURNING id
 	`
 
 	// Handle nullable transport fields
 	var transportType, transportData sql.NullString
 
+	locationCheck := report.LocationCheck
+	if locationCheck == "" {
+		locationCheck = models.LocationCheckUnverified
+	}
+
 	if report.TransportType != "" {
 		transportType.String = report.TransportType
 		transportType.Valid = true
@@ -39,8 +57,18 @@ func CreateReport(db *sql.DB, report *models.Report) (int, error) {
 		transportData.Valid = true
 	}
 
+	original := report.Description
+	var piiKinds []string
+	report.Description, piiKinds = RedactPII(report.Description)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	var id int
-	err := db.QueryRow(
+	err = tx.QueryRow(
 		query,
 		report.ProblemType,
 		report.HashedCPF,
@@ -48,34 +76,110 @@ func CreateReport(db *sql.DB, report *models.Report) (int, error) {
 		report.Email,
 		report.Location,
 		city,
+		neighborhoodID,
 		report.Latitude,
 		report.Longitude,
 		report.Description,
-		report.PhotoPath,
 		transportType,
 		transportData,
 		time.Now(),
 		0, // vote_count
 		models.StatusPending,
+		locationCheck,
 	).Scan(&id)
 
 	if err != nil {
 		return 0, err
 	}
 
+	if len(piiKinds) > 0 {
+		if err := saveRedactedOriginal(tx, "report_id", id, original, piiKinds); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing report: %w", err)
+	}
+
+	// The owner follows the report, so comments and status changes reach them by email
+	if report.Email != "" && report.HashedCPF != "" {
+		if _, err := FollowReport(db, id, report.HashedCPF, report.Email, models.FrequencyInstant, locale); err != nil {
+			fmt.Printf("Warning: Failed to add owner as follower of report %d: %v\n", id, err)
+		}
+	}
+
+	// Give the new report its initial hotness so it can show up in the trending feed
+	if err := updateHotScore(db, id); err != nil {
+		fmt.Printf("Warning: Failed to set hot score for report %d: %v\n", id, err)
+	}
+
 	return id, nil
 }
 
+// UpdateReportStatus changes the status of a report and optionally publishes an official response.
+// Followers are notified of each change.
+func UpdateReportStatus(db *sql.DB, reportID int, status, officialResponse string) error {
+	switch status {
+	case models.StatusPending, models.StatusInReview, models.StatusApproved, models.StatusRejected:
+	default:
+		return fmt.Errorf("invalid status %q", status)
+	}
+	officialResponse = strings.TrimSpace(officialResponse)
+
+	var previousStatus string
+	err := db.QueryRow(`SELECT status FROM reports WHERE id = $1 AND merged_into_id IS NULL`, reportID).Scan(&previousStatus)
+	if err != nil {
+		return err
+	}
+
+	// The change times identify the events, so notifying twice about one change sends one email
+	var statusUpdatedAt, officialResponseAt sql.NullTime
+	err = db.QueryRow(`
+		UPDATE reports
+		SET status = $2,
+		    status_updated_at = CASE WHEN status = $2 THEN status_updated_at ELSE NOW() END,
+		    official_response = CASE WHEN $3 = '' THEN official_response ELSE $3 END,
+		    official_response_at = CASE WHEN $3 = '' THEN official_response_at ELSE NOW() END
+		WHERE id = $1
+		RETURNING status_updated_at, official_response_at
+	`, reportID, status, officialResponse).Scan(&statusUpdatedAt, &officialResponseAt)
+	if err != nil {
+		return fmt.Errorf("error updating report status: %w", err)
+	}
+
+	if status != previousStatus {
+		NotifyReportFollowers(db, models.FollowerEvent{
+			Type:     models.FollowerEventStatus,
+			Key:      fmt.Sprintf("status:%d:%s:%s", reportID, status, statusUpdatedAt.Time.Format(time.RFC3339Nano)),
+			ReportID: reportID,
+			Params:   map[string]string{"from": previousStatus, "to": status},
+		})
+	}
+	if officialResponse != "" {
+		NotifyReportFollowers(db, models.FollowerEvent{
+			Type:     models.FollowerEventResponse,
+			Key:      fmt.Sprintf("response:%d:%s", reportID, officialResponseAt.Time.Format(time.RFC3339Nano)),
+			ReportID: reportID,
+			Params:   map[string]string{"excerpt": truncateEmailText(officialResponse, 500)},
+		})
+	}
+
+	return nil
+}
+
 // GetReportByID retrieves a report by its ID
 func GetReportByID(db *sql.DB, id int) (*models.Report, error) {
 	query := `
-		SELECT id, problem_type, hashed_cpf, birth_date, email, location, city, latitude, longitude, description, photo_path, transport_type, transport_data, created_at, vote_count, status
+		SELECT id, problem_type, hashed_cpf, birth_date, email, location, city, neighborhood_id, latitude, longitude, description, transport_type, transport_data, created_at, vote_count, status, merged_into_id, official_response, official_response_at, location_check
 		FROM reports
 		WHERE id = $1
 	`
 
 	report := &models.Report{}
-	var transportType, transportData sql.NullString
+	var transportType, transportData, officialResponse sql.NullString
+	var neighborhoodID, mergedIntoID sql.NullInt64
+	var officialResponseAt sql.NullTime
 
 	err := db.QueryRow(query, id).Scan(
 		&report.ID,
@@ -85,15 +189,19 @@ func GetReportByID(db *sql.DB, id int) (*models.Report, error) {
 		&report.Email,
 		&report.Location,
 		&report.City,
+		&neighborhoodID,
 		&report.Latitude,
 		&report.Longitude,
 		&report.Description,
-		&report.PhotoPath,
 		&transportType,
 		&transportData,
 		&report.CreatedAt,
 		&report.VoteCount,
 		&report.Status,
+		&mergedIntoID,
+		&officialResponse,
+		&officialResponseAt,
+		&report.LocationCheck,
 	)
 
 	if err != nil {
@@ -107,21 +215,45 @@ func GetReportByID(db *sql.DB, id int) (*models.Report, error) {
 	if transportData.Valid {
 		report.TransportData = []byte(transportData.String)
 	}
+	if neighborhoodID.Valid {
+		report.NeighborhoodID = int(neighborhoodID.Int64)
+	}
+	if mergedIntoID.Valid {
+		report.MergedIntoID = int(mergedIntoID.Int64)
+	}
+	report.OfficialResponse = officialResponse.String
+	if officialResponseAt.Valid {
+		report.OfficialResponseAt = &officialResponseAt.Time
+	}
 
 	return report, nil
 }
 
-// GetReports retrieves reports with pagination and filtering
-func GetReports(db *sql.DB, page int, category, status, city, sort string, limit int) ([]*models.Report, error) {
+// GetReports retrieves reports with pagination and filtering.
+// When search is given, only matching reports are returned with a highlighted snippet.
+// The "nearby" sort orders by distance from latitude/longitude.
+func GetReports(db *sql.DB, page int, category, status, city string, neighborhoodID int, search, sort string, latitude, longitude float64, limit int) ([]*models.Report, error) {
 	offset := (page - 1) * limit
 
+	args := []interface{}{}
+	argCount := 0
+
+	snippetColumn := "NULL"
+	if search != "" {
+		argCount++
+		args = append(args, search)
+		snippetColumn = searchSnippetExpression("$1")
+	}
+
 	query := `
-		SELECT id, problem_type, hashed_cpf, birth_date, email, location, city, latitude, longitude, description, photo_path, transport_type, transport_data, created_at, vote_count, status
+		SELECT id, problem_type, hashed_cpf, birth_date, email, location, city, neighborhood_id, latitude, longitude, description, transport_type, transport_data, created_at, vote_count, status, ` + snippetColumn + `
 		FROM reports
-		WHERE 1=1
+		WHERE merged_into_id IS NULL
 	`
-	args := []interface{}{}
-	argCount := 0
+
+	if search != "" {
+		query += " AND " + searchMatchCondition("$1")
+	}
 
 	if category != "" {
 		argCount++
@@ -141,14 +273,32 @@ func GetReports(db *sql.DB, page int, category, status, city, sort string, limit
 		args = append(args, "%"+city+"%")
 	}
 
+	if neighborhoodID != 0 {
+		argCount++
+		query += fmt.Sprintf(" AND neighborhood_id = $%d", argCount)
+		args = append(args, neighborhoodID)
+	}
+
 	// Add ORDER BY clause based on sort parameter
-	switch sort {
-	case "votes":
+	switch {
+	case sort == "relevance" && search != "":
+		query += " ORDER BY " + searchRankExpression("$1") + " DESC, created_at DESC"
+	case sort == "trending":
+		query += " ORDER BY hot_score DESC, created_at DESC"
+	case sort == "nearby" && (latitude != 0 || longitude != 0):
+		// Equirectangular distance is enough for ordering; reports without location go last
+		argCount++
+		latArg := argCount
+		args = append(args, latitude)
+		argCount++
+		lngArg := argCount
+		args = append(args, longitude)
+		query += fmt.Sprintf(` ORDER BY (latitude = 0 AND longitude = 0),
+			POWER(latitude - $%d, 2) + POWER((longitude - $%d) * COS(RADIANS($%d)), 2) ASC, created_at DESC`, latArg, lngArg, latArg)
+	case sort == "votes":
 		query += " ORDER BY vote_count DESC, created_at DESC"
-	case "oldest":
+	case sort == "oldest":
 		query += " ORDER BY created_at ASC"
-	case "recent":
-		fallthrough
 	default:
 		query += " ORDER BY created_at DESC"
 	}
@@ -170,7 +320,8 @@ func GetReports(db *sql.DB, page int, category, status, city, sort string, limit
 	var reports []*models.Report
 	for rows.Next() {
 		report := &models.Report{}
-		var transportType, transportData sql.NullString
+		var transportType, transportData, snippet sql.NullString
+		var neighborhoodID sql.NullInt64
 
 		err := rows.Scan(
 			&report.ID,
@@ -180,15 +331,16 @@ func GetReports(db *sql.DB, page int, category, status, city, sort string, limit
 			&report.Email,
 			&report.Location,
 			&report.City,
+			&neighborhoodID,
 			&report.Latitude,
 			&report.Longitude,
 			&report.Description,
-			&report.PhotoPath,
 			&transportType,
 			&transportData,
 			&report.CreatedAt,
 			&report.VoteCount,
 			&report.Status,
+			&snippet,
 		)
 		if err != nil {
 			return nil, err
@@ -201,6 +353,10 @@ func GetReports(db *sql.DB, page int, category, status, city, sort string, limit
 		if transportData.Valid {
 			report.TransportData = []byte(transportData.String)
 		}
+		if neighborhoodID.Valid {
+			report.NeighborhoodID = int(neighborhoodID.Int64)
+		}
+		report.Snippet = highlightSnippet(snippet)
 
 		reports = append(reports, report)
 	}
@@ -209,11 +365,17 @@ func GetReports(db *sql.DB, page int, category, status, city, sort string, limit
 }
 
 // GetTotalReports returns the total number of reports with optional filtering
-func GetTotalReports(db *sql.DB, category, status, city string) (int, error) {
-	query := `SELECT COUNT(*) FROM reports WHERE 1=1`
+func GetTotalReports(db *sql.DB, category, status, city string, neighborhoodID int, search string) (int, error) {
+	query := `SELECT COUNT(*) FROM reports WHERE merged_into_id IS NULL`
 	args := []interface{}{}
 	argCount := 0
 
+	if search != "" {
+		argCount++
+		query += " AND " + searchMatchCondition(fmt.Sprintf("$%d", argCount))
+		args = append(args, search)
+	}
+
 	if category != "" {
 		argCount++
 		query += fmt.Sprintf(" AND problem_type = $%d", argCount)
@@ -232,6 +394,12 @@ func GetTotalReports(db *sql.DB, category, status, city string) (int, error) {
 		args = append(args, "%"+city+"%")
 	}
 
+	if neighborhoodID != 0 {
+		argCount++
+		query += fmt.Sprintf(" AND neighborhood_id = $%d", argCount)
+		args = append(args, neighborhoodID)
+	}
+
 	var count int
 	err := db.QueryRow(query, args...).Scan(&count)
 	return count, err
@@ -242,7 +410,7 @@ func GetReportStats(db *sql.DB) (*ReportStats, error) {
 	stats := &ReportStats{}
 
 	// Total reports
-	err := db.QueryRow("SELECT COUNT(*) FROM reports").Scan(&stats.TotalReports)
+	err := db.QueryRow("SELECT COUNT(*) FROM reports WHERE merged_into_id IS NULL").Scan(&stats.TotalReports)
 	if err != nil {
 		return nil, err
 	}
@@ -250,7 +418,7 @@ func GetReportStats(db *sql.DB) (*ReportStats, error) {
 	// Reports this month
 	err = db.QueryRow(`
 		SELECT COUNT(*) FROM reports 
-		WHERE created_at >= date_trunc('month', CURRENT_DATE)
+		WHERE created_at >= date_trunc('month', CURRENT_DATE) AND merged_into_id IS NULL
 	`).Scan(&stats.ThisMonth)
 	if err != nil {
 		return nil, err
@@ -259,7 +427,7 @@ func GetReportStats(db *sql.DB) (*ReportStats, error) {
 	// Resolved reports (approved)
 	err = db.QueryRow(`
 		SELECT COUNT(*) FROM reports 
-		WHERE status = 'approved'
+		WHERE status = 'approved' AND merged_into_id IS NULL
 	`).Scan(&stats.Resolved)
 	if err != nil {
 		return nil, err
@@ -296,7 +464,7 @@ func AddVote(db *sql.DB, reportID int, hashedCPF string) error {
 		return fmt.Errorf("error updating vote count: %w", err)
 	}
 
-	return nil
+	return updateHotScore(db, reportID)
 }
 
 // GetVoteCount returns the vote count for a report
@@ -332,11 +500,12 @@ func HasUserVoted(db *sql.DB, reportID int, hashedCPF string) (bool, error) {
 }
 
 // GetReportsForMap retrieves reports with location data for map display
-func GetReportsForMap(db *sql.DB, category, status, city string) ([]*models.Report, error) {
+func GetReportsForMap(db *sql.DB, category, status, city string, neighborhoodID int, search string) ([]*models.Report, error) {
 	query := `
-		SELECT id, problem_type, hashed_cpf, birth_date, email, location, city, latitude, longitude, description, photo_path, transport_type, transport_data, created_at, vote_count, status
+		SELECT id, problem_type, hashed_cpf, birth_date, email, location, city, neighborhood_id, latitude, longitude, description, transport_type, transport_data, created_at, vote_count, status
 		FROM reports
 		WHERE latitude IS NOT NULL AND longitude IS NOT NULL AND latitude != 0 AND longitude != 0
+		  AND merged_into_id IS NULL
 	`
 	args := []interface{}{}
 	argCount := 0
@@ -359,6 +528,18 @@ func GetReportsForMap(db *sql.DB, category, status, city string) ([]*models.Repo
 		args = append(args, "%"+city+"%")
 	}
 
+	if neighborhoodID != 0 {
+		argCount++
+		query += fmt.Sprintf(" AND neighborhood_id = $%d", argCount)
+		args = append(args, neighborhoodID)
+	}
+
+	if search != "" {
+		argCount++
+		query += " AND " + searchMatchCondition(fmt.Sprintf("$%d", argCount))
+		args = append(args, search)
+	}
+
 	query += " ORDER BY created_at DESC"
 
 	rows, err := db.Query(query, args...)
@@ -371,6 +552,7 @@ func GetReportsForMap(db *sql.DB, category, status, city string) ([]*models.Repo
 	for rows.Next() {
 		report := &models.Report{}
 		var transportType, transportData sql.NullString
+		var neighborhoodID sql.NullInt64
 
 		err := rows.Scan(
 			&report.ID,
@@ -380,10 +562,10 @@ func GetReportsForMap(db *sql.DB, category, status, city string) ([]*models.Repo
 			&report.Email,
 			&report.Location,
 			&report.City,
+			&neighborhoodID,
 			&report.Latitude,
 			&report.Longitude,
 			&report.Description,
-			&report.PhotoPath,
 			&transportType,
 			&transportData,
 			&report.CreatedAt,
@@ -401,6 +583,9 @@ func GetReportsForMap(db *sql.DB, category, status, city string) ([]*models.Repo
 		if transportData.Valid {
 			report.TransportData = []byte(transportData.String)
 		}
+		if neighborhoodID.Valid {
+			report.NeighborhoodID = int(neighborhoodID.Int64)
+		}
 
 		reports = append(reports, report)
 	}
@@ -0,0 +1,330 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"olhourbano2/models"
+	"sort"
+	"strings"
+)
+
+const (
+	DuplicateRadiusMeters   = 150.0 // Maximum distance between two reports of the same problem
+	DuplicateWindowDays     = 30    // Maximum age difference between two reports of the same problem
+	DuplicateScoreThreshold = 0.5   // Minimum combined score to consider a report a possible duplicate
+	duplicateDistanceWeight = 0.6
+	duplicateTextWeight     = 0.4
+	metersPerDegree         = 111320.0
+)
+
+// duplicateStopwords are common Portuguese words ignored when comparing descriptions
+var duplicateStopwords = map[string]bool{
+	"a": true, "o": true, "as": true, "os": true, "um": true, "uma": true, "uns": true, "umas": true,
+	"de": true, "do": true, "da": true, "dos": true, "das": true, "em": true, "no": true, "na": true,
+	"nos": true, "nas": true, "por": true, "para": true, "pra": true, "com": true, "sem": true,
+	"e": true, "ou": true, "que": true, "se": true, "ao": true, "aos": true, "esta": true, "este": true,
+	"essa": true, "esse": true, "isso": true, "isto": true, "ha": true, "tem": true, "muito": true,
+	"mais": true, "ja": true, "nao": true, "sim": true, "rua": true, "avenida": true, "frente": true,
+}
+
+// FindSimilarReports returns open reports of the same category created recently near the given point
+// whose combined distance and description similarity reaches the duplicate threshold, best match first
+func FindSimilarReports(db *sql.DB, category string, latitude, longitude float64, description string, limit int) ([]*models.SimilarReport, error) {
+	if latitude == 0 && longitude == 0 {
+		return nil, nil
+	}
+
+	latDelta, lngDelta := duplicateBoundingBox(latitude)
+
+	rows, err := db.Query(`
+		SELECT id, problem_type, location, description, status, vote_count, COALESCE(comment_count, 0), created_at, latitude, longitude
+		FROM reports
+		WHERE problem_type = $1
+		  AND merged_into_id IS NULL
+		  AND status != $2
+		  AND created_at >= NOW() - make_interval(days => $3)
+		  AND latitude BETWEEN $4 AND $5
+		  AND longitude BETWEEN $6 AND $7
+	`, category, models.StatusRejected, DuplicateWindowDays,
+		latitude-latDelta, latitude+latDelta, longitude-lngDelta, longitude+lngDelta)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	descriptionTokens := descriptionTokenSet(description)
+
+	var similar []*models.SimilarReport
+	for rows.Next() {
+		report := &models.SimilarReport{}
+		var reportLatitude, reportLongitude float64
+		err := rows.Scan(
+			&report.ID,
+			&report.ProblemType,
+			&report.Location,
+			&report.Description,
+			&report.Status,
+			&report.VoteCount,
+			&report.CommentCount,
+			&report.CreatedAt,
+			&reportLatitude,
+			&reportLongitude,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		report.DistanceMeters = haversineMeters(latitude, longitude, reportLatitude, reportLongitude)
+		if report.DistanceMeters > DuplicateRadiusMeters {
+			continue
+		}
+		report.TextSimilarity = jaccardSimilarity(descriptionTokens, descriptionTokenSet(report.Description))
+		report.Score = duplicateScore(report.DistanceMeters, report.TextSimilarity)
+
+		if report.Score >= DuplicateScoreThreshold {
+			similar = append(similar, report)
+		}
+	}
+
+	sort.Slice(similar, func(i, j int) bool {
+		return similar[i].Score > similar[j].Score
+	})
+	if limit > 0 && len(similar) > limit {
+		similar = similar[:limit]
+	}
+
+	return similar, nil
+}
+
+// FindDuplicateCandidates returns pairs of unmerged reports that likely describe the same problem,
+// for moderator review. The older report of each pair is proposed as canonical.
+func FindDuplicateCandidates(db *sql.DB, limit int) ([]*models.DuplicateCandidate, error) {
+	// Longitude degrees shrink towards the poles; size the SQL prefilter for the southernmost Brazilian latitudes
+	latDelta, lngDelta := duplicateBoundingBox(35)
+
+	rows, err := db.Query(`
+		SELECT d.id, d.problem_type, d.location, d.description, d.status, d.vote_count, COALESCE(d.comment_count, 0), d.created_at, d.latitude, d.longitude,
+		       c.id, c.problem_type, c.location, c.description, c.status, c.vote_count, COALESCE(c.comment_count, 0), c.created_at, c.latitude, c.longitude
+		FROM reports d
+		JOIN reports c ON c.problem_type = d.problem_type
+		     AND c.id < d.id
+		     AND c.merged_into_id IS NULL
+		     AND c.status != $1
+		     AND d.created_at - c.created_at <= make_interval(days => $2)
+		     AND ABS(c.latitude - d.latitude) <= $3
+		     AND ABS(c.longitude - d.longitude) <= $4
+		WHERE d.merged_into_id IS NULL
+		  AND d.latitude != 0 AND d.longitude != 0
+		  AND d.created_at >= NOW() - make_interval(days => $5)
+		ORDER BY d.created_at DESC
+	`, models.StatusRejected, DuplicateWindowDays, latDelta, lngDelta, DuplicateWindowDays*3)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []*models.DuplicateCandidate
+	for rows.Next() {
+		duplicate := &models.SimilarReport{}
+		canonical := &models.SimilarReport{}
+		var duplicateLatitude, duplicateLongitude, canonicalLatitude, canonicalLongitude float64
+		err := rows.Scan(
+			&duplicate.ID, &duplicate.ProblemType, &duplicate.Location, &duplicate.Description, &duplicate.Status,
+			&duplicate.VoteCount, &duplicate.CommentCount, &duplicate.CreatedAt, &duplicateLatitude, &duplicateLongitude,
+			&canonical.ID, &canonical.ProblemType, &canonical.Location, &canonical.Description, &canonical.Status,
+			&canonical.VoteCount, &canonical.CommentCount, &canonical.CreatedAt, &canonicalLatitude, &canonicalLongitude,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		distance := haversineMeters(duplicateLatitude, duplicateLongitude, canonicalLatitude, canonicalLongitude)
+		if distance > DuplicateRadiusMeters {
+			continue
+		}
+		similarity := jaccardSimilarity(descriptionTokenSet(duplicate.Description), descriptionTokenSet(canonical.Description))
+		score := duplicateScore(distance, similarity)
+		if score < DuplicateScoreThreshold {
+			continue
+		}
+
+		duplicate.DistanceMeters, canonical.DistanceMeters = distance, distance
+		duplicate.TextSimilarity, canonical.TextSimilarity = similarity, similarity
+		duplicate.Score, canonical.Score = score, score
+
+		candidates = append(candidates, &models.DuplicateCandidate{
+			Duplicate: duplicate,
+			Canonical: canonical,
+			Score:     score,
+		})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+	if limit > 0 && len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+
+	return candidates, nil
+}
+
+// MergeReports links a duplicate report to its canonical report. Votes and comments are moved to the
+// canonical report without counting the same hashed CPF twice, and the duplicate's reporter is kept as a vote.
+func MergeReports(db *sql.DB, duplicateID, canonicalID int) error {
+	if duplicateID == canonicalID {
+		return fmt.Errorf("a report cannot be merged into itself")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Lock both reports so concurrent merges or votes see a consistent state
+	var duplicateCPF string
+	var duplicateMerged sql.NullInt64
+	err = tx.QueryRow(`SELECT hashed_cpf, merged_into_id FROM reports WHERE id = $1 FOR UPDATE`, duplicateID).Scan(&duplicateCPF, &duplicateMerged)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("report %d not found", duplicateID)
+	} else if err != nil {
+		return fmt.Errorf("error loading report %d: %w", duplicateID, err)
+	}
+	if duplicateMerged.Valid {
+		return fmt.Errorf("report %d was already merged into report %d", duplicateID, duplicateMerged.Int64)
+	}
+
+	var canonicalCPF string
+	var canonicalMerged sql.NullInt64
+	err = tx.QueryRow(`SELECT hashed_cpf, merged_into_id FROM reports WHERE id = $1 FOR UPDATE`, canonicalID).Scan(&canonicalCPF, &canonicalMerged)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("report %d not found", canonicalID)
+	} else if err != nil {
+		return fmt.Errorf("error loading report %d: %w", canonicalID, err)
+	}
+	if canonicalMerged.Valid {
+		return fmt.Errorf("report %d was merged into report %d; merge into that report instead", canonicalID, canonicalMerged.Int64)
+	}
+
+	// Move votes; ON CONFLICT skips citizens who already voted on the canonical report
+	_, err = tx.Exec(`
+		INSERT INTO votes (report_id, vote_hashed_cpf, created_at)
+		SELECT $1, vote_hashed_cpf, created_at
+		FROM votes
+		WHERE report_id = $2 AND vote_hashed_cpf IS NOT NULL AND vote_hashed_cpf != $3
+		ON CONFLICT (vote_hashed_cpf, report_id) DO NOTHING
+	`, canonicalID, duplicateID, canonicalCPF)
+	if err != nil {
+		return fmt.Errorf("error moving votes: %w", err)
+	}
+
+	// The duplicate's reporter supports the canonical report too
+	if duplicateCPF != "" && duplicateCPF != canonicalCPF {
+		_, err = tx.Exec(`
+			INSERT INTO votes (report_id, vote_hashed_cpf, created_at)
+			SELECT $1, $2, created_at FROM reports WHERE id = $3
+			ON CONFLICT (vote_hashed_cpf, report_id) DO NOTHING
+		`, canonicalID, duplicateCPF, duplicateID)
+		if err != nil {
+			return fmt.Errorf("error adding reporter vote: %w", err)
+		}
+	}
+
+	if _, err = tx.Exec(`DELETE FROM votes WHERE report_id = $1`, duplicateID); err != nil {
+		return fmt.Errorf("error removing duplicate votes: %w", err)
+	}
+
+	if _, err = tx.Exec(`UPDATE comments SET report_id = $1 WHERE report_id = $2`, canonicalID, duplicateID); err != nil {
+		return fmt.Errorf("error moving comments: %w", err)
+	}
+
+	// Followers of the duplicate keep receiving updates through the canonical report
+	_, err = tx.Exec(`
+		UPDATE report_followers SET report_id = $1
+		WHERE report_id = $2
+		  AND hashed_cpf NOT IN (SELECT hashed_cpf FROM report_followers WHERE report_id = $1)
+	`, canonicalID, duplicateID)
+	if err != nil {
+		return fmt.Errorf("error moving followers: %w", err)
+	}
+	if _, err = tx.Exec(`DELETE FROM report_followers WHERE report_id = $1`, duplicateID); err != nil {
+		return fmt.Errorf("error removing duplicate followers: %w", err)
+	}
+
+	// Reports previously merged into the duplicate now point straight at the canonical report
+	_, err = tx.Exec(`UPDATE reports SET merged_into_id = $1 WHERE merged_into_id = $2`, canonicalID, duplicateID)
+	if err != nil {
+		return fmt.Errorf("error updating merged reports: %w", err)
+	}
+
+	_, err = tx.Exec(`UPDATE reports SET merged_into_id = $1, merged_at = NOW() WHERE id = $2`, canonicalID, duplicateID)
+	if err != nil {
+		return fmt.Errorf("error marking report as merged: %w", err)
+	}
+
+	// Recalculate counters for both reports
+	_, err = tx.Exec(`
+		UPDATE reports
+		SET vote_count = (SELECT COUNT(*) FROM votes WHERE votes.report_id = reports.id),
+		    comment_count = `+commentCountExpression+`
+		WHERE id IN ($1, $2)
+	`, canonicalID, duplicateID)
+	if err != nil {
+		return fmt.Errorf("error updating counts: %w", err)
+	}
+	for _, reportID := range []int{canonicalID, duplicateID} {
+		if err := updateHotScore(tx, reportID); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing merge: %w", err)
+	}
+
+	return nil
+}
+
+// duplicateScore combines distance and description similarity into a score between 0 and 1
+func duplicateScore(distanceMeters, textSimilarity float64) float64 {
+	distanceScore := math.Max(0, 1-distanceMeters/DuplicateRadiusMeters)
+	return duplicateDistanceWeight*distanceScore + duplicateTextWeight*textSimilarity
+}
+
+// duplicateBoundingBox returns the latitude and longitude deltas covering the duplicate radius
+func duplicateBoundingBox(latitude float64) (float64, float64) {
+	latDelta := DuplicateRadiusMeters / metersPerDegree
+	lngDelta := DuplicateRadiusMeters / (metersPerDegree * math.Max(math.Cos(latitude*math.Pi/180), 0.1))
+	return latDelta, lngDelta
+}
+
+// descriptionTokenSet normalizes a description into a set of meaningful words, without accents
+func descriptionTokenSet(description string) map[string]bool {
+	tokens := make(map[string]bool)
+	for _, token := range strings.Split(Slugify(description), "-") {
+		if len(token) < 3 || duplicateStopwords[token] {
+			continue
+		}
+		tokens[token] = true
+	}
+	return tokens
+}
+
+// jaccardSimilarity returns the Jaccard index of two token sets
+func jaccardSimilarity(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+
+	intersection := 0
+	for token := range a {
+		if b[token] {
+			intersection++
+		}
+	}
+
+	union := len(a) + len(b) - intersection
+	return float64(intersection) / float64(union)
+}
@@ -1,161 +1,281 @@
 package services
 
 import (
+	"bytes"
+	"database/sql"
 	"fmt"
+	"io"
 	"log"
-	"net/smtp"
-	"olhourbano2/config"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"olhourbano2/models"
+	"strings"
+	"time"
 )
 
-// EmailTemplate represents an email template
+// siteURL is the public address used in links sent by email
+const siteURL = "https://olhourbano.com.br"
+
+// EmailTemplate represents a rendered email, with a plain-text body and an optional HTML alternative
 type EmailTemplate struct {
-	Subject string
-	Body    string
+	Subject         string
+	Body            string
+	HTML            string
+	ListUnsubscribe string // One-click unsubscribe URL; empty for transactional emails
+	Transactional   bool   // Sent to unverified addresses too; everything else needs double opt-in
 }
 
-// GetConfirmationEmailTemplate returns the email template for report confirmation
-func GetConfirmationEmailTemplate(reportID int, categoryName string) EmailTemplate {
-	subject := fmt.Sprintf("Olho Urbano - Denúncia #%d Recebida", reportID)
-
-	body := fmt.Sprintf(`
-Olá,
-
-Sua denúncia foi recebida com sucesso!
-
-Detalhes da Denúncia:
-- Número: #%d
-- Categoria: %s
-- Status: Pendente de Análise
-
-Sua denúncia será analisada pela nossa equipe e você receberá atualizações sobre o andamento.
-
-Para acompanhar o status da sua denúncia, acesse:
-https://olhourbano.com.br/report/%d
-
-Obrigado por contribuir para uma cidade melhor!
-
---
-Equipe Olho Urbano
-olhourbano.contato@gmail.com
-`, reportID, categoryName, reportID)
-
-	return EmailTemplate{
-		Subject: subject,
-		Body:    body,
-	}
+// GetConfirmationEmailTemplate returns the email template for report confirmation. A verifyURL
+// asks the owner to confirm the address so they can get notifications about the report.
+func GetConfirmationEmailTemplate(locale string, reportID int, categoryName, verifyURL string) (EmailTemplate, error) {
+	return renderTransactionalEmail(locale, "report_confirmation", map[string]interface{}{
+		"ReportID":     reportID,
+		"CategoryName": categoryName,
+		"VerifyURL":    verifyURL,
+	})
 }
 
 // GetStatusEmailTemplate returns the email template for report status
-func GetStatusEmailTemplate(reportID int, status string) EmailTemplate {
-	subject := fmt.Sprintf("Olho Urbano - Denúncia #%d Atualizada", reportID)
-
-	body := fmt.Sprintf(`
-Olá,
-
-Sua denúncia foi atualizada para o status: %s
-
-Para acompanhar o status da sua denúncia, acesse:
-https://olhourbano.com.br/report/%d
+func GetStatusEmailTemplate(locale string, reportID int, status string) (EmailTemplate, error) {
+	return renderTransactionalEmail(locale, "report_status", map[string]interface{}{
+		"ReportID":    reportID,
+		"Status":      status,
+		"StatusLabel": ReportStatusLabel(status),
+	})
+}
 
-Obrigado por contribuir para uma cidade melhor!
+// GetSubscriptionConfirmationEmailTemplate returns the double opt-in email for a new alert subscription
+func GetSubscriptionConfirmationEmailTemplate(sub *models.Subscription) (EmailTemplate, error) {
+	return renderTransactionalEmail(sub.Locale, "subscription_confirmation", map[string]interface{}{
+		"Summary":      SubscriptionSummary(sub),
+		"ConfirmToken": sub.ConfirmToken,
+	})
+}
 
---
-Equipe Olho Urbano
-olhourbano.contato@gmail.com
-`, status, reportID)
+// GetEmailVerificationEmailTemplate returns the double opt-in email for an address given when following a report
+func GetEmailVerificationEmailTemplate(locale, verifyURL string) (EmailTemplate, error) {
+	return renderTransactionalEmail(locale, "email_verification", map[string]interface{}{
+		"VerifyURL": verifyURL,
+	})
+}
 
-	return EmailTemplate{
-		Subject: subject,
-		Body:    body,
-	}
+// GetFollowerEmailChangeEmailTemplate returns the email confirming a new address given by someone
+// who already follows a report
+func GetFollowerEmailChangeEmailTemplate(locale string, reportID int, confirmURL string) (EmailTemplate, error) {
+	return renderTransactionalEmail(locale, "follower_email_change", map[string]interface{}{
+		"ReportID":   reportID,
+		"ConfirmURL": confirmURL,
+	})
 }
 
-// GetCommentNotificationEmailTemplate returns the email template for comment notifications
-func GetCommentNotificationEmailTemplate(reportID int, commenterName, commentContent string) EmailTemplate {
-	subject := fmt.Sprintf("Olho Urbano - Novo Comentário na Denúncia #%d", reportID)
+// GetSubscriptionAlertEmailTemplate returns the instant alert email for a report matching a subscription
+func GetSubscriptionAlertEmailTemplate(sub *models.Subscription, reportID int, categoryName, location, description string) (EmailTemplate, error) {
+	return renderEmailTemplate(sub.Locale, "subscription_alert", map[string]interface{}{
+		"ReportID":       reportID,
+		"CategoryName":   categoryName,
+		"Location":       location,
+		"Description":    truncateEmailText(description, 300),
+		"Summary":        SubscriptionSummary(sub),
+		"UnsubscribeURL": fmt.Sprintf("%s/alertas/cancelar/%s", siteURL, sub.UnsubscribeToken),
+	})
+}
 
-	body := fmt.Sprintf(`
-Olá,
+// GetSubscriptionDigestEmailTemplate returns the daily digest email listing the reports matching a subscription
+func GetSubscriptionDigestEmailTemplate(sub *models.Subscription, items []subscriptionDigestItem) (EmailTemplate, error) {
+	for i := range items {
+		items[i].Description = truncateEmailText(items[i].Description, 160)
+	}
 
-Sua denúncia recebeu um novo comentário!
+	return renderEmailTemplate(sub.Locale, "subscription_digest", map[string]interface{}{
+		"Items":          items,
+		"Summary":        SubscriptionSummary(sub),
+		"UnsubscribeURL": fmt.Sprintf("%s/alertas/cancelar/%s", siteURL, sub.UnsubscribeToken),
+	})
+}
 
-Detalhes:
-- Denúncia: #%d
-- Comentário de: %s
-- Conteúdo: "%s"
+// GetFollowerNotificationEmailTemplate returns the email telling a follower about a change to a report
+func GetFollowerNotificationEmailTemplate(follower *models.ReportFollower, event models.FollowerEvent, isOwner bool) (EmailTemplate, error) {
+	return renderEmailTemplate(follower.Locale, "follower_notification", map[string]interface{}{
+		"ReportID":       event.ReportID,
+		"EventType":      event.Type,
+		"IsOwner":        isOwner,
+		"Event":          event,
+		"ManageURL":      fmt.Sprintf("%s/seguindo/%s", siteURL, follower.ManageToken),
+		"UnsubscribeURL": fmt.Sprintf("%s/seguindo/%s/parar", siteURL, follower.ManageToken),
+	})
+}
 
-Para visualizar o comentário e responder, acesse:
-https://olhourbano.com.br/report/%d
+// GetFollowerDigestEmailTemplate returns the daily digest of changes to a followed report
+func GetFollowerDigestEmailTemplate(follower *models.ReportFollower, events []models.FollowerEvent) (EmailTemplate, error) {
+	return renderEmailTemplate(follower.Locale, "follower_digest", map[string]interface{}{
+		"ReportID":       follower.ReportID,
+		"Events":         events,
+		"ManageURL":      fmt.Sprintf("%s/seguindo/%s", siteURL, follower.ManageToken),
+		"UnsubscribeURL": fmt.Sprintf("%s/seguindo/%s/parar", siteURL, follower.ManageToken),
+	})
+}
 
-Obrigado por contribuir para uma cidade melhor!
+// GetCommentReplyEmailTemplate returns the email telling a commenter someone replied to them.
+// unsubscribeURL is the stop link of the recipient's follower entry for the report, if any.
+func GetCommentReplyEmailTemplate(locale string, reply *models.Comment, unsubscribeURL string) (EmailTemplate, error) {
+	return renderEmailTemplate(locale, "comment_reply", map[string]interface{}{
+		"ReportID":       reply.ReportID,
+		"CommentID":      reply.ID,
+		"Author":         "OlhoUrbano" + reply.GetHashedCPFDisplay(),
+		"Content":        truncateEmailText(reply.Content, 300),
+		"UnsubscribeURL": unsubscribeURL,
+	})
+}
 
---
-Equipe Olho Urbano
-olhourbano.contato@gmail.com
-`, reportID, commenterName, commentContent, reportID)
+// renderTransactionalEmail renders an email the recipient asked for directly, which is sent even
+// before the address is verified
+func renderTransactionalEmail(locale, name string, data map[string]interface{}) (EmailTemplate, error) {
+	template, err := renderEmailTemplate(locale, name, data)
+	template.Transactional = true
+	return template, err
+}
 
-	return EmailTemplate{
-		Subject: subject,
-		Body:    body,
+// truncateEmailText shortens long user text for email bodies
+func truncateEmailText(text string, maxRunes int) string {
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) <= maxRunes {
+		return string(runes)
 	}
+	return string(runes[:maxRunes]) + "..."
 }
 
-// SendEmail sends an email using SMTP configuration
+// SendEmail sends an email through the configured mail transport
 func SendEmail(to string, template EmailTemplate) error {
-	// Load configuration
-	cfg, err := config.Load()
+	transport, from, err := currentMailer()
 	if err != nil {
-		return fmt.Errorf("erro ao carregar configuração: %v", err)
+		return err
 	}
 
-	// Email configuration
-	from := cfg.SMTPUsername
-	password := cfg.SMTPPassword
-	smtpHost := cfg.SMTPHost
-	smtpPort := fmt.Sprintf("%d", cfg.SMTPPort)
-
-	// Authentication
-	auth := smtp.PlainAuth("", from, password, smtpHost)
-
 	// Message
-	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s", from, to, template.Subject, template.Body)
+	message, err := buildEmailMessage(from, to, template, time.Now())
+	if err != nil {
+		return fmt.Errorf("erro ao montar email: %v", err)
+	}
 
 	// Send email
-	err = smtp.SendMail(smtpHost+":"+smtpPort, auth, from, []string{to}, []byte(message))
-	if err != nil {
-		return fmt.Errorf("erro ao enviar email: %v", err)
+	if err := transport.Send(from, to, message); err != nil {
+		return err
 	}
 
-	log.Printf("Email de confirmação enviado para: %s", to)
+	log.Printf("Email enviado para: %s", to)
 	return nil
 }
 
-// SendConfirmationEmail sends a confirmation email for a report
-func SendConfirmationEmail(email string, reportID int, categoryName string) {
-	template := GetConfirmationEmailTemplate(reportID, categoryName)
-
-	err := SendEmail(email, template)
+// buildEmailMessage writes an RFC 5322 message with UTF-8 encoded headers. Emails with an HTML
+// part are sent as multipart/alternative so clients without HTML still get the text body.
+func buildEmailMessage(from, to string, template EmailTemplate, now time.Time) ([]byte, error) {
+	domain := "olhourbano.com.br"
+	if at := strings.LastIndex(from, "@"); at >= 0 && at < len(from)-1 {
+		domain = from[at+1:]
+	}
+	messageID, err := generateEmailToken()
 	if err != nil {
-		log.Printf("Erro ao enviar email de confirmação para %s: %v", email, err)
+		return nil, err
+	}
+
+	var message bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&message, "%s: %s\r\n", name, value)
+	}
+
+	header("From", (&mail.Address{Name: "Olho Urbano", Address: from}).String())
+	header("To", (&mail.Address{Address: to}).String())
+	header("Subject", mime.QEncoding.Encode("utf-8", template.Subject))
+	header("Date", now.Format(time.RFC1123Z))
+	header("Message-ID", fmt.Sprintf("<%s@%s>", messageID, domain))
+	header("MIME-Version", "1.0")
+	if template.ListUnsubscribe != "" {
+		header("List-Unsubscribe", "<"+template.ListUnsubscribe+">")
+		header("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+
+	if template.HTML == "" {
+		header("Content-Type", `text/plain; charset="UTF-8"`)
+		header("Content-Transfer-Encoding", "quoted-printable")
+		message.WriteString("\r\n")
+		if err := writeQuotedPrintable(&message, template.Body); err != nil {
+			return nil, err
+		}
+		return message.Bytes(), nil
+	}
+
+	parts := multipart.NewWriter(&message)
+	header("Content-Type", fmt.Sprintf(`multipart/alternative; boundary="%s"`, parts.Boundary()))
+	message.WriteString("\r\n")
+
+	// Least preferred alternative first, as RFC 2046 requires
+	for _, part := range []struct{ contentType, content string }{
+		{`text/plain; charset="UTF-8"`, template.Body},
+		{`text/html; charset="UTF-8"`, template.HTML},
+	} {
+		writer, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(writer, part.content); err != nil {
+			return nil, err
+		}
 	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	return message.Bytes(), nil
 }
 
-// SendStatusEmail sends a status email for a report
-func SendStatusEmail(email string, reportID int, status string) {
-	template := GetStatusEmailTemplate(reportID, status)
+// writeQuotedPrintable encodes a body part with CRLF line endings
+func writeQuotedPrintable(w io.Writer, content string) error {
+	encoder := quotedprintable.NewWriter(w)
+	content = strings.ReplaceAll(strings.ReplaceAll(content, "\r\n", "\n"), "\n", "\r\n")
+	if _, err := encoder.Write([]byte(content)); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
 
-	err := SendEmail(email, template)
+// SendConfirmationEmail queues a confirmation email for a report
+func SendConfirmationEmail(db *sql.DB, locale, email string, reportID int, categoryName string) {
+	err := WithEmailVerificationURL(db, email, locale, func(tx *sql.Tx, verifyURL string) error {
+		template, err := GetConfirmationEmailTemplate(locale, reportID, categoryName, verifyURL)
+		if err != nil {
+			return err
+		}
+		return EnqueueEmail(tx, fmt.Sprintf("report-confirmation:%d", reportID), email, template)
+	})
 	if err != nil {
-		log.Printf("Erro ao enviar email de status para %s: %v", email, err)
+		log.Printf("Erro ao enfileirar email de confirmação para %s: %v", email, err)
 	}
 }
 
-// SendCommentNotificationEmail sends a notification email when a comment is posted
-func SendCommentNotificationEmail(email string, reportID int, commenterName, commentContent string) {
-	template := GetCommentNotificationEmailTemplate(reportID, commenterName, commentContent)
+// SendStatusEmail queues a status email for a report
+func SendStatusEmail(db *sql.DB, locale, email string, reportID int, status string) {
+	template, err := GetStatusEmailTemplate(locale, reportID, status)
+	if err == nil {
+		err = EnqueueEmail(db, fmt.Sprintf("report-status:%d:%s", reportID, status), email, template)
+	}
+	if err != nil {
+		log.Printf("Erro ao enfileirar email de status para %s: %v", email, err)
+	}
+}
 
-	err := SendEmail(email, template)
+// SendSubscriptionConfirmationEmail queues the double opt-in email for a new subscription
+func SendSubscriptionConfirmationEmail(db *sql.DB, sub *models.Subscription) {
+	template, err := GetSubscriptionConfirmationEmailTemplate(sub)
+	if err == nil {
+		err = EnqueueEmail(db, fmt.Sprintf("subscription-confirm:%d", sub.ID), sub.Email, template)
+	}
 	if err != nil {
-		log.Printf("Erro ao enviar email de notificação de comentário para %s: %v", email, err)
+		log.Printf("Erro ao enfileirar email de confirmação de alerta para %s: %v", sub.Email, err)
 	}
 }
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+)
+
+// emailTemplatesDir holds one directory of email templates per locale
+const emailTemplatesDir = "./templates/emails"
+
+// DefaultEmailLocale is used when a recipient has no locale or it has no templates
+const DefaultEmailLocale = "pt-BR"
+
+// SupportedEmailLocales lists the locales with a directory in templates/emails
+var SupportedEmailLocales = []string{"pt-BR", "en"}
+
+// NormalizeEmailLocale maps a locale or language tag to a supported email locale
+func NormalizeEmailLocale(locale string) string {
+	if supported, ok := matchEmailLocale(locale); ok {
+		return supported
+	}
+	return DefaultEmailLocale
+}
+
+// EmailLocaleFromAcceptLanguage picks the email locale from an Accept-Language header.
+// Browsers list languages in order of preference, so the first supported one wins.
+func EmailLocaleFromAcceptLanguage(header string) string {
+	for _, part := range strings.Split(header, ",") {
+		if supported, ok := matchEmailLocale(strings.SplitN(part, ";", 2)[0]); ok {
+			return supported
+		}
+	}
+	return DefaultEmailLocale
+}
+
+// matchEmailLocale finds the supported locale of a tag: exact match first (pt-BR), then by
+// language (pt-PT -> pt-BR, en-US -> en)
+func matchEmailLocale(tag string) (string, bool) {
+	tag = strings.ReplaceAll(strings.TrimSpace(tag), "_", "-")
+	if tag == "" {
+		return "", false
+	}
+
+	for _, supported := range SupportedEmailLocales {
+		if strings.EqualFold(tag, supported) {
+			return supported, true
+		}
+	}
+	language := strings.SplitN(tag, "-", 2)[0]
+	for _, supported := range SupportedEmailLocales {
+		if strings.EqualFold(strings.SplitN(supported, "-", 2)[0], language) {
+			return supported, true
+		}
+	}
+	return "", false
+}
+
+// renderEmailTemplate builds an email from templates/emails/<locale>/<name>.txt and .html.
+// The text file defines "subject" and "content"; the HTML file defines "content". Both are
+// wrapped by the layout of the same locale, which adds the branding and the signature.
+func renderEmailTemplate(locale, name string, data map[string]interface{}) (EmailTemplate, error) {
+	locale = NormalizeEmailLocale(locale)
+	dir := filepath.Join(emailTemplatesDir, locale)
+	if _, err := os.Stat(filepath.Join(dir, name+".txt")); err != nil {
+		dir = filepath.Join(emailTemplatesDir, DefaultEmailLocale)
+	}
+
+	data["SiteURL"] = siteURL
+	data["Locale"] = locale
+	if _, ok := data["UnsubscribeURL"]; !ok {
+		data["UnsubscribeURL"] = ""
+	}
+
+	textTmpl, err := texttemplate.ParseFiles(filepath.Join(dir, "layout.txt"), filepath.Join(dir, name+".txt"))
+	if err != nil {
+		return EmailTemplate{}, fmt.Errorf("error parsing email template %s: %w", name, err)
+	}
+
+	var subject bytes.Buffer
+	if err := textTmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return EmailTemplate{}, fmt.Errorf("error executing email subject %s: %w", name, err)
+	}
+	// Subjects are a single header line
+	data["Subject"] = strings.Join(strings.Fields(subject.String()), " ")
+
+	var text bytes.Buffer
+	if err := textTmpl.ExecuteTemplate(&text, "layout", data); err != nil {
+		return EmailTemplate{}, fmt.Errorf("error executing email text %s: %w", name, err)
+	}
+
+	htmlTmpl, err := htmltemplate.ParseFiles(filepath.Join(dir, "layout.html"), filepath.Join(dir, name+".html"))
+	if err != nil {
+		return EmailTemplate{}, fmt.Errorf("error parsing email template %s: %w", name, err)
+	}
+
+	var html bytes.Buffer
+	if err := htmlTmpl.ExecuteTemplate(&html, "layout", data); err != nil {
+		return EmailTemplate{}, fmt.Errorf("error executing email HTML %s: %w", name, err)
+	}
+
+	return EmailTemplate{
+		Subject:         data["Subject"].(string),
+		Body:            strings.TrimLeft(text.String(), "\n"),
+		HTML:            html.String(),
+		ListUnsubscribe: data["UnsubscribeURL"].(string),
+	}, nil
+}
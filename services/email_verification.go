@@ -0,0 +1,376 @@
+package services
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"olhourbano2/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// EmailVerificationResendInterval keeps unverified addresses from getting a verification email on every event
+const EmailVerificationResendInterval = 24 * time.Hour
+
+// Bounce types accepted by ProcessBounce
+const (
+	BouncePermanent = "permanent"
+	BounceTransient = "transient"
+	BounceComplaint = "complaint"
+)
+
+// BounceEvent is a delivery failure or spam complaint reported for an address
+type BounceEvent struct {
+	Email  string `json:"email"`
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+// RequestEmailVerification makes sure a non-transactional sender can reach an address. Verified
+// addresses return true; others get a verification email, at most once per resend interval.
+func RequestEmailVerification(db *sql.DB, email, locale string) (bool, error) {
+	if isEmailVerified(db, email) {
+		return true, nil
+	}
+
+	return false, WithEmailVerificationURL(db, email, locale, func(tx *sql.Tx, verifyURL string) error {
+		if verifyURL == "" {
+			return nil
+		}
+		template, err := GetEmailVerificationEmailTemplate(locale, verifyURL)
+		if err != nil {
+			return err
+		}
+		event := fmt.Sprintf("email-verification:%s", time.Now().Format("2006-01-02T15"))
+		return EnqueueEmail(tx, event, email, template)
+	})
+}
+
+// WithEmailVerificationURL runs send with the verification link to embed in an email to an
+// address, or "" when the address is verified or was sent a link recently. The link is only
+// recorded as sent if send queues its email without error, so a failure doesn't hold back the
+// next one for the resend interval.
+func WithEmailVerificationURL(db *sql.DB, email, locale string, send func(tx *sql.Tx, verifyURL string) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	verifyURL, err := claimEmailVerificationURL(tx, email, locale)
+	if err != nil {
+		return err
+	}
+	if err := send(tx, verifyURL); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// VerifyEmail marks the address owning a verification token as verified
+func VerifyEmail(db *sql.DB, token string) (string, error) {
+	if token == "" {
+		return "", sql.ErrNoRows
+	}
+
+	var email string
+	err := db.QueryRow(`
+		UPDATE email_verifications SET verified_at = COALESCE(verified_at, NOW())
+		WHERE token = $1
+		RETURNING email
+	`, token).Scan(&email)
+	return email, err
+}
+
+// MarkEmailVerified records that an address was proven some other way, like confirming an alert
+func MarkEmailVerified(db sqlExecer, email string) error {
+	token, err := generateEmailToken()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO email_verifications (email, token, verified_at, created_at)
+		VALUES (LOWER($1), $2, NOW(), NOW())
+		ON CONFLICT (email) DO UPDATE SET verified_at = COALESCE(email_verifications.verified_at, NOW())
+	`, strings.TrimSpace(email), token)
+	if err != nil {
+		return fmt.Errorf("error marking email as verified: %w", err)
+	}
+	return nil
+}
+
+// SuppressEmail stops every email to an address
+func SuppressEmail(db *sql.DB, email, reason, detail string) error {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return fmt.Errorf("email is empty")
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO email_suppressions (email, reason, detail, created_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (email) DO UPDATE SET reason = EXCLUDED.reason, detail = EXCLUDED.detail
+	`, email, reason, truncateEmailText(detail, 1000))
+	if err != nil {
+		return fmt.Errorf("error suppressing email: %w", err)
+	}
+	return nil
+}
+
+// UnsuppressEmail removes an address from the suppression list
+func UnsuppressEmail(db *sql.DB, email string) (bool, error) {
+	result, err := db.Exec(`DELETE FROM email_suppressions WHERE email = LOWER($1)`, strings.TrimSpace(email))
+	if err != nil {
+		return false, fmt.Errorf("error removing email suppression: %w", err)
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+// ListSuppressedEmails returns the suppression list, newest first
+func ListSuppressedEmails(db *sql.DB, limit int) ([]*models.EmailSuppression, error) {
+	rows, err := db.Query(`
+		SELECT email, reason, COALESCE(detail, ''), created_at
+		FROM email_suppressions
+		ORDER BY created_at DESC
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying email suppressions: %w", err)
+	}
+	defer rows.Close()
+
+	var suppressions []*models.EmailSuppression
+	for rows.Next() {
+		suppression := &models.EmailSuppression{}
+		if err := rows.Scan(&suppression.Email, &suppression.Reason, &suppression.Detail, &suppression.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning email suppression: %w", err)
+		}
+		suppressions = append(suppressions, suppression)
+	}
+
+	return suppressions, rows.Err()
+}
+
+// ProcessBounce suppresses addresses that bounced permanently or complained. Transient bounces are
+// left to the outbox retries.
+func ProcessBounce(db *sql.DB, event BounceEvent) (bool, error) {
+	if !ValidateEmail(strings.TrimSpace(event.Email)) {
+		return false, fmt.Errorf("invalid bounce address %q", event.Email)
+	}
+
+	switch event.Type {
+	case BouncePermanent:
+		return true, SuppressEmail(db, event.Email, "bounce", event.Detail)
+	case BounceComplaint:
+		return true, SuppressEmail(db, event.Email, "complaint", event.Detail)
+	case BounceTransient:
+		log.Printf("Falha temporária de entrega para %s: %s", event.Email, event.Detail)
+		return false, nil
+	default:
+		return false, fmt.Errorf("unknown bounce type %q", event.Type)
+	}
+}
+
+// ParseBounceMessage reads a delivery status notification (RFC 3464) or a feedback loop report
+// (RFC 5965) and returns the bounces it describes. Other messages return no events.
+func ParseBounceMessage(r io.Reader) ([]BounceEvent, error) {
+	message, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading message: %w", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" {
+		return nil, nil
+	}
+
+	var events []BounceEvent
+	var complaint *BounceEvent
+	var originalRecipient string
+	parts := multipart.NewReader(message.Body, params["boundary"])
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading report part: %w", err)
+		}
+
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		switch partType {
+		case "message/delivery-status":
+			events = append(events, parseDeliveryStatus(part)...)
+		case "message/feedback-report":
+			fields, _ := textproto.NewReader(bufio.NewReader(part)).ReadMIMEHeader()
+			complaint = &BounceEvent{
+				Email:  stripAddressType(fields.Get("Original-Rcpt-To")),
+				Type:   BounceComplaint,
+				Detail: fields.Get("Feedback-Type"),
+			}
+		case "message/rfc822", "text/rfc822-headers":
+			// The returned copy of our email; its To header names the recipient when the report omits it
+			if original, err := mail.ReadMessage(part); err == nil {
+				if address, err := mail.ParseAddress(original.Header.Get("To")); err == nil {
+					originalRecipient = address.Address
+				}
+			}
+		}
+	}
+
+	if complaint != nil {
+		if complaint.Email == "" {
+			complaint.Email = originalRecipient
+		}
+		if complaint.Email != "" {
+			events = append(events, *complaint)
+		}
+	}
+
+	return events, nil
+}
+
+// ImportBounceMessages parses a bounce message file, or every message in the new and cur folders
+// of a Maildir, and processes the bounces found. Returns how many addresses were suppressed.
+func ImportBounceMessages(db *sql.DB, path string) (int, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		files = nil
+		for _, sub := range []string{"new", "cur"} {
+			matches, err := filepath.Glob(filepath.Join(path, sub, "*"))
+			if err != nil {
+				return 0, err
+			}
+			files = append(files, matches...)
+		}
+	}
+
+	suppressed := 0
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return suppressed, err
+		}
+		events, err := ParseBounceMessage(f)
+		f.Close()
+		if err != nil {
+			log.Printf("Ignorando %s: %v", file, err)
+			continue
+		}
+
+		for _, event := range events {
+			ok, err := ProcessBounce(db, event)
+			if err != nil {
+				log.Printf("Erro ao processar bounce de %s em %s: %v", event.Email, file, err)
+				continue
+			}
+			if ok {
+				suppressed++
+			}
+		}
+	}
+
+	return suppressed, nil
+}
+
+// parseDeliveryStatus reads the per-recipient fields of a delivery-status part. Action "failed"
+// with a 5.x.x status is a permanent bounce; 4.x.x and "delayed" are transient.
+func parseDeliveryStatus(part io.Reader) []BounceEvent {
+	reader := textproto.NewReader(bufio.NewReader(part))
+
+	// The first group describes the message; each following group is a recipient
+	if _, err := reader.ReadMIMEHeader(); err != nil {
+		return nil
+	}
+
+	var events []BounceEvent
+	for {
+		fields, err := reader.ReadMIMEHeader()
+		if recipient := stripAddressType(fields.Get("Final-Recipient")); recipient != "" {
+			action := strings.ToLower(fields.Get("Action"))
+			status := fields.Get("Status")
+
+			bounceType := BounceTransient
+			if action == "failed" && strings.HasPrefix(status, "5") {
+				bounceType = BouncePermanent
+			}
+			if action == "failed" || action == "delayed" {
+				detail := strings.TrimSpace(status + " " + fields.Get("Diagnostic-Code"))
+				events = append(events, BounceEvent{Email: recipient, Type: bounceType, Detail: detail})
+			}
+		}
+		if err != nil {
+			return events
+		}
+	}
+}
+
+// stripAddressType turns "rfc822; someone@example.com" into the address
+func stripAddressType(field string) string {
+	if i := strings.Index(field, ";"); i >= 0 {
+		field = field[i+1:]
+	}
+	return strings.Trim(strings.TrimSpace(field), "<>")
+}
+
+// isEmailVerified checks whether an address completed double opt-in
+func isEmailVerified(db *sql.DB, email string) bool {
+	var verified bool
+	err := db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM email_verifications WHERE email = LOWER($1) AND verified_at IS NOT NULL)
+	`, strings.TrimSpace(email)).Scan(&verified)
+	if err != nil {
+		log.Printf("Erro ao verificar confirmação do email %s: %v", email, err)
+	}
+	return verified
+}
+
+// claimEmailVerificationURL creates the verification state of an address if needed and returns its
+// link, recording it as sent. Returns "" when the address is verified or got a link recently.
+func claimEmailVerificationURL(tx *sql.Tx, email, locale string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return "", nil
+	}
+
+	token, err := generateEmailToken()
+	if err != nil {
+		return "", err
+	}
+
+	var verifyToken string
+	var verifiedAt, lastSentAt sql.NullTime
+	err = tx.QueryRow(`
+		INSERT INTO email_verifications (email, token, locale, created_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (email) DO UPDATE SET locale = EXCLUDED.locale
+		RETURNING token, verified_at, last_sent_at
+	`, email, token, NormalizeEmailLocale(locale)).Scan(&verifyToken, &verifiedAt, &lastSentAt)
+	if err != nil {
+		return "", fmt.Errorf("error loading email verification: %w", err)
+	}
+
+	if verifiedAt.Valid || (lastSentAt.Valid && time.Since(lastSentAt.Time) < EmailVerificationResendInterval) {
+		return "", nil
+	}
+
+	if _, err := tx.Exec(`UPDATE email_verifications SET last_sent_at = NOW() WHERE email = $1`, email); err != nil {
+		return "", fmt.Errorf("error updating email verification: %w", err)
+	}
+	return fmt.Sprintf("%s/email/verificar/%s", siteURL, verifyToken), nil
+}
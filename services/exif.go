@@ -0,0 +1,283 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// exifReadLimit is how much of an image is read to find its EXIF block, which JPEG, PNG and
+// WebP files keep before the pixel data
+const exifReadLimit = 256 * 1024
+
+// EXIF tags read from uploads
+const (
+	exifTagOrientation        = 0x0112
+	exifTagDateTime           = 0x0132
+	exifTagExifIFD            = 0x8769
+	exifTagGPSIFD             = 0x8825
+	exifTagDateTimeOriginal   = 0x9003
+	exifTagOffsetTimeOriginal = 0x9011
+	gpsTagLatitudeRef         = 0x0001
+	gpsTagLatitude            = 0x0002
+	gpsTagLongitudeRef        = 0x0003
+	gpsTagLongitude           = 0x0004
+)
+
+// exifDateLayout is how EXIF writes dates, in the local time of the camera
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// exifTags are the EXIF fields the upload pipeline uses
+type exifTags struct {
+	Orientation int // 1 to 8, as in the EXIF spec; 1 when missing
+	HasGPS      bool
+	Latitude    float64
+	Longitude   float64
+	TakenAt     time.Time // Zero when missing
+}
+
+// readFileEXIF reads the EXIF fields of an image file; files without EXIF give the defaults
+func readFileEXIF(filePath string) (*exifTags, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, exifReadLimit))
+	if err != nil {
+		return nil, err
+	}
+	return parseEXIF(findEXIF(data)), nil
+}
+
+// findEXIF returns the TIFF-structured EXIF block of a JPEG, PNG or WebP file, or nil
+func findEXIF(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xFF\xD8")):
+		// JPEG: walk the segments up to the image data, looking for APP1 "Exif"
+		for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+			marker := data[i+1]
+			if marker == 0xDA || marker == 0xD9 {
+				break
+			}
+			length := int(binary.BigEndian.Uint16(data[i+2:]))
+			end := i + 2 + length
+			if length < 2 || end > len(data) {
+				break
+			}
+			segment := data[i+4 : end]
+			if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+				return segment[6:]
+			}
+			i = end
+		}
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		// PNG: the eXIf chunk
+		for i := 8; i+8 <= len(data); {
+			length := int(binary.BigEndian.Uint32(data[i:]))
+			end := i + 12 + length
+			if length < 0 || end > len(data) {
+				break
+			}
+			if string(data[i+4:i+8]) == "eXIf" {
+				return data[i+8 : i+8+length]
+			}
+			i = end
+		}
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		// WebP: the EXIF chunk, padded to an even size
+		for i := 12; i+8 <= len(data); {
+			length := int(binary.LittleEndian.Uint32(data[i+4:]))
+			end := i + 8 + length + length%2
+			if length < 0 || i+8+length > len(data) {
+				break
+			}
+			if string(data[i:i+4]) == "EXIF" {
+				return bytes.TrimPrefix(data[i+8:i+8+length], []byte("Exif\x00\x00"))
+			}
+			i = end
+		}
+	}
+	return nil
+}
+
+// tiffReader reads values from a TIFF block in its byte order
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// ifdEntry is a field of an image file directory; value holds the 4 bytes that are either the
+// value itself or its offset
+type ifdEntry struct {
+	kind  uint16
+	count uint32
+	value []byte
+}
+
+// readIFD reads the entries of the directory at offset
+func (t *tiffReader) readIFD(offset uint32) map[uint16]ifdEntry {
+	entries := make(map[uint16]ifdEntry)
+	if int(offset)+2 > len(t.data) {
+		return entries
+	}
+	count := int(t.order.Uint16(t.data[offset:]))
+	for i := 0; i < count; i++ {
+		start := int(offset) + 2 + i*12
+		if start+12 > len(t.data) {
+			break
+		}
+		entry := t.data[start : start+12]
+		entries[t.order.Uint16(entry)] = ifdEntry{
+			kind:  t.order.Uint16(entry[2:]),
+			count: t.order.Uint32(entry[4:]),
+			value: entry[8:12],
+		}
+	}
+	return entries
+}
+
+// exifTypeSizes are the sizes in bytes of the TIFF field types
+var exifTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+
+// bytes returns the data of a field, which is kept in the entry when it fits in 4 bytes and
+// at an offset otherwise; nil when it lies outside the block
+func (t *tiffReader) bytes(entry ifdEntry) []byte {
+	size := exifTypeSizes[entry.kind] * int(entry.count)
+	if size <= 0 || entry.count > exifReadLimit {
+		return nil
+	}
+	if size <= 4 {
+		return entry.value[:size]
+	}
+	offset := int(t.order.Uint32(entry.value))
+	if offset < 0 || offset+size > len(t.data) {
+		return nil
+	}
+	return t.data[offset : offset+size]
+}
+
+// ascii reads an ASCII field, without its trailing NULs
+func (t *tiffReader) ascii(entry ifdEntry) string {
+	if entry.kind != 2 {
+		return ""
+	}
+	return strings.TrimRight(string(t.bytes(entry)), "\x00 ")
+}
+
+// rationals reads a RATIONAL field
+func (t *tiffReader) rationals(entry ifdEntry) []float64 {
+	if entry.kind != 5 {
+		return nil
+	}
+	data := t.bytes(entry)
+	values := make([]float64, 0, len(data)/8)
+	for i := 0; i+8 <= len(data); i += 8 {
+		numerator, denominator := t.order.Uint32(data[i:]), t.order.Uint32(data[i+4:])
+		if denominator == 0 {
+			return nil
+		}
+		values = append(values, float64(numerator)/float64(denominator))
+	}
+	return values
+}
+
+// uint reads a SHORT or LONG field
+func (t *tiffReader) uint(entry ifdEntry) (uint32, bool) {
+	switch entry.kind {
+	case 3: // SHORT
+		return uint32(t.order.Uint16(entry.value)), true
+	case 4: // LONG
+		return t.order.Uint32(entry.value), true
+	}
+	return 0, false
+}
+
+// parseEXIF reads the fields the pipeline uses from a TIFF-structured EXIF block
+func parseEXIF(data []byte) *exifTags {
+	tags := &exifTags{Orientation: 1}
+	if len(data) < 8 {
+		return tags
+	}
+
+	t := &tiffReader{data: data}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return tags
+	}
+
+	ifd0 := t.readIFD(t.order.Uint32(data[4:]))
+	if entry, ok := ifd0[exifTagOrientation]; ok {
+		if orientation, ok := t.uint(entry); ok && orientation >= 1 && orientation <= 8 {
+			tags.Orientation = int(orientation)
+		}
+	}
+
+	// The capture time is in the Exif directory; the time of the last edit in IFD0 stands in
+	// for it when missing
+	var taken, offset string
+	if entry, ok := ifd0[exifTagExifIFD]; ok {
+		if pointer, ok := t.uint(entry); ok {
+			exif := t.readIFD(pointer)
+			taken = t.ascii(exif[exifTagDateTimeOriginal])
+			offset = t.ascii(exif[exifTagOffsetTimeOriginal])
+		}
+	}
+	if taken == "" {
+		taken = t.ascii(ifd0[exifTagDateTime])
+	}
+	tags.TakenAt = parseEXIFTime(taken, offset)
+
+	if entry, ok := ifd0[exifTagGPSIFD]; ok {
+		if pointer, ok := t.uint(entry); ok {
+			gps := t.readIFD(pointer)
+			latitude, latOK := gpsCoordinate(t.rationals(gps[gpsTagLatitude]), t.ascii(gps[gpsTagLatitudeRef]), "S")
+			longitude, lngOK := gpsCoordinate(t.rationals(gps[gpsTagLongitude]), t.ascii(gps[gpsTagLongitudeRef]), "W")
+			// Cameras without a fix may write zeros
+			if latOK && lngOK && math.Abs(latitude) <= 90 && math.Abs(longitude) <= 180 && (latitude != 0 || longitude != 0) {
+				tags.HasGPS, tags.Latitude, tags.Longitude = true, latitude, longitude
+			}
+		}
+	}
+	return tags
+}
+
+// gpsCoordinate converts degrees, minutes and seconds to decimal degrees, negative on the
+// southern or western side
+func gpsCoordinate(dms []float64, ref, negativeRef string) (float64, bool) {
+	if len(dms) != 3 {
+		return 0, false
+	}
+	value := dms[0] + dms[1]/60 + dms[2]/3600
+	if strings.EqualFold(ref, negativeRef) {
+		value = -value
+	}
+	return value, true
+}
+
+// parseEXIFTime reads an EXIF date, in the offset the camera recorded or else in the server's
+// zone; zero when missing or malformed
+func parseEXIFTime(value, offset string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	if offset != "" {
+		if taken, err := time.Parse(exifDateLayout+"-07:00", value+offset); err == nil {
+			return taken
+		}
+	}
+	taken, err := time.ParseInLocation(exifDateLayout, value, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return taken
+}
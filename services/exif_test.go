@@ -0,0 +1,272 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+// tiffField is an entry of a directory built by buildTIFF
+type tiffField struct {
+	tag   uint16
+	kind  uint16
+	count uint32
+	data  []byte // Value in the byte order of the block
+	ifd   int    // For pointers, the index of the directory pointed at
+}
+
+// buildTIFF lays out a TIFF block: the header, the directories one after the other starting
+// with IFD0, then the values that don't fit in their entry
+func buildTIFF(order binary.ByteOrder, ifds ...[]tiffField) []byte {
+	offsets := make([]int, len(ifds))
+	size := 8
+	for i, fields := range ifds {
+		offsets[i] = size
+		size += 2 + 12*len(fields) + 4
+	}
+
+	data := make([]byte, size)
+	if order == binary.LittleEndian {
+		copy(data, "II")
+	} else {
+		copy(data, "MM")
+	}
+	order.PutUint16(data[2:], 42)
+	order.PutUint32(data[4:], uint32(offsets[0]))
+
+	for i, fields := range ifds {
+		order.PutUint16(data[offsets[i]:], uint16(len(fields)))
+		for j, field := range fields {
+			entry := offsets[i] + 2 + 12*j
+			order.PutUint16(data[entry:], field.tag)
+			order.PutUint16(data[entry+2:], field.kind)
+			order.PutUint32(data[entry+4:], field.count)
+			switch {
+			case field.ifd > 0:
+				order.PutUint32(data[entry+8:], uint32(offsets[field.ifd]))
+			case len(field.data) <= 4:
+				copy(data[entry+8:entry+12], field.data)
+			default:
+				order.PutUint32(data[entry+8:], uint32(len(data)))
+				data = append(data, field.data...)
+			}
+		}
+	}
+	return data
+}
+
+func asciiField(tag uint16, value string) tiffField {
+	return tiffField{tag: tag, kind: 2, count: uint32(len(value) + 1), data: append([]byte(value), 0)}
+}
+
+func shortField(order binary.ByteOrder, tag, value uint16) tiffField {
+	data := make([]byte, 2)
+	order.PutUint16(data, value)
+	return tiffField{tag: tag, kind: 3, count: 1, data: data}
+}
+
+func rationalField(order binary.ByteOrder, tag uint16, values ...uint32) tiffField {
+	data := make([]byte, 4*len(values))
+	for i, value := range values {
+		order.PutUint32(data[4*i:], value)
+	}
+	return tiffField{tag: tag, kind: 5, count: uint32(len(values) / 2), data: data}
+}
+
+// cameraEXIF is the EXIF block of a photo taken in São Paulo, turned 90 degrees
+func cameraEXIF(order binary.ByteOrder) []byte {
+	return buildTIFF(order,
+		[]tiffField{
+			shortField(order, exifTagOrientation, 6),
+			asciiField(exifTagDateTime, "2024:03:11 09:00:00"),
+			{tag: exifTagExifIFD, kind: 4, count: 1, ifd: 1},
+			{tag: exifTagGPSIFD, kind: 4, count: 1, ifd: 2},
+		},
+		[]tiffField{
+			asciiField(exifTagDateTimeOriginal, "2024:03:10 14:30:00"),
+			asciiField(exifTagOffsetTimeOriginal, "-03:00"),
+		},
+		[]tiffField{
+			asciiField(gpsTagLatitudeRef, "S"),
+			rationalField(order, gpsTagLatitude, 23, 1, 33, 1, 18, 10),
+			asciiField(gpsTagLongitudeRef, "W"),
+			rationalField(order, gpsTagLongitude, 46, 1, 38, 1, 12, 10),
+		},
+	)
+}
+
+func TestParseEXIF(t *testing.T) {
+	le, be := binary.LittleEndian, binary.BigEndian
+	takenAt := time.Date(2024, 3, 10, 17, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		data        []byte
+		orientation int
+		hasGPS      bool
+		latitude    float64
+		longitude   float64
+		takenAt     time.Time
+	}{
+		{"little endian", cameraEXIF(le), 6, true, -23.5505, -46.6337, takenAt},
+		{"big endian", cameraEXIF(be), 6, true, -23.5505, -46.6337, takenAt},
+		{
+			"northern and eastern references",
+			buildTIFF(le,
+				[]tiffField{{tag: exifTagGPSIFD, kind: 4, count: 1, ifd: 1}},
+				[]tiffField{
+					asciiField(gpsTagLatitudeRef, "N"), rationalField(le, gpsTagLatitude, 48, 1, 51, 1, 0, 1),
+					asciiField(gpsTagLongitudeRef, "E"), rationalField(le, gpsTagLongitude, 2, 1, 21, 1, 0, 1),
+				},
+			),
+			1, true, 48.85, 2.35, time.Time{},
+		},
+		{
+			"GPS without a fix",
+			buildTIFF(le,
+				[]tiffField{{tag: exifTagGPSIFD, kind: 4, count: 1, ifd: 1}},
+				[]tiffField{
+					asciiField(gpsTagLatitudeRef, "N"), rationalField(le, gpsTagLatitude, 0, 1, 0, 1, 0, 1),
+					asciiField(gpsTagLongitudeRef, "E"), rationalField(le, gpsTagLongitude, 0, 1, 0, 1, 0, 1),
+				},
+			),
+			1, false, 0, 0, time.Time{},
+		},
+		{
+			"zero denominator",
+			buildTIFF(le,
+				[]tiffField{{tag: exifTagGPSIFD, kind: 4, count: 1, ifd: 1}},
+				[]tiffField{
+					rationalField(le, gpsTagLatitude, 23, 0, 33, 1, 18, 10),
+					rationalField(le, gpsTagLongitude, 46, 1, 38, 1, 12, 10),
+				},
+			),
+			1, false, 0, 0, time.Time{},
+		},
+		{
+			"latitude out of range",
+			buildTIFF(le,
+				[]tiffField{{tag: exifTagGPSIFD, kind: 4, count: 1, ifd: 1}},
+				[]tiffField{
+					rationalField(le, gpsTagLatitude, 91, 1, 0, 1, 0, 1),
+					rationalField(le, gpsTagLongitude, 46, 1, 0, 1, 0, 1),
+				},
+			),
+			1, false, 0, 0, time.Time{},
+		},
+		{"orientation out of range", buildTIFF(le, []tiffField{shortField(le, exifTagOrientation, 9)}), 1, false, 0, 0, time.Time{}},
+		{
+			"value offset past the end",
+			buildTIFF(le, []tiffField{{tag: exifTagDateTime, kind: 2, count: 20, data: []byte{0xF0, 0xFF, 0, 0}}}),
+			1, false, 0, 0, time.Time{},
+		},
+		{
+			"huge count",
+			buildTIFF(le, []tiffField{{tag: exifTagDateTime, kind: 2, count: 0xFFFFFFFF, data: []byte{8, 0, 0, 0}}}),
+			1, false, 0, 0, time.Time{},
+		},
+		{
+			"directory pointer past the end",
+			buildTIFF(le, []tiffField{{tag: exifTagGPSIFD, kind: 4, count: 1, data: []byte{0xFF, 0xFF, 0xFF, 0x7F}}}),
+			1, false, 0, 0, time.Time{},
+		},
+		{"unknown byte order", append([]byte("XX"), cameraEXIF(le)[2:]...), 1, false, 0, 0, time.Time{}},
+		{"empty", nil, 1, false, 0, 0, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags := parseEXIF(tt.data)
+			if tags.Orientation != tt.orientation {
+				t.Errorf("orientation = %d, want %d", tags.Orientation, tt.orientation)
+			}
+			if tags.HasGPS != tt.hasGPS ||
+				math.Abs(tags.Latitude-tt.latitude) > 1e-4 || math.Abs(tags.Longitude-tt.longitude) > 1e-4 {
+				t.Errorf("GPS = %v %v,%v, want %v %v,%v", tags.HasGPS, tags.Latitude, tags.Longitude, tt.hasGPS, tt.latitude, tt.longitude)
+			}
+			if !tags.TakenAt.Equal(tt.takenAt) {
+				t.Errorf("taken at %v, want %v", tags.TakenAt, tt.takenAt)
+			}
+		})
+	}
+}
+
+func TestParseEXIFFallsBackToDateTime(t *testing.T) {
+	tags := parseEXIF(buildTIFF(binary.BigEndian, []tiffField{asciiField(exifTagDateTime, "2023:12:25 08:15:00")}))
+	want := time.Date(2023, 12, 25, 8, 15, 0, 0, time.Local)
+	if !tags.TakenAt.Equal(want) {
+		t.Errorf("taken at %v, want %v in the server's zone", tags.TakenAt, want)
+	}
+
+	tags = parseEXIF(buildTIFF(binary.BigEndian, []tiffField{asciiField(exifTagDateTime, "0000:00:00 00:00:00")}))
+	if !tags.TakenAt.IsZero() {
+		t.Errorf("taken at %v for a blank date, want zero", tags.TakenAt)
+	}
+}
+
+func TestParseEXIFTruncated(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		data := cameraEXIF(order)
+		for n := 0; n < len(data); n++ {
+			// Must not panic; what is read depends on where the block was cut
+			parseEXIF(data[:n])
+		}
+	}
+}
+
+// webpChunk returns a WebP chunk, padded to an even size
+func webpChunk(kind string, payload []byte) []byte {
+	chunk := make([]byte, 8, 9+len(payload))
+	copy(chunk, kind)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(payload)))
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func TestFindEXIF(t *testing.T) {
+	block := cameraEXIF(binary.LittleEndian)
+	app1 := jpegSegment(0xE1, concat([]byte("Exif\x00\x00"), block))
+	jfif := jpegSegment(0xE0, []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00"))
+	sos := jpegSegment(0xDA, []byte{1, 1, 0, 0, 0x3F, 0})
+	pngSignature := []byte("\x89PNG\r\n\x1a\n")
+	webp := func(chunks ...[]byte) []byte {
+		body := concat(append([][]byte{[]byte("WEBP")}, chunks...)...)
+		header := []byte("RIFF\x00\x00\x00\x00")
+		binary.LittleEndian.PutUint32(header[4:], uint32(len(body)))
+		return concat(header, body)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want []byte
+	}{
+		{"JPEG after JFIF", concat([]byte{0xFF, 0xD8}, jfif, app1, sos), block},
+		{"JPEG with XMP before EXIF", concat([]byte{0xFF, 0xD8}, jpegSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<x/>")), app1), block},
+		{"JPEG with EXIF after the scan", concat([]byte{0xFF, 0xD8}, jfif, sos, app1), nil},
+		{"JPEG truncated in a segment", concat([]byte{0xFF, 0xD8}, jfif, app1[:len(app1)-10]), nil},
+		{"JPEG with a bad segment length", concat([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x01}, app1), nil},
+		{"JPEG without EXIF", concat([]byte{0xFF, 0xD8}, jfif, sos), nil},
+		{"PNG", concat(pngSignature, pngChunk("IHDR", make([]byte, 13)), pngChunk("eXIf", block), pngChunk("IEND", nil)), block},
+		{"PNG truncated in a chunk", concat(pngSignature, pngChunk("IHDR", make([]byte, 13)), pngChunk("eXIf", block)[:20]), nil},
+		{"PNG with a huge chunk length", concat(pngSignature, []byte{0xFF, 0xFF, 0xFF, 0xFF}, []byte("IDAT")), nil},
+		{"WebP after an odd chunk", webp(webpChunk("VP8X", make([]byte, 9)), webpChunk("EXIF", concat([]byte("Exif\x00\x00"), block))), block},
+		{"WebP without the Exif prefix", webp(webpChunk("EXIF", block)), block},
+		{"WebP truncated in a chunk", webp(webpChunk("EXIF", block))[:30], nil},
+		{"GIF", []byte("GIF89a\x01\x00\x01\x00"), nil},
+		{"empty", nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findEXIF(tt.data); !bytes.Equal(got, tt.want) {
+				t.Errorf("findEXIF() = %d bytes, want %d", len(got), len(tt.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,516 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// sniffLength is how much of the start of a file is read to detect its type
+const sniffLength = 512
+
+// ErrFileTypeMismatch is returned when the content of an upload doesn't match its name or the
+// type the browser declared
+var ErrFileTypeMismatch = errors.New("o conteúdo do arquivo não corresponde ao tipo informado")
+
+// ErrSuspiciousFile is returned when an upload is valid as its type but also carries content a
+// browser or reader could run, like HTML in a picture or JavaScript in a PDF
+var ErrSuspiciousFile = errors.New("arquivo rejeitado por conter conteúdo ativo")
+
+// contentTypeAliases maps the other names browsers send to the names used in the allowed lists
+var contentTypeAliases = map[string]string{
+	"image/jpg":         "image/jpeg",
+	"image/pjpeg":       "image/jpeg",
+	"video/quicktime":   "video/mov",
+	"video/x-msvideo":   "video/avi",
+	"video/msvideo":     "video/avi",
+	"video/x-ms-wmv":    "video/wmv",
+	"video/x-ms-asf":    "video/wmv",
+	"video/x-flv":       "video/flv",
+	"application/x-pdf": "application/pdf",
+}
+
+// extensionAliases maps other spellings of an extension to the one GetFileExtension returns
+var extensionAliases = map[string]string{
+	".jpeg": ".jpg",
+	".jpe":  ".jpg",
+	".qt":   ".mov",
+	".text": ".txt",
+}
+
+// activeContentMarkers are lowercase snippets of markup that must never appear in pictures,
+// PDFs or text files, since browsers that sniff would render them as a page
+var activeContentMarkers = [][]byte{
+	[]byte("<html"),
+	[]byte("<head"),
+	[]byte("<body"),
+	[]byte("<script"),
+	[]byte("<svg"),
+	[]byte("<iframe"),
+	[]byte("<object"),
+	[]byte("<embed"),
+	[]byte("<!doctype html"),
+	[]byte("<?php"),
+	[]byte("javascript:"),
+}
+
+// pdfActiveMarkers are PDF names for scripts, launched programs and embedded files
+var pdfActiveMarkers = [][]byte{
+	[]byte("/javascript"),
+	[]byte("/launch"),
+	[]byte("/embeddedfile"),
+	[]byte("/richmedia"),
+}
+
+// zipMarkers are the signatures of ZIP entries and directories, which turn a picture or PDF
+// with an archive appended into a valid archive as well
+var zipMarkers = [][]byte{
+	[]byte("pk\x03\x04"),
+	[]byte("pk\x05\x06"),
+}
+
+// NormalizeContentType returns the allowed-list name of a content type, dropping parameters
+func NormalizeContentType(contentType string) string {
+	contentType = strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+	if alias, ok := contentTypeAliases[contentType]; ok {
+		return alias
+	}
+	return contentType
+}
+
+// DetectContentType identifies a file from its first bytes, returning the allowed-list name of
+// its type or an empty string when it isn't a type uploads accept. Any ZIP file is taken for a
+// Word document here; checkUploadedContent confirms it by reading the archive.
+func DetectContentType(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("\xFF\xD8\xFF")):
+		return "image/jpeg"
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case len(head) >= 12 && bytes.Equal(head[:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WEBP")):
+		return "image/webp"
+	case len(head) >= 12 && bytes.Equal(head[:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("AVI ")):
+		return "video/avi"
+	case bytes.HasPrefix(head, []byte("%PDF-")):
+		return "application/pdf"
+	case bytes.HasPrefix(head, []byte("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1")):
+		return "application/msword"
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	case len(head) >= 12 && bytes.Equal(head[4:8], []byte("ftyp")):
+		if bytes.Equal(head[8:12], []byte("qt  ")) {
+			return "video/mov"
+		}
+		return "video/mp4"
+	case len(head) >= 8 && (bytes.Equal(head[4:8], []byte("moov")) || bytes.Equal(head[4:8], []byte("mdat")) ||
+		bytes.Equal(head[4:8], []byte("wide"))):
+		return "video/mov"
+	case bytes.HasPrefix(head, []byte("\x1A\x45\xDF\xA3")):
+		// Matroska files are only accepted with the WebM doctype
+		if bytes.Contains(head[:min(len(head), 64)], []byte("webm")) {
+			return "video/webm"
+		}
+		return ""
+	case bytes.HasPrefix(head, []byte("\x30\x26\xB2\x75\x8E\x66\xCF\x11")):
+		return "video/wmv"
+	case bytes.HasPrefix(head, []byte("FLV\x01")):
+		return "video/flv"
+	case isPlainText(head):
+		return "text/plain"
+	}
+	return ""
+}
+
+// isPlainText accepts UTF-8 without control characters other than whitespace. The last bytes
+// may be a character cut by the sniff length.
+func isPlainText(head []byte) bool {
+	if len(head) == 0 {
+		return false
+	}
+	for len(head) > 0 {
+		r, size := utf8.DecodeRune(head)
+		if r == utf8.RuneError && size <= 1 {
+			return !utf8.FullRune(head)
+		}
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' && r != '\f' {
+			return false
+		}
+		head = head[size:]
+	}
+	return true
+}
+
+// checkDeclaredType rejects uploads whose declared content type or file extension name a
+// different type than the detected one. Missing or generic declarations are not held against it.
+func checkDeclaredType(detected, declared, filename string) error {
+	declared = NormalizeContentType(declared)
+	if declared != "" && declared != "application/octet-stream" && declared != detected {
+		return ErrFileTypeMismatch
+	}
+
+	ext := strings.ToLower(filepath.Ext(filename))
+	if alias, ok := extensionAliases[ext]; ok {
+		ext = alias
+	}
+	if ext != "" && ext != GetFileExtension(detected) {
+		return ErrFileTypeMismatch
+	}
+	return nil
+}
+
+// checkUploadedContent reads a saved upload and rejects polyglots: pictures, PDFs and text files
+// that also carry markup or an archive, PDFs with scripts, pictures that don't decode, and ZIP
+// files that aren't Word documents or that carry macros. Only the parts of pictures and PDFs
+// that hold text are scanned, since compressed pixels and streams match markers by chance.
+func checkUploadedContent(filePath, contentType string) error {
+	switch {
+	case contentType == "application/vnd.openxmlformats-officedocument.wordprocessingml.document":
+		return checkDocxContent(filePath)
+	case strings.HasPrefix(contentType, "video/"), contentType == "application/msword":
+		return nil
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("erro ao ler arquivo: %v", err)
+	}
+
+	markers := activeContentMarkers
+	if contentType != "text/plain" {
+		markers = append(append([][]byte{}, markers...), zipMarkers...)
+	}
+
+	var regions [][]byte
+	switch contentType {
+	case "image/jpeg", "image/png":
+		if _, _, err := image.DecodeConfig(bytes.NewReader(data)); err != nil {
+			return ErrFileTypeMismatch
+		}
+		if contentType == "image/jpeg" {
+			regions = jpegTextRegions(data)
+		} else {
+			regions = pngTextRegions(data)
+		}
+	case "application/pdf":
+		markers = append(markers, pdfActiveMarkers...)
+		if regions, err = pdfTextRegions(data); err != nil {
+			return err
+		}
+	default:
+		regions = [][]byte{data}
+	}
+
+	for _, region := range regions {
+		found, err := containsMarker(bytes.NewReader(region), markers)
+		if err != nil {
+			return fmt.Errorf("erro ao ler arquivo: %v", err)
+		}
+		if found {
+			return ErrSuspiciousFile
+		}
+	}
+	return nil
+}
+
+// jpegTextRegions returns the parts of a JPEG that can hold text: application and comment
+// segments, and whatever follows the end of the image. Without an end marker, everything from
+// the last scan on is returned, since what was appended can't be told from the pixels.
+func jpegTextRegions(data []byte) [][]byte {
+	var regions [][]byte
+	pos := 2 // After the start of image
+	for pos+1 < len(data) {
+		if data[pos] != 0xFF {
+			return append(regions, data[pos:])
+		}
+		marker := data[pos+1]
+		switch {
+		case marker == 0xFF: // Fill byte before a marker
+			pos++
+			continue
+		case marker == 0xD9: // End of image
+			return append(regions, data[pos+2:])
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7): // Markers without a length
+			pos += 2
+			continue
+		}
+
+		if pos+4 > len(data) {
+			return append(regions, data[pos:])
+		}
+		end := pos + 2 + (int(data[pos+2])<<8 | int(data[pos+3]))
+		if end < pos+4 || end > len(data) {
+			return append(regions, data[pos:])
+		}
+		if (marker >= 0xE0 && marker <= 0xEF) || marker == 0xFE {
+			regions = append(regions, data[pos+4:end])
+		}
+		pos = end
+
+		// A start of scan is followed by compressed pixels up to the next marker; 0xFF in them is
+		// stuffed with 0x00, and restart markers belong to the scan
+		if marker == 0xDA {
+			scan := pos
+			for pos+1 < len(data) && (data[pos] != 0xFF || data[pos+1] == 0x00 || (data[pos+1] >= 0xD0 && data[pos+1] <= 0xD7)) {
+				pos++
+			}
+			if pos+1 >= len(data) {
+				return append(regions, data[scan:])
+			}
+		}
+	}
+	return regions
+}
+
+// pngTextRegions returns the parts of a PNG that can hold text: every chunk but the image
+// header, palette and pixel data, with compressed text inflated, and whatever follows the end
+// of the image
+func pngTextRegions(data []byte) [][]byte {
+	var regions [][]byte
+	pos := 8 // After the signature
+	for pos+8 <= len(data) {
+		length := int64(binary.BigEndian.Uint32(data[pos:]))
+		chunkType := string(data[pos+4 : pos+8])
+		if int64(pos)+12+length > int64(len(data)) {
+			return append(regions, data[pos:])
+		}
+		chunk := data[pos+8 : pos+8+int(length)]
+		pos += 12 + int(length)
+
+		switch chunkType {
+		case "IEND":
+			return append(regions, data[pos:])
+		case "IHDR", "PLTE", "tRNS", "IDAT", "fdAT":
+			// Pixels and colours; compressed or binary, never read as text
+		case "zTXt", "iTXt":
+			regions = append(regions, pngText(chunkType, chunk)...)
+		default:
+			regions = append(regions, chunk)
+		}
+	}
+	return append(regions, data[pos:])
+}
+
+// pngText returns a zTXt or iTXt chunk with its text inflated when compressed. Text that
+// doesn't inflate is returned as stored.
+func pngText(chunkType string, chunk []byte) [][]byte {
+	keyword := bytes.IndexByte(chunk, 0)
+	if keyword < 0 || keyword+3 > len(chunk) {
+		return [][]byte{chunk}
+	}
+
+	// zTXt has the compression method after the keyword, then the compressed text. iTXt has a
+	// compression flag and method, then language and translated keyword, then the text.
+	start := keyword + 2
+	if chunkType == "iTXt" {
+		if chunk[keyword+1] != 1 {
+			return [][]byte{chunk}
+		}
+		start = keyword + 3
+		for i := 0; i < 2; i++ {
+			end := bytes.IndexByte(chunk[start:], 0)
+			if end < 0 {
+				return [][]byte{chunk}
+			}
+			start += end + 1
+		}
+	}
+
+	text, err := inflateLimited(chunk[start:])
+	if err != nil {
+		return [][]byte{chunk}
+	}
+	return [][]byte{chunk[:start], text}
+}
+
+// pdfTextRegions returns the parts of a PDF where names and markup can be written: everything
+// outside streams, and the objects packed in object streams, inflated. Streams of other kinds,
+// like page contents, fonts and pictures, are left out. Name escapes such as /Java#53cript are
+// decoded in the returned text. Object streams that can't be read are rejected.
+func pdfTextRegions(data []byte) ([][]byte, error) {
+	var regions [][]byte
+	pos := 0
+	for pos < len(data) {
+		keyword := bytes.Index(data[pos:], []byte("stream"))
+		if keyword < 0 {
+			break
+		}
+		keyword += pos
+		if keyword >= 3 && string(data[keyword-3:keyword]) == "end" {
+			// An endstream whose stream keyword wasn't found; the text before it is scanned
+			regions = append(regions, decodePDFNames(data[pos:keyword+len("stream")]))
+			pos = keyword + len("stream")
+			continue
+		}
+
+		// The stream keyword is followed by an end of line, then the data up to endstream
+		start := keyword + len("stream")
+		if start < len(data) && data[start] == '\r' {
+			start++
+		}
+		if start < len(data) && data[start] == '\n' {
+			start++
+		}
+		length := bytes.Index(data[start:], []byte("endstream"))
+		if length < 0 {
+			return append(regions, decodePDFNames(data[pos:])), nil
+		}
+
+		text := decodePDFNames(data[pos:keyword])
+		regions = append(regions, text)
+
+		// The dictionary of the stream is what follows the header of its object
+		dictionary := bytes.ToLower(text)
+		if headers := pdfObjectHeader.FindAllIndex(dictionary, -1); len(headers) > 0 {
+			dictionary = dictionary[headers[len(headers)-1][1]:]
+		}
+		if bytes.Contains(dictionary, []byte("/objstm")) {
+			objects, err := decodePDFObjectStream(dictionary, data[start:start+length])
+			if err != nil {
+				return nil, ErrSuspiciousFile
+			}
+			regions = append(regions, decodePDFNames(objects))
+		}
+		pos = start + length + len("endstream")
+	}
+	return append(regions, decodePDFNames(data[pos:])), nil
+}
+
+// pdfObjectHeader matches the "12 0 obj" that starts an indirect object
+var pdfObjectHeader = regexp.MustCompile(`\d+\s+\d+\s+obj\b`)
+
+// pdfUnsupportedFilters are stream filters decodePDFObjectStream can't undo
+var pdfUnsupportedFilters = [][]byte{
+	[]byte("/lzwdecode"),
+	[]byte("/asciihexdecode"),
+	[]byte("/ascii85decode"),
+	[]byte("/runlengthdecode"),
+	[]byte("/crypt"),
+	[]byte("/predictor"),
+}
+
+// decodePDFObjectStream returns the objects of an object stream given its lowercase dictionary.
+// Object streams are compressed with FlateDecode in practice; other filters aren't supported.
+func decodePDFObjectStream(dictionary, stream []byte) ([]byte, error) {
+	for _, filter := range pdfUnsupportedFilters {
+		if bytes.Contains(dictionary, filter) {
+			return nil, fmt.Errorf("unsupported object stream filter %s", filter)
+		}
+	}
+	if !bytes.Contains(dictionary, []byte("/flatedecode")) {
+		return stream, nil
+	}
+	return inflateLimited(stream)
+}
+
+// decodePDFNames replaces the #xx escapes PDF names may use for any character with the
+// character itself
+func decodePDFNames(text []byte) []byte {
+	if bytes.IndexByte(text, '#') < 0 {
+		return text
+	}
+	decoded := make([]byte, 0, len(text))
+	for i := 0; i < len(text); i++ {
+		if text[i] == '#' && i+2 < len(text) {
+			if value, err := hex.DecodeString(string(text[i+1 : i+3])); err == nil {
+				decoded = append(decoded, value[0])
+				i += 2
+				continue
+			}
+		}
+		decoded = append(decoded, text[i])
+	}
+	return decoded
+}
+
+// inflateLimited decompresses zlib data of up to MaxFileSize bytes, so a small upload can't
+// expand without bound
+func inflateLimited(data []byte) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	inflated, err := io.ReadAll(io.LimitReader(reader, MaxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(inflated)) > MaxFileSize {
+		return nil, errors.New("compressed data expands past the upload limit")
+	}
+	return inflated, nil
+}
+
+// containsMarker scans a file for any of the lowercase markers, ignoring ASCII case
+func containsMarker(r io.Reader, markers [][]byte) (bool, error) {
+	longest := 0
+	for _, marker := range markers {
+		longest = max(longest, len(marker))
+	}
+
+	buffer := make([]byte, 64*1024)
+	carry := 0
+	for {
+		n, err := r.Read(buffer[carry:])
+		window := buffer[:carry+n]
+		for i, b := range window[carry:] {
+			if b >= 'A' && b <= 'Z' {
+				window[carry+i] = b + ('a' - 'A')
+			}
+		}
+		for _, marker := range markers {
+			if bytes.Contains(window, marker) {
+				return true, nil
+			}
+		}
+
+		// Keep the tail, so markers split between reads are still found
+		carry = min(len(window), longest-1)
+		copy(buffer, window[len(window)-carry:])
+
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// checkDocxContent accepts ZIP files laid out as Word documents, without macros
+func checkDocxContent(filePath string) error {
+	archive, err := zip.OpenReader(filePath)
+	if err != nil {
+		return ErrFileTypeMismatch
+	}
+	defer archive.Close()
+
+	hasDocument, hasContentTypes := false, false
+	for _, entry := range archive.File {
+		name := strings.ToLower(entry.Name)
+		switch {
+		case name == "word/document.xml":
+			hasDocument = true
+		case name == "[content_types].xml":
+			hasContentTypes = true
+		case strings.HasSuffix(name, "vbaproject.bin"), strings.HasSuffix(name, ".exe"), strings.HasSuffix(name, ".js"),
+			strings.HasSuffix(name, ".html"), strings.HasSuffix(name, ".htm"), strings.HasSuffix(name, ".svg"):
+			return ErrSuspiciousFile
+		}
+	}
+	if !hasDocument || !hasContentTypes {
+		return ErrFileTypeMismatch
+	}
+	return nil
+}
@@ -0,0 +1,330 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// jpegSegment returns a JPEG marker segment with its length
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// pngChunk returns a PNG chunk; the CRC isn't checked by the scanners
+func pngChunk(kind string, payload []byte) []byte {
+	chunk := make([]byte, 8, 12+len(payload))
+	binary.BigEndian.PutUint32(chunk, uint32(len(payload)))
+	copy(chunk[4:], kind)
+	return append(append(chunk, payload...), 0, 0, 0, 0)
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func deflate(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	writer := zlib.NewWriter(&buffer)
+	if _, err := writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func regionStrings(regions [][]byte) []string {
+	strs := make([]string, len(regions))
+	for i, region := range regions {
+		strs[i] = string(region)
+	}
+	return strs
+}
+
+func TestJPEGTextRegions(t *testing.T) {
+	soi := []byte{0xFF, 0xD8}
+	eoi := []byte{0xFF, 0xD9}
+	app0 := jpegSegment(0xE0, []byte("JFIF"))
+	sos := jpegSegment(0xDA, []byte{1, 1, 0})
+	// Compressed pixels with a stuffed 0xFF and a restart marker, which belong to the scan
+	scan := []byte("<script\xFF\x00pixels\xFF\xD3more")
+
+	tests := []struct {
+		name string
+		data []byte
+		want []string
+	}{
+		{
+			"segments and trailer",
+			concat(soi, app0, jpegSegment(0xFE, []byte("comment")), jpegSegment(0xDB, []byte("<html")), sos, scan, eoi, []byte("<html>")),
+			[]string{"JFIF", "comment", "<html>"},
+		},
+		{"nothing after the end", concat(soi, app0, sos, scan, eoi), []string{"JFIF", ""}},
+		{"fill bytes before a marker", concat(soi, []byte{0xFF, 0xFF}, app0, eoi), []string{"JFIF", ""}},
+		{"no end of image", concat(soi, app0, sos, scan), []string{"JFIF", string(scan)}},
+		{
+			"truncated segment",
+			concat(soi, app0, jpegSegment(0xE1, []byte("Exif\x00\x00<svg"))[:8]),
+			[]string{"JFIF", "\xFF\xE1\x00\x0cExif"},
+		},
+		{"segment length too short", concat(soi, []byte{0xFF, 0xE1, 0x00, 0x01}, []byte("<svg")), []string{"\xFF\xE1\x00\x01<svg"}},
+		{"garbage between segments", concat(soi, app0, []byte("<html>"), eoi), []string{"JFIF", "<html>\xFF\xD9"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := regionStrings(jpegTextRegions(tt.data)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("jpegTextRegions() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPNGTextRegions(t *testing.T) {
+	signature := []byte("\x89PNG\r\n\x1a\n")
+	ihdr := pngChunk("IHDR", make([]byte, 13))
+	idat := pngChunk("IDAT", []byte("<script"))
+	iend := pngChunk("IEND", nil)
+	compressed := deflate(t, []byte("<script>alert(1)</script>"))
+
+	tests := []struct {
+		name string
+		data []byte
+		want []string
+	}{
+		{
+			"text chunks and trailer",
+			concat(signature, ihdr, pngChunk("tEXt", []byte("Comment\x00hi")), idat, iend, []byte("<html>")),
+			[]string{"Comment\x00hi", "<html>"},
+		},
+		{
+			"compressed text",
+			concat(signature, ihdr, pngChunk("zTXt", concat([]byte("Comment\x00\x00"), compressed)), iend),
+			[]string{"Comment\x00\x00", "<script>alert(1)</script>", ""},
+		},
+		{
+			"compressed international text",
+			concat(signature, ihdr, pngChunk("iTXt", concat([]byte("Comment\x00\x01\x00pt\x00Comentário\x00"), compressed)), iend),
+			[]string{"Comment\x00\x01\x00pt\x00Comentário\x00", "<script>alert(1)</script>", ""},
+		},
+		{
+			"uncompressed international text",
+			concat(signature, pngChunk("iTXt", []byte("Comment\x00\x00\x00\x00\x00<svg>")), iend),
+			[]string{"Comment\x00\x00\x00\x00\x00<svg>", ""},
+		},
+		{
+			"compressed text that doesn't inflate",
+			concat(signature, pngChunk("zTXt", []byte("Comment\x00\x00<svg>")), iend),
+			[]string{"Comment\x00\x00<svg>", ""},
+		},
+		{"truncated chunk", concat(signature, ihdr, pngChunk("tEXt", []byte("<svg onload>"))[:14]), []string{"\x00\x00\x00\x0ctEXt<svg o"}},
+		{"no end of image", concat(signature, ihdr, idat), []string{""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := regionStrings(pngTextRegions(tt.data)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pngTextRegions() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPDFTextRegions(t *testing.T) {
+	objects := deflate(t, []byte("5 0 << /S /JavaScript /JS (app.alert(1)) >>"))
+
+	tests := []struct {
+		name    string
+		data    string
+		want    []string
+		wantErr bool
+	}{
+		{"no streams", "%PDF-1.4\n1 0 obj\n<< /Type /Catalog >>\nendobj\n", []string{"%PDF-1.4\n1 0 obj\n<< /Type /Catalog >>\nendobj\n"}, false},
+		{
+			"stream contents left out",
+			"1 0 obj\n<< /Length 9 >>\nstream\r\n<script>\nendstream\nendobj\n",
+			[]string{"1 0 obj\n<< /Length 9 >>\n", "\nendobj\n"},
+			false,
+		},
+		{"name escapes", "<< /S /J#61va#53cript /JS #28x#29 /Bad#zz # >>", []string{"<< /S /JavaScript /JS (x) /Bad#zz # >>"}, false},
+		{
+			"object stream inflated",
+			"4 0 obj\n<< /Type /ObjStm /N 1 /First 4 /Filter /FlateDecode >>\nstream\n" + string(objects) + "endstream\nendobj",
+			[]string{
+				"4 0 obj\n<< /Type /ObjStm /N 1 /First 4 /Filter /FlateDecode >>\n",
+				"5 0 << /S /JavaScript /JS (app.alert(1)) >>",
+				"\nendobj",
+			},
+			false,
+		},
+		{
+			"object stream with an escaped name",
+			"4 0 obj\n<< /Type /Obj#53tm >>\nstream\n/Launch\nendstream",
+			[]string{"4 0 obj\n<< /Type /ObjStm >>\n", "/Launch\n", ""},
+			false,
+		},
+		{
+			"object stream named by an earlier object",
+			"3 0 obj\n<< /Type /ObjStm >>\nendobj\n4 0 obj\n<< /Filter /FlateDecode >>\nstream\nnot zlib\nendstream",
+			[]string{"3 0 obj\n<< /Type /ObjStm >>\nendobj\n4 0 obj\n<< /Filter /FlateDecode >>\n", ""},
+			false,
+		},
+		{"object stream that doesn't inflate", "4 0 obj\n<< /Type /ObjStm /Filter /FlateDecode >>\nstream\nnot zlib\nendstream", nil, true},
+		{"object stream with another filter", "4 0 obj\n<< /Type /ObjStm /Filter /LZWDecode >>\nstream\n...\nendstream", nil, true},
+		{"endstream without a stream", "abc endstream /JS", []string{"abc endstream", " /JS"}, false},
+		{"stream without an end", "1 0 obj\n<< >>\nstream\n/J#61vaScript", []string{"1 0 obj\n<< >>\nstream\n/JavaScript"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			regions, err := pdfTextRegions([]byte(tt.data))
+			if tt.wantErr {
+				if !errors.Is(err, ErrSuspiciousFile) {
+					t.Errorf("pdfTextRegions() error = %v, want ErrSuspiciousFile", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("pdfTextRegions() error = %v", err)
+			}
+			if got := regionStrings(regions); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pdfTextRegions() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckDeclaredType(t *testing.T) {
+	tests := []struct {
+		name     string
+		detected string
+		declared string
+		filename string
+		want     error
+	}{
+		{"matching", "image/jpeg", "image/jpeg", "foto.jpg", nil},
+		{"aliases", "image/jpeg", "image/pjpeg", "FOTO.JPEG", nil},
+		{"parameters", "text/plain", "text/plain; charset=utf-8", "notas.txt", nil},
+		{"generic declaration", "application/pdf", "application/octet-stream", "doc.pdf", nil},
+		{"nothing declared", "image/png", "", "", nil},
+		{"other declared type", "image/jpeg", "image/png", "foto.jpg", ErrFileTypeMismatch},
+		{"other extension", "text/plain", "text/plain", "pagina.html", ErrFileTypeMismatch},
+		{"picture named as a PDF", "image/png", "", "foto.pdf", ErrFileTypeMismatch},
+		{"double extension", "image/jpeg", "image/jpeg", "foto.jpg.exe", ErrFileTypeMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkDeclaredType(tt.detected, tt.declared, tt.filename); err != tt.want {
+				t.Errorf("checkDeclaredType() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func testPicture() image.Image {
+	picture := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for x := 0; x < 16; x++ {
+		for y := 0; y < 16; y++ {
+			picture.Set(x, y, color.RGBA{uint8(x * 16), uint8(y * 16), 128, 255})
+		}
+	}
+	return picture
+}
+
+func testDocx(t *testing.T, names ...string) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	for _, name := range names {
+		entry, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Write([]byte("<xml/>"))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestCheckUploadedContent(t *testing.T) {
+	var jpegData, pngData bytes.Buffer
+	if err := jpeg.Encode(&jpegData, testPicture(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(&pngData, testPicture()); err != nil {
+		t.Fatal(err)
+	}
+	jpegFile, pngFile := jpegData.Bytes(), pngData.Bytes()
+	// Splits a file after its signature, to insert chunks or segments
+	withSegment := func(file []byte, at int, segment []byte) []byte {
+		return concat(file[:at], segment, file[at:])
+	}
+	const docx = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+		want        error
+	}{
+		{"JPEG", "image/jpeg", jpegFile, nil},
+		{"JPEG with HTML appended", "image/jpeg", concat(jpegFile, []byte("<HTML><body>")), ErrSuspiciousFile},
+		{"JPEG with a ZIP appended", "image/jpeg", concat(jpegFile, testDocx(t, "a.txt")), ErrSuspiciousFile},
+		{"JPEG with a script comment", "image/jpeg", withSegment(jpegFile, 2, jpegSegment(0xFE, []byte("<script>"))), ErrSuspiciousFile},
+		{"JPEG that doesn't decode", "image/jpeg", concat([]byte{0xFF, 0xD8, 0xFF}, []byte("<p>hi</p>")), ErrFileTypeMismatch},
+		{"PNG", "image/png", pngFile, nil},
+		{"PNG with an SVG chunk", "image/png", withSegment(pngFile, 33, pngChunk("tEXt", []byte("x\x00<svg onload=alert(1)>"))), ErrSuspiciousFile},
+		{
+			"PNG with compressed script",
+			"image/png",
+			withSegment(pngFile, 33, pngChunk("zTXt", concat([]byte("x\x00\x00"), deflate(t, []byte("<script>"))))),
+			ErrSuspiciousFile,
+		},
+		{"PDF", "application/pdf", []byte("%PDF-1.4\n1 0 obj\n<< /Length 9 >>\nstream\n<script>\nendstream\nendobj\n%%EOF"), nil},
+		{"PDF with escaped JavaScript", "application/pdf", []byte("%PDF-1.4\n<< /S /J#61vaScript >>"), ErrSuspiciousFile},
+		{
+			"PDF with JavaScript in an object stream",
+			"application/pdf",
+			[]byte("%PDF-1.5\n4 0 obj\n<< /Type /ObjStm /Filter /FlateDecode >>\nstream\n" + string(deflate(t, []byte("<< /JS (x) /S /JavaScript >>"))) + "endstream"),
+			ErrSuspiciousFile,
+		},
+		{"PDF with a ZIP appended", "application/pdf", concat([]byte("%PDF-1.4\n%%EOF\n"), testDocx(t, "a.txt")), ErrSuspiciousFile},
+		{"text", "text/plain", []byte("Buraco na Rua das Flores, 120"), nil},
+		{"text with markup", "text/plain", []byte("veja <iframe src=x>"), ErrSuspiciousFile},
+		{"Word document", docx, testDocx(t, "[Content_Types].xml", "word/document.xml"), nil},
+		{"Word document with macros", docx, testDocx(t, "[Content_Types].xml", "word/document.xml", "word/vbaProject.bin"), ErrSuspiciousFile},
+		{"ZIP that isn't a Word document", docx, testDocx(t, "a.txt"), ErrFileTypeMismatch},
+		{"video", "video/mp4", []byte("<html>"), nil},
+	}
+
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := filepath.Join(dir, "upload")
+			if err := os.WriteFile(filePath, tt.data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := checkUploadedContent(filePath, tt.contentType); err != tt.want {
+				t.Errorf("checkUploadedContent() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package services
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha256"
 	"fmt"
 	"io"
@@ -9,6 +11,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -22,12 +25,20 @@ const (
 
 // FileUploadResult represents the result of a file upload
 type FileUploadResult struct {
-	OriginalName  string
-	SavedPath     string
-	ThumbnailPath string
-	FileSize      int64
-	ContentType   string
-	Error         error
+	OriginalName    string
+	SavedPath       string
+	OriginalPath    string // Restricted unblurred copy, for photos of categories that blur faces or plates
+	ThumbnailPath   string // Where the thumbnail goes, for file types that have one
+	FileSize        int64
+	ContentType     string
+	SHA256          string
+	Width           int
+	Height          int
+	DurationSeconds float64
+	Capture         *PhotoCapture         // GPS and capture time read before the metadata is stripped; never stored
+	LocationCheck   *DerivedLocationCheck // What a resumable upload kept of its capture
+	AttachmentID    int                   // Attachment already saved by a resumable upload
+	Error           error
 }
 
 // ProcessFileUpload handles file upload with metadata cleaning and thumbnail generation
@@ -35,24 +46,39 @@ func ProcessFileUpload(file multipart.File, header *multipart.FileHeader, catego
 	result := &FileUploadResult{
 		OriginalName: header.Filename,
 		FileSize:     header.Size,
-		ContentType:  header.Header.Get("Content-Type"),
 	}
 
-	// Validate file size
-	maxSize := getMaxFileSize(category, result.ContentType)
-	if header.Size > maxSize {
-		result.Error = fmt.Errorf("arquivo muito grande. Máximo permitido: %dMB", maxSize/(1024*1024))
+	// Detect the type from the first bytes instead of trusting the browser
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		result.Error = fmt.Errorf("erro ao ler arquivo: %v", err)
 		return result, result.Error
 	}
+	head = head[:n]
+	result.ContentType = DetectContentType(head)
 
 	// Validate file type using models package
-	if !models.IsFileTypeAllowed(category, result.ContentType) {
-		result.Error = fmt.Errorf("tipo de arquivo não permitido para esta categoria")
+	if result.ContentType == "" || !models.IsFileTypeAllowed(category, result.ContentType) {
+		result.Error = &UploadRejectedError{Reason: "tipo de arquivo não permitido para esta categoria"}
 		return result, result.Error
 	}
 
-	// Generate unique filename
-	ext := filepath.Ext(header.Filename)
+	// The name and declared type must agree with the content
+	if err := checkDeclaredType(result.ContentType, header.Header.Get("Content-Type"), header.Filename); err != nil {
+		result.Error = err
+		return result, result.Error
+	}
+
+	// Validate file size
+	maxSize := getMaxFileSize(category, result.ContentType)
+	if header.Size > maxSize {
+		result.Error = &UploadRejectedError{Reason: fmt.Sprintf("arquivo muito grande. Máximo permitido: %dMB", maxSize/(1024*1024))}
+		return result, result.Error
+	}
+
+	// Generate unique filename, with the extension of the detected type
+	ext := GetFileExtension(result.ContentType)
 	hash := generateFileHash(header.Filename, time.Now().String())
 	filename := fmt.Sprintf("%s%s", hash, ext)
 
@@ -80,14 +106,26 @@ func ProcessFileUpload(file multipart.File, header *multipart.FileHeader, catego
 	}
 	defer tempFile.Close()
 
-	// Copy file content
-	_, err = io.Copy(tempFile, file)
+	// Copy file content, starting with the bytes already read
+	_, err = io.Copy(tempFile, io.MultiReader(bytes.NewReader(head), file))
 	if err != nil {
 		os.Remove(tempPath)
 		result.Error = fmt.Errorf("erro ao salvar arquivo: %v", err)
 		return result, result.Error
 	}
 
+	// Reject files that are also valid as something a browser or reader would run
+	if err := checkUploadedContent(tempPath, result.ContentType); err != nil {
+		os.Remove(tempPath)
+		result.Error = err
+		return result, result.Error
+	}
+
+	// Read where and when photos were taken before the metadata is cleaned
+	if hasRenditions(result.ContentType) {
+		result.Capture = readFileCapture(tempPath)
+	}
+
 	// Clean metadata
 	err = cleanFileMetadata(tempPath, finalPath, result.ContentType)
 	if err != nil {
@@ -101,34 +139,79 @@ func ProcessFileUpload(file multipart.File, header *multipart.FileHeader, catego
 
 	result.SavedPath = finalPath
 
-	// Generate thumbnail asynchronously (don't block upload)
-	go generateThumbnailAsync(result.SavedPath, result.ContentType, hash)
+	// Record size, hash and dimensions of the cleaned file, which is what gets served
+	if err := inspectUploadedFile(result); err != nil {
+		os.Remove(finalPath)
+		result.Error = err
+		return result, result.Error
+	}
 
-	return result, nil
-}
+	// Videos are transcoded for playback, so their length is capped
+	if isTranscodable(result.ContentType) && result.DurationSeconds > MaxVideoDurationSeconds {
+		os.Remove(finalPath)
+		result.Error = &UploadRejectedError{Reason: fmt.Sprintf("vídeo muito longo. Duração máxima: %d minutos", MaxVideoDurationSeconds/60)}
+		return result, result.Error
+	}
 
-// generateThumbnailAsync generates thumbnail for the uploaded file
-func generateThumbnailAsync(filePath, contentType, hash string) {
-	// Determine if we should generate thumbnail
-	if !shouldGenerateThumbnail(contentType) {
-		return
+	// Photos of categories that blur faces or plates are kept restricted; the anonymize job
+	// writes the public copy, always a JPEG, once the attachment is saved
+	storedPath := finalPath
+	if needsAnonymization(category, result.ContentType) {
+		storedPath = restrictedPathFor(filename)
+		if err := os.MkdirAll(RestrictedDir, 0755); err != nil {
+			os.Remove(finalPath)
+			result.Error = fmt.Errorf("erro ao criar diretório restrito: %v", err)
+			return result, result.Error
+		}
+		if err := os.Rename(finalPath, storedPath); err != nil {
+			os.Remove(finalPath)
+			result.Error = fmt.Errorf("erro ao salvar arquivo: %v", err)
+			return result, result.Error
+		}
+		result.OriginalPath = storedPath
+		finalPath = anonymizedPathFor(filename)
+		result.SavedPath = finalPath
 	}
 
-	thumbnailPath := filepath.Join(ThumbnailDir, hash+"_thumb.jpg")
+	// Keep the file in the storage backend; media workers fetch it from there
+	if err := storeUploadedFile(storedPath, result.ContentType); err != nil {
+		os.Remove(storedPath)
+		result.Error = fmt.Errorf("erro ao armazenar arquivo: %v", err)
+		return result, result.Error
+	}
+	releaseUploadedFile(storedPath)
 
-	var err error
-	if strings.HasPrefix(contentType, "image/") {
-		err = generateImageThumbnail(filePath, thumbnailPath)
-	} else if strings.HasPrefix(contentType, "video/") {
-		err = generateVideoThumbnail(filePath, thumbnailPath)
-	} else if contentType == "application/pdf" {
-		err = generatePDFThumbnail(filePath, thumbnailPath)
+	// The original keeps its own type in the store; the attachment describes the public copy
+	if result.OriginalPath != "" {
+		result.ContentType = "image/jpeg"
 	}
 
-	if err != nil {
-		// Log error but don't fail the upload
-		fmt.Printf("Warning: Failed to generate thumbnail for %s: %v\n", filePath, err)
+	// The thumbnail is generated by a media job once the attachment is saved
+	if shouldGenerateThumbnail(result.ContentType) {
+		result.ThumbnailPath = thumbnailPathFor(finalPath)
+	}
+
+	return result, nil
+}
+
+// thumbnailPathFor returns where the thumbnail of an uploaded file is saved
+func thumbnailPathFor(filePath string) string {
+	filename := filepath.Base(filePath)
+	hash := strings.TrimSuffix(filename, filepath.Ext(filename))
+	return filepath.Join(ThumbnailDir, hash+"_thumb.jpg")
+}
+
+// generateThumbnail generates the thumbnail of an uploaded file, killing the command when ctx expires
+func generateThumbnail(ctx context.Context, filePath, contentType, thumbnailPath string) error {
+	switch {
+	case strings.HasPrefix(contentType, "image/"):
+		return generateImageThumbnail(ctx, filePath, thumbnailPath)
+	case strings.HasPrefix(contentType, "video/"):
+		return generateVideoThumbnail(ctx, filePath, thumbnailPath)
+	case contentType == "application/pdf":
+		return generatePDFThumbnail(ctx, filePath, thumbnailPath)
 	}
+	return fmt.Errorf("no thumbnail for %s files", contentType)
 }
 
 // shouldGenerateThumbnail determines if we should generate a thumbnail for this file type
@@ -138,26 +221,12 @@ func shouldGenerateThumbnail(contentType string) bool {
 		   contentType == "application/pdf"
 }
 
-// generateImageThumbnail generates a thumbnail from an image file
-func generateImageThumbnail(imagePath, thumbnailPath string) error {
-	cmd := exec.Command("convert",
-		"-resize", ThumbnailSize,
-		"-background", "white",
-		"-gravity", "center",
-		"-extent", ThumbnailSize,
-		imagePath,
-		thumbnailPath,
-	)
-
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
-
-// generateVideoThumbnail generates a thumbnail from the first frame of a video
-func generateVideoThumbnail(videoPath, thumbnailPath string) error {
-	cmd := exec.Command("ffmpeg",
+// generateVideoThumbnail generates a thumbnail from the same frame as the poster of a video
+func generateVideoThumbnail(ctx context.Context, videoPath, thumbnailPath string) error {
+	_, _, duration := probeVideo(videoPath)
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-ss", strconv.FormatFloat(videoFrameTime(duration), 'f', 3, 64), // Past black frames, and within short videos
 		"-i", videoPath,
-		"-ss", "00:00:01", // Start at 1 second to avoid black frames
 		"-vframes", "1",
 		"-vf", "scale=150:150:force_original_aspect_ratio=decrease,pad=150:150:(ow-iw)/2:(oh-ih)/2",
 		"-y", // Overwrite output file
@@ -169,8 +238,8 @@ func generateVideoThumbnail(videoPath, thumbnailPath string) error {
 }
 
 // generatePDFThumbnail generates a thumbnail from the first page of a PDF
-func generatePDFThumbnail(pdfPath, thumbnailPath string) error {
-	cmd := exec.Command("convert",
+func generatePDFThumbnail(ctx context.Context, pdfPath, thumbnailPath string) error {
+	cmd := exec.CommandContext(ctx, "convert",
 		"-density", "150", // Higher DPI for better quality
 		"-resize", ThumbnailSize,
 		"-background", "white",
@@ -190,12 +259,7 @@ func GetThumbnailPath(filePath string) string {
 		return ""
 	}
 
-	// Extract hash from filename
-	filename := filepath.Base(filePath)
-	ext := filepath.Ext(filename)
-	hash := strings.TrimSuffix(filename, ext)
-
-	thumbnailPath := filepath.Join(ThumbnailDir, hash+"_thumb.jpg")
+	thumbnailPath := thumbnailPathFor(filePath)
 
 	// Check if thumbnail exists
 	if _, err := os.Stat(thumbnailPath); err == nil {
@@ -271,8 +335,9 @@ func cleanImageMetadata(inputPath, outputPath string) error {
 		return copyFile(inputPath, outputPath)
 	}
 
-	// Use ImageMagick to strip metadata
-	cmd := exec.Command("convert", inputPath, "-strip", outputPath)
+	// Use ImageMagick to strip metadata, turning the pixels upright first since the EXIF
+	// orientation goes with the rest
+	cmd := exec.Command("convert", inputPath, "-auto-orient", "-strip", outputPath)
 	return cmd.Run()
 }
 
@@ -553,36 +618,3 @@ func GetFileExtension(contentType string) string {
 		return ""
 	}
 }
-
-// CleanupThumbnail removes the thumbnail for a given file
-func CleanupThumbnail(filePath string) error {
-	if filePath == "" {
-		return nil
-	}
-
-	thumbnailPath := GetThumbnailPath(filePath)
-	if thumbnailPath != "" {
-		return os.Remove(thumbnailPath)
-	}
-	return nil
-}
-
-// CleanupThumbnailsForReport removes thumbnails for all files in a report
-func CleanupThumbnailsForReport(photoPath string) error {
-	if photoPath == "" {
-		return nil
-	}
-
-	// Split by comma and clean up each thumbnail
-	rawPhotos := strings.Split(photoPath, ",")
-	for _, photo := range rawPhotos {
-		trimmed := strings.TrimSpace(photo)
-		if trimmed != "" {
-			if err := CleanupThumbnail(trimmed); err != nil {
-				// Log error but continue with other files
-				fmt.Printf("Warning: Failed to cleanup thumbnail for %s: %v\n", trimmed, err)
-			}
-		}
-	}
-	return nil
-}
@@ -0,0 +1,366 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"olhourbano2/models"
+	"strings"
+	"time"
+)
+
+const (
+	FollowerDigestInterval    = 24 * time.Hour
+	FollowerEmailChangeExpiry = 48 * time.Hour // How long the link confirming a new follower address works
+)
+
+// followerColumns lists the columns scanned by scanFollower
+const followerColumns = `id, report_id, hashed_cpf, email, preference, locale, manage_token, COALESCE(pending_email, ''), last_digest_at, created_at`
+
+// ValidFollowerPreference reports whether a follower preference is supported
+func ValidFollowerPreference(preference string) bool {
+	switch preference {
+	case models.FrequencyInstant, models.FrequencyDaily, models.FollowerPreferenceOff:
+		return true
+	}
+	return false
+}
+
+// FollowReport adds a follower to a report, or updates the preference and locale of an existing one.
+// A different email for an existing follower isn't applied: it is kept pending and a confirmation
+// link is sent to it, so knowing someone's CPF isn't enough to redirect their notifications.
+func FollowReport(db *sql.DB, reportID int, hashedCPF, email, preference, locale string) (*models.ReportFollower, error) {
+	if !ValidFollowerPreference(preference) {
+		return nil, fmt.Errorf("invalid follower preference %q", preference)
+	}
+
+	token, err := generateEmailToken()
+	if err != nil {
+		return nil, err
+	}
+	changeToken, err := generateEmailToken()
+	if err != nil {
+		return nil, err
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	row := db.QueryRow(`
+		INSERT INTO report_followers (report_id, hashed_cpf, email, preference, locale, manage_token, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		ON CONFLICT (report_id, hashed_cpf) DO UPDATE SET
+			preference = EXCLUDED.preference,
+			locale = EXCLUDED.locale,
+			pending_email = CASE WHEN report_followers.email = EXCLUDED.email THEN report_followers.pending_email ELSE EXCLUDED.email END,
+			email_change_token = CASE WHEN report_followers.email = EXCLUDED.email THEN report_followers.email_change_token ELSE $7 END,
+			email_change_requested_at = CASE WHEN report_followers.email = EXCLUDED.email THEN report_followers.email_change_requested_at ELSE NOW() END
+		RETURNING `+followerColumns,
+		reportID, hashedCPF, email, preference, NormalizeEmailLocale(locale), token, changeToken,
+	)
+	follower, err := scanFollower(row)
+	if err != nil {
+		return nil, err
+	}
+
+	// An existing follower with another address now has this one pending under changeToken
+	if follower.Email != email {
+		confirmURL := fmt.Sprintf("%s/seguindo/email/%s", siteURL, changeToken)
+		template, err := GetFollowerEmailChangeEmailTemplate(follower.Locale, follower.ReportID, confirmURL)
+		if err != nil {
+			return follower, err
+		}
+		if err := EnqueueEmail(db, "follower-email-change:"+changeToken, email, template); err != nil {
+			return follower, err
+		}
+	}
+	return follower, nil
+}
+
+// ConfirmFollowerEmail applies the pending email of the follower owning a change token, returning
+// the new address. Opening the link proves the address, so it is marked verified too.
+func ConfirmFollowerEmail(db *sql.DB, token string) (string, error) {
+	if token == "" {
+		return "", sql.ErrNoRows
+	}
+
+	var email string
+	err := db.QueryRow(`
+		UPDATE report_followers SET
+			email = pending_email,
+			pending_email = NULL,
+			email_change_token = NULL,
+			email_change_requested_at = NULL
+		WHERE email_change_token = $1 AND pending_email IS NOT NULL AND email_change_requested_at > $2
+		RETURNING email
+	`, token, time.Now().Add(-FollowerEmailChangeExpiry)).Scan(&email)
+	if err != nil {
+		return "", err
+	}
+
+	if err := MarkEmailVerified(db, email); err != nil {
+		log.Printf("Error marking follower email as verified: %v", err)
+	}
+	return email, nil
+}
+
+// IsFollowingReport checks whether a hashed CPF follows a report with notifications on
+func IsFollowingReport(db *sql.DB, reportID int, hashedCPF string) (bool, error) {
+	var following bool
+	err := db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM report_followers
+			WHERE report_id = $1 AND hashed_cpf = $2 AND preference != 'off'
+		)
+	`, reportID, hashedCPF).Scan(&following)
+	return following, err
+}
+
+// GetFollowerByToken retrieves a follower from the token in its email links
+func GetFollowerByToken(db *sql.DB, token string) (*models.ReportFollower, error) {
+	if token == "" {
+		return nil, sql.ErrNoRows
+	}
+	row := db.QueryRow(`SELECT `+followerColumns+` FROM report_followers WHERE manage_token = $1`, token)
+	return scanFollower(row)
+}
+
+// SetFollowerPreference changes how a follower is notified, identified by the token in its email links
+func SetFollowerPreference(db *sql.DB, token, preference string) (*models.ReportFollower, error) {
+	if !ValidFollowerPreference(preference) {
+		return nil, fmt.Errorf("invalid follower preference %q", preference)
+	}
+	if token == "" {
+		return nil, sql.ErrNoRows
+	}
+
+	row := db.QueryRow(`
+		UPDATE report_followers SET preference = $2
+		WHERE manage_token = $1
+		RETURNING `+followerColumns,
+		token, preference,
+	)
+	return scanFollower(row)
+}
+
+// NotifyReportFollowers fans an event out to the followers of a report. Instant followers get an
+// email in the outbox right away; daily followers get the event queued for SendFollowerDigests.
+func NotifyReportFollowers(db *sql.DB, event models.FollowerEvent) {
+	rows, err := db.Query(`
+		SELECT `+followerColumns+`
+		FROM report_followers
+		WHERE report_id = $1 AND preference != 'off' AND hashed_cpf != $2 AND hashed_cpf != $3
+	`, event.ReportID, event.ActorCPF, event.SkipCPF)
+	if err != nil {
+		log.Printf("Erro ao buscar seguidores da denúncia %d: %v", event.ReportID, err)
+		return
+	}
+
+	var followers []*models.ReportFollower
+	for rows.Next() {
+		follower, err := scanFollower(rows)
+		if err != nil {
+			rows.Close()
+			log.Printf("Erro ao ler seguidor da denúncia %d: %v", event.ReportID, err)
+			return
+		}
+		followers = append(followers, follower)
+	}
+	rows.Close()
+
+	if len(followers) == 0 {
+		return
+	}
+
+	// The owner keeps the "your report" wording of the original owner-only emails
+	var ownerHashedCPF string
+	if err := db.QueryRow(`SELECT COALESCE(hashed_cpf, '') FROM reports WHERE id = $1`, event.ReportID).Scan(&ownerHashedCPF); err != nil {
+		log.Printf("Erro ao buscar autor da denúncia %d: %v", event.ReportID, err)
+	}
+
+	params, err := json.Marshal(event.Params)
+	if err != nil {
+		log.Printf("Erro ao codificar notificação da denúncia %d: %v", event.ReportID, err)
+		return
+	}
+
+	for _, follower := range followers {
+		// Unverified addresses are sent a verification email; their notifications wait for it
+		verified, err := RequestEmailVerification(db, follower.Email, follower.Locale)
+		if err != nil {
+			log.Printf("Erro ao solicitar verificação do email do seguidor %d: %v", follower.ID, err)
+		}
+
+		if follower.Preference == models.FrequencyDaily {
+			_, err := db.Exec(`
+				INSERT INTO report_notifications (follower_id, event_type, params, created_at)
+				VALUES ($1, $2, $3, NOW())
+			`, follower.ID, event.Type, params)
+			if err != nil {
+				log.Printf("Erro ao registrar notificação para o seguidor %d: %v", follower.ID, err)
+			}
+			continue
+		}
+		if !verified {
+			continue
+		}
+
+		isOwner := ownerHashedCPF != "" && follower.HashedCPF == ownerHashedCPF
+		template, err := GetFollowerNotificationEmailTemplate(follower, event, isOwner)
+		if err == nil {
+			err = EnqueueEmail(db, fmt.Sprintf("follower:%d:%s", follower.ID, event.Key), follower.Email, template)
+		}
+		if err != nil {
+			log.Printf("Erro ao enfileirar notificação da denúncia %d para o seguidor %d: %v", event.ReportID, follower.ID, err)
+		}
+	}
+}
+
+// SendFollowerDigests queues an email to every verified daily follower with its pending notifications, at most once
+// per digest interval. Notifications of unverified followers wait until the address is confirmed.
+func SendFollowerDigests(db *sql.DB) (int, error) {
+	rows, err := db.Query(`
+		SELECT `+followerColumns+`
+		FROM report_followers f
+		WHERE f.preference = $1
+		  AND (f.last_digest_at IS NULL OR f.last_digest_at <= NOW() - make_interval(secs => $2))
+		  AND EXISTS (SELECT 1 FROM report_notifications n WHERE n.follower_id = f.id AND n.sent_at IS NULL)
+		  AND EXISTS (SELECT 1 FROM email_verifications v WHERE v.email = LOWER(f.email) AND v.verified_at IS NOT NULL)
+		  AND NOT EXISTS (SELECT 1 FROM email_suppressions x WHERE x.email = LOWER(f.email))
+	`, models.FrequencyDaily, FollowerDigestInterval.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("error querying digest followers: %w", err)
+	}
+
+	var followers []*models.ReportFollower
+	for rows.Next() {
+		follower, err := scanFollower(rows)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning follower: %w", err)
+		}
+		followers = append(followers, follower)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, follower := range followers {
+		events, err := getPendingFollowerEvents(db, follower)
+		if err != nil {
+			return sent, err
+		}
+
+		template, err := GetFollowerDigestEmailTemplate(follower, events)
+		if err != nil {
+			return sent, err
+		}
+		emailEvent := fmt.Sprintf("follower-digest:%d:%s", follower.ID, time.Now().Format("2006-01-02"))
+		if err := EnqueueEmail(db, emailEvent, follower.Email, template); err != nil {
+			return sent, err
+		}
+		sent++
+
+		_, err = db.Exec(`
+			UPDATE report_notifications SET sent_at = NOW()
+			WHERE follower_id = $1 AND sent_at IS NULL
+		`, follower.ID)
+		if err != nil {
+			return sent, fmt.Errorf("error marking follower notifications as sent: %w", err)
+		}
+		if _, err := db.Exec(`UPDATE report_followers SET last_digest_at = NOW() WHERE id = $1`, follower.ID); err != nil {
+			return sent, fmt.Errorf("error updating digest time: %w", err)
+		}
+	}
+
+	return sent, nil
+}
+
+// StartFollowerDigestScheduler sends follower digests in the background on a fixed interval
+func StartFollowerDigestScheduler(db *sql.DB, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			count, err := SendFollowerDigests(db)
+			if err != nil {
+				log.Printf("Error sending follower digests: %v", err)
+			} else if count > 0 {
+				log.Printf("Sent %d follower digests", count)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// ReportStatusLabel returns the Portuguese label of a report status
+func ReportStatusLabel(status string) string {
+	switch status {
+	case models.StatusApproved:
+		return "Resolvida"
+	case models.StatusRejected:
+		return "Rejeitada"
+	case models.StatusInReview:
+		return "Em análise"
+	default:
+		return "Pendente"
+	}
+}
+
+// getPendingFollowerEvents loads the queued notifications of a follower, oldest first
+func getPendingFollowerEvents(db *sql.DB, follower *models.ReportFollower) ([]models.FollowerEvent, error) {
+	rows, err := db.Query(`
+		SELECT event_type, params, created_at
+		FROM report_notifications
+		WHERE follower_id = $1 AND sent_at IS NULL
+		ORDER BY created_at ASC
+	`, follower.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying follower notifications: %w", err)
+	}
+	defer rows.Close()
+
+	var events []models.FollowerEvent
+	for rows.Next() {
+		event := models.FollowerEvent{ReportID: follower.ReportID}
+		var params []byte
+		if err := rows.Scan(&event.Type, &params, &event.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning follower notification: %w", err)
+		}
+		if err := json.Unmarshal(params, &event.Params); err != nil {
+			return nil, fmt.Errorf("error decoding follower notification: %w", err)
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// scanFollower scans a row selected with followerColumns
+func scanFollower(row rowScanner) (*models.ReportFollower, error) {
+	follower := &models.ReportFollower{}
+	var lastDigestAt sql.NullTime
+
+	err := row.Scan(
+		&follower.ID,
+		&follower.ReportID,
+		&follower.HashedCPF,
+		&follower.Email,
+		&follower.Preference,
+		&follower.Locale,
+		&follower.ManageToken,
+		&follower.PendingEmail,
+		&lastDigestAt,
+		&follower.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if lastDigestAt.Valid {
+		follower.LastDigestAt = &lastDigestAt.Time
+	}
+	return follower, nil
+}
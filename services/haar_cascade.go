@@ -0,0 +1,385 @@
+package services
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	haarScaleFactor   = 1.1 // Growth of the window between pyramid levels, as OpenCV's default
+	haarMinNeighbors  = 3   // Overlapping hits needed to keep a detection
+	haarGroupEpsilon  = 0.2 // How far apart hits of the same object may be, relative to their size
+	haarStageEpsilon  = 1e-5
+	haarMaxDetectSide = 1280 // Longest side images are shrunk to before detection
+)
+
+// haarCascade is a boosted cascade of Haar-like features, read from the XML files OpenCV ships
+// in data/haarcascades. A window is an object when it passes every stage.
+type haarCascade struct {
+	width, height int
+	stages        []haarStage
+	features      []haarFeature
+}
+
+// haarStage passes a window when the leaves its trees reach add up to its threshold
+type haarStage struct {
+	threshold float64
+	trees     []haarTree
+}
+
+// haarTree is a weak classifier; most cascades use stumps, a single node with two leaves
+type haarTree struct {
+	nodes  []haarNode
+	leaves []float64
+}
+
+// haarNode compares a feature with its threshold; left and right are the next node, or minus
+// the index of a leaf when not positive
+type haarNode struct {
+	feature     int
+	threshold   float64
+	left, right int
+}
+
+// haarFeature is a weighted sum of rectangles of the window
+type haarFeature []haarRect
+
+type haarRect struct {
+	x, y, w, h int
+	weight     float64
+}
+
+// haarCascadeXML is the layout of cascades written by opencv_traincascade
+type haarCascadeXML struct {
+	Cascade struct {
+		FeatureType string `xml:"featureType"`
+		Width       int    `xml:"width"`
+		Height      int    `xml:"height"`
+		Stages      []struct {
+			Threshold float64 `xml:"stageThreshold"`
+			Trees     []struct {
+				InternalNodes string `xml:"internalNodes"`
+				LeafValues    string `xml:"leafValues"`
+			} `xml:"weakClassifiers>_"`
+		} `xml:"stages>_"`
+		Features []struct {
+			Rects  []string `xml:"rects>_"`
+			Tilted int      `xml:"tilted"`
+		} `xml:"features>_"`
+	} `xml:"cascade"`
+}
+
+var (
+	haarCascadesMu sync.Mutex
+	haarCascades   = make(map[string]*haarCascade)
+)
+
+// loadHaarCascade reads a cascade file once and keeps it for later detections
+func loadHaarCascade(filePath string) (*haarCascade, error) {
+	haarCascadesMu.Lock()
+	defer haarCascadesMu.Unlock()
+
+	if cascade, ok := haarCascades[filePath]; ok {
+		return cascade, nil
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	cascade, err := parseHaarCascade(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+	haarCascades[filePath] = cascade
+	return cascade, nil
+}
+
+// parseHaarCascade reads a cascade in the opencv_traincascade format. Old-style cascades and
+// tilted features aren't supported.
+func parseHaarCascade(data []byte) (*haarCascade, error) {
+	var doc haarCascadeXML
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Cascade.FeatureType != "" && !strings.EqualFold(doc.Cascade.FeatureType, "HAAR") {
+		return nil, fmt.Errorf("unsupported feature type %s", doc.Cascade.FeatureType)
+	}
+	if doc.Cascade.Width < 3 || doc.Cascade.Height < 3 || len(doc.Cascade.Stages) == 0 {
+		return nil, errors.New("not a Haar cascade in the traincascade format")
+	}
+
+	cascade := &haarCascade{width: doc.Cascade.Width, height: doc.Cascade.Height}
+	for _, f := range doc.Cascade.Features {
+		if f.Tilted != 0 {
+			return nil, errors.New("tilted features are not supported")
+		}
+		var feature haarFeature
+		for _, r := range f.Rects {
+			values, err := parseHaarNumbers(r)
+			if err != nil || len(values) != 5 {
+				return nil, fmt.Errorf("invalid rectangle %q", strings.TrimSpace(r))
+			}
+			rect := haarRect{int(values[0]), int(values[1]), int(values[2]), int(values[3]), values[4]}
+			if rect.x < 0 || rect.y < 0 || rect.x+rect.w > cascade.width || rect.y+rect.h > cascade.height {
+				return nil, fmt.Errorf("rectangle %q outside the window", strings.TrimSpace(r))
+			}
+			feature = append(feature, rect)
+		}
+		cascade.features = append(cascade.features, feature)
+	}
+
+	for _, s := range doc.Cascade.Stages {
+		stage := haarStage{threshold: s.Threshold}
+		for _, t := range s.Trees {
+			nodes, err := parseHaarNumbers(t.InternalNodes)
+			if err != nil || len(nodes) == 0 || len(nodes)%4 != 0 {
+				return nil, fmt.Errorf("invalid tree nodes %q", strings.TrimSpace(t.InternalNodes))
+			}
+			leaves, err := parseHaarNumbers(t.LeafValues)
+			if err != nil || len(leaves) != len(nodes)/4+1 {
+				return nil, fmt.Errorf("invalid tree leaves %q", strings.TrimSpace(t.LeafValues))
+			}
+
+			tree := haarTree{leaves: leaves}
+			for i := 0; i < len(nodes); i += 4 {
+				node := haarNode{left: int(nodes[i]), right: int(nodes[i+1]), feature: int(nodes[i+2]), threshold: nodes[i+3]}
+				if node.feature < 0 || node.feature >= len(cascade.features) ||
+					node.left >= len(nodes)/4 || -node.left >= len(leaves) ||
+					node.right >= len(nodes)/4 || -node.right >= len(leaves) {
+					return nil, fmt.Errorf("invalid tree nodes %q", strings.TrimSpace(t.InternalNodes))
+				}
+				tree.nodes = append(tree.nodes, node)
+			}
+			stage.trees = append(stage.trees, tree)
+		}
+		cascade.stages = append(cascade.stages, stage)
+	}
+	return cascade, nil
+}
+
+// parseHaarNumbers reads whitespace-separated numbers
+func parseHaarNumbers(s string) ([]float64, error) {
+	var values []float64
+	for _, field := range strings.Fields(s) {
+		value, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// grayImage is an 8-bit luminance image
+type grayImage struct {
+	width, height int
+	pix           []uint8
+}
+
+// toGray converts an image to luminance with the BT.601 weights OpenCV uses
+func toGray(img *image.RGBA) *grayImage {
+	b := img.Bounds()
+	gray := &grayImage{width: b.Dx(), height: b.Dy(), pix: make([]uint8, b.Dx()*b.Dy())}
+	for y := 0; y < gray.height; y++ {
+		row := img.Pix[y*img.Stride:]
+		for x := 0; x < gray.width; x++ {
+			r, g, bl := int(row[x*4]), int(row[x*4+1]), int(row[x*4+2])
+			gray.pix[y*gray.width+x] = uint8((299*r + 587*g + 114*bl + 500) / 1000)
+		}
+	}
+	return gray
+}
+
+// resize scales a gray image with bilinear interpolation
+func (g *grayImage) resize(width, height int) *grayImage {
+	out := &grayImage{width: width, height: height, pix: make([]uint8, width*height)}
+	sx, sy := float64(g.width)/float64(width), float64(g.height)/float64(height)
+	for y := 0; y < height; y++ {
+		fy := math.Max((float64(y)+0.5)*sy-0.5, 0)
+		y0 := min(int(fy), g.height-1)
+		y1 := min(y0+1, g.height-1)
+		wy := fy - float64(y0)
+		for x := 0; x < width; x++ {
+			fx := math.Max((float64(x)+0.5)*sx-0.5, 0)
+			x0 := min(int(fx), g.width-1)
+			x1 := min(x0+1, g.width-1)
+			wx := fx - float64(x0)
+			top := float64(g.pix[y0*g.width+x0])*(1-wx) + float64(g.pix[y0*g.width+x1])*wx
+			bottom := float64(g.pix[y1*g.width+x0])*(1-wx) + float64(g.pix[y1*g.width+x1])*wx
+			out.pix[y*width+x] = uint8(top*(1-wy) + bottom*wy + 0.5)
+		}
+	}
+	return out
+}
+
+// integralImage holds the sums and squared sums of the pixels above and left of each point, so
+// the sum of any rectangle takes four lookups
+type integralImage struct {
+	stride int
+	sum    []int64
+	sqsum  []int64
+}
+
+func newIntegralImage(g *grayImage) *integralImage {
+	ii := &integralImage{stride: g.width + 1}
+	ii.sum = make([]int64, (g.width+1)*(g.height+1))
+	ii.sqsum = make([]int64, len(ii.sum))
+	for y := 1; y <= g.height; y++ {
+		var rowSum, rowSqsum int64
+		for x := 1; x <= g.width; x++ {
+			p := int64(g.pix[(y-1)*g.width+x-1])
+			rowSum += p
+			rowSqsum += p * p
+			ii.sum[y*ii.stride+x] = ii.sum[(y-1)*ii.stride+x] + rowSum
+			ii.sqsum[y*ii.stride+x] = ii.sqsum[(y-1)*ii.stride+x] + rowSqsum
+		}
+	}
+	return ii
+}
+
+// rectSum returns the sum of a rectangle of a table of the integral image
+func (ii *integralImage) rectSum(table []int64, x, y, w, h int) int64 {
+	return table[(y+h)*ii.stride+x+w] - table[y*ii.stride+x+w] - table[(y+h)*ii.stride+x] + table[y*ii.stride+x]
+}
+
+// detect finds objects in a gray image, returning their boxes in its coordinates. The image is
+// searched at every scale from the window size up, and overlapping hits are merged.
+func (c *haarCascade) detect(gray *grayImage) []image.Rectangle {
+	var hits []image.Rectangle
+	for scale := 1.0; ; scale *= haarScaleFactor {
+		width, height := int(float64(gray.width)/scale), int(float64(gray.height)/scale)
+		if width < c.width || height < c.height {
+			break
+		}
+		level := gray
+		if width != gray.width || height != gray.height {
+			level = gray.resize(width, height)
+		}
+		ii := newIntegralImage(level)
+
+		// Small windows are searched every other pixel, large ones every pixel, as OpenCV does
+		step := 2
+		if scale > 2 {
+			step = 1
+		}
+		for y := 0; y+c.height <= height; y += step {
+			for x := 0; x+c.width <= width; x += step {
+				if c.passes(ii, x, y) {
+					hits = append(hits, image.Rect(
+						int(float64(x)*scale+0.5), int(float64(y)*scale+0.5),
+						int(float64(x+c.width)*scale+0.5), int(float64(y+c.height)*scale+0.5)))
+				}
+			}
+		}
+	}
+	return groupRectangles(hits, haarMinNeighbors, haarGroupEpsilon)
+}
+
+// passes runs the window at x, y through the stages. Features are compared with thresholds
+// scaled by the contrast of the window, so lighting doesn't matter.
+func (c *haarCascade) passes(ii *integralImage, x, y int) bool {
+	// The contrast leaves out the border of the window, as OpenCV's normalization rectangle
+	area := float64((c.width - 2) * (c.height - 2))
+	sum := float64(ii.rectSum(ii.sum, x+1, y+1, c.width-2, c.height-2))
+	sqsum := float64(ii.rectSum(ii.sqsum, x+1, y+1, c.width-2, c.height-2))
+	norm := area*sqsum - sum*sum
+	if norm > 0 {
+		norm = math.Sqrt(norm)
+	} else {
+		norm = 1
+	}
+
+	for _, stage := range c.stages {
+		total := 0.0
+		for _, tree := range stage.trees {
+			index := 0
+			for {
+				node := tree.nodes[index]
+				value := 0.0
+				for _, r := range c.features[node.feature] {
+					value += float64(ii.rectSum(ii.sum, x+r.x, y+r.y, r.w, r.h)) * r.weight
+				}
+				next := node.right
+				if value < node.threshold*norm {
+					next = node.left
+				}
+				if next <= 0 {
+					total += tree.leaves[-next]
+					break
+				}
+				index = next
+			}
+		}
+		if total < stage.threshold-haarStageEpsilon {
+			return false
+		}
+	}
+	return true
+}
+
+// groupRectangles merges hits of the same object into their average box, dropping objects hit
+// no more than minNeighbors times, which are mostly false positives
+func groupRectangles(rects []image.Rectangle, minNeighbors int, eps float64) []image.Rectangle {
+	parent := make([]int, len(rects))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	similar := func(a, b image.Rectangle) bool {
+		delta := eps * float64(min(a.Dx(), b.Dx())+min(a.Dy(), b.Dy())) * 0.5
+		return math.Abs(float64(a.Min.X-b.Min.X)) <= delta && math.Abs(float64(a.Min.Y-b.Min.Y)) <= delta &&
+			math.Abs(float64(a.Max.X-b.Max.X)) <= delta && math.Abs(float64(a.Max.Y-b.Max.Y)) <= delta
+	}
+	for i := range rects {
+		for j := i + 1; j < len(rects); j++ {
+			if similar(rects[i], rects[j]) {
+				parent[find(i)] = find(j)
+			}
+		}
+	}
+
+	type cluster struct {
+		count                  int
+		minX, minY, maxX, maxY int
+	}
+	clusters := make(map[int]*cluster)
+	var order []int
+	for i, r := range rects {
+		root := find(i)
+		c, ok := clusters[root]
+		if !ok {
+			c = &cluster{}
+			clusters[root] = c
+			order = append(order, root)
+		}
+		c.count++
+		c.minX += r.Min.X
+		c.minY += r.Min.Y
+		c.maxX += r.Max.X
+		c.maxY += r.Max.Y
+	}
+
+	var grouped []image.Rectangle
+	for _, root := range order {
+		c := clusters[root]
+		if c.count <= minNeighbors {
+			continue
+		}
+		grouped = append(grouped, image.Rect(c.minX/c.count, c.minY/c.count, c.maxX/c.count, c.maxY/c.count))
+	}
+	return grouped
+}
@@ -0,0 +1,283 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"olhourbano2/models"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	HotspotEpsilonMeters = 250.0 // Maximum distance between neighboring reports in a cluster
+	HotspotMinReports    = 3     // Minimum number of reports to form a hotspot
+	earthRadiusMeters    = 6371000.0
+)
+
+// HotspotWindows defines the time windows (in days) hotspots are computed for
+var HotspotWindows = []int{7, 30, 90}
+
+// hotspotPoint represents a report considered for clustering
+type hotspotPoint struct {
+	ReportID  int
+	Category  string
+	Latitude  float64
+	Longitude float64
+	VoteCount int
+}
+
+// ComputeHotspots clusters recent reports with DBSCAN for every category and time window
+// and replaces the stored hotspots. Returns the number of hotspots stored.
+func ComputeHotspots(db *sql.DB) (int, error) {
+	total := 0
+
+	for _, windowDays := range HotspotWindows {
+		points, err := getHotspotPoints(db, windowDays)
+		if err != nil {
+			return total, fmt.Errorf("error loading reports for %d day window: %w", windowDays, err)
+		}
+
+		// Group points per category, plus an "all categories" group under the empty key
+		groups := map[string][]hotspotPoint{"": points}
+		for _, point := range points {
+			groups[point.Category] = append(groups[point.Category], point)
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return total, fmt.Errorf("error starting transaction: %w", err)
+		}
+
+		if _, err := tx.Exec(`DELETE FROM hotspots WHERE window_days = $1`, windowDays); err != nil {
+			tx.Rollback()
+			return total, fmt.Errorf("error clearing hotspots: %w", err)
+		}
+
+		for category, groupPoints := range groups {
+			for _, cluster := range dbscan(groupPoints, HotspotEpsilonMeters, HotspotMinReports) {
+				hotspot := summarizeCluster(groupPoints, cluster)
+				hotspot.Category = category
+				hotspot.WindowDays = windowDays
+
+				_, err := tx.Exec(`
+					INSERT INTO hotspots (category, window_days, latitude, longitude, radius_meters, report_count, vote_count, score, report_ids, computed_at)
+					VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())
+				`, hotspot.Category, hotspot.WindowDays, hotspot.Latitude, hotspot.Longitude, hotspot.RadiusMeters,
+					hotspot.ReportCount, hotspot.VoteCount, hotspot.Score, pq.Array(hotspot.ReportIDs))
+				if err != nil {
+					tx.Rollback()
+					return total, fmt.Errorf("error saving hotspot: %w", err)
+				}
+				total++
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			return total, fmt.Errorf("error committing hotspots: %w", err)
+		}
+	}
+
+	return total, nil
+}
+
+// GetHotspots returns stored hotspots for a category (empty for all) and time window, most relevant first
+func GetHotspots(db *sql.DB, category string, windowDays int) ([]*models.Hotspot, error) {
+	rows, err := db.Query(`
+		SELECT id, category, window_days, latitude, longitude, radius_meters, report_count, vote_count, score, report_ids, computed_at
+		FROM hotspots
+		WHERE category = $1 AND window_days = $2
+		ORDER BY score DESC
+	`, category, windowDays)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hotspots []*models.Hotspot
+	for rows.Next() {
+		hotspot := &models.Hotspot{}
+		err := rows.Scan(
+			&hotspot.ID,
+			&hotspot.Category,
+			&hotspot.WindowDays,
+			&hotspot.Latitude,
+			&hotspot.Longitude,
+			&hotspot.RadiusMeters,
+			&hotspot.ReportCount,
+			&hotspot.VoteCount,
+			&hotspot.Score,
+			pq.Array(&hotspot.ReportIDs),
+			&hotspot.ComputedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		hotspots = append(hotspots, hotspot)
+	}
+
+	return hotspots, nil
+}
+
+// StartHotspotScheduler recomputes hotspots in the background on a fixed interval
+func StartHotspotScheduler(db *sql.DB, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			count, err := ComputeHotspots(db)
+			if err != nil {
+				log.Printf("Error computing hotspots: %v", err)
+			} else {
+				log.Printf("Computed %d hotspots", count)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// getHotspotPoints loads located reports created within the time window
+func getHotspotPoints(db *sql.DB, windowDays int) ([]hotspotPoint, error) {
+	rows, err := db.Query(`
+		SELECT id, problem_type, latitude, longitude, vote_count
+		FROM reports
+		WHERE latitude IS NOT NULL AND longitude IS NOT NULL AND latitude != 0 AND longitude != 0
+		  AND merged_into_id IS NULL
+		  AND created_at >= NOW() - make_interval(days => $1)
+	`, windowDays)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []hotspotPoint
+	for rows.Next() {
+		var point hotspotPoint
+		if err := rows.Scan(&point.ReportID, &point.Category, &point.Latitude, &point.Longitude, &point.VoteCount); err != nil {
+			return nil, err
+		}
+		points = append(points, point)
+	}
+
+	return points, nil
+}
+
+// dbscan clusters points using DBSCAN with a haversine distance and returns the member indices of each cluster.
+// Noise points are dropped.
+func dbscan(points []hotspotPoint, epsilonMeters float64, minPoints int) [][]int {
+	const (
+		unvisited = 0
+		noise     = -1
+	)
+
+	// Grid index with cells roughly epsilon wide so neighbor lookups only scan adjacent cells
+	cellSize := epsilonMeters / 111320.0
+	type cell struct{ x, y int }
+	cellOf := func(p hotspotPoint) cell {
+		return cell{int(math.Floor(p.Longitude / cellSize)), int(math.Floor(p.Latitude / cellSize))}
+	}
+	grid := make(map[cell][]int)
+	for i, point := range points {
+		c := cellOf(point)
+		grid[c] = append(grid[c], i)
+	}
+
+	neighbors := func(i int) []int {
+		var result []int
+		c := cellOf(points[i])
+		// Longitude degrees shrink with latitude, so widen the horizontal search accordingly
+		span := int(math.Ceil(1 / math.Max(math.Cos(points[i].Latitude*math.Pi/180), 0.1)))
+		for dx := -span; dx <= span; dx++ {
+			for dy := -1; dy <= 1; dy++ {
+				for _, j := range grid[cell{c.x + dx, c.y + dy}] {
+					if haversineMeters(points[i].Latitude, points[i].Longitude, points[j].Latitude, points[j].Longitude) <= epsilonMeters {
+						result = append(result, j)
+					}
+				}
+			}
+		}
+		return result
+	}
+
+	labels := make([]int, len(points))
+	var clusters [][]int
+
+	for i := range points {
+		if labels[i] != unvisited {
+			continue
+		}
+
+		seeds := neighbors(i)
+		if len(seeds) < minPoints {
+			labels[i] = noise
+			continue
+		}
+
+		clusterID := len(clusters) + 1
+		labels[i] = clusterID
+		members := []int{i}
+
+		for k := 0; k < len(seeds); k++ {
+			j := seeds[k]
+			if labels[j] == noise {
+				labels[j] = clusterID
+				members = append(members, j)
+			}
+			if labels[j] != unvisited {
+				continue
+			}
+
+			labels[j] = clusterID
+			members = append(members, j)
+
+			if expansion := neighbors(j); len(expansion) >= minPoints {
+				seeds = append(seeds, expansion...)
+			}
+		}
+
+		clusters = append(clusters, members)
+	}
+
+	return clusters
+}
+
+// summarizeCluster computes the centroid, radius, totals and score for a cluster
+func summarizeCluster(points []hotspotPoint, members []int) *models.Hotspot {
+	hotspot := &models.Hotspot{}
+
+	for _, i := range members {
+		hotspot.Latitude += points[i].Latitude
+		hotspot.Longitude += points[i].Longitude
+		hotspot.VoteCount += points[i].VoteCount
+		hotspot.ReportIDs = append(hotspot.ReportIDs, int64(points[i].ReportID))
+	}
+	hotspot.ReportCount = len(members)
+	hotspot.Latitude /= float64(len(members))
+	hotspot.Longitude /= float64(len(members))
+
+	for _, i := range members {
+		distance := haversineMeters(hotspot.Latitude, hotspot.Longitude, points[i].Latitude, points[i].Longitude)
+		hotspot.RadiusMeters = math.Max(hotspot.RadiusMeters, distance)
+	}
+	// Keep a minimum visible radius for very tight clusters
+	hotspot.RadiusMeters = math.Max(hotspot.RadiusMeters, HotspotEpsilonMeters/2)
+
+	// Reports weigh more than votes; votes signal how many citizens are affected
+	hotspot.Score = float64(hotspot.ReportCount) + float64(hotspot.VoteCount)*0.25
+
+	return hotspot
+}
+
+// haversineMeters returns the great-circle distance between two coordinates in meters
+func haversineMeters(lat1, lng1, lat2, lng2 float64) float64 {
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLng := (lng2 - lng1) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*math.Pi/180)*math.Cos(lat2*math.Pi/180)*math.Sin(dLng/2)*math.Sin(dLng/2)
+
+	return earthRadiusMeters * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
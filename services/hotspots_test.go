@@ -0,0 +1,144 @@
+package services
+
+import (
+	"math"
+	"sort"
+	"testing"
+)
+
+// offsetPoint returns a point dx meters east and dy meters north of a coordinate
+func offsetPoint(id int, latitude, longitude, dx, dy float64) hotspotPoint {
+	return hotspotPoint{
+		ReportID:  id,
+		Latitude:  latitude + dy/111320.0,
+		Longitude: longitude + dx/(111320.0*math.Cos(latitude*math.Pi/180)),
+	}
+}
+
+func TestDBSCAN(t *testing.T) {
+	const lat, lng = -23.55, -46.63
+
+	tests := []struct {
+		name   string
+		points []hotspotPoint
+		want   [][]int // Report IDs of each cluster
+	}{
+		{
+			name: "two clusters and noise",
+			points: []hotspotPoint{
+				offsetPoint(1, lat, lng, 0, 0), offsetPoint(2, lat, lng, 50, 0), offsetPoint(3, lat, lng, 0, 50),
+				offsetPoint(4, lat, lng, 5000, 0), offsetPoint(5, lat, lng, 5050, 0), offsetPoint(6, lat, lng, 5000, 50),
+				offsetPoint(7, lat, lng, 2500, 2500),
+			},
+			want: [][]int{{1, 2, 3}, {4, 5, 6}},
+		},
+		{
+			name: "too few points",
+			points: []hotspotPoint{
+				offsetPoint(1, lat, lng, 0, 0), offsetPoint(2, lat, lng, 100, 0),
+			},
+			want: nil,
+		},
+		{
+			name: "chain joined through core points",
+			points: []hotspotPoint{
+				offsetPoint(1, lat, lng, 0, 0), offsetPoint(2, lat, lng, 200, 0), offsetPoint(3, lat, lng, 400, 0),
+				offsetPoint(4, lat, lng, 600, 0), offsetPoint(5, lat, lng, 800, 0),
+			},
+			want: [][]int{{1, 2, 3, 4, 5}},
+		},
+		{
+			name: "border point visited as noise first",
+			points: []hotspotPoint{
+				offsetPoint(1, lat, lng, -240, 0),
+				offsetPoint(2, lat, lng, 0, 0), offsetPoint(3, lat, lng, 30, 0), offsetPoint(4, lat, lng, 0, 100),
+			},
+			want: [][]int{{1, 2, 3, 4}},
+		},
+		{
+			name: "far apart",
+			points: []hotspotPoint{
+				offsetPoint(1, lat, lng, 0, 0), offsetPoint(2, lat, lng, 300, 0), offsetPoint(3, lat, lng, 600, 0),
+			},
+			want: nil,
+		},
+		{
+			name: "high latitude neighbors across longitude cells",
+			points: []hotspotPoint{
+				offsetPoint(1, 64.1, -21.9, 0, 0), offsetPoint(2, 64.1, -21.9, 200, 0), offsetPoint(3, 64.1, -21.9, 400, 0),
+			},
+			want: [][]int{{1, 2, 3}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clusters := dbscan(tt.points, HotspotEpsilonMeters, HotspotMinReports)
+
+			var got [][]int
+			for _, members := range clusters {
+				var ids []int
+				for _, i := range members {
+					ids = append(ids, tt.points[i].ReportID)
+				}
+				sort.Ints(ids)
+				got = append(got, ids)
+			}
+			sort.Slice(got, func(i, j int) bool { return got[i][0] < got[j][0] })
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("dbscan() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if len(got[i]) != len(tt.want[i]) {
+					t.Fatalf("dbscan() = %v, want %v", got, tt.want)
+				}
+				for j := range got[i] {
+					if got[i][j] != tt.want[i][j] {
+						t.Fatalf("dbscan() = %v, want %v", got, tt.want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestHaversineMeters(t *testing.T) {
+	tests := []struct {
+		name                   string
+		lat1, lng1, lat2, lng2 float64
+		want                   float64
+	}{
+		{"same point", -23.55, -46.63, -23.55, -46.63, 0},
+		{"one degree of latitude", 0, 0, 1, 0, 111195},
+		{"one degree of longitude at the equator", 0, 0, 0, 1, 111195},
+		{"São Paulo to Rio de Janeiro", -23.5505, -46.6333, -22.9068, -43.1729, 360750},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := haversineMeters(tt.lat1, tt.lng1, tt.lat2, tt.lng2)
+			if math.Abs(got-tt.want) > tt.want*0.005+1 {
+				t.Errorf("haversineMeters() = %.0f, want about %.0f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarizeCluster(t *testing.T) {
+	points := []hotspotPoint{
+		{ReportID: 1, Latitude: -23.55, Longitude: -46.63, VoteCount: 4},
+		{ReportID: 2, Latitude: -23.55, Longitude: -46.63, VoteCount: 0},
+		{ReportID: 3, Latitude: -23.55, Longitude: -46.63, VoteCount: 0},
+	}
+	hotspot := summarizeCluster(points, []int{0, 1, 2})
+
+	if hotspot.ReportCount != 3 || hotspot.VoteCount != 4 {
+		t.Errorf("summarizeCluster() counted %d reports and %d votes", hotspot.ReportCount, hotspot.VoteCount)
+	}
+	if hotspot.RadiusMeters != HotspotEpsilonMeters/2 {
+		t.Errorf("summarizeCluster() radius = %v, want the minimum %v", hotspot.RadiusMeters, HotspotEpsilonMeters/2)
+	}
+	if hotspot.Score != 4 {
+		t.Errorf("summarizeCluster() score = %v, want 4", hotspot.Score)
+	}
+}
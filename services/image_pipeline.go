@@ -0,0 +1,367 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"olhourbano2/models"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	_ "golang.org/x/image/webp" // Registers the WebP decoder with image.Decode
+)
+
+const (
+	RenditionDir     = "./uploads/renditions"
+	renditionQuality = 82
+	thumbnailSide    = 150 // Same box as ThumbnailSize
+	maxDecodePixels  = 50_000_000
+)
+
+// RenditionWidths are the widths image attachments are resized to for srcset. Widths above the
+// original are skipped, since they would only be upscaled.
+var RenditionWidths = []int{320, 640, 1280}
+
+// errNoEncoder is returned when no installed tool writes a rendition format
+var errNoEncoder = errors.New("no encoder installed")
+
+// renditionEncoders are the commands tried, in order, to write WebP and AVIF renditions from a
+// PNG; Go's standard library only encodes JPEG and PNG
+var renditionEncoders = map[string][][]string{
+	models.RenditionFormatWebP: {
+		{"cwebp", "-quiet", "-q", "75", "{in}", "-o", "{out}"},
+		{"convert", "{in}", "-quality", "75", "{out}"},
+	},
+	models.RenditionFormatAVIF: {
+		{"avifenc", "--speed", "6", "-q", "55", "{in}", "{out}"},
+		{"convert", "{in}", "-quality", "55", "{out}"},
+	},
+}
+
+// renditionExtensions are the file extensions of each rendition format
+var renditionExtensions = map[string]string{
+	models.RenditionFormatJPEG: ".jpg",
+	models.RenditionFormatWebP: ".webp",
+	models.RenditionFormatAVIF: ".avif",
+}
+
+// hasRenditions reports whether the pipeline resizes files of a content type
+func hasRenditions(contentType string) bool {
+	return contentType == "image/jpeg" || contentType == "image/png" || contentType == "image/webp"
+}
+
+// decodeImage decodes a JPEG, PNG or WebP file upright, applying its EXIF orientation
+func decodeImage(filePath string) (*image.RGBA, error) {
+	tags, err := readFileEXIF(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Refuse decompression bombs before allocating their pixels
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding image: %w", err)
+	}
+	if config.Width*config.Height > maxDecodePixels {
+		return nil, fmt.Errorf("image too large to resize: %dx%d", config.Width, config.Height)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	decoded, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding image: %w", err)
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, decoded.Bounds().Dx(), decoded.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Bounds(), decoded, decoded.Bounds().Min, draw.Src)
+	return orientImage(rgba, tags.Orientation), nil
+}
+
+// orientImage turns an image stored with an EXIF orientation upright
+func orientImage(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // Mirrored
+				sx, sy = w-1-x, y
+			case 3: // Rotated 180°
+				sx, sy = w-1-x, h-1-y
+			case 4: // Upside down mirrored
+				sx, sy = x, h-1-y
+			case 5: // Transposed
+				sx, sy = y, x
+			case 6: // Rotated 90° clockwise to display
+				sx, sy = y, h-1-x
+			case 7: // Transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // Rotated 90° counterclockwise to display
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
+
+// resizeImage scales an image to width x height, averaging the source pixels each destination
+// pixel covers, which keeps downscaled photos smooth
+func resizeImage(src *image.RGBA, width, height int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		sy0 := y * sh / height
+		sy1 := max((y+1)*sh/height, sy0+1)
+		for x := 0; x < width; x++ {
+			sx0 := x * sw / width
+			sx1 := max((x+1)*sw/width, sx0+1)
+
+			var r, g, b, a, count uint64
+			for sy := sy0; sy < sy1; sy++ {
+				offset := src.PixOffset(sx0, sy)
+				for sx := sx0; sx < sx1; sx++ {
+					r += uint64(src.Pix[offset])
+					g += uint64(src.Pix[offset+1])
+					b += uint64(src.Pix[offset+2])
+					a += uint64(src.Pix[offset+3])
+					offset += 4
+				}
+				count += uint64(sx1 - sx0)
+			}
+
+			offset := dst.PixOffset(x, y)
+			dst.Pix[offset] = uint8(r / count)
+			dst.Pix[offset+1] = uint8(g / count)
+			dst.Pix[offset+2] = uint8(b / count)
+			dst.Pix[offset+3] = uint8(a / count)
+		}
+	}
+	return dst
+}
+
+// flattenImage draws an image over a white background, as JPEG has no transparency
+func flattenImage(src *image.RGBA) *image.RGBA {
+	dst := image.NewRGBA(src.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), src, src.Bounds().Min, draw.Over)
+	return dst
+}
+
+// writeJPEG encodes an image as a JPEG file
+func writeJPEG(img image.Image, filePath string, quality int) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(file, img, &jpeg.Options{Quality: quality}); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// generateImageThumbnail fits an image in a white square of thumbnailSide pixels
+func generateImageThumbnail(ctx context.Context, imagePath, thumbnailPath string) error {
+	img, err := decodeImage(imagePath)
+	if err != nil {
+		return err
+	}
+
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	tw, th := thumbnailSide, thumbnailSide
+	if w > h {
+		th = max(1, h*thumbnailSide/w)
+	} else {
+		tw = max(1, w*thumbnailSide/h)
+	}
+	resized := resizeImage(img, tw, th)
+
+	thumbnail := image.NewRGBA(image.Rect(0, 0, thumbnailSide, thumbnailSide))
+	draw.Draw(thumbnail, thumbnail.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	offset := image.Pt((thumbnailSide-tw)/2, (thumbnailSide-th)/2)
+	draw.Draw(thumbnail, resized.Bounds().Add(offset), resized, image.Point{}, draw.Over)
+
+	return writeJPEG(thumbnail, thumbnailPath, renditionQuality)
+}
+
+// generateAttachmentRenditions resizes an image attachment to each of RenditionWidths, as JPEG
+// plus WebP and AVIF where an encoder is installed, stores the files and records them
+func generateAttachmentRenditions(ctx context.Context, db *sql.DB, attachment *models.Attachment) error {
+	if err := os.MkdirAll(RenditionDir, 0755); err != nil {
+		return err
+	}
+
+	var renditions []*models.AttachmentRendition
+	err := withUploadedFile(attachment.Path, func(localPath string) error {
+		img, err := decodeImage(localPath)
+		if err != nil {
+			return err
+		}
+		img = flattenImage(img)
+
+		w, h := img.Bounds().Dx(), img.Bounds().Dy()
+		base := strings.TrimSuffix(filepath.Base(attachment.Path), filepath.Ext(attachment.Path))
+		for i, width := range RenditionWidths {
+			// Originals narrower than a width get one rendition at their own size
+			if width >= w {
+				if i > 0 {
+					break
+				}
+				width = w
+			}
+			height := max(1, h*width/w)
+			resized := resizeImage(img, width, height)
+
+			made, err := writeRenditions(ctx, resized, fmt.Sprintf("%s_%d", base, width))
+			if err != nil {
+				return err
+			}
+			for _, rendition := range made {
+				rendition.AttachmentID = attachment.ID
+				rendition.Width, rendition.Height = width, height
+			}
+			renditions = append(renditions, made...)
+
+			if width == w {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return saveRenditions(db, attachment.ID, renditions)
+}
+
+// writeRenditions writes one resized image in every format that can be encoded, stores the
+// files and returns them
+func writeRenditions(ctx context.Context, img *image.RGBA, name string) ([]*models.AttachmentRendition, error) {
+	jpegPath := filepath.Join(RenditionDir, name+renditionExtensions[models.RenditionFormatJPEG])
+	if err := writeJPEG(img, jpegPath, renditionQuality); err != nil {
+		return nil, err
+	}
+	files := map[string]string{models.RenditionFormatJPEG: jpegPath}
+
+	// The other formats are encoded from a lossless copy, so quality isn't lost twice
+	pngFile, err := os.CreateTemp("", "rendition-*.png")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(pngFile.Name())
+	err = png.Encode(pngFile, img)
+	pngFile.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, format := range []string{models.RenditionFormatWebP, models.RenditionFormatAVIF} {
+		outputPath := filepath.Join(RenditionDir, name+renditionExtensions[format])
+		if err := runFirstCommand(ctx, renditionEncoders[format], pngFile.Name(), outputPath); err != nil {
+			if !errors.Is(err, errNoEncoder) {
+				log.Printf("Warning: Failed to encode %s rendition %s: %v", format, name, err)
+			}
+			os.Remove(outputPath)
+			continue
+		}
+		files[format] = outputPath
+	}
+
+	var renditions []*models.AttachmentRendition
+	for _, format := range []string{models.RenditionFormatJPEG, models.RenditionFormatWebP, models.RenditionFormatAVIF} {
+		filePath, ok := files[format]
+		if !ok {
+			continue
+		}
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return nil, err
+		}
+		if err := storeUploadedFile(filePath, "image/"+format); err != nil {
+			return nil, err
+		}
+		releaseUploadedFile(filePath)
+
+		renditions = append(renditions, &models.AttachmentRendition{
+			Format:   format,
+			Path:     filepath.ToSlash(filePath),
+			ByteSize: info.Size(),
+		})
+	}
+	return renditions, nil
+}
+
+// saveRenditions replaces the recorded renditions of an attachment
+func saveRenditions(db *sql.DB, attachmentID int, renditions []*models.AttachmentRendition) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM attachment_renditions WHERE attachment_id = $1`, attachmentID); err != nil {
+		return fmt.Errorf("error clearing renditions: %w", err)
+	}
+	for _, rendition := range renditions {
+		_, err := tx.Exec(`
+			INSERT INTO attachment_renditions (attachment_id, width, height, format, path, byte_size, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		`, attachmentID, rendition.Width, rendition.Height, rendition.Format, strings.TrimPrefix(rendition.Path, "./"), rendition.ByteSize)
+		if err != nil {
+			return fmt.Errorf("error saving rendition %s: %w", rendition.Path, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// runFirstCommand runs the first installed command of a list, replacing {in} and {out} in its
+// arguments. Returns errNoEncoder when none is installed.
+func runFirstCommand(ctx context.Context, commands [][]string, inputPath, outputPath string) error {
+	for _, command := range commands {
+		if _, err := exec.LookPath(command[0]); err != nil {
+			continue
+		}
+		args := make([]string, 0, len(command)-1)
+		for _, arg := range command[1:] {
+			arg = strings.ReplaceAll(arg, "{in}", inputPath)
+			args = append(args, strings.ReplaceAll(arg, "{out}", outputPath))
+		}
+		output, err := exec.CommandContext(ctx, command[0], args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s: %v: %s", command[0], err, strings.TrimSpace(string(output)))
+		}
+		return nil
+	}
+	return errNoEncoder
+}
@@ -0,0 +1,314 @@
+package services
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"olhourbano2/config"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	smtpDialTimeout = 15 * time.Second
+	smtpSendTimeout = time.Minute
+	smtpIdleTimeout = 30 * time.Second // Servers drop idle sessions; reconnect rather than fail a send
+	smtpMaxIdle     = EmailOutboxWorkers
+)
+
+// Mailer delivers a complete RFC 5322 message
+type Mailer interface {
+	Send(from, to string, message []byte) error
+	Close() error
+}
+
+// NewMailer creates the mail transport selected by MAIL_TRANSPORT
+func NewMailer(cfg *config.Config) (Mailer, error) {
+	switch cfg.MailTransport {
+	case "smtp":
+		return NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPEncryption)
+	case "file":
+		return NewFileMailer(cfg.MailDir)
+	case "memory":
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail transport %q", cfg.MailTransport)
+	}
+}
+
+// The transport used by SendEmail; main sets it up from config, otherwise it is created on first use
+var (
+	mailerMu sync.Mutex
+	mailer   Mailer
+	mailFrom string
+)
+
+// SetMailer replaces the transport and sender address used by SendEmail
+func SetMailer(m Mailer, from string) {
+	mailerMu.Lock()
+	defer mailerMu.Unlock()
+	mailer = m
+	mailFrom = from
+}
+
+// currentMailer returns the configured transport and sender address
+func currentMailer() (Mailer, string, error) {
+	mailerMu.Lock()
+	defer mailerMu.Unlock()
+
+	if mailer == nil {
+		cfg, err := config.Load()
+		if err != nil {
+			return nil, "", fmt.Errorf("erro ao carregar configuração: %v", err)
+		}
+		if mailer, err = NewMailer(cfg); err != nil {
+			return nil, "", err
+		}
+		mailFrom = cfg.MailFrom
+	}
+	return mailer, mailFrom, nil
+}
+
+// SMTPMailer sends through an SMTP server, keeping a few authenticated connections open for reuse
+type SMTPMailer struct {
+	host       string
+	port       int
+	username   string
+	password   string
+	encryption string
+	idle       chan *smtpConn
+}
+
+// smtpConn is an open SMTP session and when it was last used
+type smtpConn struct {
+	conn     net.Conn // Kept to set deadlines, which smtp.Client does not expose
+	client   *smtp.Client
+	lastUsed time.Time
+}
+
+// NewSMTPMailer creates an SMTP transport. Encryption is "tls" for STARTTLS, "ssl" for implicit TLS or "none".
+func NewSMTPMailer(host string, port int, username, password, encryption string) (*SMTPMailer, error) {
+	switch encryption {
+	case "tls", "ssl", "none":
+	default:
+		return nil, fmt.Errorf("unknown SMTP encryption %q", encryption)
+	}
+
+	return &SMTPMailer{
+		host:       host,
+		port:       port,
+		username:   username,
+		password:   password,
+		encryption: encryption,
+		idle:       make(chan *smtpConn, smtpMaxIdle),
+	}, nil
+}
+
+// Send delivers a message over a pooled connection
+func (m *SMTPMailer) Send(from, to string, message []byte) error {
+	conn, err := m.acquire()
+	if err != nil {
+		return err
+	}
+
+	conn.conn.SetDeadline(time.Now().Add(smtpSendTimeout))
+	if err := m.deliver(conn.client, from, to, message); err != nil {
+		// The session state is unknown after a failure, so it is not reused
+		conn.client.Close()
+		return err
+	}
+
+	conn.lastUsed = time.Now()
+	select {
+	case m.idle <- conn:
+	default:
+		conn.client.Quit()
+	}
+	return nil
+}
+
+// Close ends every idle session
+func (m *SMTPMailer) Close() error {
+	for {
+		select {
+		case conn := <-m.idle:
+			conn.client.Quit()
+		default:
+			return nil
+		}
+	}
+}
+
+// acquire returns an idle session that is still alive, or dials a new one
+func (m *SMTPMailer) acquire() (*smtpConn, error) {
+	for {
+		select {
+		case conn := <-m.idle:
+			conn.conn.SetDeadline(time.Now().Add(smtpDialTimeout))
+			if time.Since(conn.lastUsed) < smtpIdleTimeout && conn.client.Noop() == nil {
+				return conn, nil
+			}
+			conn.client.Close()
+		default:
+			return m.dial()
+		}
+	}
+}
+
+// dial connects, secures and authenticates a new SMTP session
+func (m *SMTPMailer) dial() (*smtpConn, error) {
+	addr := net.JoinHostPort(m.host, strconv.Itoa(m.port))
+	tlsConfig := &tls.Config{ServerName: m.host, MinVersion: tls.VersionTLS12}
+
+	var conn net.Conn
+	var err error
+	if m.encryption == "ssl" {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: smtpDialTimeout}, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, smtpDialTimeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao conectar ao servidor SMTP: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(smtpDialTimeout))
+
+	client, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("erro ao iniciar sessão SMTP: %v", err)
+	}
+
+	if m.encryption == "tls" {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, fmt.Errorf("servidor SMTP não suporta STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("erro no STARTTLS: %v", err)
+		}
+	}
+
+	if m.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("erro de autenticação SMTP: %v", err)
+		}
+	}
+
+	return &smtpConn{conn: conn, client: client}, nil
+}
+
+// deliver runs one mail transaction on an open session
+func (m *SMTPMailer) deliver(client *smtp.Client, from, to string, message []byte) error {
+	if err := client.Mail(from); err != nil {
+		return fmt.Errorf("erro ao enviar email: %v", err)
+	}
+	if err := client.Rcpt(to); err != nil {
+		return fmt.Errorf("erro ao enviar email: %v", err)
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("erro ao enviar email: %v", err)
+	}
+	if _, err := writer.Write(message); err != nil {
+		writer.Close()
+		return fmt.Errorf("erro ao enviar email: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("erro ao enviar email: %v", err)
+	}
+	return nil
+}
+
+// FileMailer writes every message into a Maildir, for development and staging
+type FileMailer struct {
+	dir string
+}
+
+// NewFileMailer creates the tmp, new and cur folders of a Maildir
+func NewFileMailer(dir string) (*FileMailer, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, fmt.Errorf("error creating maildir: %w", err)
+		}
+	}
+	return &FileMailer{dir: dir}, nil
+}
+
+// Send writes the message to tmp and moves it into new, so readers never see partial files
+func (m *FileMailer) Send(from, to string, message []byte) error {
+	token, err := generateEmailToken()
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d.%s.olhourbano", time.Now().UnixNano(), token[:16])
+	tmpPath := filepath.Join(m.dir, "tmp", name)
+
+	content := append([]byte(fmt.Sprintf("Return-Path: <%s>\r\nDelivered-To: %s\r\n", from, to)), message...)
+	if err := os.WriteFile(tmpPath, content, 0644); err != nil {
+		return fmt.Errorf("error writing message: %w", err)
+	}
+	if err := os.Rename(tmpPath, filepath.Join(m.dir, "new", name)); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error delivering message to maildir: %w", err)
+	}
+	return nil
+}
+
+// Close is a no-op; every message is written on its own
+func (m *FileMailer) Close() error {
+	return nil
+}
+
+// SentMail is a message recorded by MemoryMailer
+type SentMail struct {
+	From    string
+	To      string
+	Message []byte
+	SentAt  time.Time
+}
+
+// MemoryMailer keeps messages in memory instead of sending them, for tests
+type MemoryMailer struct {
+	mu   sync.Mutex
+	sent []SentMail
+}
+
+// NewMemoryMailer creates an empty recorder
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+// Send records the message
+func (m *MemoryMailer) Send(from, to string, message []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, SentMail{From: from, To: to, Message: append([]byte(nil), message...), SentAt: time.Now()})
+	return nil
+}
+
+// Sent returns a copy of the recorded messages, oldest first
+func (m *MemoryMailer) Sent() []SentMail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]SentMail(nil), m.sent...)
+}
+
+// Reset forgets the recorded messages
+func (m *MemoryMailer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = nil
+}
+
+// Close is a no-op
+func (m *MemoryMailer) Close() error {
+	return nil
+}
@@ -0,0 +1,419 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"olhourbano2/models"
+	"sync"
+	"time"
+)
+
+const (
+	MediaJobWorkers      = 2 // convert and ffmpeg are heavy; a burst of uploads waits in the queue
+	MediaJobPollInterval = 5 * time.Second
+	MediaJobMaxAttempts  = 3
+	mediaJobBaseBackoff  = time.Minute
+	mediaJobStaleLock    = 20 * time.Minute // Longer than any job timeout
+)
+
+// mediaJobTimeouts bounds how long each kind of job may run before its command is killed
+var mediaJobTimeouts = map[string]time.Duration{
+	models.MediaJobThumbnail:  2 * time.Minute,
+	models.MediaJobRenditions: 3 * time.Minute,
+	models.MediaJobTranscode:  10 * time.Minute,
+	models.MediaJobAnonymize:  3 * time.Minute,
+}
+
+// mediaJobColumns lists the columns scanned by scanMediaJob
+const mediaJobColumns = `id, attachment_id, kind, status, attempts, max_attempts, next_attempt_at, COALESCE(last_error, ''), created_at, finished_at`
+
+// EnqueueMediaJob queues a processing step of an attachment. Queueing a kind the attachment
+// already has starts it over, unless a worker is running it.
+func EnqueueMediaJob(db sqlExecer, attachmentID int, kind string) error {
+	_, err := db.Exec(`
+		INSERT INTO media_jobs (attachment_id, kind, max_attempts, next_attempt_at, created_at)
+		VALUES ($1, $2, $3, NOW(), NOW())
+		ON CONFLICT (attachment_id, kind) DO UPDATE
+		SET status = 'pending', attempts = 0, max_attempts = EXCLUDED.max_attempts, next_attempt_at = NOW(),
+			locked_at = NULL, last_error = NULL, finished_at = NULL
+		WHERE media_jobs.status != 'running'
+	`, attachmentID, kind, MediaJobMaxAttempts)
+	if err != nil {
+		return fmt.Errorf("error enqueueing %s job of attachment %d: %w", kind, attachmentID, err)
+	}
+	return nil
+}
+
+// StartMediaJobWorkers starts a pool of workers running due media jobs
+func StartMediaJobWorkers(db *sql.DB, workers int) {
+	for i := 0; i < workers; i++ {
+		go func(worker int) {
+			ticker := time.NewTicker(MediaJobPollInterval)
+			defer ticker.Stop()
+
+			for {
+				// Drain everything that is due before waiting for the next tick
+				for {
+					ran, err := runNextMediaJob(db)
+					if err != nil {
+						log.Printf("Media job worker %d: %v", worker, err)
+						break
+					}
+					if !ran {
+						break
+					}
+				}
+				<-ticker.C
+			}
+		}(i + 1)
+	}
+}
+
+// RunMediaJobs runs every due media job with a pool of workers and returns once none is left.
+// Returns how many jobs ran.
+func RunMediaJobs(db *sql.DB, workers int) (int, error) {
+	var (
+		mu       sync.Mutex
+		ran      int
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				ok, err := runNextMediaJob(db)
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				if ok {
+					ran++
+				}
+				mu.Unlock()
+				if err != nil || !ok {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return ran, firstErr
+}
+
+// ListMediaJobs returns media jobs in a status, newest first. An empty status lists dead jobs
+// and pending ones that already failed at least once.
+func ListMediaJobs(db *sql.DB, status string, limit int) ([]*models.MediaJob, error) {
+	query := `SELECT ` + mediaJobColumns + ` FROM media_jobs`
+	args := []interface{}{}
+
+	if status == "" {
+		query += ` WHERE status = 'dead' OR (status = 'pending' AND attempts > 0)`
+	} else {
+		query += ` WHERE status = $1`
+		args = append(args, status)
+	}
+
+	query += ` ORDER BY created_at DESC`
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying media jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []*models.MediaJob
+	for rows.Next() {
+		job, err := scanMediaJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning media job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// RegenerateThumbnails queues thumbnail jobs for the attachments whose thumbnail isn't ready,
+// rendition jobs for the images without renditions, transcode jobs for the videos not yet
+// transcoded and anonymize jobs for the photos not yet blurred, or all of them for every
+// attachment that can have them when all is set. Resumable uploads no report claimed yet are
+// left out, since their jobs are queued when they are claimed. Returns how many jobs were queued.
+func RegenerateThumbnails(db *sql.DB, all bool) (int, error) {
+	query := `SELECT ` + attachmentColumns + ` FROM attachments
+		WHERE (content_type LIKE 'image/%' OR content_type LIKE 'video/%' OR content_type = 'application/pdf')
+		AND report_id IS NOT NULL`
+	if !all {
+		query += ` AND (thumbnail_status != 'ready' OR NOT EXISTS (SELECT 1 FROM attachment_renditions WHERE attachment_id = attachments.id))`
+	}
+	query += ` ORDER BY id`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return 0, fmt.Errorf("error querying attachments: %w", err)
+	}
+	var attachments []*models.Attachment
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning attachment: %w", err)
+		}
+		attachments = append(attachments, attachment)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if err := loadAttachmentRenditions(db, attachments); err != nil {
+		return 0, err
+	}
+
+	queued := 0
+	for _, attachment := range attachments {
+		// Blurred photos get their thumbnail and renditions once the public copy is written
+		if attachment.AnonymizeStatus != models.AnonymizeStatusNone &&
+			(all || attachment.AnonymizeStatus != models.AnonymizeStatusReady) {
+			_, err := db.Exec(`UPDATE attachments SET anonymize_status = 'pending' WHERE id = $1 AND anonymize_status != 'ready'`,
+				attachment.ID)
+			if err != nil {
+				return queued, fmt.Errorf("error updating attachment %d: %w", attachment.ID, err)
+			}
+			if err := EnqueueMediaJob(db, attachment.ID, models.MediaJobAnonymize); err != nil {
+				return queued, err
+			}
+			queued++
+			continue
+		}
+
+		if all || attachment.ThumbnailStatus != models.ThumbnailStatusReady {
+			thumbnailPath := attachment.ThumbnailPath
+			if thumbnailPath == "" {
+				thumbnailPath = thumbnailPathFor(attachment.Path)
+			}
+
+			_, err := db.Exec(`UPDATE attachments SET thumbnail_path = $2, thumbnail_status = 'pending' WHERE id = $1`,
+				attachment.ID, thumbnailPath)
+			if err != nil {
+				return queued, fmt.Errorf("error updating attachment %d: %w", attachment.ID, err)
+			}
+			if err := EnqueueMediaJob(db, attachment.ID, models.MediaJobThumbnail); err != nil {
+				return queued, err
+			}
+			queued++
+		}
+
+		if hasRenditions(attachment.ContentType) && (all || len(attachment.Renditions) == 0) {
+			if err := EnqueueMediaJob(db, attachment.ID, models.MediaJobRenditions); err != nil {
+				return queued, err
+			}
+			queued++
+		}
+
+		if isTranscodable(attachment.ContentType) && (all || attachment.TranscodeStatus != models.TranscodeStatusReady) {
+			_, err := db.Exec(`UPDATE attachments SET transcode_status = 'pending' WHERE id = $1 AND transcode_status != 'ready'`,
+				attachment.ID)
+			if err != nil {
+				return queued, fmt.Errorf("error updating attachment %d: %w", attachment.ID, err)
+			}
+			if err := EnqueueMediaJob(db, attachment.ID, models.MediaJobTranscode); err != nil {
+				return queued, err
+			}
+			queued++
+		}
+	}
+	return queued, nil
+}
+
+// runNextMediaJob claims one due job and runs it. Returns false when nothing is due.
+func runNextMediaJob(db *sql.DB) (bool, error) {
+	if err := buryStaleMediaJobs(db); err != nil {
+		return false, err
+	}
+
+	// SKIP LOCKED lets workers (and other app instances) claim different jobs concurrently
+	row := db.QueryRow(`
+		UPDATE media_jobs
+		SET status = 'running', locked_at = NOW(), attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM media_jobs
+			WHERE (status = 'pending' AND next_attempt_at <= NOW())
+			   OR (status = 'running' AND locked_at < NOW() - make_interval(secs => $1) AND attempts < max_attempts)
+			ORDER BY next_attempt_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+mediaJobColumns,
+		mediaJobStaleLock.Seconds(),
+	)
+
+	job, err := scanMediaJob(row)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error claiming media job: %w", err)
+	}
+
+	timeout, ok := mediaJobTimeouts[job.Kind]
+	if !ok {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	jobErr := runMediaJob(ctx, db, job)
+	cancel()
+
+	if jobErr == nil {
+		_, err = db.Exec(`
+			UPDATE media_jobs SET status = 'done', finished_at = NOW(), locked_at = NULL, last_error = NULL
+			WHERE id = $1
+		`, job.ID)
+		if err != nil {
+			return true, fmt.Errorf("error marking media job %d as done: %w", job.ID, err)
+		}
+		return true, nil
+	}
+
+	if job.Attempts >= job.MaxAttempts {
+		log.Printf("Media job %d (%s of attachment %d) given up after %d attempts: %v", job.ID, job.Kind, job.AttachmentID, job.Attempts, jobErr)
+		_, err = db.Exec(`
+			UPDATE media_jobs SET status = 'dead', finished_at = NOW(), locked_at = NULL, last_error = $2
+			WHERE id = $1
+		`, job.ID, jobErr.Error())
+		if err == nil {
+			err = failMediaJob(db, job)
+		}
+	} else {
+		_, err = db.Exec(`
+			UPDATE media_jobs SET status = 'pending', locked_at = NULL, last_error = $2, next_attempt_at = $3
+			WHERE id = $1
+		`, job.ID, jobErr.Error(), time.Now().Add(mediaJobBackoff(job.Attempts)))
+	}
+	if err != nil {
+		return true, fmt.Errorf("error recording failure of media job %d: %w", job.ID, err)
+	}
+	return true, nil
+}
+
+// buryStaleMediaJobs gives up on jobs whose worker stopped during their last attempt, as when a
+// command brought the process down; claiming them again would only take it down again
+func buryStaleMediaJobs(db *sql.DB) error {
+	rows, err := db.Query(`
+		UPDATE media_jobs
+		SET status = 'dead', finished_at = NOW(), locked_at = NULL, last_error = 'worker stopped while running the job'
+		WHERE status = 'running' AND locked_at < NOW() - make_interval(secs => $1) AND attempts >= max_attempts
+		RETURNING `+mediaJobColumns,
+		mediaJobStaleLock.Seconds(),
+	)
+	if err != nil {
+		return fmt.Errorf("error burying stale media jobs: %w", err)
+	}
+
+	var jobs []*models.MediaJob
+	for rows.Next() {
+		job, err := scanMediaJob(rows)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning media job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		log.Printf("Media job %d (%s of attachment %d) given up after %d attempts: worker stopped while running it",
+			job.ID, job.Kind, job.AttachmentID, job.Attempts)
+		if err := failMediaJob(db, job); err != nil {
+			return fmt.Errorf("error recording failure of media job %d: %w", job.ID, err)
+		}
+	}
+	return nil
+}
+
+// runMediaJob does the work of a job; commands it starts are killed when ctx expires
+func runMediaJob(ctx context.Context, db *sql.DB, job *models.MediaJob) error {
+	attachment, err := scanAttachment(db.QueryRow(`SELECT `+attachmentColumns+` FROM attachments WHERE id = $1`, job.AttachmentID))
+	if err != nil {
+		return fmt.Errorf("error loading attachment: %w", err)
+	}
+
+	switch job.Kind {
+	case models.MediaJobThumbnail:
+		if err := generateAttachmentThumbnail(ctx, attachment); err != nil {
+			return err
+		}
+		_, err = db.Exec(`UPDATE attachments SET thumbnail_status = 'ready' WHERE id = $1`, attachment.ID)
+		return err
+	case models.MediaJobRenditions:
+		return generateAttachmentRenditions(ctx, db, attachment)
+	case models.MediaJobTranscode:
+		return generateAttachmentVideo(ctx, db, attachment)
+	case models.MediaJobAnonymize:
+		return anonymizeAttachment(ctx, db, attachment)
+	}
+	return fmt.Errorf("unknown media job kind %q", job.Kind)
+}
+
+// failMediaJob records on the attachment that a job was given up on. Images without
+// renditions are served as uploaded, so those need nothing; videos that failed to transcode are
+// played from the original, and photos that failed to blur are never published.
+func failMediaJob(db *sql.DB, job *models.MediaJob) error {
+	switch job.Kind {
+	case models.MediaJobThumbnail:
+		_, err := db.Exec(`UPDATE attachments SET thumbnail_status = 'failed' WHERE id = $1`, job.AttachmentID)
+		return err
+	case models.MediaJobTranscode:
+		_, err := db.Exec(`UPDATE attachments SET transcode_status = 'failed' WHERE id = $1`, job.AttachmentID)
+		return err
+	case models.MediaJobAnonymize:
+		_, err := db.Exec(`UPDATE attachments SET anonymize_status = 'failed', thumbnail_status = 'failed' WHERE id = $1`,
+			job.AttachmentID)
+		return err
+	}
+	return nil
+}
+
+// mediaJobBackoff returns the delay before the next attempt: 1, 2, 4... minutes
+func mediaJobBackoff(attempts int) time.Duration {
+	delay := mediaJobBaseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+	}
+	return delay
+}
+
+// scanMediaJob scans a row selected with mediaJobColumns
+func scanMediaJob(row rowScanner) (*models.MediaJob, error) {
+	job := &models.MediaJob{}
+	var finishedAt sql.NullTime
+
+	err := row.Scan(
+		&job.ID,
+		&job.AttachmentID,
+		&job.Kind,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.NextAttemptAt,
+		&job.LastError,
+		&job.CreatedAt,
+		&finishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	return job, nil
+}
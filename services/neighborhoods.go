@@ -0,0 +1,510 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"olhourbano2/config"
+	"olhourbano2/models"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// geoJSONFeatureCollection represents the subset of GeoJSON needed for imports
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+// geoJSONFeature represents a single GeoJSON feature
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Properties map[string]interface{} `json:"properties"`
+	Geometry   geoJSONGeometry        `json:"geometry"`
+}
+
+// geoJSONGeometry represents a GeoJSON geometry with raw coordinates
+type geoJSONGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// ImportNeighborhoodsFromGeoJSON imports neighborhood polygons for a city from a GeoJSON FeatureCollection.
+// Existing neighborhoods with the same city and name are updated in place, keeping their slug.
+func ImportNeighborhoodsFromGeoJSON(db *sql.DB, city, filePath, nameProperty string) (int, error) {
+	if strings.TrimSpace(city) == "" {
+		return 0, fmt.Errorf("city is required")
+	}
+	if nameProperty == "" {
+		nameProperty = "name"
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("error reading GeoJSON file: %w", err)
+	}
+
+	var collection geoJSONFeatureCollection
+	if err := json.Unmarshal(content, &collection); err != nil {
+		return 0, fmt.Errorf("error parsing GeoJSON file: %w", err)
+	}
+
+	if collection.Type != "FeatureCollection" {
+		return 0, fmt.Errorf("GeoJSON must be a FeatureCollection, got %q", collection.Type)
+	}
+
+	query := `
+		INSERT INTO neighborhoods (city, name, slug, geometry, min_latitude, max_latitude, min_longitude, max_longitude, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW())
+		ON CONFLICT (city, name) DO UPDATE SET
+			geometry = EXCLUDED.geometry,
+			min_latitude = EXCLUDED.min_latitude,
+			max_latitude = EXCLUDED.max_latitude,
+			min_longitude = EXCLUDED.min_longitude,
+			max_longitude = EXCLUDED.max_longitude
+	`
+
+	imported := 0
+	for i, feature := range collection.Features {
+		name, _ := feature.Properties[nameProperty].(string)
+		name = strings.TrimSpace(name)
+		if name == "" {
+			fmt.Printf("Warning: skipping feature %d without %q property\n", i, nameProperty)
+			continue
+		}
+
+		polygons, err := parsePolygons(feature.Geometry)
+		if err != nil {
+			fmt.Printf("Warning: skipping neighborhood %s: %v\n", name, err)
+			continue
+		}
+
+		minLat, maxLat, minLng, maxLng := polygonsBoundingBox(polygons)
+		geometry, err := json.Marshal(feature.Geometry)
+		if err != nil {
+			return imported, fmt.Errorf("error encoding geometry for %s: %w", name, err)
+		}
+
+		slug, err := neighborhoodSlug(db, city, name)
+		if err != nil {
+			return imported, fmt.Errorf("error choosing slug for %s: %w", name, err)
+		}
+
+		_, err = db.Exec(query, city, name, slug, string(geometry), minLat, maxLat, minLng, maxLng)
+		if err != nil {
+			return imported, fmt.Errorf("error saving neighborhood %s: %w", name, err)
+		}
+		imported++
+	}
+
+	return imported, nil
+}
+
+// neighborhoodSlug returns the slug a neighborhood already has, or a new one no other neighborhood
+// uses. Names differing only in accents, case or punctuation slugify alike, so later ones are numbered.
+func neighborhoodSlug(db *sql.DB, city, name string) (string, error) {
+	var slug string
+	err := db.QueryRow(`SELECT slug FROM neighborhoods WHERE city = $1 AND name = $2`, city, name).Scan(&slug)
+	if err == nil {
+		return slug, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+
+	base := Slugify(city + " " + name)
+	slug = base
+	for n := 2; ; n++ {
+		var taken bool
+		if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM neighborhoods WHERE slug = $1)`, slug).Scan(&taken); err != nil {
+			return "", err
+		}
+		if !taken {
+			return slug, nil
+		}
+		slug = fmt.Sprintf("%s-%d", base, n)
+	}
+}
+
+// FindNeighborhoodForPoint returns the ID of the neighborhood containing the coordinates, or 0 if none
+func FindNeighborhoodForPoint(db *sql.DB, latitude, longitude float64) (int, error) {
+	if latitude == 0 && longitude == 0 {
+		return 0, nil
+	}
+
+	// Bounding box pre-filter; the exact test runs in Go since PostGIS is not available
+	rows, err := db.Query(`
+		SELECT id, geometry
+		FROM neighborhoods
+		WHERE min_latitude <= $1 AND max_latitude >= $1
+		  AND min_longitude <= $2 AND max_longitude >= $2
+		ORDER BY (max_latitude - min_latitude) * (max_longitude - min_longitude) ASC
+	`, latitude, longitude)
+	if err != nil {
+		return 0, fmt.Errorf("error querying neighborhoods: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var rawGeometry []byte
+		if err := rows.Scan(&id, &rawGeometry); err != nil {
+			return 0, fmt.Errorf("error scanning neighborhood: %w", err)
+		}
+
+		var geometry geoJSONGeometry
+		if err := json.Unmarshal(rawGeometry, &geometry); err != nil {
+			continue
+		}
+
+		polygons, err := parsePolygons(geometry)
+		if err != nil {
+			continue
+		}
+
+		if polygonsContain(polygons, latitude, longitude) {
+			return id, nil
+		}
+	}
+
+	return 0, rows.Err()
+}
+
+// AssignNeighborhoodsToReports assigns a neighborhood to every report that doesn't have one yet
+func AssignNeighborhoodsToReports(db *sql.DB) (int, error) {
+	rows, err := db.Query(`
+		SELECT id, latitude, longitude
+		FROM reports
+		WHERE neighborhood_id IS NULL
+		  AND latitude IS NOT NULL AND longitude IS NOT NULL
+		  AND latitude != 0 AND longitude != 0
+	`)
+	if err != nil {
+		return 0, err
+	}
+
+	type pendingReport struct {
+		id        int
+		latitude  float64
+		longitude float64
+	}
+
+	var pending []pendingReport
+	for rows.Next() {
+		var report pendingReport
+		if err := rows.Scan(&report.id, &report.latitude, &report.longitude); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		pending = append(pending, report)
+	}
+	rows.Close()
+
+	assigned := 0
+	for _, report := range pending {
+		neighborhoodID, err := FindNeighborhoodForPoint(db, report.latitude, report.longitude)
+		if err != nil {
+			return assigned, err
+		}
+		if neighborhoodID == 0 {
+			continue
+		}
+
+		_, err = db.Exec(`UPDATE reports SET neighborhood_id = $1 WHERE id = $2`, neighborhoodID, report.id)
+		if err != nil {
+			return assigned, err
+		}
+		assigned++
+	}
+
+	return assigned, nil
+}
+
+// GetNeighborhoods returns the neighborhoods of a city (or all cities when empty), without geometry
+func GetNeighborhoods(db *sql.DB, city string) ([]*models.Neighborhood, error) {
+	query := `
+		SELECT id, city, name, slug, min_latitude, max_latitude, min_longitude, max_longitude, created_at
+		FROM neighborhoods
+	`
+	args := []interface{}{}
+
+	if city != "" {
+		query += " WHERE city ILIKE $1"
+		args = append(args, "%"+city+"%")
+	}
+
+	query += " ORDER BY city, name"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var neighborhoods []*models.Neighborhood
+	for rows.Next() {
+		neighborhood := &models.Neighborhood{}
+		err := rows.Scan(
+			&neighborhood.ID,
+			&neighborhood.City,
+			&neighborhood.Name,
+			&neighborhood.Slug,
+			&neighborhood.MinLatitude,
+			&neighborhood.MaxLatitude,
+			&neighborhood.MinLongitude,
+			&neighborhood.MaxLongitude,
+			&neighborhood.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		neighborhoods = append(neighborhoods, neighborhood)
+	}
+
+	return neighborhoods, nil
+}
+
+// GetNeighborhoodBySlug retrieves a neighborhood, including its geometry, by slug
+func GetNeighborhoodBySlug(db *sql.DB, slug string) (*models.Neighborhood, error) {
+	neighborhood := &models.Neighborhood{}
+	var geometry []byte
+
+	err := db.QueryRow(`
+		SELECT id, city, name, slug, geometry, min_latitude, max_latitude, min_longitude, max_longitude, created_at
+		FROM neighborhoods
+		WHERE slug = $1
+	`, slug).Scan(
+		&neighborhood.ID,
+		&neighborhood.City,
+		&neighborhood.Name,
+		&neighborhood.Slug,
+		&geometry,
+		&neighborhood.MinLatitude,
+		&neighborhood.MaxLatitude,
+		&neighborhood.MinLongitude,
+		&neighborhood.MaxLongitude,
+		&neighborhood.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	neighborhood.Geometry = geometry
+	return neighborhood, nil
+}
+
+// GetNeighborhoodRanking returns neighborhoods ranked by number of reports
+func GetNeighborhoodRanking(db *sql.DB, city string, limit int) ([]*models.NeighborhoodStats, error) {
+	query := `
+		SELECT n.id, n.city, n.name, n.slug,
+		       COUNT(r.id) AS total,
+		       COUNT(r.id) FILTER (WHERE r.status = 'approved') AS resolved
+		FROM neighborhoods n
+		LEFT JOIN reports r ON r.neighborhood_id = n.id AND r.merged_into_id IS NULL
+	`
+	args := []interface{}{}
+	argCount := 0
+
+	if city != "" {
+		argCount++
+		query += fmt.Sprintf(" WHERE n.city ILIKE $%d", argCount)
+		args = append(args, "%"+city+"%")
+	}
+
+	query += " GROUP BY n.id, n.city, n.name, n.slug ORDER BY total DESC, n.name ASC"
+
+	if limit > 0 {
+		argCount++
+		query += fmt.Sprintf(" LIMIT $%d", argCount)
+		args = append(args, limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ranking []*models.NeighborhoodStats
+	for rows.Next() {
+		stats := &models.NeighborhoodStats{}
+		err := rows.Scan(&stats.ID, &stats.City, &stats.Name, &stats.Slug, &stats.TotalReports, &stats.Resolved)
+		if err != nil {
+			return nil, err
+		}
+		fillNeighborhoodRates(stats)
+		ranking = append(ranking, stats)
+	}
+
+	return ranking, nil
+}
+
+// GetNeighborhoodStats returns report counts by category and the resolution rate for a neighborhood
+func GetNeighborhoodStats(db *sql.DB, neighborhood *models.Neighborhood) (*models.NeighborhoodStats, error) {
+	stats := &models.NeighborhoodStats{
+		ID:   neighborhood.ID,
+		City: neighborhood.City,
+		Name: neighborhood.Name,
+		Slug: neighborhood.Slug,
+	}
+
+	rows, err := db.Query(`
+		SELECT problem_type,
+		       COUNT(*) AS total,
+		       COUNT(*) FILTER (WHERE status = 'approved') AS resolved
+		FROM reports
+		WHERE neighborhood_id = $1 AND merged_into_id IS NULL
+		GROUP BY problem_type
+		ORDER BY total DESC
+	`, neighborhood.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var problemType string
+		var total, resolved int
+		if err := rows.Scan(&problemType, &total, &resolved); err != nil {
+			return nil, err
+		}
+
+		count := models.CategoryCount{
+			Category: problemType,
+			Name:     problemType,
+			Icon:     "❓",
+			Count:    total,
+		}
+		if category := config.GetCategory(problemType); category != nil {
+			count.Name = category.Name
+			count.Icon = category.Icon
+		}
+
+		stats.ByCategory = append(stats.ByCategory, count)
+		stats.TotalReports += total
+		stats.Resolved += resolved
+	}
+
+	fillNeighborhoodRates(stats)
+	return stats, nil
+}
+
+// fillNeighborhoodRates derives pending count and resolution rate from totals
+func fillNeighborhoodRates(stats *models.NeighborhoodStats) {
+	stats.Pending = stats.TotalReports - stats.Resolved
+	if stats.TotalReports > 0 {
+		stats.ResolutionRate = int(float64(stats.Resolved) / float64(stats.TotalReports) * 100)
+	}
+}
+
+// parsePolygons converts a Polygon or MultiPolygon geometry into a list of polygons (rings of [lng, lat])
+func parsePolygons(geometry geoJSONGeometry) ([][][][2]float64, error) {
+	switch geometry.Type {
+	case "Polygon":
+		var polygon [][][2]float64
+		if err := json.Unmarshal(geometry.Coordinates, &polygon); err != nil {
+			return nil, fmt.Errorf("invalid Polygon coordinates: %w", err)
+		}
+		if len(polygon) == 0 {
+			return nil, fmt.Errorf("empty Polygon")
+		}
+		return [][][][2]float64{polygon}, nil
+	case "MultiPolygon":
+		var polygons [][][][2]float64
+		if err := json.Unmarshal(geometry.Coordinates, &polygons); err != nil {
+			return nil, fmt.Errorf("invalid MultiPolygon coordinates: %w", err)
+		}
+		if len(polygons) == 0 {
+			return nil, fmt.Errorf("empty MultiPolygon")
+		}
+		return polygons, nil
+	default:
+		return nil, fmt.Errorf("unsupported geometry type %q", geometry.Type)
+	}
+}
+
+// polygonsBoundingBox returns the bounding box of all outer rings
+func polygonsBoundingBox(polygons [][][][2]float64) (minLat, maxLat, minLng, maxLng float64) {
+	minLat, minLng = math.MaxFloat64, math.MaxFloat64
+	maxLat, maxLng = -math.MaxFloat64, -math.MaxFloat64
+
+	for _, polygon := range polygons {
+		if len(polygon) == 0 {
+			continue
+		}
+		for _, point := range polygon[0] {
+			minLng = math.Min(minLng, point[0])
+			maxLng = math.Max(maxLng, point[0])
+			minLat = math.Min(minLat, point[1])
+			maxLat = math.Max(maxLat, point[1])
+		}
+	}
+
+	return minLat, maxLat, minLng, maxLng
+}
+
+// polygonsContain checks whether a point falls inside any polygon, honoring holes
+func polygonsContain(polygons [][][][2]float64, latitude, longitude float64) bool {
+	for _, polygon := range polygons {
+		if len(polygon) == 0 || !ringContains(polygon[0], latitude, longitude) {
+			continue
+		}
+
+		insideHole := false
+		for _, hole := range polygon[1:] {
+			if ringContains(hole, latitude, longitude) {
+				insideHole = true
+				break
+			}
+		}
+
+		if !insideHole {
+			return true
+		}
+	}
+	return false
+}
+
+// ringContains implements the ray casting point-in-polygon test for a single ring
+func ringContains(ring [][2]float64, latitude, longitude float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		xi, yi := ring[i][0], ring[i][1]
+		xj, yj := ring[j][0], ring[j][1]
+
+		if (yi > latitude) != (yj > latitude) &&
+			longitude < (xj-xi)*(latitude-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Slugify converts a name into a URL-friendly slug, removing Portuguese accents
+func Slugify(s string) string {
+	replacer := strings.NewReplacer(
+		"á", "a", "à", "a", "â", "a", "ã", "a", "ä", "a",
+		"é", "e", "è", "e", "ê", "e", "ë", "e",
+		"í", "i", "ì", "i", "î", "i", "ï", "i",
+		"ó", "o", "ò", "o", "ô", "o", "õ", "o", "ö", "o",
+		"ú", "u", "ù", "u", "û", "u", "ü", "u",
+		"ç", "c", "ñ", "n",
+	)
+	s = replacer.Replace(strings.ToLower(strings.TrimSpace(s)))
+
+	var builder strings.Builder
+	lastDash := false
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			builder.WriteRune(r)
+			lastDash = false
+		} else if !lastDash && builder.Len() > 0 {
+			builder.WriteByte('-')
+			lastDash = true
+		}
+	}
+
+	return strings.TrimSuffix(builder.String(), "-")
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPolygonsContain(t *testing.T) {
+	// A 10x10 square with a 2x2 hole in the middle, and a separate square to the east
+	square := [][][2]float64{
+		{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}},
+		{{4, 4}, {6, 4}, {6, 6}, {4, 6}, {4, 4}},
+	}
+	east := [][][2]float64{{{20, 0}, {30, 0}, {30, 10}, {20, 10}, {20, 0}}}
+	polygons := [][][][2]float64{square, east}
+
+	tests := []struct {
+		name      string
+		latitude  float64
+		longitude float64
+		want      bool
+	}{
+		{"inside", 2, 2, true},
+		{"inside hole", 5, 5, false},
+		{"between ring and hole", 5, 3, true},
+		{"second polygon", 5, 25, true},
+		{"between polygons", 5, 15, false},
+		{"outside", -1, 5, false},
+		{"far away", -23.5, -46.6, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := polygonsContain(polygons, tt.latitude, tt.longitude); got != tt.want {
+				t.Errorf("polygonsContain(%v, %v) = %v, want %v", tt.latitude, tt.longitude, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRingContainsConcave(t *testing.T) {
+	// An L shape: the notch at the top right is outside
+	ring := [][2]float64{{0, 0}, {10, 0}, {10, 5}, {5, 5}, {5, 10}, {0, 10}, {0, 0}}
+
+	tests := []struct {
+		latitude, longitude float64
+		want                bool
+	}{
+		{2, 2, true},
+		{8, 2, true},
+		{2, 8, true},
+		{8, 8, false},
+	}
+	for _, tt := range tests {
+		if got := ringContains(ring, tt.latitude, tt.longitude); got != tt.want {
+			t.Errorf("ringContains(%v, %v) = %v, want %v", tt.latitude, tt.longitude, got, tt.want)
+		}
+	}
+}
+
+func TestParsePolygons(t *testing.T) {
+	tests := []struct {
+		name     string
+		geometry string
+		polygons int
+		wantErr  bool
+	}{
+		{"polygon", `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]}`, 1, false},
+		{"multipolygon", `{"type":"MultiPolygon","coordinates":[[[[0,0],[1,0],[1,1],[0,0]]],[[[2,2],[3,2],[3,3],[2,2]]]]}`, 2, false},
+		{"empty polygon", `{"type":"Polygon","coordinates":[]}`, 0, true},
+		{"empty multipolygon", `{"type":"MultiPolygon","coordinates":[]}`, 0, true},
+		{"point", `{"type":"Point","coordinates":[0,0]}`, 0, true},
+		{"bad coordinates", `{"type":"Polygon","coordinates":[["a"]]}`, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var geometry geoJSONGeometry
+			if err := json.Unmarshal([]byte(tt.geometry), &geometry); err != nil {
+				t.Fatal(err)
+			}
+			polygons, err := parsePolygons(geometry)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePolygons() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(polygons) != tt.polygons {
+				t.Errorf("parsePolygons() returned %d polygons, want %d", len(polygons), tt.polygons)
+			}
+		})
+	}
+}
+
+func TestPolygonsBoundingBox(t *testing.T) {
+	polygons := [][][][2]float64{
+		{{{-46.7, -23.6}, {-46.5, -23.6}, {-46.5, -23.4}, {-46.7, -23.6}}},
+		{{{-46.9, -23.8}, {-46.8, -23.8}, {-46.8, -23.7}, {-46.9, -23.8}}},
+	}
+	minLat, maxLat, minLng, maxLng := polygonsBoundingBox(polygons)
+	if minLat != -23.8 || maxLat != -23.4 || minLng != -46.9 || maxLng != -46.5 {
+		t.Errorf("polygonsBoundingBox() = %v, %v, %v, %v", minLat, maxLat, minLng, maxLng)
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"São Paulo Vila Mariana", "sao-paulo-vila-mariana"},
+		{"  Jardim  Ângela ", "jardim-angela"},
+		{"Brás", "bras"},
+		{"BRÁS", "bras"},
+		{"Itaim Bibi (Norte)", "itaim-bibi-norte"},
+		{"Conceição", "conceicao"},
+		{"---", ""},
+	}
+	for _, tt := range tests {
+		if got := Slugify(tt.in); got != tt.want {
+			t.Errorf("Slugify(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"olhourbano2/models"
+	"strings"
+	"time"
+)
+
+const (
+	EmailOutboxWorkers      = 4
+	EmailOutboxPollInterval = 5 * time.Second
+	OutboxMaxAttempts       = 8
+	outboxBaseBackoff       = time.Minute
+	outboxMaxBackoff        = 6 * time.Hour
+	outboxStaleLock         = 10 * time.Minute // A claim older than this is assumed lost in a crash
+)
+
+// outboxColumns lists the columns scanned by scanOutboxMessage
+const outboxColumns = `id, idempotency_key, recipient, subject, body, html_body, list_unsubscribe, status, attempts, max_attempts, next_attempt_at, COALESCE(last_error, ''), created_at, sent_at`
+
+// EnqueueEmail stores an email in the outbox for the workers to deliver. The idempotency key is
+// built from the event and the recipient, so enqueueing the same event twice sends one email.
+// Suppressed addresses get nothing, and only verified addresses get non-transactional emails.
+func EnqueueEmail(db sqlExecer, event, to string, template EmailTemplate) error {
+	to = strings.TrimSpace(to)
+	if to == "" {
+		return fmt.Errorf("email recipient is empty")
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO email_outbox (idempotency_key, recipient, subject, body, html_body, list_unsubscribe, max_attempts, next_attempt_at, created_at)
+		SELECT $1, $2, $3, $4, $5, $6, $7, NOW(), NOW()
+		WHERE NOT EXISTS (SELECT 1 FROM email_suppressions WHERE email = LOWER($2))
+		  AND ($8 OR EXISTS (SELECT 1 FROM email_verifications WHERE email = LOWER($2) AND verified_at IS NOT NULL))
+		ON CONFLICT (idempotency_key) DO NOTHING
+	`, outboxKey(event, to), to, template.Subject, template.Body, template.HTML, template.ListUnsubscribe, OutboxMaxAttempts,
+		template.Transactional)
+	if err != nil {
+		return fmt.Errorf("error enqueueing email: %w", err)
+	}
+	return nil
+}
+
+// StartEmailOutboxWorkers starts a pool of workers delivering due outbox messages
+func StartEmailOutboxWorkers(db *sql.DB, workers int) {
+	for i := 0; i < workers; i++ {
+		go func(worker int) {
+			ticker := time.NewTicker(EmailOutboxPollInterval)
+			defer ticker.Stop()
+
+			for {
+				// Drain everything that is due before waiting for the next tick
+				for {
+					delivered, err := deliverNextOutboxMessage(db)
+					if err != nil {
+						log.Printf("Email outbox worker %d: %v", worker, err)
+						break
+					}
+					if !delivered {
+						break
+					}
+				}
+				<-ticker.C
+			}
+		}(i + 1)
+	}
+}
+
+// ListOutboxMessages returns outbox messages in a status, newest first. An empty status lists
+// dead messages and pending ones that already failed at least once.
+func ListOutboxMessages(db *sql.DB, status string, limit int) ([]*models.OutboxMessage, error) {
+	query := `SELECT ` + outboxColumns + ` FROM email_outbox`
+	args := []interface{}{}
+
+	if status == "" {
+		query += ` WHERE status = 'dead' OR (status = 'pending' AND attempts > 0)`
+	} else {
+		query += ` WHERE status = $1`
+		args = append(args, status)
+	}
+
+	query += ` ORDER BY created_at DESC`
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying email outbox: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []*models.OutboxMessage
+	for rows.Next() {
+		message, err := scanOutboxMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning outbox message: %w", err)
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, rows.Err()
+}
+
+// ResendOutboxMessage puts a dead or pending message back in the queue with a fresh set of attempts
+func ResendOutboxMessage(db *sql.DB, id int64) error {
+	result, err := db.Exec(`
+		UPDATE email_outbox
+		SET status = 'pending', attempts = 0, next_attempt_at = NOW(), locked_at = NULL
+		WHERE id = $1 AND status IN ('dead', 'pending')
+	`, id)
+	if err != nil {
+		return fmt.Errorf("error requeueing outbox message: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("outbox message %d not found or already sent", id)
+	}
+	return nil
+}
+
+// ResendDeadOutboxMessages puts every dead message back in the queue
+func ResendDeadOutboxMessages(db *sql.DB) (int, error) {
+	result, err := db.Exec(`
+		UPDATE email_outbox
+		SET status = 'pending', attempts = 0, next_attempt_at = NOW(), locked_at = NULL
+		WHERE status = 'dead'
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("error requeueing dead outbox messages: %w", err)
+	}
+	affected, _ := result.RowsAffected()
+	return int(affected), nil
+}
+
+// deliverNextOutboxMessage claims one due message and tries to send it. Returns false when nothing is due.
+func deliverNextOutboxMessage(db *sql.DB) (bool, error) {
+	// SKIP LOCKED lets workers (and other app instances) claim different messages concurrently
+	row := db.QueryRow(`
+		UPDATE email_outbox
+		SET status = 'sending', locked_at = NOW(), attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM email_outbox
+			WHERE (status = 'pending' AND next_attempt_at <= NOW())
+			   OR (status = 'sending' AND locked_at < NOW() - make_interval(secs => $1))
+			ORDER BY next_attempt_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+outboxColumns,
+		outboxStaleLock.Seconds(),
+	)
+
+	message, err := scanOutboxMessage(row)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error claiming outbox message: %w", err)
+	}
+
+	// The address may have bounced or complained since the message was queued
+	var suppressed bool
+	if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM email_suppressions WHERE email = LOWER($1))`, message.Recipient).Scan(&suppressed); err != nil {
+		log.Printf("Erro ao consultar supressão de %s: %v", message.Recipient, err)
+	}
+	if suppressed {
+		_, err = db.Exec(`UPDATE email_outbox SET status = 'suppressed', locked_at = NULL WHERE id = $1`, message.ID)
+		if err != nil {
+			return true, fmt.Errorf("error marking outbox message %d as suppressed: %w", message.ID, err)
+		}
+		return true, nil
+	}
+
+	sendErr := SendEmail(message.Recipient, EmailTemplate{
+		Subject:         message.Subject,
+		Body:            message.Body,
+		HTML:            message.HTMLBody,
+		ListUnsubscribe: message.ListUnsubscribe,
+	})
+	if sendErr == nil {
+		_, err = db.Exec(`
+			UPDATE email_outbox SET status = 'sent', sent_at = NOW(), locked_at = NULL, last_error = NULL
+			WHERE id = $1
+		`, message.ID)
+		if err != nil {
+			return true, fmt.Errorf("error marking outbox message %d as sent: %w", message.ID, err)
+		}
+		return true, nil
+	}
+
+	if message.Attempts >= message.MaxAttempts {
+		log.Printf("Email %d para %s descartado após %d tentativas: %v", message.ID, message.Recipient, message.Attempts, sendErr)
+		_, err = db.Exec(`
+			UPDATE email_outbox SET status = 'dead', locked_at = NULL, last_error = $2
+			WHERE id = $1
+		`, message.ID, sendErr.Error())
+	} else {
+		_, err = db.Exec(`
+			UPDATE email_outbox SET status = 'pending', locked_at = NULL, last_error = $2, next_attempt_at = $3
+			WHERE id = $1
+		`, message.ID, sendErr.Error(), time.Now().Add(outboxBackoff(message.Attempts)))
+	}
+	if err != nil {
+		return true, fmt.Errorf("error recording failure of outbox message %d: %w", message.ID, err)
+	}
+	return true, nil
+}
+
+// outboxBackoff returns the delay before the next attempt: 1, 2, 4, 8... minutes, capped
+func outboxBackoff(attempts int) time.Duration {
+	delay := outboxBaseBackoff
+	for i := 1; i < attempts && delay < outboxMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > outboxMaxBackoff {
+		delay = outboxMaxBackoff
+	}
+	return delay
+}
+
+// outboxKey builds the idempotency key of an event sent to a recipient
+func outboxKey(event, to string) string {
+	return event + "|" + strings.ToLower(to)
+}
+
+// scanOutboxMessage scans a row selected with outboxColumns
+func scanOutboxMessage(row rowScanner) (*models.OutboxMessage, error) {
+	message := &models.OutboxMessage{}
+	var sentAt sql.NullTime
+
+	err := row.Scan(
+		&message.ID,
+		&message.IdempotencyKey,
+		&message.Recipient,
+		&message.Subject,
+		&message.Body,
+		&message.HTMLBody,
+		&message.ListUnsubscribe,
+		&message.Status,
+		&message.Attempts,
+		&message.MaxAttempts,
+		&message.NextAttemptAt,
+		&message.LastError,
+		&message.CreatedAt,
+		&sentAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if sentAt.Valid {
+		message.SentAt = &sentAt.Time
+	}
+	return message, nil
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"io"
+	"olhourbano2/models"
+	"time"
+)
+
+const (
+	photoLocationMaxDistance = 300.0               // Meters between a photo and the pin for it to confirm the location
+	photoMaxAge              = 30 * 24 * time.Hour // Older photos don't show the current state of the place
+	photoClockSkew           = 24 * time.Hour      // Capture times up to this far ahead are taken as camera clock drift
+)
+
+// PhotoCapture is where and when a photo was taken, read from its EXIF before it is stripped.
+// It is only kept in memory; reports store the LocationCheck derived from it.
+type PhotoCapture struct {
+	HasLocation bool
+	Latitude    float64
+	Longitude   float64
+	TakenAt     time.Time // Zero when the camera didn't record it
+}
+
+// PinLocation is where the pin of the report form is
+type PinLocation struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// DerivedLocationCheck is the location check of a single photo, which resumable uploads keep
+// instead of its capture until a report claims them. Pin is where the report pin was when it
+// was derived; without one, only the capture time counted.
+type DerivedLocationCheck struct {
+	Outcome string
+	Pin     *PinLocation
+}
+
+// DeriveLocationCheck checks a photo against the pin of the form sending it, nil when none is placed
+func DeriveLocationCheck(capture *PhotoCapture, pin *PinLocation, now time.Time) *DerivedLocationCheck {
+	upload := &FileUploadResult{Capture: capture}
+	if pin != nil {
+		return &DerivedLocationCheck{Outcome: CheckPhotoLocation([]*FileUploadResult{upload}, pin.Latitude, pin.Longitude, now), Pin: pin}
+	}
+	if capture != nil {
+		// A location without a pin to compare it with proves nothing
+		capture = &PhotoCapture{TakenAt: capture.TakenAt}
+	}
+	upload.Capture = capture
+	return &DerivedLocationCheck{Outcome: CheckPhotoLocation([]*FileUploadResult{upload}, 0, 0, now)}
+}
+
+// outcomeAt returns the outcome of a derived check for a report pinned at the coordinates, or
+// "" when it was derived against a pin that has moved since
+func (c *DerivedLocationCheck) outcomeAt(latitude, longitude float64) string {
+	if c.Pin != nil && haversineMeters(latitude, longitude, c.Pin.Latitude, c.Pin.Longitude) > 1 {
+		return ""
+	}
+	return c.Outcome
+}
+
+// captureFromEXIF picks the capture fields of parsed EXIF tags; nil when there are none
+func captureFromEXIF(tags *exifTags) *PhotoCapture {
+	if !tags.HasGPS && tags.TakenAt.IsZero() {
+		return nil
+	}
+	return &PhotoCapture{
+		HasLocation: tags.HasGPS,
+		Latitude:    tags.Latitude,
+		Longitude:   tags.Longitude,
+		TakenAt:     tags.TakenAt,
+	}
+}
+
+// readFileCapture reads the capture fields of an image file
+func readFileCapture(filePath string) *PhotoCapture {
+	tags, err := readFileEXIF(filePath)
+	if err != nil {
+		return nil
+	}
+	return captureFromEXIF(tags)
+}
+
+// ReadPhotoCapture reads the capture fields from the start of an image, which is where the
+// EXIF block is; nil when the image has none
+func ReadPhotoCapture(r io.Reader) (*PhotoCapture, error) {
+	data, err := io.ReadAll(io.LimitReader(r, exifReadLimit))
+	if err != nil {
+		return nil, err
+	}
+	return captureFromEXIF(parseEXIF(findEXIF(data))), nil
+}
+
+// CheckPhotoLocation derives the location check of a report from the photos sent with it.
+// One recent photo taken near the pin verifies the report; otherwise a photo taken far away
+// flags it before an old one does, since that is the stronger sign the reporter wasn't there.
+func CheckPhotoLocation(uploads []*FileUploadResult, latitude, longitude float64, now time.Time) string {
+	far, old := false, false
+	for _, upload := range uploads {
+		capture := upload.Capture
+		if capture == nil {
+			if upload.LocationCheck != nil {
+				switch upload.LocationCheck.outcomeAt(latitude, longitude) {
+				case models.LocationCheckVerified:
+					return models.LocationCheckVerified
+				case models.LocationCheckFar:
+					far = true
+				case models.LocationCheckOld:
+					old = true
+				}
+			}
+			continue
+		}
+
+		near := false
+		if capture.HasLocation {
+			near = haversineMeters(latitude, longitude, capture.Latitude, capture.Longitude) <= photoLocationMaxDistance
+			far = far || !near
+		}
+
+		recent := false
+		if !capture.TakenAt.IsZero() {
+			age := now.Sub(capture.TakenAt)
+			recent = age <= photoMaxAge && age >= -photoClockSkew
+			old = old || age > photoMaxAge
+		}
+
+		if near && recent {
+			return models.LocationCheckVerified
+		}
+	}
+
+	switch {
+	case far:
+		return models.LocationCheckFar
+	case old:
+		return models.LocationCheckOld
+	default:
+		return models.LocationCheckUnverified
+	}
+}
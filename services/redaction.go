@@ -0,0 +1,183 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Kinds of personal data masked in public texts
+const (
+	PIIKindCPF   = "cpf"
+	PIIKindCNPJ  = "cnpj"
+	PIIKindPhone = "phone"
+	PIIKindEmail = "email"
+	PIIKindPlate = "plate"
+)
+
+// piiMasks are the placeholders that replace each kind of personal data in public texts
+var piiMasks = map[string]string{
+	PIIKindCPF:   "[CPF oculto]",
+	PIIKindCNPJ:  "[CNPJ oculto]",
+	PIIKindPhone: "[telefone oculto]",
+	PIIKindEmail: "[email oculto]",
+	PIIKindPlate: "[placa oculta]",
+}
+
+// piiDetector finds candidates of one kind of personal data; valid confirms a candidate, so
+// numbers that merely look like a CPF or a phone are kept
+type piiDetector struct {
+	kind    string
+	pattern *regexp.Regexp
+	valid   func(match string) bool
+}
+
+// piiDetectors run in order, so emails go before the numbers they may contain and CNPJs and
+// CPFs before the phone numbers they look like
+var piiDetectors = []piiDetector{
+	{PIIKindEmail, regexp.MustCompile(`[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}`), nil},
+	{PIIKindCNPJ, regexp.MustCompile(`\b\d{2}\.?\d{3}\.?\d{3}/?\d{4}-?\d{2}\b`), ValidateCNPJ},
+	{PIIKindCPF, regexp.MustCompile(`\b\d{3}\.?\d{3}\.?\d{3}[-.]?\d{2}\b`), ValidateCPF},
+	// Old plates (ABC-1234) and Mercosul plates (ABC1D23)
+	{PIIKindPlate, regexp.MustCompile(`(?i)\b[A-Z]{3}-?\d{4}\b|\b[A-Z]{3}\d[A-Z]\d{2}\b`), nil},
+	// Phones start at a word boundary, after +55 or after the area code in parentheses, so
+	// digits in the middle of a longer number are never taken for one
+	{PIIKindPhone, regexp.MustCompile(`(?:\+55[\s-]?)?(?:\(\d{2}\)[\s-]?|\b(?:55)?\d{2}[\s-]?|\b)9?\d{4}[\s-]?\d{4}\b`), validPhone},
+}
+
+// validPhone accepts numbers with a Brazilian area code, or local numbers written with a separator
+// (9999-9999), which tells them apart from protocol and bus numbers
+func validPhone(match string) bool {
+	digits := NormalizeCPF(match)
+	if (len(digits) == 12 || len(digits) == 13) && strings.HasPrefix(digits, "55") {
+		digits = digits[2:]
+	}
+
+	switch len(digits) {
+	case 10, 11:
+		if digits[0] == '0' || digits[1] == '0' {
+			return false
+		}
+		return len(digits) == 10 || digits[2] == '9'
+	case 8, 9:
+		return strings.ContainsAny(match, "- ")
+	}
+	return false
+}
+
+// RedactPII masks CPFs, CNPJs, phone numbers, emails and vehicle plates in a text meant to be
+// public. Returns the masked text and the kinds of personal data found, if any.
+func RedactPII(text string) (string, []string) {
+	found := make(map[string]bool)
+	for _, detector := range piiDetectors {
+		text = detector.pattern.ReplaceAllStringFunc(text, func(match string) string {
+			if detector.valid != nil && !detector.valid(match) {
+				return match
+			}
+			found[detector.kind] = true
+			return piiMasks[detector.kind]
+		})
+	}
+
+	kinds := make([]string, 0, len(found))
+	for kind := range found {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return text, kinds
+}
+
+// piiCipher is the AES-GCM cipher for the originals of redacted texts; main sets it up from config
+var piiCipher cipher.AEAD
+
+// SetPIIKey builds the cipher for the originals of redacted texts from the configured key
+func SetPIIKey(key string) error {
+	if key == "" {
+		return errors.New("PII key is empty")
+	}
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	piiCipher = gcm
+	return nil
+}
+
+// encryptPII seals a text with a random nonce, which is stored before the ciphertext
+func encryptPII(text string) ([]byte, error) {
+	gcm := piiCipher
+	if gcm == nil {
+		return nil, errors.New("PII key is not set")
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, []byte(text), nil), nil
+}
+
+// decryptPII opens a text sealed by encryptPII
+func decryptPII(data []byte) (string, error) {
+	gcm := piiCipher
+	if gcm == nil {
+		return "", errors.New("PII key is not set")
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("encrypted text is too short")
+	}
+	text, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("error decrypting text: %w", err)
+	}
+	return string(text), nil
+}
+
+// saveRedactedOriginal encrypts and stores the original of a redacted text for the report
+// description or comment named by column
+func saveRedactedOriginal(db sqlExecer, column string, id int, original string, kinds []string) error {
+	ciphertext, err := encryptPII(original)
+	if err != nil {
+		return fmt.Errorf("error encrypting original text: %w", err)
+	}
+
+	_, err = db.Exec(`INSERT INTO redacted_originals (`+column+`, kinds, ciphertext, created_at) VALUES ($1, $2, $3, NOW())`,
+		id, pq.Array(kinds), ciphertext)
+	if err != nil {
+		return fmt.Errorf("error saving original text: %w", err)
+	}
+	return nil
+}
+
+// GetReportOriginal returns the original description of a report for moderators, or an empty
+// string when nothing was masked
+func GetReportOriginal(db *sql.DB, reportID int) (string, error) {
+	var ciphertext []byte
+	err := db.QueryRow(`
+		SELECT ciphertext FROM redacted_originals
+		WHERE report_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT 1
+	`, reportID).Scan(&ciphertext)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error querying original description: %w", err)
+	}
+	return decryptPII(ciphertext)
+}
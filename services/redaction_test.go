@@ -0,0 +1,84 @@
+package services
+
+import (
+	"crypto/cipher"
+	"reflect"
+	"testing"
+)
+
+func TestRedactPII(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		want  string
+		kinds []string
+	}{
+		{"formatted CPF", "Meu CPF é 529.982.247-25.", "Meu CPF é [CPF oculto].", []string{PIIKindCPF}},
+		{"bare CPF", "cpf 52998224725", "cpf [CPF oculto]", []string{PIIKindCPF}},
+		{"invalid CPF kept", "cpf 123.456.789-00", "cpf 123.456.789-00", []string{}},
+		{"formatted CNPJ", "CNPJ 11.222.333/0001-81", "CNPJ [CNPJ oculto]", []string{PIIKindCNPJ}},
+		{"bare CNPJ", "cnpj 11222333000181", "cnpj [CNPJ oculto]", []string{PIIKindCNPJ}},
+		{"mobile with area code", "ligue (11) 98765-4321", "ligue [telefone oculto]", []string{PIIKindPhone}},
+		{"mobile without separators", "ligue 11987654321", "ligue [telefone oculto]", []string{PIIKindPhone}},
+		{"mobile with country code", "ligue +55 11 98765-4321", "ligue [telefone oculto]", []string{PIIKindPhone}},
+		{"local number with separator", "ligue 9876-5432", "ligue [telefone oculto]", []string{PIIKindPhone}},
+		{"protocol number kept", "protocolo 20240012345678", "protocolo 20240012345678", []string{}},
+		{"bus line kept", "linha 875A-10 e 8751", "linha 875A-10 e 8751", []string{}},
+		{"court case number kept", "processo 0012345-67.2024.8.26.0100", "processo 0012345-67.2024.8.26.0100", []string{}},
+		{"old plate", "placa ABC-1234", "placa [placa oculta]", []string{PIIKindPlate}},
+		{"Mercosul plate", "placa abc1d23", "placa [placa oculta]", []string{PIIKindPlate}},
+		{"email before its digits", "escreva para joao1234@example.com.br", "escreva para [email oculto]", []string{PIIKindEmail}},
+		{
+			"several kinds",
+			"Carro ABC1D23, dono 529.982.247-25, tel (21) 99876-5432",
+			"Carro [placa oculta], dono [CPF oculto], tel [telefone oculto]",
+			[]string{PIIKindCPF, PIIKindPhone, PIIKindPlate},
+		},
+		{"nothing to mask", "Buraco na rua há 3 meses", "Buraco na rua há 3 meses", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, kinds := RedactPII(tt.text)
+			if got != tt.want {
+				t.Errorf("RedactPII(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+			if !reflect.DeepEqual(kinds, tt.kinds) {
+				t.Errorf("RedactPII(%q) kinds = %v, want %v", tt.text, kinds, tt.kinds)
+			}
+		})
+	}
+}
+
+func TestPIIEncryption(t *testing.T) {
+	defer func(previous cipher.AEAD) { piiCipher = previous }(piiCipher)
+
+	if err := SetPIIKey(""); err == nil {
+		t.Fatal("SetPIIKey accepted an empty key")
+	}
+	if err := SetPIIKey("test key"); err != nil {
+		t.Fatal(err)
+	}
+
+	original := "Meu CPF é 529.982.247-25"
+	sealed, err := encryptPII(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened, err := decryptPII(sealed)
+	if err != nil || opened != original {
+		t.Fatalf("decryptPII() = %q, %v, want %q", opened, err, original)
+	}
+
+	sealed[len(sealed)-1] ^= 1
+	if _, err := decryptPII(sealed); err == nil {
+		t.Error("decryptPII accepted a tampered text")
+	}
+
+	if err := SetPIIKey("another key"); err != nil {
+		t.Fatal(err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, err := decryptPII(sealed); err == nil {
+		t.Error("decryptPII opened a text sealed with another key")
+	}
+}
@@ -0,0 +1,477 @@
+package services
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/textproto"
+	"olhourbano2/config"
+	"olhourbano2/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	UploadStagingDir      = "./uploads/staging"
+	ResumableUploadExpiry = 24 * time.Hour // Unfinished uploads, and finished ones no report claimed, are dropped after this
+	maxUploadFilenameLen  = 255
+)
+
+// Errors of resumable uploads, which the tus handlers turn into status codes
+var (
+	ErrUploadNotFound       = errors.New("upload not found")
+	ErrUploadOffsetMismatch = errors.New("upload offset doesn't match")
+	ErrUploadBusy           = errors.New("upload is being written by another request")
+	ErrUploadTooLarge       = errors.New("upload exceeds its length")
+)
+
+// UploadRejectedError is a file refused by validation, as opposed to one that couldn't be read,
+// cleaned or stored; Error is the message for the user
+type UploadRejectedError struct {
+	Reason string
+}
+
+func (e *UploadRejectedError) Error() string {
+	return e.Reason
+}
+
+// isUploadRejection reports whether ProcessFileUpload refused a file for what it is. Other
+// errors are failures to read, clean or store it, which sending it again may get past.
+func isUploadRejection(err error) bool {
+	var rejected *UploadRejectedError
+	return errors.As(err, &rejected) || errors.Is(err, ErrFileTypeMismatch) || errors.Is(err, ErrSuspiciousFile)
+}
+
+// State of the uploads this instance is handling. Chunks are staged on local disk, so an upload
+// is resumed on the instance that started it.
+var resumableUploads = struct {
+	sync.Mutex
+	writing map[string]bool
+}{
+	writing: make(map[string]bool),
+}
+
+// resumableUploadColumns are the columns scanned by scanResumableUpload
+const resumableUploadColumns = `id, category, filename, COALESCE(filetype, ''), upload_length, upload_offset,
+	COALESCE(attachment_id, 0), created_at, expires_at`
+
+// scanResumableUpload reads a row selected with resumableUploadColumns
+func scanResumableUpload(row rowScanner) (*models.ResumableUpload, error) {
+	upload := &models.ResumableUpload{}
+	err := row.Scan(&upload.ID, &upload.Category, &upload.Filename, &upload.FileType, &upload.Length, &upload.Offset,
+		&upload.AttachmentID, &upload.CreatedAt, &upload.ExpiresAt)
+	return upload, err
+}
+
+// IsStagingKey reports whether a storage key is a partial upload, which is never served
+func IsStagingKey(key string) bool {
+	return strings.HasPrefix(key, UploadKey(UploadStagingDir)+"/")
+}
+
+// stagingPathFor returns where the chunks of an upload are written
+func stagingPathFor(id string) string {
+	return filepath.Join(UploadStagingDir, id)
+}
+
+// CreateResumableUpload starts an upload of length bytes for a report of a category
+func CreateResumableUpload(db *sql.DB, category, filename, filetype string, length int64) (*models.ResumableUpload, error) {
+	if config.GetCategory(category) == nil {
+		return nil, &UploadRejectedError{Reason: "categoria inválida"}
+	}
+	if length <= 0 {
+		return nil, &UploadRejectedError{Reason: "tamanho de arquivo inválido"}
+	}
+	if length > MaxFileSize {
+		return nil, ErrUploadTooLarge
+	}
+	filename = filepath.Base(filepath.Clean("/" + filename))
+	if len(filename) > maxUploadFilenameLen {
+		filename = filename[len(filename)-maxUploadFilenameLen:]
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	id := hex.EncodeToString(token)
+
+	if err := os.MkdirAll(UploadStagingDir, 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(stagingPathFor(id), nil, 0644); err != nil {
+		return nil, err
+	}
+
+	upload, err := scanResumableUpload(db.QueryRow(`
+		INSERT INTO resumable_uploads (id, category, filename, filetype, upload_length, created_at, expires_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, NOW(), NOW() + make_interval(secs => $6))
+		RETURNING `+resumableUploadColumns,
+		id, category, filename, filetype, length, ResumableUploadExpiry.Seconds()))
+	if err != nil {
+		os.Remove(stagingPathFor(id))
+		return nil, fmt.Errorf("error saving upload: %w", err)
+	}
+	return upload, nil
+}
+
+// GetResumableUpload returns an upload that hasn't expired
+func GetResumableUpload(db *sql.DB, id string) (*models.ResumableUpload, error) {
+	upload, err := scanResumableUpload(db.QueryRow(`
+		SELECT `+resumableUploadColumns+` FROM resumable_uploads WHERE id = $1 AND expires_at > NOW()
+	`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrUploadNotFound
+	}
+	return upload, err
+}
+
+// lockResumableUpload keeps two requests from writing the same upload at once
+func lockResumableUpload(id string) bool {
+	resumableUploads.Lock()
+	defer resumableUploads.Unlock()
+	if resumableUploads.writing[id] {
+		return false
+	}
+	resumableUploads.writing[id] = true
+	return true
+}
+
+func unlockResumableUpload(id string) {
+	resumableUploads.Lock()
+	defer resumableUploads.Unlock()
+	delete(resumableUploads.writing, id)
+}
+
+// AppendResumableUpload writes a chunk at offset, which must be where the upload stopped. The
+// bytes that arrive are kept even when the connection drops, so the next chunk starts after
+// them. The chunk completing the upload runs the file through ProcessFileUpload, which makes
+// it an attachment without a report, and checks photos against pin, the pin of the form
+// sending it; nil when none is placed yet.
+func AppendResumableUpload(db *sql.DB, id string, offset int64, body io.Reader, pin *PinLocation) (*models.ResumableUpload, error) {
+	if !lockResumableUpload(id) {
+		return nil, ErrUploadBusy
+	}
+	defer unlockResumableUpload(id)
+
+	upload, err := GetResumableUpload(db, id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != upload.Offset {
+		return upload, ErrUploadOffsetMismatch
+	}
+	if !upload.Complete() {
+		if err := writeUploadChunk(db, upload, body); err != nil {
+			return upload, err
+		}
+	}
+
+	// A complete upload without an attachment is one whose processing failed; it is retried
+	if upload.Complete() && upload.AttachmentID == 0 {
+		if err := finishResumableUpload(db, upload, pin); err != nil {
+			return upload, err
+		}
+	}
+	return upload, nil
+}
+
+// writeUploadChunk appends a chunk to the staged file of an upload and records the new offset
+func writeUploadChunk(db *sql.DB, upload *models.ResumableUpload, body io.Reader) error {
+	file, err := os.OpenFile(stagingPathFor(upload.ID), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	// Bytes past the recorded offset are left by a write that failed before it was recorded
+	if err := file.Truncate(upload.Offset); err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := file.Seek(upload.Offset, io.SeekStart); err != nil {
+		file.Close()
+		return err
+	}
+
+	remaining := upload.Length - upload.Offset
+	written, copyErr := io.Copy(file, io.LimitReader(body, remaining+1))
+	if written > remaining {
+		file.Close()
+		return ErrUploadTooLarge
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(`UPDATE resumable_uploads SET upload_offset = $2 WHERE id = $1`, upload.ID, upload.Offset+written); err != nil {
+		return fmt.Errorf("error saving upload offset: %w", err)
+	}
+	upload.Offset += written
+	return copyErr
+}
+
+// finishResumableUpload validates and stores a complete upload and records it as an attachment
+// without a report. Files that fail validation are dropped, since sending them again won't help;
+// on other errors the staged file is kept and the next request for the upload tries again.
+func finishResumableUpload(db *sql.DB, upload *models.ResumableUpload, pin *PinLocation) error {
+	stagingPath := stagingPathFor(upload.ID)
+	file, err := os.Open(stagingPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header := &multipart.FileHeader{Filename: upload.Filename, Size: upload.Length, Header: textproto.MIMEHeader{}}
+	if upload.FileType != "" {
+		header.Header.Set("Content-Type", upload.FileType)
+	}
+	result, err := ProcessFileUpload(file, header, upload.Category)
+	if err != nil {
+		if !isUploadRejection(err) {
+			return err
+		}
+		if _, err := discardResumableUpload(db, upload.ID); err != nil {
+			log.Printf("Error dropping rejected upload %s: %v", upload.ID, err)
+		}
+		return &UploadRejectedError{Reason: err.Error()}
+	}
+
+	// Only the outcome of the location check is kept with the upload, never the capture itself
+	var locationCheck sql.NullString
+	var pinLatitude, pinLongitude sql.NullFloat64
+	if hasRenditions(result.ContentType) {
+		check := DeriveLocationCheck(result.Capture, pin, time.Now())
+		locationCheck = sql.NullString{String: check.Outcome, Valid: true}
+		if check.Pin != nil {
+			pinLatitude = sql.NullFloat64{Float64: check.Pin.Latitude, Valid: true}
+			pinLongitude = sql.NullFloat64{Float64: check.Pin.Longitude, Valid: true}
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	attachment, err := insertAttachment(tx, sql.NullInt64{}, 0, result, models.AttachmentRoleReporter)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		UPDATE resumable_uploads
+		SET attachment_id = $2, location_check = $3, location_check_latitude = $4, location_check_longitude = $5
+		WHERE id = $1
+	`, upload.ID, attachment.ID, locationCheck, pinLatitude, pinLongitude); err != nil {
+		return fmt.Errorf("error saving upload attachment: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing upload attachment: %w", err)
+	}
+	upload.AttachmentID = attachment.ID
+	os.Remove(stagingPath)
+	return nil
+}
+
+// DeleteResumableUpload cancels an upload, dropping its chunks or the attachment made from it
+func DeleteResumableUpload(db *sql.DB, id string) error {
+	if !lockResumableUpload(id) {
+		return ErrUploadBusy
+	}
+	defer unlockResumableUpload(id)
+
+	found, err := discardResumableUpload(db, id)
+	if err == nil && !found {
+		return ErrUploadNotFound
+	}
+	return err
+}
+
+// discardResumableUpload deletes an upload with its staged chunks and, when no report claimed
+// it, its attachment and stored files
+func discardResumableUpload(db *sql.DB, id string) (bool, error) {
+	var attachmentID int
+	err := db.QueryRow(`DELETE FROM resumable_uploads WHERE id = $1 RETURNING COALESCE(attachment_id, 0)`, id).Scan(&attachmentID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	os.Remove(stagingPathFor(id))
+	if attachmentID != 0 {
+		if _, err := deleteUnclaimedAttachments(db, []int64{int64(attachmentID)}); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// deleteUnclaimedAttachments deletes attachments that no report claimed, with their stored
+// files. Returns how many were deleted.
+func deleteUnclaimedAttachments(db *sql.DB, ids []int64) (int, error) {
+	rows, err := db.Query(`
+		DELETE FROM attachments WHERE id = ANY($1) AND report_id IS NULL
+		RETURNING path, COALESCE(original_path, '')
+	`, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	deleted := 0
+	var paths []string
+	for rows.Next() {
+		var publicPath, originalPath string
+		if err := rows.Scan(&publicPath, &originalPath); err != nil {
+			return 0, err
+		}
+		deleted++
+		paths = append(paths, publicPath)
+		if originalPath != "" {
+			paths = append(paths, originalPath)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	store, err := CurrentBlobStore()
+	if err != nil {
+		return 0, err
+	}
+	for _, filePath := range paths {
+		if err := store.Delete(UploadKey(filePath)); err != nil && !errors.Is(err, ErrBlobNotFound) {
+			log.Printf("Error deleting stored file %s: %v", filePath, err)
+		}
+	}
+	return deleted, nil
+}
+
+// CompletedUploads returns the finished uploads of a category that no report claimed yet, in
+// the order given, ready to be passed to CreateAttachments. Unknown, unfinished and expired
+// uploads are skipped, like files that fail to upload with the form.
+func CompletedUploads(db *sql.DB, category string, ids []string) ([]*FileUploadResult, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	rows, err := db.Query(`
+		SELECT u.id, a.id, a.path, COALESCE(a.original_name, ''), COALESCE(a.content_type, ''), COALESCE(a.byte_size, 0),
+			u.location_check, u.location_check_latitude, u.location_check_longitude
+		FROM resumable_uploads u
+		JOIN attachments a ON a.id = u.attachment_id
+		WHERE u.id = ANY($1) AND u.category = $2 AND u.expires_at > NOW() AND a.report_id IS NULL
+	`, pq.Array(ids), category)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := make(map[string]*FileUploadResult)
+	for rows.Next() {
+		var id string
+		var locationCheck sql.NullString
+		var pinLatitude, pinLongitude sql.NullFloat64
+		result := &FileUploadResult{}
+		if err := rows.Scan(&id, &result.AttachmentID, &result.SavedPath, &result.OriginalName, &result.ContentType, &result.FileSize,
+			&locationCheck, &pinLatitude, &pinLongitude); err != nil {
+			return nil, err
+		}
+		if locationCheck.Valid {
+			result.LocationCheck = &DerivedLocationCheck{Outcome: locationCheck.String}
+			if pinLatitude.Valid && pinLongitude.Valid {
+				result.LocationCheck.Pin = &PinLocation{Latitude: pinLatitude.Float64, Longitude: pinLongitude.Float64}
+			}
+		}
+		found[id] = result
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var results []*FileUploadResult
+	for _, id := range ids {
+		if result, ok := found[id]; ok {
+			results = append(results, result)
+			delete(found, id)
+		}
+	}
+	return results, nil
+}
+
+// CleanupResumableUploads drops expired uploads: their staged chunks and the attachments made
+// from the ones no report claimed. Returns how many uploads were dropped.
+func CleanupResumableUploads(db *sql.DB) (int, error) {
+	rows, err := db.Query(`
+		DELETE FROM resumable_uploads WHERE expires_at <= NOW()
+		RETURNING id, COALESCE(attachment_id, 0)
+	`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	dropped := 0
+	var attachmentIDs []int64
+	for rows.Next() {
+		var id string
+		var attachmentID int64
+		if err := rows.Scan(&id, &attachmentID); err != nil {
+			return dropped, err
+		}
+		os.Remove(stagingPathFor(id))
+		if attachmentID != 0 {
+			attachmentIDs = append(attachmentIDs, attachmentID)
+		}
+		dropped++
+	}
+	if err := rows.Err(); err != nil {
+		return dropped, err
+	}
+
+	if len(attachmentIDs) > 0 {
+		if _, err := deleteUnclaimedAttachments(db, attachmentIDs); err != nil {
+			return dropped, err
+		}
+	}
+
+	// Chunks whose upload was never recorded, or whose record is gone, are left on disk
+	entries, err := os.ReadDir(UploadStagingDir)
+	if err != nil && !os.IsNotExist(err) {
+		return dropped, err
+	}
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > ResumableUploadExpiry {
+			os.Remove(filepath.Join(UploadStagingDir, entry.Name()))
+		}
+	}
+	return dropped, nil
+}
+
+// StartResumableUploadCleanup drops expired uploads in the background every interval
+func StartResumableUploadCleanup(db *sql.DB, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			count, err := CleanupResumableUploads(db)
+			if err != nil {
+				log.Printf("Error cleaning up resumable uploads: %v", err)
+			} else if count > 0 {
+				log.Printf("Dropped %d expired resumable uploads", count)
+			}
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"strings"
+)
+
+const (
+	MaxSearchQueryLength = 200
+	searchConfiguration  = "portuguese_unaccent"
+
+	// Private-use characters marking matches in ts_headline output; replaced by <mark> after escaping
+	snippetStartMarker = "\uE000"
+	snippetStopMarker  = "\uE001"
+)
+
+// NormalizeSearchQuery trims and limits a user search query
+func NormalizeSearchQuery(query string) string {
+	query = strings.Join(strings.Fields(query), " ")
+	if len([]rune(query)) > MaxSearchQueryLength {
+		query = string([]rune(query)[:MaxSearchQueryLength])
+	}
+	return query
+}
+
+// searchMatchCondition returns a condition matching reports against the search query in the given placeholder.
+// Full-text matches use Portuguese stemming without accents; trigram word similarity tolerates typos.
+func searchMatchCondition(placeholder string) string {
+	return fmt.Sprintf(
+		"(search_vector @@ websearch_to_tsquery('%s', %s::text) OR immutable_unaccent(lower(%s::text)) <%% search_text)",
+		searchConfiguration, placeholder, placeholder,
+	)
+}
+
+// searchRankExpression returns an expression ranking how well a report matches the search query
+func searchRankExpression(placeholder string) string {
+	return fmt.Sprintf(
+		"(ts_rank_cd(search_vector, websearch_to_tsquery('%s', %s::text)) + 0.5 * word_similarity(immutable_unaccent(lower(%s::text)), search_text))",
+		searchConfiguration, placeholder, placeholder,
+	)
+}
+
+// searchSnippetExpression returns an expression producing a description excerpt with the matched words marked
+func searchSnippetExpression(placeholder string) string {
+	return fmt.Sprintf(
+		"ts_headline('%s', description, websearch_to_tsquery('%s', %s::text), 'StartSel=%s, StopSel=%s, MaxWords=35, MinWords=15, MaxFragments=2, FragmentDelimiter=\" … \"')",
+		searchConfiguration, searchConfiguration, placeholder, snippetStartMarker, snippetStopMarker,
+	)
+}
+
+// highlightSnippet escapes a ts_headline result and turns its markers into <mark> tags
+func highlightSnippet(headline sql.NullString) string {
+	if !headline.Valid {
+		return ""
+	}
+
+	escaped := html.EscapeString(headline.String)
+	escaped = strings.ReplaceAll(escaped, snippetStartMarker, "<mark>")
+	escaped = strings.ReplaceAll(escaped, snippetStopMarker, "</mark>")
+	return escaped
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	StorageGCGracePeriod = 24 * time.Hour // Younger files may belong to a report or media job still being saved
+	StorageGCInterval    = 24 * time.Hour
+)
+
+// storageGCKeptKeys are files of the uploads directory that come with the repository
+var storageGCKeptKeys = map[string]bool{"README.md": true}
+
+// StorageGCResult is what a storage garbage collection found
+type StorageGCResult struct {
+	Scanned        int      // Files listed
+	Deleted        int      // Orphaned files deleted, or that would be in a dry run
+	ReclaimedBytes int64    // Size of the deleted files
+	Missing        []string // Evidence files the database points at that aren't stored
+}
+
+// CollectStorageGarbage deletes stored files that no attachment, rendition or media output
+// points at, such as uploads of reports whose validation or insert failed, once they are older
+// than grace. When the store isn't the uploads directory, leftover working copies there are
+// collected too. Chunks of resumable uploads are left to their own cleanup. With dryRun
+// nothing is deleted.
+func CollectStorageGarbage(db *sql.DB, grace time.Duration, dryRun bool) (*StorageGCResult, error) {
+	store, err := CurrentBlobStore()
+	if err != nil {
+		return nil, err
+	}
+	stores := []BlobStore{store}
+	if !storesInUploadDir(store) {
+		stores = append(stores, NewLocalBlobStore(UploadDir))
+	}
+
+	// References are loaded before listing, so files written since are young enough to be kept
+	referenced, required, err := referencedStorageKeys(db)
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-grace)
+
+	result := &StorageGCResult{}
+	for i, current := range stores {
+		var orphans []BlobInfo
+		err := current.List("", func(info BlobInfo) error {
+			if i == 0 {
+				result.Scanned++
+				delete(required, info.Key)
+			}
+			if referenced[info.Key] || IsStagingKey(info.Key) || storageGCKeptKeys[info.Key] ||
+				strings.HasPrefix(path.Base(info.Key), ".") || info.ModTime.After(cutoff) {
+				return nil
+			}
+			orphans = append(orphans, info)
+			return nil
+		})
+		if err != nil {
+			return result, fmt.Errorf("error listing files: %w", err)
+		}
+
+		for _, orphan := range orphans {
+			if !dryRun {
+				if err := current.Delete(orphan.Key); err != nil {
+					log.Printf("Error deleting orphaned file %s: %v", orphan.Key, err)
+					continue
+				}
+			}
+			result.Deleted++
+			result.ReclaimedBytes += orphan.Size
+		}
+	}
+
+	for key := range required {
+		result.Missing = append(result.Missing, key)
+	}
+	sort.Strings(result.Missing)
+	return result, nil
+}
+
+// referencedStorageKeys returns the keys of every file the database points at, and among them
+// the evidence files themselves, which can't be made again when missing
+func referencedStorageKeys(db *sql.DB) (map[string]bool, map[string]bool, error) {
+	referenced := make(map[string]bool)
+	required := make(map[string]bool)
+	add := func(filePath string) {
+		if filePath != "" {
+			referenced[UploadKey(filePath)] = true
+		}
+	}
+
+	rows, err := db.Query(`
+		SELECT path, COALESCE(thumbnail_path, ''), COALESCE(playback_path, ''), COALESCE(poster_path, ''),
+			COALESCE(sprite_path, ''), COALESCE(original_path, ''), anonymize_status
+		FROM attachments
+	`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error querying attachments: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var filePath, thumbnailPath, playbackPath, posterPath, spritePath, originalPath, anonymizeStatus string
+		if err := rows.Scan(&filePath, &thumbnailPath, &playbackPath, &posterPath, &spritePath, &originalPath, &anonymizeStatus); err != nil {
+			return nil, nil, err
+		}
+		// Thumbnails of attachments not yet backfilled are named after the file
+		for _, p := range []string{filePath, thumbnailPath, thumbnailPathFor(filePath), playbackPath, posterPath, spritePath, originalPath} {
+			add(p)
+		}
+		// The public copy of a blurred photo only exists once the blurring is done
+		if originalPath == "" || anonymizeStatus == "ready" {
+			required[UploadKey(filePath)] = true
+		}
+		if originalPath != "" {
+			required[UploadKey(originalPath)] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	renditions, err := db.Query(`SELECT path FROM attachment_renditions`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error querying renditions: %w", err)
+	}
+	defer renditions.Close()
+	for renditions.Next() {
+		var filePath string
+		if err := renditions.Scan(&filePath); err != nil {
+			return nil, nil, err
+		}
+		add(filePath)
+	}
+	return referenced, required, renditions.Err()
+}
+
+// StartStorageGCScheduler deletes orphaned files in the background every interval
+func StartStorageGCScheduler(db *sql.DB, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			result, err := CollectStorageGarbage(db, StorageGCGracePeriod, false)
+			if err != nil {
+				log.Printf("Error collecting orphaned files: %v", err)
+			} else if result.Deleted > 0 || len(result.Missing) > 0 {
+				log.Printf("Deleted %d orphaned files (%d bytes reclaimed); %d referenced files missing",
+					result.Deleted, result.ReclaimedBytes, len(result.Missing))
+			}
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,624 @@
+package services
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"olhourbano2/config"
+	"olhourbano2/models"
+	"strings"
+	"time"
+)
+
+const (
+	MaxSubscriptionsPerEmail    = 20
+	MinSubscriptionRadiusMeters = 100
+	MaxSubscriptionRadiusMeters = 20000
+	DefaultSubscriptionRadius   = 1000
+	MaxSubscriptionPolygonBytes = 100 * 1024
+	SubscriptionDigestInterval  = 24 * time.Hour
+	SubscriptionMatchInterval   = 5 * time.Second // New reports are matched shortly after being saved
+	SubscriptionMatchAttempts   = 5               // Runs a report gets before the matcher gives up on it
+)
+
+// subscriptionColumns lists the columns scanned by scanSubscription
+const subscriptionColumns = `
+	s.id, s.email, s.category, s.city, s.neighborhood_id, COALESCE(n.name, ''), s.polygon,
+	s.center_latitude, s.center_longitude, s.radius_meters, s.frequency, s.locale,
+	s.confirm_token, s.unsubscribe_token, s.confirmed_at, s.unsubscribed_at, s.last_digest_at, s.created_at
+`
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// subscriptionDigestItem summarizes a matched report for the daily digest
+type subscriptionDigestItem struct {
+	ReportID    int
+	Category    string
+	Location    string
+	Description string
+}
+
+// ValidateSubscription checks a subscription request and returns user-facing errors
+func ValidateSubscription(sub *models.Subscription) []string {
+	var errors []string
+
+	if !ValidateEmail(sub.Email) {
+		errors = append(errors, "Email inválido")
+	}
+
+	if sub.Category != "" && config.GetCategory(sub.Category) == nil {
+		errors = append(errors, "Categoria inválida")
+	}
+
+	if sub.Frequency != models.FrequencyInstant && sub.Frequency != models.FrequencyDaily {
+		errors = append(errors, "Frequência inválida")
+	}
+
+	if sub.RadiusMeters > 0 {
+		if sub.CenterLatitude < -90 || sub.CenterLatitude > 90 || sub.CenterLongitude < -180 || sub.CenterLongitude > 180 ||
+			(sub.CenterLatitude == 0 && sub.CenterLongitude == 0) {
+			errors = append(errors, "Localização do raio inválida")
+		}
+		if sub.RadiusMeters < MinSubscriptionRadiusMeters || sub.RadiusMeters > MaxSubscriptionRadiusMeters {
+			errors = append(errors, fmt.Sprintf("O raio deve estar entre %d e %d metros", MinSubscriptionRadiusMeters, MaxSubscriptionRadiusMeters))
+		}
+	}
+
+	if len(sub.Polygon) > MaxSubscriptionPolygonBytes {
+		errors = append(errors, "Polígono muito grande")
+	} else if len(sub.Polygon) > 0 {
+		var geometry geoJSONGeometry
+		if err := json.Unmarshal(sub.Polygon, &geometry); err != nil {
+			errors = append(errors, "Polígono inválido")
+		} else if _, err := parsePolygons(geometry); err != nil {
+			errors = append(errors, "Polígono inválido: use um Polygon ou MultiPolygon GeoJSON")
+		}
+	}
+
+	if sub.Category == "" && sub.City == "" && sub.NeighborhoodID == 0 && sub.RadiusMeters == 0 && len(sub.Polygon) == 0 {
+		errors = append(errors, "Escolha ao menos uma categoria ou uma área")
+	}
+
+	return errors
+}
+
+// CreateSubscription stores an unconfirmed subscription and returns it with its tokens
+func CreateSubscription(db *sql.DB, sub *models.Subscription) (*models.Subscription, error) {
+	sub.Email = strings.ToLower(strings.TrimSpace(sub.Email))
+
+	var active int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM subscriptions
+		WHERE LOWER(email) = $1 AND unsubscribed_at IS NULL
+	`, sub.Email).Scan(&active)
+	if err != nil {
+		return nil, fmt.Errorf("error counting subscriptions: %w", err)
+	}
+	if active >= MaxSubscriptionsPerEmail {
+		return nil, fmt.Errorf("limite de %d alertas por email atingido", MaxSubscriptionsPerEmail)
+	}
+
+	confirmToken, err := generateEmailToken()
+	if err != nil {
+		return nil, err
+	}
+	unsubscribeToken, err := generateEmailToken()
+	if err != nil {
+		return nil, err
+	}
+
+	var neighborhoodID sql.NullInt64
+	if sub.NeighborhoodID > 0 {
+		neighborhoodID = sql.NullInt64{Int64: int64(sub.NeighborhoodID), Valid: true}
+	}
+
+	// Polygon and radius areas get a bounding box so the matcher can pre-filter in SQL
+	var polygon sql.NullString
+	var centerLat, centerLng, radius, minLat, maxLat, minLng, maxLng sql.NullFloat64
+	if len(sub.Polygon) > 0 {
+		var geometry geoJSONGeometry
+		if err := json.Unmarshal(sub.Polygon, &geometry); err != nil {
+			return nil, fmt.Errorf("invalid polygon: %w", err)
+		}
+		polygons, err := parsePolygons(geometry)
+		if err != nil {
+			return nil, err
+		}
+		a, b, c, d := polygonsBoundingBox(polygons)
+		polygon = sql.NullString{String: string(sub.Polygon), Valid: true}
+		minLat, maxLat = sql.NullFloat64{Float64: a, Valid: true}, sql.NullFloat64{Float64: b, Valid: true}
+		minLng, maxLng = sql.NullFloat64{Float64: c, Valid: true}, sql.NullFloat64{Float64: d, Valid: true}
+	} else if sub.RadiusMeters > 0 {
+		latDelta := sub.RadiusMeters / metersPerDegree
+		lngDelta := sub.RadiusMeters / (metersPerDegree * math.Cos(sub.CenterLatitude*math.Pi/180))
+		centerLat = sql.NullFloat64{Float64: sub.CenterLatitude, Valid: true}
+		centerLng = sql.NullFloat64{Float64: sub.CenterLongitude, Valid: true}
+		radius = sql.NullFloat64{Float64: sub.RadiusMeters, Valid: true}
+		minLat = sql.NullFloat64{Float64: sub.CenterLatitude - latDelta, Valid: true}
+		maxLat = sql.NullFloat64{Float64: sub.CenterLatitude + latDelta, Valid: true}
+		minLng = sql.NullFloat64{Float64: sub.CenterLongitude - lngDelta, Valid: true}
+		maxLng = sql.NullFloat64{Float64: sub.CenterLongitude + lngDelta, Valid: true}
+	}
+
+	sub.Locale = NormalizeEmailLocale(sub.Locale)
+
+	err = db.QueryRow(`
+		INSERT INTO subscriptions (email, category, city, neighborhood_id, polygon, center_latitude, center_longitude, radius_meters,
+			min_latitude, max_latitude, min_longitude, max_longitude, frequency, locale, confirm_token, unsubscribe_token, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, NOW())
+		RETURNING id, created_at
+	`, sub.Email, sub.Category, strings.TrimSpace(sub.City), neighborhoodID, polygon, centerLat, centerLng, radius,
+		minLat, maxLat, minLng, maxLng, sub.Frequency, sub.Locale, confirmToken, unsubscribeToken,
+	).Scan(&sub.ID, &sub.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error creating subscription: %w", err)
+	}
+
+	sub.ConfirmToken = confirmToken
+	sub.UnsubscribeToken = unsubscribeToken
+	return sub, nil
+}
+
+// ConfirmSubscription activates the subscription owning the confirmation token
+func ConfirmSubscription(db *sql.DB, token string) (*models.Subscription, error) {
+	if token == "" {
+		return nil, sql.ErrNoRows
+	}
+
+	var id int
+	var email string
+	err := db.QueryRow(`
+		UPDATE subscriptions
+		SET confirmed_at = NOW(), confirm_token = NULL
+		WHERE confirm_token = $1 AND unsubscribed_at IS NULL
+		RETURNING id, email
+	`, token).Scan(&id, &email)
+	if err != nil {
+		return nil, err
+	}
+
+	// Confirming the alert proves the address, so other notifications can reach it too
+	if err := MarkEmailVerified(db, email); err != nil {
+		return nil, err
+	}
+
+	return GetSubscriptionByID(db, id)
+}
+
+// Unsubscribe cancels the subscription owning the unsubscribe token. Repeated calls are harmless.
+func Unsubscribe(db *sql.DB, token string) (*models.Subscription, error) {
+	if token == "" {
+		return nil, sql.ErrNoRows
+	}
+
+	var id int
+	err := db.QueryRow(`
+		UPDATE subscriptions
+		SET unsubscribed_at = COALESCE(unsubscribed_at, NOW()), confirm_token = NULL
+		WHERE unsubscribe_token = $1
+		RETURNING id
+	`, token).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	return GetSubscriptionByID(db, id)
+}
+
+// GetSubscriptionByID retrieves a subscription with its neighborhood name
+func GetSubscriptionByID(db *sql.DB, id int) (*models.Subscription, error) {
+	row := db.QueryRow(`
+		SELECT `+subscriptionColumns+`
+		FROM subscriptions s
+		LEFT JOIN neighborhoods n ON n.id = s.neighborhood_id
+		WHERE s.id = $1
+	`, id)
+	return scanSubscription(row)
+}
+
+// MatchReportSubscriptions records the active subscriptions matching a new report and
+// emails the instant ones right away. Daily subscriptions are picked up by SendDailyDigests.
+// Each match is recorded in one transaction with its email, so running it again after a
+// failure queues exactly the alerts that are missing.
+func MatchReportSubscriptions(db *sql.DB, reportID int) (int, error) {
+	report, err := GetReportByID(db, reportID)
+	if err != nil {
+		return 0, fmt.Errorf("error loading report: %w", err)
+	}
+
+	hasLocation := report.Latitude != 0 || report.Longitude != 0
+
+	// Category, city, neighborhood and bounding box are checked in SQL; exact areas in Go
+	rows, err := db.Query(`
+		SELECT `+subscriptionColumns+`
+		FROM subscriptions s
+		LEFT JOIN neighborhoods n ON n.id = s.neighborhood_id
+		WHERE s.confirmed_at IS NOT NULL AND s.unsubscribed_at IS NULL
+		  AND (s.category = '' OR s.category = $1)
+		  AND (s.city = '' OR LOWER(s.city) = LOWER($2))
+		  AND (s.neighborhood_id IS NULL OR s.neighborhood_id = $3)
+		  AND (s.min_latitude IS NULL OR ($4 AND $5 BETWEEN s.min_latitude AND s.max_latitude AND $6 BETWEEN s.min_longitude AND s.max_longitude))
+	`, report.ProblemType, report.City, report.NeighborhoodID, hasLocation, report.Latitude, report.Longitude)
+	if err != nil {
+		return 0, fmt.Errorf("error querying subscriptions: %w", err)
+	}
+
+	var matched []*models.Subscription
+	for rows.Next() {
+		sub, err := scanSubscription(rows)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning subscription: %w", err)
+		}
+		if subscriptionContains(sub, report.Latitude, report.Longitude) {
+			matched = append(matched, sub)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	categoryName := report.ProblemType
+	if category := config.GetCategory(report.ProblemType); category != nil {
+		categoryName = category.Name
+	}
+
+	for _, sub := range matched {
+		if err := recordSubscriptionMatch(db, sub, report, categoryName); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(matched), nil
+}
+
+// recordSubscriptionMatch saves the match of a subscription with a report and, for instant
+// subscriptions, queues its alert, all or nothing. Matches already recorded are left alone.
+func recordSubscriptionMatch(db *sql.DB, sub *models.Subscription, report *models.Report, categoryName string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		INSERT INTO subscription_matches (subscription_id, report_id, matched_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (subscription_id, report_id) DO NOTHING
+	`, sub.ID, report.ID)
+	if err != nil {
+		return fmt.Errorf("error recording subscription match: %w", err)
+	}
+	if inserted, _ := result.RowsAffected(); inserted == 0 {
+		return nil
+	}
+
+	if sub.Frequency == models.FrequencyInstant {
+		template, err := GetSubscriptionAlertEmailTemplate(sub, report.ID, categoryName, report.Location, report.Description)
+		if err != nil {
+			return err
+		}
+		emailEvent := fmt.Sprintf("subscription:%d:report:%d", sub.ID, report.ID)
+		if err := EnqueueEmail(tx, emailEvent, sub.Email, template); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`
+			UPDATE subscription_matches SET sent_at = NOW()
+			WHERE subscription_id = $1 AND report_id = $2
+		`, sub.ID, report.ID); err != nil {
+			return fmt.Errorf("error marking subscription match as sent: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing subscription match: %w", err)
+	}
+	return nil
+}
+
+// MatchPendingReportSubscriptions runs the subscription matcher for every report created since the
+// last run. The report row is the queue entry: matching is idempotent, so a report whose run
+// failed half-way is matched again on the next run, after the reports not tried yet, and given
+// up on after SubscriptionMatchAttempts. Returns how many reports were matched.
+func MatchPendingReportSubscriptions(db *sql.DB) (int, error) {
+	rows, err := db.Query(`
+		SELECT id FROM reports
+		WHERE subscriptions_matched_at IS NULL AND subscription_match_attempts < $1
+		ORDER BY subscription_match_attempts, id
+		LIMIT 100
+	`, SubscriptionMatchAttempts)
+	if err != nil {
+		return 0, fmt.Errorf("error querying unmatched reports: %w", err)
+	}
+
+	var reportIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		reportIDs = append(reportIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	matched := 0
+	for _, reportID := range reportIDs {
+		count, err := MatchReportSubscriptions(db, reportID)
+		if err != nil {
+			log.Printf("Erro ao processar alertas da denúncia %d: %v", reportID, err)
+			if _, err := db.Exec(`
+				UPDATE reports
+				SET subscription_match_attempts = subscription_match_attempts + 1, subscription_match_error = $2
+				WHERE id = $1
+			`, reportID, err.Error()); err != nil {
+				return matched, fmt.Errorf("error recording failed match of report %d: %w", reportID, err)
+			}
+			continue
+		}
+		if count > 0 {
+			log.Printf("Denúncia %d corresponde a %d alertas", reportID, count)
+		}
+
+		if _, err := db.Exec(`UPDATE reports SET subscriptions_matched_at = NOW(), subscription_match_error = NULL WHERE id = $1`, reportID); err != nil {
+			return matched, fmt.Errorf("error marking report %d as matched: %w", reportID, err)
+		}
+		matched++
+	}
+	return matched, nil
+}
+
+// StartSubscriptionMatcher alerts subscribers about new reports in the background every interval
+func StartSubscriptionMatcher(db *sql.DB, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := MatchPendingReportSubscriptions(db); err != nil {
+				log.Printf("Error matching report subscriptions: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// SendDailyDigests queues an email to every daily subscription with its unsent matches, at most once per digest interval
+func SendDailyDigests(db *sql.DB) (int, error) {
+	rows, err := db.Query(`
+		SELECT `+subscriptionColumns+`
+		FROM subscriptions s
+		LEFT JOIN neighborhoods n ON n.id = s.neighborhood_id
+		WHERE s.frequency = $1
+		  AND s.confirmed_at IS NOT NULL AND s.unsubscribed_at IS NULL
+		  AND (s.last_digest_at IS NULL OR s.last_digest_at <= NOW() - make_interval(secs => $2))
+		  AND EXISTS (SELECT 1 FROM subscription_matches m WHERE m.subscription_id = s.id AND m.sent_at IS NULL)
+	`, models.FrequencyDaily, SubscriptionDigestInterval.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("error querying digest subscriptions: %w", err)
+	}
+
+	var subscriptions []*models.Subscription
+	for rows.Next() {
+		sub, err := scanSubscription(rows)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning subscription: %w", err)
+		}
+		subscriptions = append(subscriptions, sub)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, sub := range subscriptions {
+		items, err := getPendingDigestItems(db, sub.ID)
+		if err != nil {
+			return sent, err
+		}
+
+		if len(items) > 0 {
+			template, err := GetSubscriptionDigestEmailTemplate(sub, items)
+			if err != nil {
+				return sent, err
+			}
+			emailEvent := fmt.Sprintf("subscription-digest:%d:%s", sub.ID, time.Now().Format("2006-01-02"))
+			if err := EnqueueEmail(db, emailEvent, sub.Email, template); err != nil {
+				return sent, err
+			}
+			sent++
+		}
+
+		// Matches merged away or deleted since are marked too so they don't keep the digest pending
+		_, err = db.Exec(`
+			UPDATE subscription_matches SET sent_at = NOW()
+			WHERE subscription_id = $1 AND sent_at IS NULL
+		`, sub.ID)
+		if err != nil {
+			return sent, fmt.Errorf("error marking digest matches as sent: %w", err)
+		}
+		if _, err := db.Exec(`UPDATE subscriptions SET last_digest_at = NOW() WHERE id = $1`, sub.ID); err != nil {
+			return sent, fmt.Errorf("error updating digest time: %w", err)
+		}
+	}
+
+	return sent, nil
+}
+
+// StartSubscriptionDigestScheduler sends daily digests in the background on a fixed interval
+func StartSubscriptionDigestScheduler(db *sql.DB, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			count, err := SendDailyDigests(db)
+			if err != nil {
+				log.Printf("Error sending subscription digests: %v", err)
+			} else if count > 0 {
+				log.Printf("Sent %d subscription digests", count)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// getPendingDigestItems loads the unsent, unmerged reports matched to a subscription
+func getPendingDigestItems(db *sql.DB, subscriptionID int) ([]subscriptionDigestItem, error) {
+	rows, err := db.Query(`
+		SELECT r.id, r.problem_type, r.location, r.description
+		FROM subscription_matches m
+		JOIN reports r ON r.id = m.report_id
+		WHERE m.subscription_id = $1 AND m.sent_at IS NULL AND r.merged_into_id IS NULL
+		ORDER BY r.created_at ASC
+	`, subscriptionID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying digest matches: %w", err)
+	}
+	defer rows.Close()
+
+	var items []subscriptionDigestItem
+	for rows.Next() {
+		var item subscriptionDigestItem
+		if err := rows.Scan(&item.ReportID, &item.Category, &item.Location, &item.Description); err != nil {
+			return nil, fmt.Errorf("error scanning digest match: %w", err)
+		}
+		if category := config.GetCategory(item.Category); category != nil {
+			item.Category = category.Name
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// SubscriptionSummary describes the filters of a subscription in Portuguese, one per line
+func SubscriptionSummary(sub *models.Subscription) string {
+	var lines []string
+
+	categoryName := "Todas"
+	if sub.Category != "" {
+		categoryName = sub.Category
+		if category := config.GetCategory(sub.Category); category != nil {
+			categoryName = category.Name
+		}
+	}
+	lines = append(lines, "- Categoria: "+categoryName)
+
+	if sub.City != "" {
+		lines = append(lines, "- Cidade: "+sub.City)
+	}
+	if sub.NeighborhoodName != "" {
+		lines = append(lines, "- Bairro: "+sub.NeighborhoodName)
+	}
+	if sub.RadiusMeters > 0 {
+		lines = append(lines, fmt.Sprintf("- Raio de %.0f m ao redor de %.5f, %.5f", sub.RadiusMeters, sub.CenterLatitude, sub.CenterLongitude))
+	}
+	if len(sub.Polygon) > 0 {
+		lines = append(lines, "- Área desenhada no mapa")
+	}
+
+	frequency := "Imediata"
+	if sub.Frequency == models.FrequencyDaily {
+		frequency = "Resumo diário"
+	}
+	lines = append(lines, "- Frequência: "+frequency)
+
+	return strings.Join(lines, "\n")
+}
+
+// subscriptionContains checks the exact polygon or radius of a subscription against a point
+func subscriptionContains(sub *models.Subscription, latitude, longitude float64) bool {
+	if len(sub.Polygon) > 0 {
+		var geometry geoJSONGeometry
+		if err := json.Unmarshal(sub.Polygon, &geometry); err != nil {
+			return false
+		}
+		polygons, err := parsePolygons(geometry)
+		if err != nil {
+			return false
+		}
+		return polygonsContain(polygons, latitude, longitude)
+	}
+
+	if sub.RadiusMeters > 0 {
+		return haversineMeters(sub.CenterLatitude, sub.CenterLongitude, latitude, longitude) <= sub.RadiusMeters
+	}
+
+	return true
+}
+
+// scanSubscription scans a row selected with subscriptionColumns
+func scanSubscription(row rowScanner) (*models.Subscription, error) {
+	sub := &models.Subscription{}
+	var neighborhoodID sql.NullInt64
+	var polygon []byte
+	var centerLat, centerLng, radius sql.NullFloat64
+	var confirmToken sql.NullString
+	var confirmedAt, unsubscribedAt, lastDigestAt sql.NullTime
+
+	err := row.Scan(
+		&sub.ID,
+		&sub.Email,
+		&sub.Category,
+		&sub.City,
+		&neighborhoodID,
+		&sub.NeighborhoodName,
+		&polygon,
+		&centerLat,
+		&centerLng,
+		&radius,
+		&sub.Frequency,
+		&sub.Locale,
+		&confirmToken,
+		&sub.UnsubscribeToken,
+		&confirmedAt,
+		&unsubscribedAt,
+		&lastDigestAt,
+		&sub.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	sub.NeighborhoodID = int(neighborhoodID.Int64)
+	sub.Polygon = polygon
+	sub.CenterLatitude = centerLat.Float64
+	sub.CenterLongitude = centerLng.Float64
+	sub.RadiusMeters = radius.Float64
+	sub.ConfirmToken = confirmToken.String
+	if confirmedAt.Valid {
+		sub.ConfirmedAt = &confirmedAt.Time
+	}
+	if unsubscribedAt.Valid {
+		sub.UnsubscribedAt = &unsubscribedAt.Time
+	}
+	if lastDigestAt.Valid {
+		sub.LastDigestAt = &lastDigestAt.Time
+	}
+
+	return sub, nil
+}
+
+// generateEmailToken returns a random token for links sent by email
+func generateEmailToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("error generating token: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,25 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// hotScoreExpression computes a report's hotness from its counters and age. Every 45000 seconds
+// (12.5 hours) of recency is worth ten times the interactions, so old popular reports fade out
+// without a periodic recomputation; comments weigh twice as much as votes.
+const hotScoreExpression = `LOG(GREATEST(COALESCE(vote_count, 0) + 2 * COALESCE(comment_count, 0), 1)) + EXTRACT(EPOCH FROM created_at) / 45000`
+
+// sqlExecer is satisfied by both *sql.DB and *sql.Tx
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// updateHotScore recalculates the hotness score of a report after its counters change
+func updateHotScore(db sqlExecer, reportID int) error {
+	_, err := db.Exec(`UPDATE reports SET hot_score = `+hotScoreExpression+` WHERE id = $1`, reportID)
+	if err != nil {
+		return fmt.Errorf("error updating hot score: %w", err)
+	}
+	return nil
+}
@@ -76,6 +76,36 @@ func validateCPFCheckDigits(cpf string) bool {
 	return digits[10] == secondCheckDigit
 }
 
+// ValidateCNPJ validates Brazilian CNPJ format and check digits
+func ValidateCNPJ(cnpj string) bool {
+	cnpj = regexp.MustCompile(`\D`).ReplaceAllString(cnpj, "")
+	if len(cnpj) != 14 || strings.Count(cnpj, cnpj[:1]) == 14 {
+		return false
+	}
+
+	digits := make([]int, 14)
+	for i, char := range cnpj {
+		digits[i] = int(char - '0')
+	}
+
+	// Each check digit weighs the previous digits from 2 to 9, right to left
+	for _, length := range []int{12, 13} {
+		sum := 0
+		for i := 0; i < length; i++ {
+			sum += digits[length-1-i] * (2 + i%8)
+		}
+		checkDigit := 0
+		if remainder := sum % 11; remainder >= 2 {
+			checkDigit = 11 - remainder
+		}
+		if digits[length] != checkDigit {
+			return false
+		}
+	}
+
+	return true
+}
+
 // ValidateEmail validates email format using regex
 func ValidateEmail(email string) bool {
 	// Basic email regex pattern
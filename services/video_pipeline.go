@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"olhourbano2/models"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	VideoDir                = "./uploads/videos"
+	MaxVideoDurationSeconds = 180              // Longer videos are rejected on upload
+	maxPlaybackBytes        = 50 * 1024 * 1024 // Same cap as uploads, so a copy is never heavier than allowed
+	playbackMaxSide         = 1280
+	playbackMaxVideoRate    = 2000 // kbit/s, lowered for long videos to fit maxPlaybackBytes
+	playbackAudioRate       = 128  // kbit/s
+	spriteFrames            = 10
+	spriteFrameWidth        = 160
+)
+
+// playbackScale fits a video or its poster into playbackMaxSide on its longest side without
+// upscaling, keeping both sides even as H.264 requires
+var playbackScale = fmt.Sprintf(
+	"scale='if(gte(iw,ih),trunc(min(%[1]d,iw)/2)*2,-2)':'if(gte(iw,ih),-2,trunc(min(%[1]d,ih)/2)*2)'",
+	playbackMaxSide)
+
+// isTranscodable reports whether an attachment gets a web-safe playback copy, a poster and a sprite
+func isTranscodable(contentType string) bool {
+	return strings.HasPrefix(contentType, "video/")
+}
+
+// videoFrameTime returns where the poster and thumbnail of a video are taken: a tenth of the way
+// in and at most 5 seconds, past the black frames most videos open with, or the start when the
+// duration is unknown
+func videoFrameTime(duration float64) float64 {
+	return min(duration*0.1, 5)
+}
+
+// generateAttachmentVideo transcodes a video attachment to H.264/AAC MP4, extracts its poster
+// and preview sprite, keeps the three in the storage backend next to the original and records
+// them on the attachment
+func generateAttachmentVideo(ctx context.Context, db *sql.DB, attachment *models.Attachment) error {
+	if err := os.MkdirAll(VideoDir, 0755); err != nil {
+		return err
+	}
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return fmt.Errorf("ffmpeg is not installed")
+	}
+
+	base := strings.TrimSuffix(filepath.Base(attachment.Path), filepath.Ext(attachment.Path))
+	playbackPath := filepath.Join(VideoDir, base+".mp4")
+	posterPath := filepath.Join(VideoDir, base+"_poster.jpg")
+	spritePath := filepath.Join(VideoDir, base+"_sprite.jpg")
+
+	err := withUploadedFile(attachment.Path, func(localPath string) error {
+		duration := attachment.DurationSeconds
+		if duration <= 0 {
+			_, _, duration = probeVideo(localPath)
+		}
+
+		if err := transcodeVideo(ctx, localPath, playbackPath, duration); err != nil {
+			os.Remove(playbackPath)
+			return err
+		}
+		if err := extractPoster(ctx, localPath, posterPath, duration); err != nil {
+			os.Remove(posterPath)
+			return err
+		}
+		if err := extractSprite(ctx, localPath, spritePath, duration); err != nil {
+			os.Remove(spritePath)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	outputs := map[string]string{playbackPath: "video/mp4", posterPath: "image/jpeg", spritePath: "image/jpeg"}
+	for filePath, contentType := range outputs {
+		if err := storeUploadedFile(filePath, contentType); err != nil {
+			return err
+		}
+		releaseUploadedFile(filePath)
+	}
+
+	_, err = db.Exec(`
+		UPDATE attachments SET playback_path = $2, poster_path = $3, sprite_path = $4, transcode_status = 'ready'
+		WHERE id = $1
+	`, attachment.ID, filepath.ToSlash(playbackPath), filepath.ToSlash(posterPath), filepath.ToSlash(spritePath))
+	return err
+}
+
+// transcodeVideo writes a web-safe copy of a video: H.264 in yuv420p with AAC stereo audio, the
+// index up front so playback starts before the download ends, no metadata, no longer than
+// MaxVideoDurationSeconds and no heavier than maxPlaybackBytes
+func transcodeVideo(ctx context.Context, inputPath, outputPath string, duration float64) error {
+	videoRate := playbackMaxVideoRate
+	if duration > 0 {
+		// Leave a tenth of the budget for the container and rate control overshoot
+		budget := int(float64(maxPlaybackBytes) * 8 / 1000 * 0.9 / min(duration, MaxVideoDurationSeconds))
+		videoRate = max(min(videoRate, budget-playbackAudioRate), 200)
+	}
+
+	err := runFFmpeg(ctx,
+		"-i", inputPath,
+		"-t", strconv.Itoa(MaxVideoDurationSeconds),
+		"-map", "0:v:0",
+		"-map", "0:a:0?",
+		"-map_metadata", "-1",
+		"-vf", playbackScale,
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-profile:v", "main",
+		"-pix_fmt", "yuv420p",
+		"-crf", "26",
+		"-maxrate", fmt.Sprintf("%dk", videoRate),
+		"-bufsize", fmt.Sprintf("%dk", videoRate*2),
+		"-c:a", "aac",
+		"-b:a", fmt.Sprintf("%dk", playbackAudioRate),
+		"-ac", "2",
+		"-movflags", "+faststart",
+		"-fs", strconv.Itoa(maxPlaybackBytes),
+		outputPath,
+	)
+	if err != nil {
+		return err
+	}
+
+	// -fs stops writing at the cap instead of failing, which leaves a cut video
+	info, err := os.Stat(outputPath)
+	if err != nil {
+		return err
+	}
+	if info.Size() >= maxPlaybackBytes {
+		return fmt.Errorf("transcoded video exceeds %d bytes", maxPlaybackBytes)
+	}
+	return nil
+}
+
+// extractPoster writes the frame shown before a video plays
+func extractPoster(ctx context.Context, inputPath, outputPath string, duration float64) error {
+	return runFFmpeg(ctx,
+		"-ss", strconv.FormatFloat(videoFrameTime(duration), 'f', 3, 64),
+		"-i", inputPath,
+		"-frames:v", "1",
+		"-vf", playbackScale,
+		"-q:v", "3",
+		outputPath,
+	)
+}
+
+// extractSprite writes spriteFrames frames spread evenly over a video side by side in one JPEG,
+// so players can preview a position while scrubbing
+func extractSprite(ctx context.Context, inputPath, outputPath string, duration float64) error {
+	rate := 1.0
+	if duration > 0 {
+		rate = spriteFrames / min(duration, MaxVideoDurationSeconds)
+	}
+	return runFFmpeg(ctx,
+		"-i", inputPath,
+		"-t", strconv.Itoa(MaxVideoDurationSeconds),
+		"-vf", fmt.Sprintf("fps=%s,scale=%d:-2,tile=%dx1", strconv.FormatFloat(rate, 'f', 6, 64), spriteFrameWidth, spriteFrames),
+		"-frames:v", "1",
+		"-q:v", "5",
+		outputPath,
+	)
+}
+
+// runFFmpeg runs ffmpeg quietly, overwriting the output, and returns its error output on failure
+func runFFmpeg(ctx context.Context, args ...string) error {
+	args = append([]string{"-hide_banner", "-loglevel", "error", "-y"}, args...)
+	output, err := exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 // Comments functionality
 let currentCommentOffset = 10; // Start with 10 comments loaded
 let currentCommentSort = 'recent';
+const COMMENT_EDIT_WINDOW_MS = 30 * 60 * 1000; // Matches models.CommentEditWindow
 
 // Initialize comments functionality
 document.addEventListener('DOMContentLoaded', function() {
@@ -48,9 +49,188 @@ function initializeComments() {
         });
     }
 
+    // Reply and load more replies buttons, delegated so loaded comments work too
+    const commentsList = document.getElementById('commentsList');
+    if (commentsList) {
+        commentsList.addEventListener('click', function(e) {
+            const replyBtn = e.target.closest('.comment-reply-btn');
+            if (replyBtn) {
+                startReply(replyBtn.dataset.commentId, replyBtn.dataset.author);
+                return;
+            }
+
+            const flagBtn = e.target.closest('.comment-flag-btn');
+            if (flagBtn) {
+                showCommentFlagModal(flagBtn.dataset.commentId);
+                return;
+            }
+
+            const editBtn = e.target.closest('.comment-edit-btn');
+            if (editBtn) {
+                startCommentEdit(editBtn.dataset.commentId);
+                return;
+            }
+
+            const deleteBtn = e.target.closest('.comment-delete-btn');
+            if (deleteBtn) {
+                deleteComment(deleteBtn.dataset.commentId);
+                return;
+            }
+
+            const moreRepliesBtn = e.target.closest('.load-more-replies');
+            if (moreRepliesBtn) {
+                loadMoreReplies(moreRepliesBtn);
+            }
+        });
+    }
+
+    const submitFlagBtn = document.getElementById('submitFlagBtn');
+    if (submitFlagBtn) {
+        submitFlagBtn.addEventListener('click', submitCommentFlag);
+    }
+
+    const cancelReplyBtn = document.getElementById('cancelReply');
+    if (cancelReplyBtn) {
+        cancelReplyBtn.addEventListener('click', cancelReply);
+    }
+
+
+
+
+}
+
+function startReply(commentId, author) {
+    document.getElementById('commentParentID').value = commentId;
+
+    const replyingTo = document.getElementById('commentReplyingTo');
+    replyingTo.querySelector('.replying-author').textContent = author;
+    replyingTo.style.display = 'flex';
+
+    const textarea = document.getElementById('commentContent');
+    textarea.placeholder = 'Escreva sua resposta (máximo 500 caracteres)...';
+    document.getElementById('commentForm').scrollIntoView({ behavior: 'smooth', block: 'center' });
+    textarea.focus();
+}
+
+function cancelReply() {
+    document.getElementById('commentParentID').value = '';
+    document.getElementById('commentReplyingTo').style.display = 'none';
+    document.getElementById('commentContent').placeholder = 'Adicione seu comentário (máximo 500 caracteres)...';
+}
+
+function loadMoreReplies(button) {
+    const commentId = button.dataset.commentId;
+    const offset = parseInt(button.dataset.offset);
+    const total = parseInt(button.dataset.total);
+    const repliesContainer = document.querySelector(`.comment-replies[data-parent-id="${commentId}"]`);
+
+    button.disabled = true;
+    fetch(`/api/comments/${commentId}/replies?offset=${offset}`)
+    .then(response => response.json())
+    .then(data => {
+        if (data.success && data.comments) {
+            data.comments.forEach(reply => {
+                repliesContainer.appendChild(createCommentElement(reply));
+            });
+            const loaded = offset + data.comments.length;
+            button.dataset.offset = loaded;
+            updateMoreRepliesButton(button, total - loaded);
+        }
+    })
+    .catch(error => {
+        console.error('Error loading replies:', error);
+    })
+    .finally(() => {
+        button.disabled = false;
+    });
+}
+
+function updateMoreRepliesButton(button, remaining) {
+    if (remaining <= 0) {
+        button.remove();
+    } else {
+        button.innerHTML = `<i class="bi bi-chevron-down me-1"></i>Ver mais ${remaining} respostas`;
+    }
+}
+
+function showCommentFlagModal(commentId) {
+    const modalElement = document.getElementById('commentFlagModal');
+    if (!modalElement || typeof bootstrap === 'undefined') {
+        showCommentError('Erro ao abrir a denúncia. Recarregue a página.');
+        return;
+    }
+
+    document.getElementById('flagCommentId').value = commentId;
+    document.getElementById('flagDetail').value = '';
+    document.getElementById('flagStatus').style.display = 'none';
 
+    const cpfInput = document.getElementById('flagCpf');
+    const birthDateInput = document.getElementById('flagBirthDate');
+    if (cpfInput && !cpfInput.hasAttribute('data-mask-applied') && typeof applyCPFMask === 'function') {
+        applyCPFMask(cpfInput);
+        cpfInput.setAttribute('data-mask-applied', 'true');
+    }
+    if (birthDateInput && !birthDateInput.hasAttribute('data-mask-applied') && typeof applyBirthDateMask === 'function') {
+        applyBirthDateMask(birthDateInput);
+        birthDateInput.setAttribute('data-mask-applied', 'true');
+    }
+
+    bootstrap.Modal.getOrCreateInstance(modalElement).show();
+}
+
+function submitCommentFlag() {
+    const commentId = document.getElementById('flagCommentId').value;
+    const cpf = document.getElementById('flagCpf').value;
+    const birthDate = document.getElementById('flagBirthDate').value;
+    const status = document.getElementById('flagStatus');
+    const submitBtn = document.getElementById('submitFlagBtn');
+
+    if (!cpf || !birthDate) {
+        status.className = 'mb-0 small text-danger';
+        status.textContent = 'Por favor, preencha o CPF e a data de nascimento.';
+        status.style.display = 'block';
+        return;
+    }
+
+    submitBtn.disabled = true;
+    status.className = 'mb-0 small text-muted';
+    status.textContent = 'Verificando CPF...';
+    status.style.display = 'block';
+
+    fetch(`/api/comments/${commentId}/flag`, {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({
+            cpf: cpf,
+            birth_date: birthDate,
+            reason: document.getElementById('flagReason').value,
+            detail: document.getElementById('flagDetail').value.trim()
+        })
+    })
+    .then(response => response.json())
+    .then(data => {
+        if (!data.success) {
+            throw new Error(data.message || 'Erro ao denunciar comentário.');
+        }
 
+        bootstrap.Modal.getInstance(document.getElementById('commentFlagModal')).hide();
+        showCommentSuccess(data.message);
 
+        // The flag button is gone once flagged, each CPF counts once
+        const flagBtn = document.querySelector(`.comment-flag-btn[data-comment-id="${commentId}"]`);
+        if (flagBtn) {
+            flagBtn.remove();
+        }
+    })
+    .catch(error => {
+        status.className = 'mb-0 small text-danger';
+        status.textContent = error.message;
+    })
+    .finally(() => {
+        submitBtn.disabled = false;
+    });
 }
 
 function handleCommentSubmission() {
@@ -229,6 +409,8 @@ function submitComment() {
 }
 
 function createCommentWithVerifiedCPF(reportId, cpf, birthDate, content) {
+    const parentId = parseInt(document.getElementById('commentParentID').value) || 0;
+
     fetch('/api/comments', {
         method: 'POST',
         headers: {
@@ -238,7 +420,8 @@ function createCommentWithVerifiedCPF(reportId, cpf, birthDate, content) {
             report_id: parseInt(reportId),
             cpf: cpf,
             birth_date: birthDate,
-            content: content
+            content: content,
+            parent_id: parentId
         })
     })
     .then(response => response.json())
@@ -251,14 +434,21 @@ function createCommentWithVerifiedCPF(reportId, cpf, birthDate, content) {
             // Clear form
             document.getElementById('commentContent').value = '';
             document.getElementById('commentCharCount').textContent = '0';
+            cancelReply();
             
             // Reload comments
             currentCommentOffset = 0;
             loadComments(true);
             
-            // Show success message
-            showCommentSuccess('Comentário adicionado com sucesso!');
+            // Show success message; held comments wait for moderation
+            if (data.comment && data.comment.status !== 'visible') {
+                showCommentSuccess(data.message);
+            } else {
+                showCommentSuccess('Comentário adicionado com sucesso!');
+            }
         } else {
+            const modal = bootstrap.Modal.getInstance(document.getElementById('voteVerificationModal'));
+            modal.hide();
             showCommentError(data.message || 'Erro ao adicionar comentário.');
         }
     })
@@ -340,8 +530,8 @@ function loadComments(replace = false) {
                 currentCommentOffset += data.comments.length;
             }
             
-            // Update load more button
-            updateLoadMoreButton(data.total);
+            // Update load more button; pages are top-level comments
+            updateLoadMoreButton(data.threads);
         }
     })
     .catch(error => {
@@ -355,29 +545,171 @@ function loadMoreComments() {
 
 function createCommentElement(comment) {
     const div = document.createElement('div');
-    div.className = 'comment-item';
+    const hidden = comment.status !== 'visible';
+    div.className = 'comment-item' + (comment.parent_id ? ' comment-reply' : '') + (hidden ? ' comment-hidden' : '');
+    div.id = `comment-${comment.id}`;
     div.setAttribute('data-comment-id', comment.id);
+    div.setAttribute('data-depth', comment.depth);
     
-    div.innerHTML = `
-        <div class="comment-header">
-            <div class="comment-author">
-                <i class="bi bi-eye-fill text-muted me-1"></i>
-                <span class="author-name">OlhoUrbano${comment.hashed_cpf_display}</span>
+    if (hidden) {
+        const placeholder = comment.status === 'removed'
+            ? 'Comentário removido pela moderação'
+            : comment.status === 'deleted'
+                ? 'Comentário excluído pelo autor'
+                : 'Comentário oculto aguardando revisão da moderação';
+        div.innerHTML = `
+            <div class="comment-content">
+                <p class="mb-2 text-muted fst-italic">
+                    <i class="bi bi-eye-slash me-1"></i>
+                    ${placeholder}
+                </p>
             </div>
-            <div class="comment-meta">
-                <small class="text-muted">${formatDate(comment.created_at)}</small>
+            <div class="comment-replies" data-parent-id="${comment.id}"></div>
+        `;
+    } else {
+        div.innerHTML = `
+            <div class="comment-header">
+                <div class="comment-author">
+                    <i class="bi bi-eye-fill text-muted me-1"></i>
+                    <span class="author-name">OlhoUrbano${comment.hashed_cpf_display}</span>
+                </div>
+                <div class="comment-meta">
+                    <small class="text-muted">${formatDate(comment.created_at)}</small>
+                    ${comment.edited_at ? `<small class="text-muted comment-edited" title="Editado em ${formatDate(comment.edited_at)}">(editado)</small>` : ''}
+                </div>
             </div>
-        </div>
-        <div class="comment-content">
-            <p class="mb-2">${escapeHtml(comment.content)}</p>
-        </div>
-    `;
+            <div class="comment-content">
+                <p class="mb-2">${escapeHtml(comment.content)}</p>
+            </div>
+            <div class="comment-actions">
+                <button type="button" class="btn btn-link btn-sm p-0 comment-reply-btn" data-comment-id="${comment.id}" data-author="OlhoUrbano${comment.hashed_cpf_display}">
+                    <i class="bi bi-reply me-1"></i>Responder
+                </button>
+                <button type="button" class="btn btn-link btn-sm p-0 ms-3 comment-flag-btn" data-comment-id="${comment.id}">
+                    <i class="bi bi-flag me-1"></i>Denunciar
+                </button>
+                ${comment.own && Date.now() - new Date(comment.created_at).getTime() < COMMENT_EDIT_WINDOW_MS ? `
+                <button type="button" class="btn btn-link btn-sm p-0 ms-3 comment-edit-btn" data-comment-id="${comment.id}">
+                    <i class="bi bi-pencil me-1"></i>Editar
+                </button>` : ''}
+                ${comment.own ? `
+                <button type="button" class="btn btn-link btn-sm p-0 ms-3 text-danger comment-delete-btn" data-comment-id="${comment.id}">
+                    <i class="bi bi-trash me-1"></i>Excluir
+                </button>` : ''}
+            </div>
+            <div class="comment-replies" data-parent-id="${comment.id}"></div>
+        `;
+    }
+
+    const replies = comment.replies || [];
+    const repliesContainer = div.querySelector('.comment-replies');
+    replies.forEach(reply => {
+        repliesContainer.appendChild(createCommentElement(reply));
+    });
+
+    if (comment.reply_count > replies.length) {
+        const moreRepliesBtn = document.createElement('button');
+        moreRepliesBtn.type = 'button';
+        moreRepliesBtn.className = 'btn btn-link btn-sm p-0 load-more-replies';
+        moreRepliesBtn.dataset.commentId = comment.id;
+        moreRepliesBtn.dataset.offset = replies.length;
+        moreRepliesBtn.dataset.total = comment.reply_count;
+        updateMoreRepliesButton(moreRepliesBtn, comment.reply_count - replies.length);
+        div.appendChild(moreRepliesBtn);
+    }
     
     return div;
 }
 
 
 
+// Replace the comment text with an inline editor; authors are recognized by their session
+function startCommentEdit(commentId) {
+    const item = document.getElementById(`comment-${commentId}`);
+    const content = item && item.querySelector(':scope > .comment-content');
+    if (!content || content.querySelector('textarea')) {
+        return;
+    }
+
+    const paragraph = content.querySelector('p');
+    const textarea = document.createElement('textarea');
+    textarea.className = 'form-control mb-2';
+    textarea.maxLength = 500;
+    textarea.rows = 3;
+    textarea.value = paragraph.textContent;
+
+    const actions = document.createElement('div');
+    actions.className = 'd-flex gap-2 mb-2';
+    actions.innerHTML = `
+        <button type="button" class="btn btn-primary btn-sm">Salvar</button>
+        <button type="button" class="btn btn-outline-secondary btn-sm">Cancelar</button>
+    `;
+    const [saveBtn, cancelBtn] = actions.querySelectorAll('button');
+
+    const closeEditor = () => {
+        textarea.remove();
+        actions.remove();
+        paragraph.hidden = false;
+    };
+    cancelBtn.addEventListener('click', closeEditor);
+    saveBtn.addEventListener('click', () => {
+        saveBtn.disabled = true;
+        fetch(`/api/comments/${commentId}`, {
+            method: 'PUT',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify({ content: textarea.value.trim() })
+        })
+        .then(response => response.json())
+        .then(data => {
+            if (!data.success) {
+                throw new Error(data.message || 'Erro ao editar comentário.');
+            }
+            if (data.comment.status !== 'visible') {
+                showCommentSuccess(data.message);
+                currentCommentOffset = 0;
+                loadComments(true);
+                return;
+            }
+            paragraph.textContent = data.comment.content;
+            closeEditor();
+            const meta = item.querySelector(':scope > .comment-header .comment-meta');
+            if (meta && !meta.querySelector('.comment-edited')) {
+                meta.insertAdjacentHTML('beforeend', ' <small class="text-muted comment-edited">(editado)</small>');
+            }
+        })
+        .catch(error => {
+            showCommentError(error.message);
+            saveBtn.disabled = false;
+        });
+    });
+
+    paragraph.hidden = true;
+    content.appendChild(textarea);
+    content.appendChild(actions);
+    textarea.focus();
+}
+
+// Delete an own comment; its replies stay under a placeholder
+function deleteComment(commentId) {
+    if (!confirm('Excluir este comentário? Esta ação não pode ser desfeita.')) {
+        return;
+    }
+
+    fetch(`/api/comments/${commentId}`, { method: 'DELETE' })
+    .then(response => response.json())
+    .then(data => {
+        if (!data.success) {
+            throw new Error(data.message || 'Erro ao excluir comentário.');
+        }
+        currentCommentOffset = 0;
+        loadComments(true);
+        showCommentSuccess(data.message);
+    })
+    .catch(error => showCommentError(error.message));
+}
+
 function updateLoadMoreButton(total) {
     const loadMoreBtn = document.getElementById('loadMoreComments');
     if (loadMoreBtn) {
@@ -47,7 +47,9 @@ function hideModalFallback() {
 }
 
 // Open file modal for single file
-function openFileModal(filePath, fileName, fileType) {
+// media holds the data attributes of the clicked item: the responsive renditions of an image
+// or the poster of a video, when the server made them
+function openFileModal(filePath, fileName, fileType, media) {
     console.log('openFileModal called:', { filePath, fileName, fileType });
     
     const modal = document.getElementById('fileModal');
@@ -63,9 +65,9 @@ function openFileModal(filePath, fileName, fileType) {
     
     // Show appropriate viewer based on file type
     if (fileType === 'image' || isImageFile(fileName)) {
-        showImageViewer(filePath);
+        showImageViewer(filePath, media);
     } else if (fileType === 'video' || isVideoFile(fileName)) {
-        showVideoViewer(filePath);
+        showVideoViewer(filePath, media);
     } else if (fileType === 'pdf' || isPdfFile(fileName)) {
         showPdfViewer(filePath);
     } else {
@@ -99,7 +101,7 @@ function openFileGallery(element) {
 }
 
 // Show image viewer with zoom functionality
-function showImageViewer(filePath) {
+function showImageViewer(filePath, srcsets) {
     const imageViewer = document.getElementById('imageViewer');
     const modalImage = document.getElementById('modalImage');
     const imageLoading = document.getElementById('imageLoading');
@@ -113,7 +115,8 @@ function showImageViewer(filePath) {
         imageLoading.style.display = 'block';
     }
     
-    // Set image source
+    // Set image source, letting the browser pick a smaller rendition when there are any
+    setImageSources(modalImage, srcsets || {});
     modalImage.src = filePath;
     imageViewer.style.display = 'block';
     
@@ -520,11 +523,17 @@ function updateZoomLevelDisplay() {
 }
 
 // Show video viewer
-function showVideoViewer(filePath) {
+function showVideoViewer(filePath, media) {
     const videoViewer = document.getElementById('videoViewer');
     const modalVideo = document.getElementById('modalVideo');
     const videoSource = document.getElementById('videoSource');
     
+    // Transcoded videos come with a poster frame
+    if (media && media.poster) {
+        modalVideo.poster = media.poster;
+    } else {
+        modalVideo.removeAttribute('poster');
+    }
     videoSource.src = filePath;
     videoSource.type = getVideoMimeType(filePath);
     modalVideo.load();
@@ -634,6 +643,32 @@ function showDocumentViewer(filePath, fileName) {
     }
 }
 
+// Point the modal picture at the renditions of an image, or clear the ones of the last image
+function setImageSources(modalImage, srcsets) {
+    const sources = {
+        modalImageAvif: srcsets.avif,
+        modalImageWebp: srcsets.webp
+    };
+    Object.keys(sources).forEach(id => {
+        const source = document.getElementById(id);
+        if (!source) return;
+        if (sources[id]) {
+            source.srcset = sources[id];
+            source.sizes = '100vw';
+        } else {
+            source.removeAttribute('srcset');
+        }
+    });
+
+    if (srcsets.srcset) {
+        modalImage.srcset = srcsets.srcset;
+        modalImage.sizes = '100vw';
+    } else {
+        modalImage.removeAttribute('srcset');
+        modalImage.removeAttribute('sizes');
+    }
+}
+
 // Hide all viewers
 function hideAllViewers() {
     const viewers = ['imageViewer', 'videoViewer', 'pdfViewer', 'documentViewer'];
@@ -7,14 +7,22 @@ function updateMapLinksWithFilters() {
     const category = urlParams.get('category');
     const status = urlParams.get('status');
     const city = urlParams.get('city');
+    const neighborhood = urlParams.get('neighborhood');
+    const q = urlParams.get('q');
     const sort = urlParams.get('sort') || 'recent';
     
     // Build filter parameters
     const params = [];
+    if (q) params.push(`q=${encodeURIComponent(q)}`);
     if (category) params.push(`category=${encodeURIComponent(category)}`);
     if (status) params.push(`status=${encodeURIComponent(status)}`);
     if (city) params.push(`city=${encodeURIComponent(city)}`);
+    if (neighborhood) params.push(`neighborhood=${encodeURIComponent(neighborhood)}`);
     if (sort && sort !== 'recent') params.push(`sort=${encodeURIComponent(sort)}`);
+    if (urlParams.get('lat') && urlParams.get('lng')) {
+        params.push(`lat=${encodeURIComponent(urlParams.get('lat'))}`);
+        params.push(`lng=${encodeURIComponent(urlParams.get('lng'))}`);
+    }
     
     const filterString = params.length > 0 ? '?' + params.join('&') : '';
     
@@ -108,7 +116,15 @@ function clearLateralFilters() {
     document.getElementById('lateral-category').value = '';
     document.getElementById('lateral-status').value = '';
     document.getElementById('lateral-city').value = '';
+    const lateralNeighborhood = document.getElementById('lateral-neighborhood');
+    if (lateralNeighborhood) lateralNeighborhood.value = '';
+    const lateralQuery = document.getElementById('lateral-q');
+    if (lateralQuery) lateralQuery.value = '';
     document.getElementById('lateral-sort').value = 'recent';
+    const lateralLat = document.getElementById('lateral-lat');
+    if (lateralLat) lateralLat.value = '';
+    const lateralLng = document.getElementById('lateral-lng');
+    if (lateralLng) lateralLng.value = '';
     
     // If on map page, apply the cleared filters
     if (window.location.pathname === '/map') {
@@ -121,7 +137,15 @@ function clearMobileFilters() {
     document.getElementById('mobile-category').value = '';
     document.getElementById('mobile-status').value = '';
     document.getElementById('mobile-city').value = '';
+    const mobileNeighborhood = document.getElementById('mobile-neighborhood');
+    if (mobileNeighborhood) mobileNeighborhood.value = '';
+    const mobileQuery = document.getElementById('mobile-q');
+    if (mobileQuery) mobileQuery.value = '';
     document.getElementById('mobile-sort').value = 'recent';
+    const mobileLat = document.getElementById('mobile-lat');
+    if (mobileLat) mobileLat.value = '';
+    const mobileLng = document.getElementById('mobile-lng');
+    if (mobileLng) mobileLng.value = '';
     
     // If on map page, apply the cleared filters
     if (window.location.pathname === '/map') {
@@ -213,8 +237,13 @@ document.addEventListener('DOMContentLoaded', function() {
             if (isMapPage) {
                 e.preventDefault();
                 handleMapFilterSubmission('lateral');
+                return;
+            }
+            // On feed page, let it submit normally once a location is known for "nearby"
+            if (needsLocationForSort('lateral')) {
+                e.preventDefault();
+                fillLocationAndSubmit('lateral', lateralForm);
             }
-            // On feed page, let it submit normally
         });
     }
     
@@ -225,8 +254,13 @@ document.addEventListener('DOMContentLoaded', function() {
             if (isMapPage) {
                 e.preventDefault();
                 handleMapFilterSubmission('mobile');
+                return;
+            }
+            // On feed page, let it submit normally once a location is known for "nearby"
+            if (needsLocationForSort('mobile')) {
+                e.preventDefault();
+                fillLocationAndSubmit('mobile', mobileForm);
             }
-            // On feed page, let it submit normally
         });
     }
     
@@ -251,6 +285,7 @@ function populateFilterPanelWithCurrentFilters() {
     const category = urlParams.get('category');
     const status = urlParams.get('status');
     const city = urlParams.get('city');
+    const q = urlParams.get('q');
     const sort = urlParams.get('sort') || 'recent';
     
     // Populate lateral panel filters
@@ -263,6 +298,8 @@ function populateFilterPanelWithCurrentFilters() {
     if (lateralStatus && status) lateralStatus.value = status;
     if (lateralCity && city) lateralCity.value = city;
     if (lateralSort) lateralSort.value = sort;
+    const lateralQuery = document.getElementById('lateral-q');
+    if (lateralQuery && q) lateralQuery.value = q;
     
     // Populate mobile panel filters
     const mobileCategory = document.getElementById('mobile-category');
@@ -274,10 +311,40 @@ function populateFilterPanelWithCurrentFilters() {
     if (mobileStatus && status) mobileStatus.value = status;
     if (mobileCity && city) mobileCity.value = city;
     if (mobileSort) mobileSort.value = sort;
+    const mobileQuery = document.getElementById('mobile-q');
+    if (mobileQuery && q) mobileQuery.value = q;
     
     console.log('Populated filter panel with:', { category, status, city, sort });
 }
 
+// Whether the "nearby" sort is selected without a known location
+function needsLocationForSort(prefix) {
+    const sortSelect = document.getElementById(`${prefix}-sort`);
+    const latInput = document.getElementById(`${prefix}-lat`);
+    return sortSelect && latInput && sortSelect.value === 'nearby' && !latInput.value;
+}
+
+// Ask the browser for the user's position, then submit the feed form sorted by distance
+function fillLocationAndSubmit(prefix, form) {
+    if (!navigator.geolocation) {
+        alert('Seu navegador não permite obter a localização. Escolha outra ordenação.');
+        return;
+    }
+    
+    navigator.geolocation.getCurrentPosition(
+        function(position) {
+            document.getElementById(`${prefix}-lat`).value = position.coords.latitude.toFixed(6);
+            document.getElementById(`${prefix}-lng`).value = position.coords.longitude.toFixed(6);
+            form.submit();
+        },
+        function(error) {
+            console.warn('Geolocation error:', error);
+            alert('Não foi possível obter sua localização. Permita o acesso à localização para ver as denúncias mais próximas.');
+        },
+        { enableHighAccuracy: false, timeout: 10000, maximumAge: 300000 }
+    );
+}
+
 // Handle map filter submission
 function handleMapFilterSubmission(formType) {
     const prefix = formType === 'mobile' ? 'mobile' : 'lateral';
@@ -285,14 +352,20 @@ function handleMapFilterSubmission(formType) {
     const category = document.getElementById(`${prefix}-category`).value;
     const status = document.getElementById(`${prefix}-status`).value;
     const city = document.getElementById(`${prefix}-city`).value;
+    const neighborhoodSelect = document.getElementById(`${prefix}-neighborhood`);
+    const neighborhood = neighborhoodSelect ? neighborhoodSelect.value : '';
+    const queryInput = document.getElementById(`${prefix}-q`);
+    const q = queryInput ? queryInput.value.trim() : '';
     const sort = document.getElementById(`${prefix}-sort`).value || 'recent';
     
     // Build URL with filters
     let url = '/map?';
     const params = [];
+    if (q) params.push(`q=${encodeURIComponent(q)}`);
     if (category) params.push(`category=${encodeURIComponent(category)}`);
     if (status) params.push(`status=${encodeURIComponent(status)}`);
     if (city) params.push(`city=${encodeURIComponent(city)}`);
+    if (neighborhood) params.push(`neighborhood=${encodeURIComponent(neighborhood)}`);
     if (sort && sort !== 'recent') params.push(`sort=${encodeURIComponent(sort)}`);
     
     if (params.length > 0) {
@@ -311,6 +384,9 @@ function handleMapFilterSubmission(formType) {
     if (typeof loadReportsOnMap === 'function') {
         loadReportsOnMap();
     }
+    if (typeof reloadHotspotLayer === 'function') {
+        reloadHotspotLayer();
+    }
     
     // Close the panel
     if (formType === 'mobile') {
@@ -0,0 +1,96 @@
+// Follow a report: email notifications for comments, status changes and official responses
+
+document.addEventListener('DOMContentLoaded', function() {
+    document.querySelectorAll('.follow-btn').forEach(button => {
+        button.addEventListener('click', function(e) {
+            e.preventDefault();
+            showFollowModal(this.getAttribute('data-report-id'));
+        });
+    });
+});
+
+// Open the follow modal for a report
+function showFollowModal(reportId) {
+    const modalElement = document.getElementById('followReportModal');
+    if (!modalElement) return;
+
+    document.getElementById('followReportId').value = reportId;
+    document.getElementById('followStatus').style.display = 'none';
+    document.getElementById('submitFollowBtn').disabled = false;
+
+    modalElement.addEventListener('shown.bs.modal', function applyMasks() {
+        if (typeof applyCPFMask === 'function') {
+            applyCPFMask(document.getElementById('followCpf'));
+        }
+        if (typeof applyBirthDateMask === 'function') {
+            applyBirthDateMask(document.getElementById('followBirthDate'));
+        }
+        modalElement.removeEventListener('shown.bs.modal', applyMasks);
+    });
+
+    new bootstrap.Modal(modalElement).show();
+}
+
+// Submit the follow request after CPF verification
+function submitFollow() {
+    const reportId = document.getElementById('followReportId').value;
+    const cpf = document.getElementById('followCpf').value;
+    const birthDate = document.getElementById('followBirthDate').value;
+    const email = document.getElementById('followEmail').value.trim();
+    const preference = document.getElementById('followPreference').value;
+
+    if (!cpf || !birthDate || !email) {
+        showFollowStatus('Por favor, preencha todos os campos.', 'danger');
+        return;
+    }
+
+    const submitButton = document.getElementById('submitFollowBtn');
+    submitButton.disabled = true;
+    showFollowStatus('Verificando CPF...', 'info');
+
+    fetch('/api/follow', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({
+            report_id: parseInt(reportId),
+            cpf: cpf,
+            birth_date: birthDate,
+            email: email,
+            preference: preference
+        })
+    })
+    .then(response => response.json())
+    .then(data => {
+        if (!data.success) {
+            throw new Error(data.message || 'Erro ao acompanhar denúncia');
+        }
+
+        showFollowStatus(data.message, 'success');
+        document.querySelectorAll(`.follow-btn[data-report-id="${reportId}"]`).forEach(button => {
+            const following = data.preference !== 'off';
+            button.innerHTML = following
+                ? '<i class="bi bi-bell-fill me-2"></i>Acompanhando'
+                : '<i class="bi bi-bell me-2"></i>Acompanhar';
+        });
+
+        setTimeout(() => {
+            const modal = bootstrap.Modal.getInstance(document.getElementById('followReportModal'));
+            if (modal) modal.hide();
+        }, 1500);
+    })
+    .catch(error => {
+        console.error('Error following report:', error);
+        showFollowStatus(error.message, 'danger');
+        submitButton.disabled = false;
+    });
+}
+
+// Show a status message inside the follow modal
+function showFollowStatus(message, type) {
+    const status = document.getElementById('followStatus');
+    status.className = `alert alert-${type} mb-3`;
+    status.textContent = message;
+    status.style.display = 'block';
+}
@@ -7,6 +7,8 @@ let AdvancedMarkerElement;
 let PinElement;
 let clusterMarkers = [];
 let isClustered = false;
+let hotspotCircles = [];
+let hotspotsVisible = false;
 
 // Initialize the map when the page loads
 async function initMap(retryCount = 0) {
@@ -103,6 +105,8 @@ function loadReportsOnMap() {
     const category = urlParams.get('category');
     const status = urlParams.get('status');
     const city = urlParams.get('city');
+    const neighborhood = urlParams.get('neighborhood');
+    const q = urlParams.get('q');
     
     // Check for focus parameters (from report detail page)
     const focusLat = urlParams.get('focus_lat');
@@ -115,6 +119,8 @@ function loadReportsOnMap() {
     if (category) params.push(`category=${encodeURIComponent(category)}`);
     if (status) params.push(`status=${encodeURIComponent(status)}`);
     if (city) params.push(`city=${encodeURIComponent(city)}`);
+    if (neighborhood) params.push(`neighborhood=${encodeURIComponent(neighborhood)}`);
+    if (q) params.push(`q=${encodeURIComponent(q)}`);
     if (params.length > 0) {
         apiUrl += '?' + params.join('&');
     }
@@ -734,4 +740,90 @@ function clearClusterMarkers() {
 }
 
 // Export functions for global access
-window.loadReportsOnMap = loadReportsOnMap; 
\ No newline at end of file
+window.loadReportsOnMap = loadReportsOnMap; 
+// Toggle the hotspot layer on and off
+function toggleHotspotLayer() {
+    hotspotsVisible = !hotspotsVisible;
+
+    const button = document.getElementById('hotspot-toggle-btn');
+    const windowSelect = document.getElementById('hotspot-window');
+    if (button) button.classList.toggle('active', hotspotsVisible);
+    if (windowSelect) windowSelect.style.display = hotspotsVisible ? 'block' : 'none';
+
+    if (hotspotsVisible) {
+        loadHotspotLayer();
+    } else {
+        clearHotspotLayer();
+    }
+}
+
+// Reload hotspots when the time window changes
+function reloadHotspotLayer() {
+    if (hotspotsVisible) {
+        loadHotspotLayer();
+    }
+}
+
+// Fetch hotspots as GeoJSON and draw them as circles sized by cluster radius
+function loadHotspotLayer() {
+    const urlParams = new URLSearchParams(window.location.search);
+    const category = urlParams.get('category');
+    const windowSelect = document.getElementById('hotspot-window');
+    const windowDays = windowSelect ? windowSelect.value : '30';
+
+    const params = [`window=${encodeURIComponent(windowDays)}`];
+    if (category) params.push(`category=${encodeURIComponent(category)}`);
+
+    fetch(`/api/v1/hotspots?${params.join('&')}`)
+        .then(response => response.json())
+        .then(data => {
+            clearHotspotLayer();
+            if (!data.features || data.features.length === 0) {
+                return;
+            }
+
+            const maxScore = Math.max(...data.features.map(f => f.properties.score));
+
+            data.features.forEach(feature => {
+                const [lng, lat] = feature.geometry.coordinates;
+                const props = feature.properties;
+                const intensity = maxScore > 0 ? props.score / maxScore : 0;
+                const color = props.category ? getCategoryColor(props.category) : '#F44336';
+
+                const circle = new google.maps.Circle({
+                    map: map,
+                    center: { lat: lat, lng: lng },
+                    radius: props.radius_meters,
+                    strokeColor: color,
+                    strokeOpacity: 0.8,
+                    strokeWeight: 1,
+                    fillColor: color,
+                    fillOpacity: 0.15 + intensity * 0.4,
+                    clickable: true
+                });
+
+                circle.addListener('click', function() {
+                    infoWindow.setContent(`
+                        <div style="font-family:'Montserrat',sans-serif; padding:4px;">
+                            <strong><i class="bi bi-fire"></i> Hotspot</strong><br>
+                            ${props.report_count} denúncias &middot; ${props.vote_count} votos<br>
+                            <small>Últimos ${props.window_days} dias</small>
+                        </div>
+                    `);
+                    infoWindow.setPosition({ lat: lat, lng: lng });
+                    infoWindow.open(map);
+                });
+
+                hotspotCircles.push(circle);
+            });
+        })
+        .catch(error => {
+            console.error('Error loading hotspots:', error);
+        });
+}
+
+// Remove all hotspot circles from the map
+function clearHotspotLayer() {
+    hotspotCircles.forEach(circle => circle.setMap(null));
+    hotspotCircles = [];
+}
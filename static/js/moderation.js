@@ -0,0 +1,146 @@
+// Moderation tools: merging duplicate reports, updating report status and reviewing comments
+
+// Merge a duplicate report into its canonical report
+function mergeReports(duplicateId, canonicalId, buttonElement) {
+    if (!confirm(`Unificar a denúncia #${duplicateId} na denúncia #${canonicalId}? Votos e comentários serão movidos.`)) {
+        return;
+    }
+
+    const card = buttonElement.closest('.card');
+    const buttons = card ? card.querySelectorAll('button') : [buttonElement];
+    buttons.forEach(button => button.disabled = true);
+
+    fetch('/api/moderation/merge', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({
+            duplicate_id: duplicateId,
+            canonical_id: canonicalId
+        })
+    })
+    .then(response => response.json())
+    .then(data => {
+        if (!data.success) {
+            throw new Error(data.message || 'Erro ao unificar denúncias');
+        }
+
+        // Remove every pair involving the merged report, it no longer exists on its own
+        document.querySelectorAll('.card[id^="duplicate-"]').forEach(pair => {
+            const ids = pair.id.replace('duplicate-', '').split('-');
+            if (ids.includes(String(duplicateId))) {
+                pair.remove();
+            }
+        });
+    })
+    .catch(error => {
+        console.error('Error merging reports:', error);
+        alert(error.message);
+        buttons.forEach(button => button.disabled = false);
+    });
+}
+
+// Update the status of a report and publish an optional official response
+function updateReportStatus(buttonElement) {
+    const panel = document.getElementById('moderatorStatusPanel');
+    const reportId = parseInt(panel.dataset.reportId);
+    const status = document.getElementById('moderatorStatus').value;
+    const officialResponse = document.getElementById('moderatorOfficialResponse').value.trim();
+
+    if (!confirm('Atualizar a denúncia e notificar os seguidores?')) {
+        return;
+    }
+
+    buttonElement.disabled = true;
+
+    fetch('/api/moderation/status', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({
+            report_id: reportId,
+            status: status,
+            official_response: officialResponse
+        })
+    })
+    .then(response => response.json())
+    .then(data => {
+        if (!data.success) {
+            throw new Error(data.message || 'Erro ao atualizar denúncia');
+        }
+        window.location.reload();
+    })
+    .catch(error => {
+        console.error('Error updating report status:', error);
+        alert(error.message);
+        buttonElement.disabled = false;
+    });
+}
+
+// Keep or remove a comment from the review queue
+function reviewComment(commentId, decision, buttonElement) {
+    if (decision === 'remove' && !confirm('Remover este comentário? Ele será substituído por um aviso de remoção.')) {
+        return;
+    }
+
+    const card = buttonElement.closest('.card');
+    const buttons = card ? card.querySelectorAll('button') : [buttonElement];
+    buttons.forEach(button => button.disabled = true);
+
+    fetch('/api/moderation/comments', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({
+            comment_id: commentId,
+            decision: decision
+        })
+    })
+    .then(response => response.json())
+    .then(data => {
+        if (!data.success) {
+            throw new Error(data.message || 'Erro ao revisar comentário');
+        }
+        if (card) {
+            card.remove();
+        }
+    })
+    .catch(error => {
+        console.error('Error reviewing comment:', error);
+        alert(error.message);
+        buttons.forEach(button => button.disabled = false);
+    });
+}
+
+// Show the previous versions of an edited comment below its card body
+function showCommentRevisions(commentId, buttonElement) {
+    const container = buttonElement.nextElementSibling;
+    buttonElement.disabled = true;
+
+    fetch(`/api/moderation/comments/${commentId}/revisions`)
+    .then(response => response.json())
+    .then(data => {
+        if (!data.success) {
+            throw new Error(data.message || 'Erro ao carregar versões');
+        }
+        container.replaceChildren();
+        data.revisions.forEach(revision => {
+            const item = document.createElement('p');
+            item.className = 'mb-1 mt-2 small border-start ps-2 text-muted';
+            const date = document.createElement('strong');
+            date.textContent = new Date(revision.written_at).toLocaleString('pt-BR') + ': ';
+            item.appendChild(date);
+            item.appendChild(document.createTextNode(revision.content));
+            container.appendChild(item);
+        });
+        buttonElement.remove();
+    })
+    .catch(error => {
+        console.error('Error loading comment revisions:', error);
+        alert(error.message);
+        buttonElement.disabled = false;
+    });
+}
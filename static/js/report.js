@@ -423,6 +423,10 @@ function handleFilePreview() {
             
             if (!isDuplicate) {
                 accumulatedFiles.push(file);
+                suggestLocationFromPhoto(file);
+                if (file.size > RESUMABLE_UPLOAD_THRESHOLD) {
+                    startResumableUpload(file);
+                }
             }
         });
         
@@ -436,6 +440,208 @@ function handleFilePreview() {
     }
 }
 
+// Place the pin where a photo was taken, when the user hasn't placed it yet. Only the start of
+// the file, where the EXIF block is, is sent; the server strips the metadata from the upload.
+function suggestLocationFromPhoto(file) {
+    if (!file.type.startsWith('image/') || document.getElementById('latitude').value) {
+        return;
+    }
+
+    fetch('/api/photos/location', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/octet-stream' },
+        body: file.slice(0, 256 * 1024)
+    })
+        .then(response => response.json())
+        .then(data => {
+            // Another photo or the user may have placed the pin meanwhile
+            if (!data.success || !data.has_location || document.getElementById('latitude').value) {
+                return;
+            }
+            const position = { lat: data.latitude, lng: data.longitude };
+            updateCoordinates(position.lat, position.lng);
+            if (map) {
+                map.setCenter(position);
+                map.setZoom(16);
+                marker.position = position;
+                reverseGeocode(position.lat, position.lng);
+            }
+        })
+        .catch(error => {
+            // The pin can still be placed by hand
+            console.error('Error reading photo location:', error);
+        });
+}
+
+// Files above this size are sent in chunks before the form, so a dropped connection resumes
+// where it stopped instead of starting over (tus protocol)
+const RESUMABLE_UPLOAD_THRESHOLD = 4 * 1024 * 1024;
+const RESUMABLE_CHUNK_SIZE = 1024 * 1024;
+const RESUMABLE_RETRY_DELAYS = [1000, 3000, 5000, 10000, 20000];
+const TUS_HEADERS = { 'Tus-Resumable': '1.0.0' };
+
+// Error for files the server refused, which retrying won't fix
+class UploadRejectedError extends Error {}
+
+// Start sending a file in the background; its progress is shown in the file list
+function startResumableUpload(file) {
+    file.upload = { progress: 0, id: null, error: null, cancelled: false };
+    file.upload.promise = sendResumableUpload(file);
+    file.upload.promise
+        .then(id => {
+            file.upload.id = id;
+        })
+        .catch(error => {
+            file.upload.error = error.message;
+        })
+        .finally(displayAccumulatedFiles);
+}
+
+// Send a file in chunks, resuming an upload of the same file left by a previous page. Resolves
+// to the upload ID the form references.
+async function sendResumableUpload(file) {
+    const form = document.getElementById('reportForm');
+    const category = form ? form.dataset.category : '';
+    const storageKey = `upload:${category}:${file.name}:${file.size}:${file.lastModified}`;
+
+    let url = localStorage.getItem(storageKey);
+    let offset = url ? await getUploadOffset(url) : null;
+    if (offset === null) {
+        url = await createResumableUpload(file, category);
+        offset = 0;
+        localStorage.setItem(storageKey, url);
+    }
+    file.upload.url = url;
+
+    // The request completing the upload is repeated until the server has processed the file,
+    // even when all its bytes already arrived
+    let failures = 0;
+    let complete = false;
+    while (!complete) {
+        if (file.upload.cancelled) {
+            throw new Error('Envio cancelado');
+        }
+        try {
+            offset = await sendUploadChunk(url, file, offset);
+            complete = offset >= file.size;
+            failures = 0;
+        } catch (error) {
+            if (error instanceof UploadRejectedError || failures >= RESUMABLE_RETRY_DELAYS.length) {
+                localStorage.removeItem(storageKey);
+                throw error;
+            }
+            await new Promise(resolve => setTimeout(resolve, RESUMABLE_RETRY_DELAYS[failures++]));
+            // Part of the chunk may have arrived before the connection dropped
+            const received = await getUploadOffset(url).catch(() => null);
+            if (received !== null) {
+                offset = received;
+            }
+        }
+        file.upload.progress = offset / file.size;
+        displayAccumulatedFiles();
+    }
+
+    localStorage.removeItem(storageKey);
+    return url.split('/').pop();
+}
+
+// Start an upload on the server, returning its URL
+async function createResumableUpload(file, category) {
+    const metadata = { filename: file.name, filetype: file.type, category: category };
+    const response = await fetch('/api/uploads', {
+        method: 'POST',
+        headers: {
+            ...TUS_HEADERS,
+            'Upload-Length': String(file.size),
+            'Upload-Metadata': Object.entries(metadata)
+                .map(([key, value]) => `${key} ${btoa(unescape(encodeURIComponent(value)))}`)
+                .join(',')
+        }
+    });
+    if (!response.ok) {
+        throw new UploadRejectedError(await response.text());
+    }
+    return response.headers.get('Location');
+}
+
+// How many bytes of an upload the server has, or null when it is gone
+async function getUploadOffset(url) {
+    const response = await fetch(url, { method: 'HEAD', headers: TUS_HEADERS });
+    if (!response.ok) {
+        return null;
+    }
+    return parseInt(response.headers.get('Upload-Offset'), 10);
+}
+
+// Where the pin of the form is, so the server checks photos against it
+function reportLocationHeader() {
+    const latitude = document.getElementById('latitude').value;
+    const longitude = document.getElementById('longitude').value;
+    return latitude && longitude ? { 'Report-Location': `${latitude},${longitude}` } : {};
+}
+
+// Send the chunk starting at offset, returning the new offset
+async function sendUploadChunk(url, file, offset) {
+    const response = await fetch(url, {
+        method: 'PATCH',
+        headers: {
+            ...TUS_HEADERS,
+            ...reportLocationHeader(),
+            'Upload-Offset': String(offset),
+            'Content-Type': 'application/offset+octet-stream'
+        },
+        body: file.slice(offset, offset + RESUMABLE_CHUNK_SIZE)
+    });
+    if (response.status === 400 || response.status === 404 || response.status === 413) {
+        throw new UploadRejectedError(`${file.name}: ${await response.text()}`);
+    }
+    if (!response.ok) {
+        throw new Error(`Erro ao enviar ${file.name}`);
+    }
+    return parseInt(response.headers.get('Upload-Offset'), 10);
+}
+
+// Stop sending a removed file and drop what the server already has
+function cancelResumableUpload(file) {
+    if (!file || !file.upload) {
+        return;
+    }
+    file.upload.cancelled = true;
+    if (file.upload.url) {
+        fetch(file.upload.url, { method: 'DELETE', headers: TUS_HEADERS }).catch(() => {});
+    }
+}
+
+// Status of a resumable upload for the file list
+function resumableUploadStatus(upload) {
+    if (upload.error) {
+        return `<small class="text-danger me-2">${escapeSimilarText(upload.error)}</small>`;
+    }
+    if (upload.id) {
+        return '<small class="text-success me-2"><i class="bi bi-check-circle"></i> enviado</small>';
+    }
+    return `<small class="text-muted me-2">enviando ${Math.floor(upload.progress * 100)}%</small>`;
+}
+
+// Wait for the resumable uploads and add their IDs to the form
+async function addResumableUploadIds(form) {
+    form.querySelectorAll('input[name="upload_ids"]').forEach(input => input.remove());
+
+    for (const file of accumulatedFiles.filter(file => file.upload)) {
+        let id;
+        try {
+            id = await file.upload.promise;
+        } catch (error) {
+            throw new Error(`Falha ao enviar ${file.name}: ${error.message}. Remova o arquivo e tente novamente.`);
+        }
+        const input = document.createElement('input');
+        input.type = 'hidden';
+        input.name = 'upload_ids';
+        input.value = id;
+        form.appendChild(input);
+    }
+}
+
 function displayAccumulatedFiles() {
     const filePreview = document.getElementById('filePreview');
     const fileList = document.getElementById('fileList');
@@ -454,6 +660,7 @@ function displayAccumulatedFiles() {
             fileItem.innerHTML = `
                 <i class="${icon} me-2"></i>
                 <span class="flex-grow-1">${file.name}</span>
+                ${file.upload ? resumableUploadStatus(file.upload) : ''}
                 <small class="text-muted">(${(file.size / 1024 / 1024).toFixed(2)} MB)</small>
                 <button type="button" class="btn btn-sm btn-outline-danger ms-2" onclick="removeFile(${index})">
                     <i class="bi bi-trash"></i>
@@ -469,6 +676,7 @@ function displayAccumulatedFiles() {
 }
 
 function removeFile(index) {
+    cancelResumableUpload(accumulatedFiles[index]);
     accumulatedFiles.splice(index, 1);
     displayAccumulatedFiles();
     updateFileInputText();
@@ -513,9 +721,11 @@ function prepareFormSubmission() {
     // Create a new DataTransfer object
     const dataTransfer = new DataTransfer();
     
-    // Add all accumulated files to the DataTransfer
+    // Add the accumulated files to the DataTransfer; large ones go through resumable uploads
     accumulatedFiles.forEach(file => {
-        dataTransfer.items.add(file);
+        if (!file.upload) {
+            dataTransfer.items.add(file);
+        }
     });
     
     // Set the file input's files to the accumulated files
@@ -619,7 +829,8 @@ function validateReportForm(e) {
     
     // 6. Check file uploads - at least one file is required
     const fileInput = document.getElementById('files');
-    if (!fileInput || !fileInput.files || fileInput.files.length === 0) {
+    const hasResumableUploads = accumulatedFiles.some(file => file.upload);
+    if ((!fileInput || !fileInput.files || fileInput.files.length === 0) && !hasResumableUploads) {
         validationErrors.push('Pelo menos um arquivo (foto, vídeo ou documento) é obrigatório para comprovar a denúncia');
     }
     
@@ -631,16 +842,154 @@ function validateReportForm(e) {
         return false;
     }
     
-    // If validation passes, show success message and submit
+    // Before submitting, check whether the same problem was already reported nearby
+    if (!similarReportsChecked) {
+        submitBtn.innerHTML = '<i class="bi bi-hourglass-split me-2"></i>Verificando denúncias semelhantes...';
+
+        checkSimilarReports(latitude, longitude, description).then(reports => {
+            similarReportsChecked = true;
+
+            if (reports.length > 0) {
+                showSimilarReports(reports);
+                submitBtn.disabled = false;
+                submitBtn.innerHTML = originalText;
+                return;
+            }
+
+            submitReportForm();
+        });
+        return false;
+    }
+
+    submitReportForm();
+    return true;
+}
+
+// Submit the report form once validation and the duplicate check are done
+async function submitReportForm() {
+    const submitBtn = document.getElementById('submitBtn');
+    const originalText = submitBtn.innerHTML;
+    submitBtn.disabled = true;
     submitBtn.innerHTML = '<i class="bi bi-hourglass-split me-2"></i>Enviando...';
+
+    // Large files must finish uploading before the form that references them is sent
+    const form = document.getElementById('reportForm');
+    try {
+        await addResumableUploadIds(form);
+    } catch (error) {
+        showValidationErrors([error.message]);
+        submitBtn.disabled = false;
+        submitBtn.innerHTML = originalText;
+        return;
+    }
     
     // Submit the form
-    const form = document.querySelector('form');
     if (form) {
         form.submit();
     }
-    
-    return true;
+}
+
+// Whether the user has already seen the similar reports for this submission
+let similarReportsChecked = false;
+
+// Fetch existing reports that may describe the same problem
+function checkSimilarReports(latitude, longitude, description) {
+    const form = document.getElementById('reportForm');
+    const params = new URLSearchParams({
+        category: form ? form.dataset.category : '',
+        lat: latitude,
+        lng: longitude,
+        description: description
+    });
+
+    return fetch(`/api/reports/similar?${params.toString()}`)
+        .then(response => response.json())
+        .then(data => (data.success && data.reports) ? data.reports : [])
+        .catch(error => {
+            // Never block a report because the duplicate check failed
+            console.error('Error checking similar reports:', error);
+            return [];
+        });
+}
+
+// Ask whether one of the similar reports is the same problem, offering a vote instead of a new report
+function showSimilarReports(reports) {
+    const container = document.getElementById('similarReports');
+    if (!container) {
+        submitReportForm();
+        return;
+    }
+
+    const items = reports.map(report => `
+        <div class="similar-report-item">
+            <div class="similar-report-info">
+                <a href="/report/${report.id}" target="_blank" rel="noopener">Denúncia #${report.id}</a>
+                <small class="text-muted">
+                    a ${Math.round(report.distance_meters)} m &middot; ${report.vote_count} votos &middot;
+                    ${new Date(report.created_at).toLocaleDateString('pt-BR')}
+                </small>
+                <p class="mb-0">${escapeSimilarText(report.description)}</p>
+            </div>
+            <button type="button" class="btn btn-primary btn-sm" onclick="voteOnSimilarReport(${report.id}, this)">
+                <i class="bi bi-hand-thumbs-up me-1"></i>É o mesmo, votar
+            </button>
+        </div>
+    `).join('');
+
+    container.innerHTML = `
+        <div class="alert alert-warning">
+            <h6 class="mb-2"><i class="bi bi-question-circle-fill me-2"></i>É o mesmo problema?</h6>
+            <p class="mb-3">Encontramos denúncias parecidas perto deste local. Se for o mesmo problema,
+            vote na denúncia existente para fortalecê-la em vez de criar uma nova.</p>
+            ${items}
+            <div class="text-end mt-3">
+                <button type="button" class="btn btn-outline-secondary btn-sm" onclick="submitReportForm()">
+                    Não, é outro problema &mdash; enviar minha denúncia
+                </button>
+            </div>
+        </div>
+    `;
+    container.style.display = 'block';
+    container.scrollIntoView({ behavior: 'smooth', block: 'center' });
+}
+
+// Vote on an existing report with the already verified CPF instead of creating a new one
+function voteOnSimilarReport(reportId, buttonElement) {
+    buttonElement.disabled = true;
+    buttonElement.innerHTML = '<i class="bi bi-hourglass-split me-1"></i>Votando...';
+
+    fetch('/api/vote', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({
+            report_id: reportId,
+            cpf: document.getElementById('cpf').value,
+            birth_date: document.getElementById('birth_date').value
+        })
+    })
+    .then(response => response.json())
+    .then(data => {
+        // A previous vote from the same citizen already counts, so just open the report
+        if (!data.success && !data.vote_count) {
+            throw new Error(data.message || 'Erro ao registrar voto');
+        }
+        window.location.href = `/report/${reportId}`;
+    })
+    .catch(error => {
+        console.error('Error voting on similar report:', error);
+        alert(error.message);
+        buttonElement.disabled = false;
+        buttonElement.innerHTML = '<i class="bi bi-hand-thumbs-up me-1"></i>É o mesmo, votar';
+    });
+}
+
+// Escape report descriptions before inserting them into the page
+function escapeSimilarText(text) {
+    const div = document.createElement('div');
+    div.textContent = text;
+    return div.innerHTML;
 }
 
 // Show validation errors
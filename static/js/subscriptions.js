@@ -0,0 +1,45 @@
+// Alert subscription form: shows the fields of the chosen area type and fills the user's location
+
+document.addEventListener('DOMContentLoaded', function() {
+    const form = document.getElementById('subscriptionForm');
+    if (!form) return;
+
+    const areaInputs = form.querySelectorAll('input[name="area"]');
+    const areaSections = form.querySelectorAll('.subscription-area');
+
+    function showSelectedArea() {
+        const selected = form.querySelector('input[name="area"]:checked');
+        const area = selected ? selected.value : 'city';
+        areaSections.forEach(section => {
+            section.style.display = section.dataset.area === area ? '' : 'none';
+        });
+    }
+
+    areaInputs.forEach(input => input.addEventListener('change', showSelectedArea));
+    showSelectedArea();
+
+    const locationButton = document.getElementById('useMyLocation');
+    if (locationButton) {
+        locationButton.addEventListener('click', function() {
+            if (!navigator.geolocation) {
+                alert('Seu navegador não permite obter a localização. Informe a latitude e a longitude.');
+                return;
+            }
+
+            locationButton.disabled = true;
+            navigator.geolocation.getCurrentPosition(
+                function(position) {
+                    document.getElementById('lat').value = position.coords.latitude.toFixed(6);
+                    document.getElementById('lng').value = position.coords.longitude.toFixed(6);
+                    locationButton.disabled = false;
+                },
+                function(error) {
+                    console.warn('Geolocation error:', error);
+                    alert('Não foi possível obter sua localização. Informe a latitude e a longitude.');
+                    locationButton.disabled = false;
+                },
+                { enableHighAccuracy: false, timeout: 10000, maximumAge: 300000 }
+            );
+        });
+    }
+});
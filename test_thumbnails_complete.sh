@@ -25,7 +25,7 @@ ls -la uploads/thumbnails/ 2>/dev/null || echo "No thumbnails directory found"
 
 # Check reports with multiple files
 echo "4. Checking reports with multiple files..."
-docker exec -it olhourbano2-db-1 psql -U olhourbano olhourbanovault -c "SELECT id, problem_type, photo_path FROM reports WHERE photo_path LIKE '%,%' ORDER BY id;"
+docker exec -it olhourbano2-db-1 psql -U olhourbano olhourbanovault -c "SELECT report_id, COUNT(*), string_agg(path, ', ' ORDER BY position) FROM attachments GROUP BY report_id HAVING COUNT(*) > 1 ORDER BY report_id;"
 
 # Test thumbnail generation for existing files
 echo "5. Testing thumbnail generation..."